@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/buildinfo"
+	"github.com/adron/golang-services-build-base/internal/detect"
+	"github.com/adron/golang-services-build-base/internal/health"
+	"github.com/adron/golang-services-build-base/internal/logging"
+	"github.com/adron/golang-services-build-base/internal/postprocess"
+	"github.com/adron/golang-services-build-base/internal/service"
+	"github.com/adron/golang-services-build-base/internal/systemd"
+	"github.com/adron/golang-services-build-base/internal/tlsconfig"
+)
+
+// newRootCmd builds the command tree. Running the root command without a
+// subcommand serves, so existing `vision-service --headless` invocations
+// keep working.
+func newRootCmd() *cobra.Command {
+	var headless bool
+
+	rootCmd := &cobra.Command{
+		Use:   "vision-service",
+		Short: "Computer Vision Service for line detection",
+		Long: `A Windows-based service for computer vision capabilities to identify lines of people
+and vehicles for order processing.`,
+		Version:       buildinfo.Get().Version,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serve(cmd, headless)
+		},
+	}
+
+	rootCmd.Flags().BoolVarP(&headless, "headless", "H", false, "Run service in headless mode")
+	rootCmd.Flags().MarkHidden("headless")
+	config.RegisterFlags(rootCmd.PersistentFlags())
+
+	rootCmd.AddCommand(
+		newServeCmd(),
+		newHealthcheckCmd(),
+		newConfigCmd(),
+		newVersionCmd(),
+	)
+	return rootCmd
+}
+
+func newServeCmd() *cobra.Command {
+	var headless bool
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the service with the operator console, or headless",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serve(cmd, headless)
+		},
+	}
+	cmd.Flags().BoolVarP(&headless, "headless", "H", false, "Run service in headless mode")
+	return cmd
+}
+
+// serve loads the configuration, starts telemetry and config reloading, and
+// runs the service until it is stopped.
+func serve(cmd *cobra.Command, headless bool) error {
+	loaded, err := config.LoadConfig(config.WithFlags(cmd.Flags()))
+	if err != nil {
+		return err
+	}
+	cfg = loaded
+	if err := logging.SetLevel(logger, cfg.LogLevel); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := startTelemetry(ctx); err != nil {
+		return err
+	}
+	defer shutdownTelemetry()
+
+	reloader = newReloader(cmd)
+	go reloader.WatchSignals(ctx)
+	if path := config.FilePath(config.WithFlags(cmd.Flags())); path != "" {
+		go reloader.WatchFile(ctx, path, 2*time.Second)
+	}
+
+	// Serve on the socket systemd passed in, if any, so it can own the port
+	var opts []service.Option
+	if files := systemd.ListenFiles(); len(files) > 0 {
+		logger.Infof("Using socket %s passed by systemd", files[0].Name())
+		opts = append(opts, service.WithListener(func(string) (net.Listener, error) {
+			return net.FileListener(files[0])
+		}))
+	}
+
+	// Serve HTTPS with certificates reloaded from disk as they rotate, on
+	// the admin listener too
+	var adminOpts []service.Option
+	if cfg.TLS.Enabled {
+		certs, err := tlsconfig.New(cfg.TLS, logger)
+		if err != nil {
+			return err
+		}
+		go certs.Watch(ctx, cfg.TLS.ReloadInterval)
+		opts = append(opts, service.WithTLS(certs.Config()))
+		adminOpts = append(adminOpts, service.WithTLS(certs.Config()))
+	}
+
+	closeAudit, err := setupAccessControl()
+	if err != nil {
+		return err
+	}
+	defer closeAudit()
+
+	// Process uploaded frames until the service exits, finishing queued
+	// ones before the detector is closed
+	detector, err = detect.New(cfg.Detector, logger, meter)
+	if err != nil {
+		return err
+	}
+	if closer, ok := detector.(io.Closer); ok {
+		defer closer.Close()
+	}
+	// Backgrounds are kept as long as the pipeline remembers the camera
+	if background, ok := detector.(*detect.Background); ok {
+		go background.Watch(ctx, time.Second, cfg.Frames.ResultTTL)
+	}
+	post := postprocess.New(cfg.Detector.Postprocess)
+	if err := post.RegisterMetrics(meter); err != nil {
+		logger.Errorf("Failed to register post-processing metrics: %v", err)
+	}
+	if cfg.Tracking.Enabled {
+		tracker = newTracker()
+		go tracker.Watch(ctx, time.Second)
+	}
+	zoneRegistry, err = newZoneRegistry()
+	if err != nil {
+		return err
+	}
+	pipeline = newPipeline(detector, post)
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := pipeline.Close(stopCtx); err != nil {
+			logger.Errorf("Frame pipeline did not stop cleanly: %v", err)
+		}
+	}()
+
+	registry = newHealthRegistry()
+	svc = newService(opts...)
+	if err := svc.RegisterMetrics(meter); err != nil {
+		logger.Errorf("Failed to register service metrics: %v", err)
+	}
+
+	// Diagnostics and runtime controls stay off the public port
+	if cfg.Admin.Enabled {
+		adminSvc = newAdminService(adminOpts...)
+		if err := adminSvc.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start admin listener: %w", err)
+		}
+		defer func() {
+			stopCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			adminSvc.Stop(stopCtx)
+		}()
+		logger.Infof("Admin listener on %s", adminSvc.Status().Addr)
+	}
+
+	return runService(headless)
+}
+
+func newHealthcheckCmd() *cobra.Command {
+	var (
+		url      string
+		probe    string
+		timeout  time.Duration
+		quiet    bool
+		insecure bool
+		certFile string
+		keyFile  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "healthcheck",
+		Short: "Probe a running instance and exit non-zero unless it is healthy",
+		Long: `Probe the readiness (default) or liveness endpoint of a running instance.
+Exits 0 when healthy and 1 otherwise, for use as a container HEALTHCHECK.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if probe != "ready" && probe != "live" {
+				return fmt.Errorf("--probe must be ready or live, got %q", probe)
+			}
+			if (certFile == "") != (keyFile == "") {
+				return errors.New("--cert and --key must be given together")
+			}
+			target := url
+			if target == "" {
+				loaded, err := config.LoadConfig(config.WithFlags(cmd.Flags()))
+				if err != nil {
+					return err
+				}
+				scheme := "http"
+				if loaded.TLS.Enabled {
+					scheme = "https"
+				}
+				target = fmt.Sprintf("%s://localhost:%d/health/%s", scheme, loaded.Port, probe)
+			}
+
+			client := &http.Client{Timeout: timeout}
+			if insecure || certFile != "" {
+				tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+				// Listeners under client_auth: require refuse probes without one
+				if certFile != "" {
+					cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+					if err != nil {
+						return fmt.Errorf("failed to load client certificate: %w", err)
+					}
+					tlsConfig.Certificates = []tls.Certificate{cert}
+				}
+				client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+			}
+			resp, err := client.Get(target)
+			if err != nil {
+				return fmt.Errorf("%s probe failed: %w", probe, err)
+			}
+			defer resp.Body.Close()
+
+			var report health.Report
+			decodeErr := json.NewDecoder(resp.Body).Decode(&report)
+
+			if !quiet {
+				out := cmd.OutOrStdout()
+				if decodeErr != nil {
+					fmt.Fprintf(out, "%s: HTTP %d\n", probe, resp.StatusCode)
+				} else {
+					fmt.Fprintf(out, "%s: %s\n", probe, report.Status)
+					for _, c := range report.Components {
+						if c.Status != health.StatusUp {
+							fmt.Fprintf(out, "  %s: %s\n", c.Name, c.LastError)
+						}
+					}
+				}
+			}
+
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("%s probe returned HTTP %d", probe, resp.StatusCode)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&url, "url", "", "Probe URL (default http[s]://localhost:<port>/health/<probe>)")
+	cmd.Flags().StringVar(&probe, "probe", "ready", "Probe to check: ready or live")
+	cmd.Flags().DurationVar(&timeout, "timeout", 3*time.Second, "Request timeout")
+	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Only report through the exit code")
+	cmd.Flags().BoolVar(&insecure, "insecure", false, "Skip verifying the server certificate, for probing localhost over TLS")
+	cmd.Flags().StringVar(&certFile, "cert", "", "Client certificate to present, for listeners that require one")
+	cmd.Flags().StringVar(&keyFile, "key", "", "Private key for --cert")
+	return cmd
+}
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the effective configuration",
+	}
+
+	var format string
+	printCmd := &cobra.Command{
+		Use:   "print",
+		Short: "Print the merged configuration with secrets redacted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			loaded, err := config.LoadConfig(config.WithFlags(cmd.Flags()))
+			if err != nil {
+				return err
+			}
+			values := loaded.Redacted().Values()
+
+			out := cmd.OutOrStdout()
+			switch format {
+			case "yaml":
+				enc := yaml.NewEncoder(out)
+				enc.SetIndent(2)
+				if err := enc.Encode(values); err != nil {
+					return err
+				}
+				return enc.Close()
+			case "json":
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(values)
+			case "toml":
+				return toml.NewEncoder(out).Encode(values)
+			default:
+				return fmt.Errorf("--format must be yaml, json or toml, got %q", format)
+			}
+		},
+	}
+	printCmd.Flags().StringVarP(&format, "format", "o", "yaml", "Output format: yaml, json or toml")
+
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the configuration file, environment and flags",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, err := config.LoadConfig(config.WithFlags(cmd.Flags()))
+			out := cmd.OutOrStdout()
+
+			var verr *config.ValidationError
+			if errors.As(err, &verr) {
+				fmt.Fprintln(out, "Configuration is invalid:")
+				for _, fe := range verr.Errors {
+					fmt.Fprintf(out, "  %s\n", fe.Error())
+				}
+				return errors.New("configuration is invalid")
+			}
+			if err != nil {
+				return err
+			}
+
+			if path := config.FilePath(config.WithFlags(cmd.Flags())); path != "" {
+				fmt.Fprintf(out, "Configuration is valid (%s)\n", path)
+			} else {
+				fmt.Fprintln(out, "Configuration is valid")
+			}
+			return nil
+		},
+	}
+
+	cmd.AddCommand(printCmd, validateCmd)
+	return cmd
+}
+
+func newVersionCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print build information",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			info := buildinfo.Get()
+			out := cmd.OutOrStdout()
+			if asJSON {
+				return json.NewEncoder(out).Encode(info)
+			}
+
+			commit := info.Commit
+			if info.Modified {
+				commit += " (modified)"
+			}
+			fmt.Fprintf(out, "vision-service %s\n", info.Version)
+			fmt.Fprintf(out, "  commit: %s\n", commit)
+			fmt.Fprintf(out, "  built:  %s\n", info.Date)
+			fmt.Fprintf(out, "  go:     %s %s\n", info.GoVersion, info.Platform)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print as JSON")
+	return cmd
+}
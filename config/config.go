@@ -1,30 +1,753 @@
 package config
 
 import (
+	"fmt"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
 )
 
+// Config holds the effective service configuration. Values are layered in
+// the order defaults < config file < environment variables < flags.
 type Config struct {
-	Port             int
-	OtelEndpoint     string
-	LogLevel         string
-	ServiceName      string
-	ServiceVersion   string
-	ServiceNamespace string
+	Port             int    `yaml:"port" toml:"port"`
+	OtelEndpoint     string `yaml:"otel_endpoint" toml:"otel_endpoint"`
+	LogLevel         string `yaml:"log_level" toml:"log_level"`
+	ServiceName      string `yaml:"service_name" toml:"service_name"`
+	ServiceVersion   string `yaml:"service_version" toml:"service_version"`
+	ServiceNamespace string `yaml:"service_namespace" toml:"service_namespace"`
+
+	// OTLP exporter settings, mirroring the OTEL_EXPORTER_OTLP_* variables
+	OtelProtocol    string            `yaml:"otel_protocol" toml:"otel_protocol"`
+	OtelHeaders     map[string]string `yaml:"otel_headers" toml:"otel_headers" secret:"true"`
+	OtelCACert      string            `yaml:"otel_ca_cert" toml:"otel_ca_cert"`
+	OtelClientCert  string            `yaml:"otel_client_cert" toml:"otel_client_cert"`
+	OtelClientKey   string            `yaml:"otel_client_key" toml:"otel_client_key"`
+	OtelCompression string            `yaml:"otel_compression" toml:"otel_compression"`
+	OtelTimeout     time.Duration     `yaml:"otel_timeout" toml:"otel_timeout"`
+
+	Metrics  MetricsConfig  `yaml:"metrics" toml:"metrics"`
+	Traces   TracesConfig   `yaml:"traces" toml:"traces"`
+	Logs     LogsConfig     `yaml:"logs" toml:"logs"`
+	Health   HealthConfig   `yaml:"health" toml:"health"`
+	TLS      TLSConfig      `yaml:"tls" toml:"tls"`
+	Auth     AuthConfig     `yaml:"auth" toml:"auth"`
+	Limits   LimitsConfig   `yaml:"limits" toml:"limits"`
+	Admin    AdminConfig    `yaml:"admin" toml:"admin"`
+	Frames   FramesConfig   `yaml:"frames" toml:"frames"`
+	Detector DetectorConfig `yaml:"detector" toml:"detector"`
+	Tracking TrackingConfig `yaml:"tracking" toml:"tracking"`
+	Zones    []ZoneConfig   `yaml:"zones" toml:"zones"`
+}
+
+// MetricsConfig selects where metrics are exported. OTLP push and the
+// Prometheus scrape endpoint can be enabled independently.
+type MetricsConfig struct {
+	OTLPEnabled       bool   `yaml:"otlp_enabled" toml:"otlp_enabled"`
+	PrometheusEnabled bool   `yaml:"prometheus_enabled" toml:"prometheus_enabled"`
+	PrometheusPrefix  string `yaml:"prometheus_prefix" toml:"prometheus_prefix"`
+}
+
+// HealthConfig tunes the liveness and readiness probes. A non-zero
+// MaxFrameAge fails readiness once no frame has been accepted for that long.
+type HealthConfig struct {
+	CheckTimeout time.Duration `yaml:"check_timeout" toml:"check_timeout"`
+	CacheTTL     time.Duration `yaml:"cache_ttl" toml:"cache_ttl"`
+	DiskPath     string        `yaml:"disk_path" toml:"disk_path"`
+	MinFreeMB    int           `yaml:"min_free_mb" toml:"min_free_mb"`
+	MaxFrameAge  time.Duration `yaml:"max_frame_age" toml:"max_frame_age"`
+}
+
+// TLSConfig enables HTTPS on the service listener. ClientAuth "require"
+// with a ClientCAFile turns on mutual TLS; "verify_if_given" verifies
+// client certificates without requiring them. Certificate, key and CA
+// files are reloaded from disk when they change.
+type TLSConfig struct {
+	Enabled        bool          `yaml:"enabled" toml:"enabled"`
+	CertFile       string        `yaml:"cert_file" toml:"cert_file"`
+	KeyFile        string        `yaml:"key_file" toml:"key_file"`
+	MinVersion     string        `yaml:"min_version" toml:"min_version"`
+	CipherSuites   []string      `yaml:"cipher_suites" toml:"cipher_suites"`
+	ClientCAFile   string        `yaml:"client_ca_file" toml:"client_ca_file"`
+	ClientAuth     string        `yaml:"client_auth" toml:"client_auth"`
+	ReloadInterval time.Duration `yaml:"reload_interval" toml:"reload_interval"`
+}
+
+// AuthConfig protects every route except ExemptPaths. Callers present an
+// API key in the X-API-Key header or a JWT in an Authorization bearer
+// header. APIKeys maps a key name to the hex SHA-256 hash of the key, so the
+// keys themselves never appear in configuration. Tokens must be signed by
+// a key in JWKSFile and, when set, carry the given issuer and audience.
+// KeyRoles gives each API key name a role. PolicyFile maps roles to
+// permissions, replacing the built-in policy, and privileged actions are
+// audited to AuditFile or, if unset, the log.
+type AuthConfig struct {
+	Enabled     bool              `yaml:"enabled" toml:"enabled"`
+	APIKeys     map[string]string `yaml:"api_keys" toml:"api_keys" secret:"true"`
+	KeyRoles    map[string]string `yaml:"key_roles" toml:"key_roles"`
+	JWKSFile    string            `yaml:"jwks_file" toml:"jwks_file"`
+	Issuer      string            `yaml:"issuer" toml:"issuer"`
+	Audience    string            `yaml:"audience" toml:"audience"`
+	ClockSkew   time.Duration     `yaml:"clock_skew" toml:"clock_skew"`
+	ExemptPaths []string          `yaml:"exempt_paths" toml:"exempt_paths"`
+	PolicyFile  string            `yaml:"policy_file" toml:"policy_file"`
+	AuditFile   string            `yaml:"audit_file" toml:"audit_file"`
+}
+
+// LimitsConfig protects the service from overload. Each client, identified
+// by its API key or token subject or else its IP address, gets a token
+// bucket refilled at RateLimit requests per second holding up to RateBurst.
+// Requests beyond MaxInFlight concurrent requests, or arriving while the
+// frame pipeline queue holds MaxQueueDepth items, are shed with a 503. Each
+// IP address may fail authentication AuthFailureLimit times per second, up
+// to AuthFailureBurst at once, before its requests get a 429. Zero
+// disables each limit. ExemptPaths, and everything below them, are never
+// limited so probes keep working under load.
+type LimitsConfig struct {
+	RateLimit     float64       `yaml:"rate_limit" toml:"rate_limit"`
+	RateBurst     int           `yaml:"rate_burst" toml:"rate_burst"`
+	MaxInFlight   int           `yaml:"max_in_flight" toml:"max_in_flight"`
+	MaxQueueDepth int           `yaml:"max_queue_depth" toml:"max_queue_depth"`
+	RetryAfter    time.Duration `yaml:"retry_after" toml:"retry_after"`
+	ExemptPaths   []string      `yaml:"exempt_paths" toml:"exempt_paths"`
+
+	AuthFailureLimit float64 `yaml:"auth_failure_limit" toml:"auth_failure_limit"`
+	AuthFailureBurst int     `yaml:"auth_failure_burst" toml:"auth_failure_burst"`
+}
+
+// AdminConfig runs a second listener for pprof, runtime log level, reload
+// and inspection endpoints. It binds to localhost by default so none of it
+// is reachable on the public port. It serves TLS with the service's
+// certificate when TLS is enabled, and must stay on a loopback address
+// when it is not.
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled"`
+	Addr    string `yaml:"addr" toml:"addr"`
+}
+
+// FramesConfig limits frame uploads and sizes the processing pipeline.
+// Uploads larger than MaxBytes, or whose header declares more than
+// MaxPixels pixels or a side longer than MaxDimension, are rejected before
+// decoding. Workers process frames from a queue of QueueSize frames, each
+// camera's frames one at a time and in order; an upload waits up to
+// SyncTimeout for its detections before the caller gets a reference to
+// poll, and results are kept for ResultTTL, up to MaxResults of them.
+// Frames from more than MaxCameras cameras active within ResultTTL are
+// refused.
+type FramesConfig struct {
+	MaxBytes     int           `yaml:"max_bytes" toml:"max_bytes"`
+	MaxPixels    int           `yaml:"max_pixels" toml:"max_pixels"`
+	MaxDimension int           `yaml:"max_dimension" toml:"max_dimension"`
+	Workers      int           `yaml:"workers" toml:"workers"`
+	QueueSize    int           `yaml:"queue_size" toml:"queue_size"`
+	SyncTimeout  time.Duration `yaml:"sync_timeout" toml:"sync_timeout"`
+	ResultTTL    time.Duration `yaml:"result_ttl" toml:"result_ttl"`
+	MaxResults   int           `yaml:"max_results" toml:"max_results"`
+	MaxCameras   int           `yaml:"max_cameras" toml:"max_cameras"`
+}
+
+// DetectorConfig selects what runs on each frame. Type "background" is the
+// built-in motion detector, which needs no model; "plugin" runs an external
+// detector executable; "kserve" calls a remote model server; "none" accepts
+// frames without detecting anything.
+type DetectorConfig struct {
+	Type        string            `yaml:"type" toml:"type"`
+	Background  BackgroundConfig  `yaml:"background" toml:"background"`
+	Plugin      PluginConfig      `yaml:"plugin" toml:"plugin"`
+	KServe      KServeConfig      `yaml:"kserve" toml:"kserve"`
+	Postprocess PostprocessConfig `yaml:"postprocess" toml:"postprocess"`
+}
+
+// BackgroundConfig tunes the background subtraction detector. Frames are
+// shrunk by Downscale in each direction and compared to a per-camera
+// running average that adapts at LearningRate per frame. Pixels differing
+// by more than Threshold grey levels are foreground, and connected regions
+// covering at least MinArea frame pixels are reported.
+type BackgroundConfig struct {
+	LearningRate float64 `yaml:"learning_rate" toml:"learning_rate"`
+	Threshold    int     `yaml:"threshold" toml:"threshold"`
+	MinArea      int     `yaml:"min_area" toml:"min_area"`
+	Downscale    int     `yaml:"downscale" toml:"downscale"`
+}
+
+// PluginConfig runs Command with Args as a detector plugin, talking to it
+// over its stdin and stdout, or over a Unix socket when Transport is
+// "socket". Each frame must be answered within Timeout. The plugin is
+// pinged when it has answered nothing for HealthInterval, and restarted,
+// with backoff, when it exits or stops answering.
+type PluginConfig struct {
+	Command        string        `yaml:"command" toml:"command"`
+	Args           []string      `yaml:"args" toml:"args"`
+	Transport      string        `yaml:"transport" toml:"transport"`
+	Timeout        time.Duration `yaml:"timeout" toml:"timeout"`
+	StartTimeout   time.Duration `yaml:"start_timeout" toml:"start_timeout"`
+	HealthInterval time.Duration `yaml:"health_interval" toml:"health_interval"`
+}
+
+// KServeConfig calls Model on a server speaking the KServe v2 (Open
+// Inference) protocol at URL. Frames are prepared by Preprocess at
+// InputWidth by InputHeight and sent as an FP32 tensor named InputName, up
+// to MaxBatch frames per request, waiting at most BatchWait to fill a batch.
+// OutputName must be a [batch, detections, 6] tensor of x1, y1, x2, y2,
+// score and class index rows, and Classes names the class indexes. After
+// FailureThreshold consecutive failed requests, frames fail fast for
+// OpenTimeout before a single request probes the server again.
+type KServeConfig struct {
+	URL              string           `yaml:"url" toml:"url"`
+	Model            string           `yaml:"model" toml:"model"`
+	Version          string           `yaml:"version" toml:"version"`
+	InputName        string           `yaml:"input_name" toml:"input_name"`
+	OutputName       string           `yaml:"output_name" toml:"output_name"`
+	InputWidth       int              `yaml:"input_width" toml:"input_width"`
+	InputHeight      int              `yaml:"input_height" toml:"input_height"`
+	Classes          []string         `yaml:"classes" toml:"classes"`
+	Timeout          time.Duration    `yaml:"timeout" toml:"timeout"`
+	MaxBatch         int              `yaml:"max_batch" toml:"max_batch"`
+	BatchWait        time.Duration    `yaml:"batch_wait" toml:"batch_wait"`
+	FailureThreshold int              `yaml:"failure_threshold" toml:"failure_threshold"`
+	OpenTimeout      time.Duration    `yaml:"open_timeout" toml:"open_timeout"`
+	Preprocess       PreprocessConfig `yaml:"preprocess" toml:"preprocess"`
+}
+
+// PostprocessConfig filters every detector's output. Detections below
+// their class's entry in ClassThresholds, or MinConfidence for other
+// classes, are dropped. NMS then removes overlapping boxes: "hard" drops
+// boxes overlapping a more confident one by more than IoUThreshold,
+// "soft" instead decays their confidence by exp(-IoU²/SoftNMSSigma) and
+// drops those falling below their threshold, and "none" skips it. Only
+// boxes of the same class suppress each other unless ClassAgnostic is
+// set. At most MaxDetections of the most confident boxes are kept, 0 for
+// no limit. Cameras overrides the thresholds for the cameras it names.
+type PostprocessConfig struct {
+	MinConfidence   float64                     `yaml:"min_confidence" toml:"min_confidence"`
+	ClassThresholds map[string]float64          `yaml:"class_thresholds" toml:"class_thresholds"`
+	Cameras         map[string]CameraThresholds `yaml:"cameras" toml:"cameras"`
+	NMS             string                      `yaml:"nms" toml:"nms"`
+	ClassAgnostic   bool                        `yaml:"class_agnostic" toml:"class_agnostic"`
+	IoUThreshold    float64                     `yaml:"iou_threshold" toml:"iou_threshold"`
+	SoftNMSSigma    float64                     `yaml:"soft_nms_sigma" toml:"soft_nms_sigma"`
+	MaxDetections   int                         `yaml:"max_detections" toml:"max_detections"`
+}
+
+// CameraThresholds overrides the confidence thresholds for one camera. A
+// set MinConfidence replaces the global one, and ClassThresholds entries
+// replace the global entries for the same classes.
+type CameraThresholds struct {
+	MinConfidence   *float64           `yaml:"min_confidence" toml:"min_confidence"`
+	ClassThresholds map[string]float64 `yaml:"class_thresholds" toml:"class_thresholds"`
+}
+
+// PreprocessConfig describes the input tensor a model expects. Frames are
+// scaled to fit and padded with PadValue grey when Letterbox is set, and
+// stretched otherwise. Channels are in ChannelOrder ("rgb" or "bgr") and
+// laid out as Layout ("nchw" or "nhwc"). Values are scaled to [0, 1], then
+// have Mean subtracted and are divided by Std, both given per channel in
+// ChannelOrder; empty lists leave values unchanged.
+type PreprocessConfig struct {
+	Letterbox    bool      `yaml:"letterbox" toml:"letterbox"`
+	PadValue     int       `yaml:"pad_value" toml:"pad_value"`
+	ChannelOrder string    `yaml:"channel_order" toml:"channel_order"`
+	Layout       string    `yaml:"layout" toml:"layout"`
+	Mean         []float64 `yaml:"mean" toml:"mean"`
+	Std          []float64 `yaml:"std" toml:"std"`
+}
+
+// TrackingConfig links each camera's detections across frames into tracks
+// with persistent IDs. A detection continues the track of its class whose
+// predicted box it overlaps most, by at least IoUThreshold. A new track is
+// confirmed after MinHits consecutive matches, and a confirmed one is kept,
+// coasting on its predicted motion through occlusions, until it has gone
+// unmatched for MaxAge.
+type TrackingConfig struct {
+	Enabled      bool          `yaml:"enabled" toml:"enabled"`
+	IoUThreshold float64       `yaml:"iou_threshold" toml:"iou_threshold"`
+	MinHits      int           `yaml:"min_hits" toml:"min_hits"`
+	MaxAge       time.Duration `yaml:"max_age" toml:"max_age"`
+}
+
+// ZoneConfig marks an area or line in a camera's image, with Points as
+// [x, y] pairs in frame pixels. A "polygon" zone counts the tracks whose
+// foot-point, the bottom centre of their box, is inside it; a "line" zone
+// is a polyline counting the tracks that cross it.
+type ZoneConfig struct {
+	Camera string       `yaml:"camera" toml:"camera"`
+	ID     string       `yaml:"id" toml:"id"`
+	Name   string       `yaml:"name" toml:"name"`
+	Kind   string       `yaml:"kind" toml:"kind"`
+	Points [][2]float64 `yaml:"points" toml:"points"`
+}
+
+// TracesConfig controls trace export. With OTLP export off, spans are still
+// created so logs carry trace IDs, but are not sent anywhere. SampleRatio
+// is the fraction of new traces recorded; requests continuing a trace
+// follow the caller's decision. It can change on reload.
+type TracesConfig struct {
+	OTLPEnabled bool    `yaml:"otlp_enabled" toml:"otlp_enabled"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+// LogsConfig controls log export. Logs are always written to stdout; OTLP
+// export sends a copy to the collector.
+type LogsConfig struct {
+	OTLPEnabled bool `yaml:"otlp_enabled" toml:"otlp_enabled"`
+}
+
+// OTLPEnabled reports whether any signal is exported to the OTLP collector
+func (c *Config) OTLPEnabled() bool {
+	return c.Metrics.OTLPEnabled || c.Traces.OTLPEnabled || c.Logs.OTLPEnabled
+}
+
+// Option customizes how LoadConfig gathers configuration sources
+type Option func(*loader)
+
+type loader struct {
+	file  string
+	flags *pflag.FlagSet
 }
 
-func LoadConfig() *Config {
-	port, _ := strconv.Atoi(getEnv("PORT", "8080"))
+// WithFile loads the given YAML or TOML file before applying the environment
+func WithFile(path string) Option {
+	return func(l *loader) {
+		l.file = path
+	}
+}
 
+// WithFlags applies flags registered with RegisterFlags that were set on the command line
+func WithFlags(fs *pflag.FlagSet) Option {
+	return func(l *loader) {
+		l.flags = fs
+	}
+}
+
+// Default returns the configuration used when no other source sets a value
+func Default() *Config {
 	return &Config{
-		Port:             port,
-		OtelEndpoint:     getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"),
-		LogLevel:         getEnv("LOG_LEVEL", "info"),
-		ServiceName:      getEnv("SERVICE_NAME", "vision-service"),
-		ServiceVersion:   getEnv("SERVICE_VERSION", "1.0.0"),
-		ServiceNamespace: getEnv("SERVICE_NAMESPACE", "default"),
+		Port:             8080,
+		OtelEndpoint:     "http://localhost:4318",
+		LogLevel:         "info",
+		ServiceName:      "vision-service",
+		ServiceVersion:   "1.0.0",
+		ServiceNamespace: "default",
+		OtelProtocol:     "http/protobuf",
+		OtelCompression:  "none",
+		OtelTimeout:      10 * time.Second,
+		Metrics: MetricsConfig{
+			OTLPEnabled:      true,
+			PrometheusPrefix: "vision_service",
+		},
+		Traces: TracesConfig{
+			OTLPEnabled: true,
+			SampleRatio: 1,
+		},
+		Health: HealthConfig{
+			CheckTimeout: 2 * time.Second,
+			CacheTTL:     5 * time.Second,
+			DiskPath:     ".",
+			MinFreeMB:    512,
+		},
+		TLS: TLSConfig{
+			MinVersion:     "1.2",
+			ClientAuth:     "none",
+			ReloadInterval: 30 * time.Second,
+		},
+		Auth: AuthConfig{
+			ClockSkew:   time.Minute,
+			ExemptPaths: []string{"/health"},
+		},
+		Limits: LimitsConfig{
+			MaxInFlight:      512,
+			RetryAfter:       time.Second,
+			ExemptPaths:      []string{"/health"},
+			AuthFailureLimit: 0.1,
+			AuthFailureBurst: 10,
+		},
+		Admin: AdminConfig{
+			Enabled: true,
+			Addr:    "127.0.0.1:6060",
+		},
+		Frames: FramesConfig{
+			MaxBytes:     16 << 20,
+			MaxPixels:    4096 * 4096,
+			MaxDimension: 8192,
+			Workers:      2,
+			QueueSize:    32,
+			SyncTimeout:  2 * time.Second,
+			ResultTTL:    5 * time.Minute,
+			MaxResults:   4096,
+			MaxCameras:   1024,
+		},
+		Detector: DetectorConfig{
+			Type: "background",
+			Background: BackgroundConfig{
+				LearningRate: 0.05,
+				Threshold:    30,
+				MinArea:      400,
+				Downscale:    4,
+			},
+			Plugin: PluginConfig{
+				Transport:      "stdio",
+				Timeout:        5 * time.Second,
+				StartTimeout:   10 * time.Second,
+				HealthInterval: 10 * time.Second,
+			},
+			KServe: KServeConfig{
+				InputName:        "images",
+				OutputName:       "detections",
+				InputWidth:       640,
+				InputHeight:      640,
+				Timeout:          2 * time.Second,
+				MaxBatch:         8,
+				BatchWait:        5 * time.Millisecond,
+				FailureThreshold: 5,
+				OpenTimeout:      30 * time.Second,
+				Preprocess: PreprocessConfig{
+					Letterbox:    true,
+					PadValue:     114,
+					ChannelOrder: "rgb",
+					Layout:       "nchw",
+				},
+			},
+			Postprocess: PostprocessConfig{
+				NMS:           "hard",
+				IoUThreshold:  0.5,
+				SoftNMSSigma:  0.5,
+				MaxDetections: 100,
+			},
+		},
+		Tracking: TrackingConfig{
+			Enabled:      true,
+			IoUThreshold: 0.3,
+			MinHits:      3,
+			MaxAge:       time.Second,
+		},
+	}
+}
+
+// LoadConfig merges defaults, the config file, environment variables and
+// flags, and returns a *ValidationError if the result is not usable.
+func LoadConfig(opts ...Option) (*Config, error) {
+	l := newLoader(opts)
+
+	cfg := Default()
+	if l.file != "" {
+		if err := loadFile(l.file, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	verr := &ValidationError{}
+	applyEnv(cfg, verr)
+	if l.flags != nil {
+		applyFlags(cfg, l.flags)
+	}
+	cfg.validate(verr)
+
+	if len(verr.Errors) > 0 {
+		verr.sort()
+		return nil, verr
+	}
+	return cfg, nil
+}
+
+// FilePath returns the config file LoadConfig would read for the given options
+func FilePath(opts ...Option) string {
+	return newLoader(opts).file
+}
+
+func newLoader(opts []Option) *loader {
+	l := &loader{file: os.Getenv("CONFIG_FILE")}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.flags != nil && l.flags.Changed(flagConfig) {
+		l.file, _ = l.flags.GetString(flagConfig)
+	}
+	return l
+}
+
+func applyEnv(cfg *Config, verr *ValidationError) {
+	getEnvInt("PORT", "port", &cfg.Port, verr)
+	cfg.OtelEndpoint = getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", cfg.OtelEndpoint)
+	cfg.LogLevel = getEnv("LOG_LEVEL", cfg.LogLevel)
+	cfg.ServiceName = getEnv("SERVICE_NAME", cfg.ServiceName)
+	cfg.ServiceVersion = getEnv("SERVICE_VERSION", cfg.ServiceVersion)
+	cfg.ServiceNamespace = getEnv("SERVICE_NAMESPACE", cfg.ServiceNamespace)
+
+	cfg.OtelProtocol = getEnv("OTEL_EXPORTER_OTLP_PROTOCOL", cfg.OtelProtocol)
+	if value := os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"); value != "" {
+		headers, err := parseHeaders(value)
+		if err != nil {
+			verr.add("otel_headers", "env OTEL_EXPORTER_OTLP_HEADERS", value, err.Error())
+		} else {
+			cfg.OtelHeaders = headers
+		}
+	}
+	cfg.OtelCACert = getEnv("OTEL_EXPORTER_OTLP_CERTIFICATE", cfg.OtelCACert)
+	cfg.OtelClientCert = getEnv("OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE", cfg.OtelClientCert)
+	cfg.OtelClientKey = getEnv("OTEL_EXPORTER_OTLP_CLIENT_KEY", cfg.OtelClientKey)
+	cfg.OtelCompression = getEnv("OTEL_EXPORTER_OTLP_COMPRESSION", cfg.OtelCompression)
+	if value := os.Getenv("OTEL_EXPORTER_OTLP_TIMEOUT"); value != "" {
+		ms, err := strconv.Atoi(value)
+		if err != nil {
+			verr.add("otel_timeout", "env OTEL_EXPORTER_OTLP_TIMEOUT", value, "must be an integer number of milliseconds")
+		} else {
+			cfg.OtelTimeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	getEnvBool("METRICS_OTLP_ENABLED", "metrics.otlp_enabled", &cfg.Metrics.OTLPEnabled, verr)
+	getEnvBool("METRICS_PROMETHEUS_ENABLED", "metrics.prometheus_enabled", &cfg.Metrics.PrometheusEnabled, verr)
+	cfg.Metrics.PrometheusPrefix = getEnv("METRICS_PROMETHEUS_PREFIX", cfg.Metrics.PrometheusPrefix)
+	getEnvBool("TRACES_OTLP_ENABLED", "traces.otlp_enabled", &cfg.Traces.OTLPEnabled, verr)
+	getEnvFloat("TRACES_SAMPLE_RATIO", "traces.sample_ratio", &cfg.Traces.SampleRatio, verr)
+	getEnvBool("LOGS_OTLP_ENABLED", "logs.otlp_enabled", &cfg.Logs.OTLPEnabled, verr)
+
+	getEnvDuration("HEALTH_CHECK_TIMEOUT", "health.check_timeout", &cfg.Health.CheckTimeout, verr)
+	getEnvDuration("HEALTH_CACHE_TTL", "health.cache_ttl", &cfg.Health.CacheTTL, verr)
+	cfg.Health.DiskPath = getEnv("HEALTH_DISK_PATH", cfg.Health.DiskPath)
+	getEnvInt("HEALTH_MIN_FREE_MB", "health.min_free_mb", &cfg.Health.MinFreeMB, verr)
+	getEnvDuration("HEALTH_MAX_FRAME_AGE", "health.max_frame_age", &cfg.Health.MaxFrameAge, verr)
+
+	getEnvBool("TLS_ENABLED", "tls.enabled", &cfg.TLS.Enabled, verr)
+	cfg.TLS.CertFile = getEnv("TLS_CERT_FILE", cfg.TLS.CertFile)
+	cfg.TLS.KeyFile = getEnv("TLS_KEY_FILE", cfg.TLS.KeyFile)
+	cfg.TLS.MinVersion = getEnv("TLS_MIN_VERSION", cfg.TLS.MinVersion)
+	getEnvList("TLS_CIPHER_SUITES", &cfg.TLS.CipherSuites)
+	cfg.TLS.ClientCAFile = getEnv("TLS_CLIENT_CA_FILE", cfg.TLS.ClientCAFile)
+	cfg.TLS.ClientAuth = getEnv("TLS_CLIENT_AUTH", cfg.TLS.ClientAuth)
+	getEnvDuration("TLS_RELOAD_INTERVAL", "tls.reload_interval", &cfg.TLS.ReloadInterval, verr)
+
+	getEnvBool("AUTH_ENABLED", "auth.enabled", &cfg.Auth.Enabled, verr)
+	if value := os.Getenv("AUTH_API_KEYS"); value != "" {
+		keys, err := parseHeaders(value)
+		if err != nil {
+			verr.add("auth.api_keys", "env AUTH_API_KEYS", redactedValue, err.Error())
+		} else {
+			cfg.Auth.APIKeys = keys
+		}
+	}
+	if value := os.Getenv("AUTH_KEY_ROLES"); value != "" {
+		roles, err := parseHeaders(value)
+		if err != nil {
+			verr.add("auth.key_roles", "env AUTH_KEY_ROLES", value, err.Error())
+		} else {
+			cfg.Auth.KeyRoles = roles
+		}
+	}
+	cfg.Auth.JWKSFile = getEnv("AUTH_JWKS_FILE", cfg.Auth.JWKSFile)
+	cfg.Auth.Issuer = getEnv("AUTH_ISSUER", cfg.Auth.Issuer)
+	cfg.Auth.Audience = getEnv("AUTH_AUDIENCE", cfg.Auth.Audience)
+	getEnvDuration("AUTH_CLOCK_SKEW", "auth.clock_skew", &cfg.Auth.ClockSkew, verr)
+	getEnvList("AUTH_EXEMPT_PATHS", &cfg.Auth.ExemptPaths)
+	cfg.Auth.PolicyFile = getEnv("AUTH_POLICY_FILE", cfg.Auth.PolicyFile)
+	cfg.Auth.AuditFile = getEnv("AUTH_AUDIT_FILE", cfg.Auth.AuditFile)
+
+	getEnvFloat("LIMITS_RATE_LIMIT", "limits.rate_limit", &cfg.Limits.RateLimit, verr)
+	getEnvInt("LIMITS_RATE_BURST", "limits.rate_burst", &cfg.Limits.RateBurst, verr)
+	getEnvInt("LIMITS_MAX_IN_FLIGHT", "limits.max_in_flight", &cfg.Limits.MaxInFlight, verr)
+	getEnvInt("LIMITS_MAX_QUEUE_DEPTH", "limits.max_queue_depth", &cfg.Limits.MaxQueueDepth, verr)
+	getEnvDuration("LIMITS_RETRY_AFTER", "limits.retry_after", &cfg.Limits.RetryAfter, verr)
+	getEnvList("LIMITS_EXEMPT_PATHS", &cfg.Limits.ExemptPaths)
+	getEnvFloat("LIMITS_AUTH_FAILURE_LIMIT", "limits.auth_failure_limit", &cfg.Limits.AuthFailureLimit, verr)
+	getEnvInt("LIMITS_AUTH_FAILURE_BURST", "limits.auth_failure_burst", &cfg.Limits.AuthFailureBurst, verr)
+
+	getEnvBool("ADMIN_ENABLED", "admin.enabled", &cfg.Admin.Enabled, verr)
+	cfg.Admin.Addr = getEnv("ADMIN_ADDR", cfg.Admin.Addr)
+
+	getEnvInt("FRAMES_MAX_BYTES", "frames.max_bytes", &cfg.Frames.MaxBytes, verr)
+	getEnvInt("FRAMES_MAX_PIXELS", "frames.max_pixels", &cfg.Frames.MaxPixels, verr)
+	getEnvInt("FRAMES_MAX_DIMENSION", "frames.max_dimension", &cfg.Frames.MaxDimension, verr)
+	getEnvInt("FRAMES_WORKERS", "frames.workers", &cfg.Frames.Workers, verr)
+	getEnvInt("FRAMES_QUEUE_SIZE", "frames.queue_size", &cfg.Frames.QueueSize, verr)
+	getEnvDuration("FRAMES_SYNC_TIMEOUT", "frames.sync_timeout", &cfg.Frames.SyncTimeout, verr)
+	getEnvDuration("FRAMES_RESULT_TTL", "frames.result_ttl", &cfg.Frames.ResultTTL, verr)
+	getEnvInt("FRAMES_MAX_RESULTS", "frames.max_results", &cfg.Frames.MaxResults, verr)
+	getEnvInt("FRAMES_MAX_CAMERAS", "frames.max_cameras", &cfg.Frames.MaxCameras, verr)
+
+	cfg.Detector.Type = getEnv("DETECTOR_TYPE", cfg.Detector.Type)
+	getEnvFloat("DETECTOR_BACKGROUND_LEARNING_RATE", "detector.background.learning_rate", &cfg.Detector.Background.LearningRate, verr)
+	getEnvInt("DETECTOR_BACKGROUND_THRESHOLD", "detector.background.threshold", &cfg.Detector.Background.Threshold, verr)
+	getEnvInt("DETECTOR_BACKGROUND_MIN_AREA", "detector.background.min_area", &cfg.Detector.Background.MinArea, verr)
+	getEnvInt("DETECTOR_BACKGROUND_DOWNSCALE", "detector.background.downscale", &cfg.Detector.Background.Downscale, verr)
+	cfg.Detector.Plugin.Command = getEnv("DETECTOR_PLUGIN_COMMAND", cfg.Detector.Plugin.Command)
+	getEnvList("DETECTOR_PLUGIN_ARGS", &cfg.Detector.Plugin.Args)
+	cfg.Detector.Plugin.Transport = getEnv("DETECTOR_PLUGIN_TRANSPORT", cfg.Detector.Plugin.Transport)
+	getEnvDuration("DETECTOR_PLUGIN_TIMEOUT", "detector.plugin.timeout", &cfg.Detector.Plugin.Timeout, verr)
+	getEnvDuration("DETECTOR_PLUGIN_START_TIMEOUT", "detector.plugin.start_timeout", &cfg.Detector.Plugin.StartTimeout, verr)
+	getEnvDuration("DETECTOR_PLUGIN_HEALTH_INTERVAL", "detector.plugin.health_interval", &cfg.Detector.Plugin.HealthInterval, verr)
+	cfg.Detector.KServe.URL = getEnv("DETECTOR_KSERVE_URL", cfg.Detector.KServe.URL)
+	cfg.Detector.KServe.Model = getEnv("DETECTOR_KSERVE_MODEL", cfg.Detector.KServe.Model)
+	cfg.Detector.KServe.Version = getEnv("DETECTOR_KSERVE_VERSION", cfg.Detector.KServe.Version)
+	cfg.Detector.KServe.InputName = getEnv("DETECTOR_KSERVE_INPUT_NAME", cfg.Detector.KServe.InputName)
+	cfg.Detector.KServe.OutputName = getEnv("DETECTOR_KSERVE_OUTPUT_NAME", cfg.Detector.KServe.OutputName)
+	getEnvInt("DETECTOR_KSERVE_INPUT_WIDTH", "detector.kserve.input_width", &cfg.Detector.KServe.InputWidth, verr)
+	getEnvInt("DETECTOR_KSERVE_INPUT_HEIGHT", "detector.kserve.input_height", &cfg.Detector.KServe.InputHeight, verr)
+	getEnvList("DETECTOR_KSERVE_CLASSES", &cfg.Detector.KServe.Classes)
+	getEnvDuration("DETECTOR_KSERVE_TIMEOUT", "detector.kserve.timeout", &cfg.Detector.KServe.Timeout, verr)
+	getEnvInt("DETECTOR_KSERVE_MAX_BATCH", "detector.kserve.max_batch", &cfg.Detector.KServe.MaxBatch, verr)
+	getEnvDuration("DETECTOR_KSERVE_BATCH_WAIT", "detector.kserve.batch_wait", &cfg.Detector.KServe.BatchWait, verr)
+	getEnvInt("DETECTOR_KSERVE_FAILURE_THRESHOLD", "detector.kserve.failure_threshold", &cfg.Detector.KServe.FailureThreshold, verr)
+	getEnvDuration("DETECTOR_KSERVE_OPEN_TIMEOUT", "detector.kserve.open_timeout", &cfg.Detector.KServe.OpenTimeout, verr)
+	getEnvBool("DETECTOR_KSERVE_LETTERBOX", "detector.kserve.preprocess.letterbox", &cfg.Detector.KServe.Preprocess.Letterbox, verr)
+	getEnvInt("DETECTOR_KSERVE_PAD_VALUE", "detector.kserve.preprocess.pad_value", &cfg.Detector.KServe.Preprocess.PadValue, verr)
+	cfg.Detector.KServe.Preprocess.ChannelOrder = getEnv("DETECTOR_KSERVE_CHANNEL_ORDER", cfg.Detector.KServe.Preprocess.ChannelOrder)
+	cfg.Detector.KServe.Preprocess.Layout = getEnv("DETECTOR_KSERVE_LAYOUT", cfg.Detector.KServe.Preprocess.Layout)
+	getEnvFloatList("DETECTOR_KSERVE_MEAN", "detector.kserve.preprocess.mean", &cfg.Detector.KServe.Preprocess.Mean, verr)
+	getEnvFloatList("DETECTOR_KSERVE_STD", "detector.kserve.preprocess.std", &cfg.Detector.KServe.Preprocess.Std, verr)
+	getEnvFloat("DETECTOR_MIN_CONFIDENCE", "detector.postprocess.min_confidence", &cfg.Detector.Postprocess.MinConfidence, verr)
+	if value := os.Getenv("DETECTOR_CLASS_THRESHOLDS"); value != "" {
+		thresholds, err := parseThresholds(value)
+		if err != nil {
+			verr.add("detector.postprocess.class_thresholds", "env DETECTOR_CLASS_THRESHOLDS", value, err.Error())
+		} else {
+			cfg.Detector.Postprocess.ClassThresholds = thresholds
+		}
+	}
+	cfg.Detector.Postprocess.NMS = getEnv("DETECTOR_NMS", cfg.Detector.Postprocess.NMS)
+	getEnvBool("DETECTOR_NMS_CLASS_AGNOSTIC", "detector.postprocess.class_agnostic", &cfg.Detector.Postprocess.ClassAgnostic, verr)
+	getEnvFloat("DETECTOR_NMS_IOU_THRESHOLD", "detector.postprocess.iou_threshold", &cfg.Detector.Postprocess.IoUThreshold, verr)
+	getEnvFloat("DETECTOR_SOFT_NMS_SIGMA", "detector.postprocess.soft_nms_sigma", &cfg.Detector.Postprocess.SoftNMSSigma, verr)
+	getEnvInt("DETECTOR_MAX_DETECTIONS", "detector.postprocess.max_detections", &cfg.Detector.Postprocess.MaxDetections, verr)
+
+	getEnvBool("TRACKING_ENABLED", "tracking.enabled", &cfg.Tracking.Enabled, verr)
+	getEnvFloat("TRACKING_IOU_THRESHOLD", "tracking.iou_threshold", &cfg.Tracking.IoUThreshold, verr)
+	getEnvInt("TRACKING_MIN_HITS", "tracking.min_hits", &cfg.Tracking.MinHits, verr)
+	getEnvDuration("TRACKING_MAX_AGE", "tracking.max_age", &cfg.Tracking.MaxAge, verr)
+}
+
+// parseHeaders parses the OTLP "key1=value1,key2=value2" header format
+// with URL-encoded values. AUTH_API_KEYS uses the same format.
+func parseHeaders(value string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("must be a comma-separated list of key=value pairs")
+		}
+		decoded, err := url.QueryUnescape(strings.TrimSpace(val))
+		if err != nil {
+			return nil, fmt.Errorf("header %s has an invalid URL-encoded value", key)
+		}
+		headers[key] = decoded
+	}
+	return headers, nil
+}
+
+// parseThresholds parses "class1=0.5,class2=0.3" confidence thresholds
+func parseThresholds(value string) (map[string]float64, error) {
+	thresholds := make(map[string]float64)
+	for _, pair := range strings.Split(value, ",") {
+		class, val, ok := strings.Cut(pair, "=")
+		class = strings.TrimSpace(class)
+		if !ok || class == "" {
+			return nil, fmt.Errorf("must be a comma-separated list of class=threshold pairs")
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil {
+			return nil, fmt.Errorf("threshold for %s must be a number", class)
+		}
+		thresholds[class] = f
+	}
+	return thresholds, nil
+}
+
+// getEnvInt overrides *dst when key is set, recording a field error for
+// values that are not integers.
+func getEnvInt(key, field string, dst *int, verr *ValidationError) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		verr.add(field, "env "+key, value, "must be an integer")
+		return
+	}
+	*dst = n
+}
+
+// getEnvFloat overrides *dst when key is set, recording a field error for
+// values that are not numbers.
+func getEnvFloat(key, field string, dst *float64, verr *ValidationError) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		verr.add(field, "env "+key, value, "must be a number")
+		return
+	}
+	*dst = f
+}
+
+// getEnvDuration overrides *dst when key is set, recording a field error
+// for values time.ParseDuration does not accept.
+func getEnvDuration(key, field string, dst *time.Duration, verr *ValidationError) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		verr.add(field, "env "+key, value, "must be a duration such as 5s")
+		return
+	}
+	*dst = d
+}
+
+// getEnvBool overrides *dst when key is set, recording a field error for
+// values strconv.ParseBool does not accept.
+func getEnvBool(key, field string, dst *bool, verr *ValidationError) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		verr.add(field, "env "+key, value, "must be true or false")
+		return
+	}
+	*dst = b
+}
+
+// getEnvFloatList overrides *dst with the comma-separated numbers of key
+// when it is set, recording a field error if any item is not a number.
+func getEnvFloatList(key, field string, dst *[]float64, verr *ValidationError) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	var items []float64
+	for _, item := range strings.Split(value, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+		if err != nil {
+			verr.add(field, "env "+key, value, "must be a comma-separated list of numbers")
+			return
+		}
+		items = append(items, f)
+	}
+	*dst = items
+}
+
+// getEnvList overrides *dst with the comma-separated items of key when it is set
+func getEnvList(key string, dst *[]string) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	*dst = items
 }
 
 func getEnv(key, defaultValue string) string {
@@ -1,8 +1,15 @@
 package config
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -54,7 +61,10 @@ func TestLoadConfig(t *testing.T) {
 			}
 
 			// Load configuration
-			cfg := LoadConfig()
+			cfg, err := LoadConfig()
+			if err != nil {
+				t.Fatalf("LoadConfig() returned error: %v", err)
+			}
 
 			// Compare values
 			if cfg.Port != tt.expected.Port {
@@ -121,3 +131,847 @@ func TestGetEnv(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadConfigValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		envVars  map[string]string
+		expected []string
+	}{
+		{
+			name:     "non-numeric port",
+			envVars:  map[string]string{"PORT": "eighty"},
+			expected: []string{"port"},
+		},
+		{
+			name:     "out of range port",
+			envVars:  map[string]string{"PORT": "70000"},
+			expected: []string{"port"},
+		},
+		{
+			name: "multiple invalid fields",
+			envVars: map[string]string{
+				"LOG_LEVEL":                   "verbose",
+				"OTEL_EXPORTER_OTLP_ENDPOINT": "localhost",
+			},
+			expected: []string{"log_level", "otel_endpoint"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			for k, v := range tt.envVars {
+				os.Setenv(k, v)
+			}
+
+			cfg, err := LoadConfig()
+			if cfg != nil {
+				t.Errorf("LoadConfig() returned config %+v, want nil", cfg)
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("LoadConfig() error = %v, want *ValidationError", err)
+			}
+			var fields []string
+			for _, fe := range verr.Errors {
+				fields = append(fields, fe.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.expected) {
+				t.Errorf("invalid fields = %v, want %v", fields, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		expected *Config
+		wantErr  bool
+	}{
+		{
+			name: "yaml file",
+			file: "site.yaml",
+			content: "port: 9000\n" +
+				"log_level: warn\n" +
+				"service_namespace: site-42\n",
+			expected: func() *Config {
+				cfg := Default()
+				cfg.Port = 9000
+				cfg.LogLevel = "warn"
+				cfg.ServiceNamespace = "site-42"
+				return cfg
+			}(),
+		},
+		{
+			name: "toml file",
+			file: "site.toml",
+			content: "port = 9001\n" +
+				"otel_endpoint = \"https://collector.example.com:4318\"\n",
+			expected: func() *Config {
+				cfg := Default()
+				cfg.Port = 9001
+				cfg.OtelEndpoint = "https://collector.example.com:4318"
+				return cfg
+			}(),
+		},
+		{
+			name:    "unknown yaml field",
+			file:    "site.yml",
+			content: "prot: 9000\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown toml field",
+			file:    "site.toml",
+			content: "prot = 9000\n",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported extension",
+			file:    "site.json",
+			content: "{}",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatalf("Failed to write config file: %v", err)
+			}
+
+			cfg, err := LoadConfig(WithFile(path))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("LoadConfig() returned no error, want one")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() returned error: %v", err)
+			}
+			if !reflect.DeepEqual(cfg, tt.expected) {
+				t.Errorf("LoadConfig() = %+v, want %+v", cfg, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	os.Clearenv()
+
+	path := filepath.Join(t.TempDir(), "site.yaml")
+	content := "port: 9000\nlog_level: warn\nservice_name: from-file\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	os.Setenv("CONFIG_FILE", path)
+	os.Setenv("PORT", "9100")
+	os.Setenv("LOG_LEVEL", "debug")
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	RegisterFlags(fs)
+	if err := fs.Parse([]string{"--port", "9200"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	cfg, err := LoadConfig(WithFlags(fs))
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+
+	if cfg.Port != 9200 {
+		t.Errorf("Port = %v, want flag value 9200", cfg.Port)
+	}
+	if cfg.LogLevel != "debug" {
+		t.Errorf("LogLevel = %v, want env value debug", cfg.LogLevel)
+	}
+	if cfg.ServiceName != "from-file" {
+		t.Errorf("ServiceName = %v, want file value from-file", cfg.ServiceName)
+	}
+	if cfg.ServiceVersion != "1.0.0" {
+		t.Errorf("ServiceVersion = %v, want default 1.0.0", cfg.ServiceVersion)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := Default()
+	new := Default()
+	new.LogLevel = "debug"
+	new.Port = 9090
+
+	changed := Diff(old, new)
+	if !reflect.DeepEqual(changed, []string{"port", "log_level"}) {
+		t.Errorf("Diff() = %v, want [port log_level]", changed)
+	}
+
+	if !CopyField(old, new, "log_level") {
+		t.Fatalf("CopyField() did not find log_level")
+	}
+	if old.LogLevel != "debug" || old.Port != 8080 {
+		t.Errorf("CopyField() copied wrong fields: %+v", old)
+	}
+	if CopyField(old, new, "unknown") {
+		t.Errorf("CopyField() found unknown field")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.OtelHeaders = map[string]string{"api-key": "secret"}
+
+	redacted := cfg.Redacted()
+	if got := redacted.OtelHeaders["api-key"]; got != "REDACTED" {
+		t.Errorf("Redacted() header = %q, want REDACTED", got)
+	}
+	if cfg.OtelHeaders["api-key"] != "secret" {
+		t.Errorf("Redacted() modified the original config")
+	}
+	if redacted.Port != cfg.Port || redacted.OtelEndpoint != cfg.OtelEndpoint {
+		t.Errorf("Redacted() changed non-secret fields: %+v", redacted)
+	}
+}
+
+func TestValues(t *testing.T) {
+	values := Default().Values()
+
+	if values["port"] != 8080 {
+		t.Errorf("Values() port = %v, want 8080", values["port"])
+	}
+	if values["otel_timeout"] != "10s" {
+		t.Errorf("Values() otel_timeout = %v, want 10s", values["otel_timeout"])
+	}
+	health, ok := values["health"].(map[string]interface{})
+	if !ok || health["cache_ttl"] != "5s" {
+		t.Errorf("Values() health = %v, want nested map with cache_ttl 5s", values["health"])
+	}
+}
+
+func TestLoadConfigOtlpEnv(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "grpc")
+	os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=secret%20value, tenant=site-42")
+	os.Setenv("OTEL_EXPORTER_OTLP_COMPRESSION", "gzip")
+	os.Setenv("OTEL_EXPORTER_OTLP_TIMEOUT", "2500")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+
+	if cfg.OtelProtocol != "grpc" {
+		t.Errorf("OtelProtocol = %v, want grpc", cfg.OtelProtocol)
+	}
+	expectedHeaders := map[string]string{"api-key": "secret value", "tenant": "site-42"}
+	if !reflect.DeepEqual(cfg.OtelHeaders, expectedHeaders) {
+		t.Errorf("OtelHeaders = %v, want %v", cfg.OtelHeaders, expectedHeaders)
+	}
+	if cfg.OtelCompression != "gzip" {
+		t.Errorf("OtelCompression = %v, want gzip", cfg.OtelCompression)
+	}
+	if cfg.OtelTimeout != 2500*time.Millisecond {
+		t.Errorf("OtelTimeout = %v, want 2.5s", cfg.OtelTimeout)
+	}
+
+	os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "missing-separator")
+	os.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "thrift")
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 2 {
+		t.Errorf("LoadConfig() error = %v, want otel_headers and otel_protocol errors", err)
+	}
+}
+
+func TestLoadConfigMetricsEnv(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("METRICS_OTLP_ENABLED", "false")
+	os.Setenv("METRICS_PROMETHEUS_ENABLED", "true")
+	os.Setenv("METRICS_PROMETHEUS_PREFIX", "site_42")
+	os.Setenv("TRACES_OTLP_ENABLED", "false")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	expected := MetricsConfig{OTLPEnabled: false, PrometheusEnabled: true, PrometheusPrefix: "site_42"}
+	if cfg.Metrics != expected {
+		t.Errorf("Metrics = %+v, want %+v", cfg.Metrics, expected)
+	}
+	if cfg.OTLPEnabled() {
+		t.Errorf("OTLPEnabled() = true with metrics, traces and logs export off")
+	}
+
+	os.Setenv("METRICS_PROMETHEUS_ENABLED", "sometimes")
+	os.Setenv("METRICS_PROMETHEUS_PREFIX", "site-42")
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 2 {
+		t.Errorf("LoadConfig() error = %v, want metrics.prometheus_enabled and metrics.prometheus_prefix errors", err)
+	}
+}
+
+func TestLoadConfigTracesEnv(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("TRACES_SAMPLE_RATIO", "0.25")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	expected := TracesConfig{OTLPEnabled: true, SampleRatio: 0.25}
+	if cfg.Traces != expected {
+		t.Errorf("Traces = %+v, want %+v", cfg.Traces, expected)
+	}
+
+	os.Setenv("TRACES_SAMPLE_RATIO", "1.5")
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Field != "traces.sample_ratio" {
+		t.Errorf("LoadConfig() error = %v, want a traces.sample_ratio error", err)
+	}
+}
+
+func TestLoadConfigTLSEnv(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("TLS_ENABLED", "true")
+	os.Setenv("TLS_CERT_FILE", "/etc/vision/server.pem")
+	os.Setenv("TLS_KEY_FILE", "/etc/vision/server-key.pem")
+	os.Setenv("TLS_MIN_VERSION", "1.3")
+	os.Setenv("TLS_CIPHER_SUITES", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+	os.Setenv("TLS_CLIENT_CA_FILE", "/etc/vision/ca.pem")
+	os.Setenv("TLS_CLIENT_AUTH", "require")
+	os.Setenv("TLS_RELOAD_INTERVAL", "1m")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	expected := TLSConfig{
+		Enabled:        true,
+		CertFile:       "/etc/vision/server.pem",
+		KeyFile:        "/etc/vision/server-key.pem",
+		MinVersion:     "1.3",
+		CipherSuites:   []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		ClientCAFile:   "/etc/vision/ca.pem",
+		ClientAuth:     "require",
+		ReloadInterval: time.Minute,
+	}
+	if !reflect.DeepEqual(cfg.TLS, expected) {
+		t.Errorf("TLS = %+v, want %+v", cfg.TLS, expected)
+	}
+
+	os.Setenv("TLS_KEY_FILE", "")
+	os.Setenv("TLS_MIN_VERSION", "1.0")
+	os.Setenv("TLS_CIPHER_SUITES", "TLS_RSA_WITH_RC4_128_SHA")
+	os.Setenv("TLS_CLIENT_CA_FILE", "")
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 4 {
+		t.Errorf("LoadConfig() error = %v, want tls.min_version, tls.cipher_suites, tls.client_auth and tls.cert_file errors", err)
+	}
+}
+
+func TestLoadConfigAuthEnv(t *testing.T) {
+	os.Clearenv()
+	hash := strings.Repeat("ab", 32)
+	os.Setenv("AUTH_ENABLED", "true")
+	os.Setenv("AUTH_API_KEYS", "line-display="+hash)
+	os.Setenv("AUTH_KEY_ROLES", "line-display=viewer")
+	os.Setenv("AUTH_JWKS_FILE", "/etc/vision/jwks.json")
+	os.Setenv("AUTH_ISSUER", "https://auth.example.com")
+	os.Setenv("AUTH_AUDIENCE", "vision-service")
+	os.Setenv("AUTH_CLOCK_SKEW", "30s")
+	os.Setenv("AUTH_EXEMPT_PATHS", "/health,/metrics")
+	os.Setenv("AUTH_POLICY_FILE", "/etc/vision/policy.yaml")
+	os.Setenv("AUTH_AUDIT_FILE", "/var/log/vision/audit.log")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	expected := AuthConfig{
+		Enabled:     true,
+		APIKeys:     map[string]string{"line-display": hash},
+		KeyRoles:    map[string]string{"line-display": "viewer"},
+		JWKSFile:    "/etc/vision/jwks.json",
+		Issuer:      "https://auth.example.com",
+		Audience:    "vision-service",
+		ClockSkew:   30 * time.Second,
+		ExemptPaths: []string{"/health", "/metrics"},
+		PolicyFile:  "/etc/vision/policy.yaml",
+		AuditFile:   "/var/log/vision/audit.log",
+	}
+	if !reflect.DeepEqual(cfg.Auth, expected) {
+		t.Errorf("Auth = %+v, want %+v", cfg.Auth, expected)
+	}
+	if got := cfg.Redacted().Auth.APIKeys["line-display"]; got != redactedValue {
+		t.Errorf("Redacted() API key = %q, want %q", got, redactedValue)
+	}
+
+	os.Setenv("AUTH_API_KEYS", "line-display=not-a-hash")
+	os.Setenv("AUTH_JWKS_FILE", "")
+	os.Setenv("AUTH_EXEMPT_PATHS", "health")
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 2 {
+		t.Errorf("LoadConfig() error = %v, want auth.api_keys and auth.exempt_paths errors", err)
+	}
+
+	os.Setenv("AUTH_API_KEYS", "")
+	os.Setenv("AUTH_KEY_ROLES", "")
+	os.Setenv("AUTH_EXEMPT_PATHS", "")
+	_, err = LoadConfig()
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Field != "auth.enabled" {
+		t.Errorf("LoadConfig() error = %v, want an auth.enabled error", err)
+	}
+}
+
+func TestLoadConfigAuthKeyRoles(t *testing.T) {
+	os.Clearenv()
+	hash := strings.Repeat("ab", 32)
+	os.Setenv("AUTH_ENABLED", "true")
+	os.Setenv("AUTH_API_KEYS", "line-display="+hash+",pos-bridge="+hash)
+	os.Setenv("AUTH_KEY_ROLES", "line-display=viewer,pos-bridge=integrator")
+	if _, err := LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+
+	// Without a policy file, keys need a built-in role or they could never
+	// be allowed anything
+	os.Setenv("AUTH_KEY_ROLES", "line-display=auditor,kiosk=viewer")
+	_, err := LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 3 {
+		t.Errorf("LoadConfig() error = %v, want auth.key_roles.line-display, auth.key_roles.kiosk and auth.api_keys.pos-bridge errors", err)
+	}
+
+	// A policy file may define other roles and assign them itself
+	os.Setenv("AUTH_KEY_ROLES", "line-display=auditor")
+	os.Setenv("AUTH_POLICY_FILE", "/etc/vision/policy.yaml")
+	if _, err := LoadConfig(); err != nil {
+		t.Errorf("LoadConfig() returned error with a policy file: %v", err)
+	}
+}
+
+func TestValidationErrorsSortedByField(t *testing.T) {
+	os.Clearenv()
+	hash := strings.Repeat("ab", 32)
+	os.Setenv("PORT", "0")
+	os.Setenv("AUTH_ENABLED", "true")
+	os.Setenv("AUTH_API_KEYS", "zone-bridge="+hash+",kiosk="+hash+",line-display="+hash+",pos-bridge="+hash)
+
+	// The api_keys errors come from ranging over a map, so run it a few
+	// times to catch any ordering that depends on iteration
+	want := []string{"auth.api_keys.kiosk", "auth.api_keys.line-display", "auth.api_keys.pos-bridge", "auth.api_keys.zone-bridge", "port"}
+	for i := 0; i < 10; i++ {
+		_, err := LoadConfig()
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Fatalf("LoadConfig() error = %v, want *ValidationError", err)
+		}
+		var fields []string
+		for _, fe := range verr.Errors {
+			fields = append(fields, fe.Field)
+		}
+		if !reflect.DeepEqual(fields, want) {
+			t.Fatalf("fields = %v, want %v", fields, want)
+		}
+	}
+}
+
+func TestLoadConfigLimitsEnv(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("LIMITS_RATE_LIMIT", "2.5")
+	os.Setenv("LIMITS_RATE_BURST", "10")
+	os.Setenv("LIMITS_MAX_IN_FLIGHT", "64")
+	os.Setenv("LIMITS_MAX_QUEUE_DEPTH", "200")
+	os.Setenv("LIMITS_RETRY_AFTER", "5s")
+	os.Setenv("LIMITS_EXEMPT_PATHS", "/health,/metrics")
+	os.Setenv("LIMITS_AUTH_FAILURE_LIMIT", "0.5")
+	os.Setenv("LIMITS_AUTH_FAILURE_BURST", "5")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	expected := LimitsConfig{
+		RateLimit:     2.5,
+		RateBurst:     10,
+		MaxInFlight:   64,
+		MaxQueueDepth: 200,
+		RetryAfter:    5 * time.Second,
+		ExemptPaths:   []string{"/health", "/metrics"},
+
+		AuthFailureLimit: 0.5,
+		AuthFailureBurst: 5,
+	}
+	if !reflect.DeepEqual(cfg.Limits, expected) {
+		t.Errorf("Limits = %+v, want %+v", cfg.Limits, expected)
+	}
+
+	os.Setenv("LIMITS_RATE_LIMIT", "fast")
+	os.Setenv("LIMITS_MAX_IN_FLIGHT", "-1")
+	os.Setenv("LIMITS_RETRY_AFTER", "100ms")
+	os.Setenv("LIMITS_AUTH_FAILURE_LIMIT", "-1")
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 4 {
+		t.Errorf("LoadConfig() error = %v, want limits.rate_limit, limits.max_in_flight, limits.retry_after and limits.auth_failure_limit errors", err)
+	}
+}
+
+func TestLoadConfigAdminEnv(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("ADMIN_ADDR", "127.0.0.1:9191")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	if cfg.Admin != (AdminConfig{Enabled: true, Addr: "127.0.0.1:9191"}) {
+		t.Errorf("Admin = %+v", cfg.Admin)
+	}
+
+	// Other interfaces need TLS
+	os.Setenv("ADMIN_ADDR", "0.0.0.0:9191")
+	if _, err = LoadConfig(); err == nil {
+		t.Error("LoadConfig() accepted a non-loopback admin address without TLS")
+	}
+	os.Setenv("TLS_ENABLED", "true")
+	os.Setenv("TLS_CERT_FILE", "/etc/vision/tls.crt")
+	os.Setenv("TLS_KEY_FILE", "/etc/vision/tls.key")
+	if _, err = LoadConfig(); err != nil {
+		t.Errorf("LoadConfig() returned error for a non-loopback admin address with TLS: %v", err)
+	}
+	os.Unsetenv("TLS_ENABLED")
+
+	os.Setenv("ADMIN_ADDR", "localhost")
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Field != "admin.addr" {
+		t.Errorf("LoadConfig() error = %v, want an admin.addr error", err)
+	}
+
+	os.Setenv("ADMIN_ADDR", "127.0.0.1:8080")
+	if _, err = LoadConfig(); err == nil {
+		t.Error("LoadConfig() accepted an admin address on the service port")
+	}
+
+	os.Setenv("ADMIN_ENABLED", "false")
+	if _, err = LoadConfig(); err != nil {
+		t.Errorf("LoadConfig() returned error for a disabled admin listener: %v", err)
+	}
+}
+
+func TestLoadConfigFramesEnv(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("FRAMES_MAX_BYTES", "1048576")
+	os.Setenv("FRAMES_MAX_PIXELS", "2073600")
+	os.Setenv("FRAMES_WORKERS", "4")
+	os.Setenv("FRAMES_SYNC_TIMEOUT", "0s")
+	os.Setenv("FRAMES_MAX_CAMERAS", "8")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	want := Default().Frames
+	want.MaxBytes = 1 << 20
+	want.MaxPixels = 1920 * 1080
+	want.Workers = 4
+	want.SyncTimeout = 0
+	want.MaxCameras = 8
+	if cfg.Frames != want {
+		t.Errorf("Frames = %+v, want %+v", cfg.Frames, want)
+	}
+
+	os.Setenv("FRAMES_WORKERS", "0")
+	os.Setenv("FRAMES_QUEUE_SIZE", "lots")
+	os.Setenv("FRAMES_MAX_RESULTS", "0")
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 3 {
+		t.Errorf("LoadConfig() error = %v, want 3 field errors", err)
+	}
+}
+
+func TestLoadConfigDetectorEnv(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("DETECTOR_TYPE", "none")
+	os.Setenv("DETECTOR_BACKGROUND_THRESHOLD", "40")
+	os.Setenv("DETECTOR_BACKGROUND_LEARNING_RATE", "0.1")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	want := BackgroundConfig{LearningRate: 0.1, Threshold: 40, MinArea: 400, Downscale: 4}
+	if cfg.Detector.Type != "none" || cfg.Detector.Background != want {
+		t.Errorf("Detector = %+v", cfg.Detector)
+	}
+
+	os.Setenv("DETECTOR_TYPE", "yolo")
+	os.Setenv("DETECTOR_BACKGROUND_LEARNING_RATE", "2")
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 2 {
+		t.Errorf("LoadConfig() error = %v, want 2 field errors", err)
+	}
+}
+
+func TestLoadConfigPluginEnv(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("DETECTOR_TYPE", "plugin")
+	os.Setenv("DETECTOR_PLUGIN_COMMAND", "/opt/detectors/yolo")
+	os.Setenv("DETECTOR_PLUGIN_ARGS", "--model, /opt/models/yolo.onnx")
+	os.Setenv("DETECTOR_PLUGIN_TRANSPORT", "socket")
+	os.Setenv("DETECTOR_PLUGIN_TIMEOUT", "750ms")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	want := Default().Detector.Plugin
+	want.Command = "/opt/detectors/yolo"
+	want.Args = []string{"--model", "/opt/models/yolo.onnx"}
+	want.Transport = "socket"
+	want.Timeout = 750 * time.Millisecond
+	if !reflect.DeepEqual(cfg.Detector.Plugin, want) {
+		t.Errorf("Plugin = %+v, want %+v", cfg.Detector.Plugin, want)
+	}
+
+	os.Setenv("DETECTOR_PLUGIN_COMMAND", "")
+	os.Setenv("DETECTOR_PLUGIN_TRANSPORT", "tcp")
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 2 {
+		t.Errorf("LoadConfig() error = %v, want 2 field errors", err)
+	}
+}
+
+func TestLoadConfigKServeEnv(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("DETECTOR_TYPE", "kserve")
+	os.Setenv("DETECTOR_KSERVE_URL", "http://triton:8000")
+	os.Setenv("DETECTOR_KSERVE_MODEL", "people")
+	os.Setenv("DETECTOR_KSERVE_CLASSES", "person,car")
+	os.Setenv("DETECTOR_KSERVE_MAX_BATCH", "4")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	want := Default().Detector.KServe
+	want.URL = "http://triton:8000"
+	want.Model = "people"
+	want.Classes = []string{"person", "car"}
+	want.MaxBatch = 4
+	if !reflect.DeepEqual(cfg.Detector.KServe, want) {
+		t.Errorf("KServe = %+v, want %+v", cfg.Detector.KServe, want)
+	}
+
+	os.Setenv("DETECTOR_KSERVE_URL", "triton:8000")
+	os.Setenv("DETECTOR_KSERVE_MODEL", "")
+	os.Setenv("DETECTOR_KSERVE_MAX_BATCH", "0")
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 3 {
+		t.Errorf("LoadConfig() error = %v, want 3 field errors", err)
+	}
+}
+
+func TestLoadConfigPreprocessEnv(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("DETECTOR_KSERVE_LETTERBOX", "false")
+	os.Setenv("DETECTOR_KSERVE_CHANNEL_ORDER", "bgr")
+	os.Setenv("DETECTOR_KSERVE_LAYOUT", "nhwc")
+	os.Setenv("DETECTOR_KSERVE_MEAN", "0.485, 0.456, 0.406")
+	os.Setenv("DETECTOR_KSERVE_STD", "0.229,0.224,0.225")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	want := PreprocessConfig{
+		PadValue:     114,
+		ChannelOrder: "bgr",
+		Layout:       "nhwc",
+		Mean:         []float64{0.485, 0.456, 0.406},
+		Std:          []float64{0.229, 0.224, 0.225},
+	}
+	if !reflect.DeepEqual(cfg.Detector.KServe.Preprocess, want) {
+		t.Errorf("Preprocess = %+v, want %+v", cfg.Detector.KServe.Preprocess, want)
+	}
+
+	os.Setenv("DETECTOR_KSERVE_LAYOUT", "chw")
+	os.Setenv("DETECTOR_KSERVE_MEAN", "0.5,x,0.5")
+	os.Setenv("DETECTOR_KSERVE_STD", "1,0,1")
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 3 {
+		t.Errorf("LoadConfig() error = %v, want 3 field errors", err)
+	}
+}
+
+func TestLoadConfigPostprocessEnv(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("DETECTOR_MIN_CONFIDENCE", "0.3")
+	os.Setenv("DETECTOR_CLASS_THRESHOLDS", "person=0.5, car=0.4")
+	os.Setenv("DETECTOR_NMS", "soft")
+	os.Setenv("DETECTOR_NMS_CLASS_AGNOSTIC", "true")
+	os.Setenv("DETECTOR_MAX_DETECTIONS", "20")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	want := Default().Detector.Postprocess
+	want.MinConfidence = 0.3
+	want.ClassThresholds = map[string]float64{"person": 0.5, "car": 0.4}
+	want.NMS = "soft"
+	want.ClassAgnostic = true
+	want.MaxDetections = 20
+	if !reflect.DeepEqual(cfg.Detector.Postprocess, want) {
+		t.Errorf("Postprocess = %+v, want %+v", cfg.Detector.Postprocess, want)
+	}
+
+	os.Setenv("DETECTOR_CLASS_THRESHOLDS", "person=high")
+	os.Setenv("DETECTOR_NMS", "fast")
+	os.Setenv("DETECTOR_NMS_IOU_THRESHOLD", "0")
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 3 {
+		t.Errorf("LoadConfig() error = %v, want 3 field errors", err)
+	}
+
+	os.Setenv("DETECTOR_CLASS_THRESHOLDS", "person=1.5")
+	os.Setenv("DETECTOR_NMS", "hard")
+	os.Setenv("DETECTOR_NMS_IOU_THRESHOLD", "0.5")
+	_, err = LoadConfig()
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Field != "detector.postprocess.class_thresholds.person" {
+		t.Errorf("LoadConfig() error = %v, want a person threshold error", err)
+	}
+}
+
+func TestLoadConfigTrackingEnv(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("TRACKING_MIN_HITS", "5")
+	os.Setenv("TRACKING_MAX_AGE", "2s")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	want := TrackingConfig{Enabled: true, IoUThreshold: 0.3, MinHits: 5, MaxAge: 2 * time.Second}
+	if cfg.Tracking != want {
+		t.Errorf("Tracking = %+v, want %+v", cfg.Tracking, want)
+	}
+
+	os.Setenv("TRACKING_ENABLED", "maybe")
+	os.Setenv("TRACKING_MIN_HITS", "0")
+	os.Setenv("TRACKING_MAX_AGE", "0s")
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 3 {
+		t.Errorf("LoadConfig() error = %v, want 3 field errors", err)
+	}
+}
+
+func TestLoadConfigCameraThresholdsFile(t *testing.T) {
+	os.Clearenv()
+	path := filepath.Join(t.TempDir(), "site.yaml")
+	content := `detector:
+  postprocess:
+    cameras:
+      dock-1:
+        min_confidence: 0.6
+        class_thresholds: {person: 0.7}
+      lane-2:
+        class_thresholds: {car: 0.2}
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	os.Setenv("CONFIG_FILE", path)
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	minConfidence := 0.6
+	want := map[string]CameraThresholds{
+		"dock-1": {MinConfidence: &minConfidence, ClassThresholds: map[string]float64{"person": 0.7}},
+		"lane-2": {ClassThresholds: map[string]float64{"car": 0.2}},
+	}
+	if !reflect.DeepEqual(cfg.Detector.Postprocess.Cameras, want) {
+		t.Errorf("Cameras = %+v, want %+v", cfg.Detector.Postprocess.Cameras, want)
+	}
+
+	content = `detector:
+  postprocess:
+    cameras:
+      dock-1: {min_confidence: 2, class_thresholds: {person: -1}}
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 2 {
+		t.Errorf("LoadConfig() error = %v, want 2 field errors", err)
+	}
+}
+
+func TestLoadConfigZonesFile(t *testing.T) {
+	os.Clearenv()
+	path := filepath.Join(t.TempDir(), "zones.yaml")
+	content := `zones:
+  - camera: drive-1
+    id: drive-thru
+    name: Drive-thru lane
+    kind: polygon
+    points: [[0, 300], [640, 300], [640, 480], [0, 480]]
+  - camera: drive-1
+    id: entry
+    kind: line
+    points: [[100, 0], [100, 480]]
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	os.Setenv("CONFIG_FILE", path)
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error: %v", err)
+	}
+	want := []ZoneConfig{
+		{Camera: "drive-1", ID: "drive-thru", Name: "Drive-thru lane", Kind: "polygon", Points: [][2]float64{{0, 300}, {640, 300}, {640, 480}, {0, 480}}},
+		{Camera: "drive-1", ID: "entry", Kind: "line", Points: [][2]float64{{100, 0}, {100, 480}}},
+	}
+	if !reflect.DeepEqual(cfg.Zones, want) {
+		t.Errorf("Zones = %+v, want %+v", cfg.Zones, want)
+	}
+
+	content = `zones:
+  - {camera: drive-1, id: a, kind: polygon, points: [[0, 0], [1, 1]]}
+  - {camera: drive-1, id: a, kind: line, points: [[0, 0], [1, 1]]}
+  - {camera: drive-1, id: b, kind: circle}
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	_, err = LoadConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 3 {
+		t.Errorf("LoadConfig() error = %v, want 3 field errors", err)
+	}
+}
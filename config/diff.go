@@ -0,0 +1,65 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Diff returns the dotted file keys (for example "log_level") of every field
+// that differs between old and new.
+func Diff(old, new *Config) []string {
+	var changed []string
+	diffStruct(reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem(), "", &changed)
+	return changed
+}
+
+// CopyField copies the field named by a Diff key from src into dst
+func CopyField(dst, src *Config, key string) bool {
+	d, ok := fieldByKey(reflect.ValueOf(dst).Elem(), key)
+	if !ok {
+		return false
+	}
+	s, _ := fieldByKey(reflect.ValueOf(src).Elem(), key)
+	d.Set(s)
+	return true
+}
+
+func diffStruct(a, b reflect.Value, prefix string, changed *[]string) {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := prefix + fieldKey(t.Field(i))
+		if t.Field(i).Type.Kind() == reflect.Struct {
+			diffStruct(a.Field(i), b.Field(i), key+".", changed)
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			*changed = append(*changed, key)
+		}
+	}
+}
+
+func fieldByKey(v reflect.Value, key string) (reflect.Value, bool) {
+	for _, part := range strings.Split(key, ".") {
+		found := false
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if fieldKey(t.Field(i)) == part {
+				v = v.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, false
+		}
+	}
+	return v, true
+}
+
+func fieldKey(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
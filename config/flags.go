@@ -0,0 +1,49 @@
+package config
+
+import (
+	"github.com/spf13/pflag"
+)
+
+const flagConfig = "config"
+
+// RegisterFlags adds the configuration flags to fs. Only flags explicitly
+// set on the command line override the file and environment.
+func RegisterFlags(fs *pflag.FlagSet) {
+	def := Default()
+	fs.String(flagConfig, "", "Path to a YAML or TOML config file (env CONFIG_FILE)")
+	fs.Int("port", def.Port, "Service port (env PORT)")
+	fs.String("otel-endpoint", def.OtelEndpoint, "OpenTelemetry Collector endpoint (env OTEL_EXPORTER_OTLP_ENDPOINT)")
+	fs.String("otel-protocol", def.OtelProtocol, "OTLP protocol, http/protobuf or grpc (env OTEL_EXPORTER_OTLP_PROTOCOL)")
+	fs.String("log-level", def.LogLevel, "Logging level (env LOG_LEVEL)")
+	fs.String("service-name", def.ServiceName, "Service name for telemetry (env SERVICE_NAME)")
+	fs.String("service-version", def.ServiceVersion, "Service version (env SERVICE_VERSION)")
+	fs.String("service-namespace", def.ServiceNamespace, "Service namespace (env SERVICE_NAMESPACE)")
+	fs.String("admin-addr", def.Admin.Addr, "Admin listener address (env ADMIN_ADDR)")
+}
+
+func applyFlags(cfg *Config, fs *pflag.FlagSet) {
+	if fs.Changed("port") {
+		cfg.Port, _ = fs.GetInt("port")
+	}
+	if fs.Changed("otel-endpoint") {
+		cfg.OtelEndpoint, _ = fs.GetString("otel-endpoint")
+	}
+	if fs.Changed("otel-protocol") {
+		cfg.OtelProtocol, _ = fs.GetString("otel-protocol")
+	}
+	if fs.Changed("log-level") {
+		cfg.LogLevel, _ = fs.GetString("log-level")
+	}
+	if fs.Changed("service-name") {
+		cfg.ServiceName, _ = fs.GetString("service-name")
+	}
+	if fs.Changed("service-version") {
+		cfg.ServiceVersion, _ = fs.GetString("service-version")
+	}
+	if fs.Changed("service-namespace") {
+		cfg.ServiceNamespace, _ = fs.GetString("service-namespace")
+	}
+	if fs.Changed("admin-addr") {
+		cfg.Admin.Addr, _ = fs.GetString("admin-addr")
+	}
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"reflect"
+	"time"
+)
+
+// redactedValue replaces secret values in printed configuration
+const redactedValue = "REDACTED"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Redacted returns a copy of c with every string, string map or string
+// slice field tagged secret:"true" masked. Map fields keep their keys so
+// operators can see which headers are set.
+func (c *Config) Redacted() *Config {
+	out := *c
+	redactStruct(reflect.ValueOf(&out).Elem())
+	return &out
+}
+
+func redactStruct(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		if t.Field(i).Type.Kind() == reflect.Struct {
+			redactStruct(f)
+			continue
+		}
+		if t.Field(i).Tag.Get("secret") != "true" {
+			continue
+		}
+		switch f.Kind() {
+		case reflect.String:
+			if f.Len() > 0 {
+				f.SetString(redactedValue)
+			}
+		case reflect.Map:
+			if f.IsNil() || f.Type().Elem().Kind() != reflect.String {
+				continue
+			}
+			masked := reflect.MakeMapWithSize(f.Type(), f.Len())
+			iter := f.MapRange()
+			for iter.Next() {
+				masked.SetMapIndex(iter.Key(), reflect.ValueOf(redactedValue))
+			}
+			f.Set(masked)
+		case reflect.Slice:
+			if f.Type().Elem().Kind() != reflect.String {
+				continue
+			}
+			masked := reflect.MakeSlice(f.Type(), f.Len(), f.Len())
+			for j := 0; j < f.Len(); j++ {
+				masked.Index(j).SetString(redactedValue)
+			}
+			f.Set(masked)
+		}
+	}
+}
+
+// Values returns c as nested maps keyed by config file keys, with durations
+// written the way the file accepts them, so it can be printed as YAML, TOML
+// or JSON and loaded back.
+func (c *Config) Values() map[string]interface{} {
+	return structValues(reflect.ValueOf(c).Elem())
+}
+
+func structValues(v reflect.Value) map[string]interface{} {
+	t := v.Type()
+	values := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		key := fieldKey(t.Field(i))
+		switch {
+		case f.Type() == durationType:
+			values[key] = time.Duration(f.Int()).String()
+		case f.Kind() == reflect.Struct:
+			values[key] = structValues(f)
+		default:
+			values[key] = f.Interface()
+		}
+	}
+	return values
+}
@@ -0,0 +1,439 @@
+package config
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var metricPrefixPattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// builtinRoles are the roles of the policy used without a policy file
+var builtinRoles = map[string]bool{"viewer": true, "integrator": true, "operator": true}
+
+// FieldError describes a single invalid configuration field
+type FieldError struct {
+	Field  string
+	Source string
+	Value  string
+	Reason string
+}
+
+func (e FieldError) Error() string {
+	if e.Source != "" {
+		return fmt.Sprintf("%s: %s (got %q from %s)", e.Field, e.Reason, e.Value, e.Source)
+	}
+	return fmt.Sprintf("%s: %s (got %q)", e.Field, e.Reason, e.Value)
+}
+
+// ValidationError collects every field that failed to parse or validate
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Error()
+	}
+	return "invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) add(field, source, value, reason string) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Source: source, Value: value, Reason: reason})
+}
+
+// sort orders the errors by field, so that errors found while ranging
+// over maps are reported the same way every time
+func (e *ValidationError) sort() {
+	sort.SliceStable(e.Errors, func(i, j int) bool {
+		if e.Errors[i].Field != e.Errors[j].Field {
+			return e.Errors[i].Field < e.Errors[j].Field
+		}
+		return e.Errors[i].Value < e.Errors[j].Value
+	})
+}
+
+// Validate checks the configuration and returns a *ValidationError if any field is invalid
+func (c *Config) Validate() error {
+	verr := &ValidationError{}
+	c.validate(verr)
+	if len(verr.Errors) > 0 {
+		verr.sort()
+		return verr
+	}
+	return nil
+}
+
+func (c *Config) validate(verr *ValidationError) {
+	if c.Port < 1 || c.Port > 65535 {
+		verr.add("port", "", fmt.Sprint(c.Port), "must be between 1 and 65535")
+	}
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		verr.add("log_level", "", c.LogLevel, "must be one of panic, fatal, error, warn, info, debug, trace")
+	}
+	if u, err := url.Parse(c.OtelEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		verr.add("otel_endpoint", "", c.OtelEndpoint, "must be an http or https URL")
+	}
+	if c.ServiceName == "" {
+		verr.add("service_name", "", c.ServiceName, "must not be empty")
+	}
+	if c.OtelProtocol != "http/protobuf" && c.OtelProtocol != "grpc" {
+		verr.add("otel_protocol", "", c.OtelProtocol, "must be http/protobuf or grpc")
+	}
+	if c.OtelCompression != "none" && c.OtelCompression != "gzip" {
+		verr.add("otel_compression", "", c.OtelCompression, "must be none or gzip")
+	}
+	if c.OtelTimeout <= 0 {
+		verr.add("otel_timeout", "", c.OtelTimeout.String(), "must be positive")
+	}
+	if (c.OtelClientCert == "") != (c.OtelClientKey == "") {
+		verr.add("otel_client_cert", "", c.OtelClientCert, "must be set together with otel_client_key")
+	}
+	if c.Metrics.PrometheusPrefix != "" && !metricPrefixPattern.MatchString(c.Metrics.PrometheusPrefix) {
+		verr.add("metrics.prometheus_prefix", "", c.Metrics.PrometheusPrefix, "must be a valid Prometheus metric name prefix")
+	}
+	if c.Traces.SampleRatio < 0 || c.Traces.SampleRatio > 1 {
+		verr.add("traces.sample_ratio", "", fmt.Sprint(c.Traces.SampleRatio), "must be between 0 and 1")
+	}
+	if c.Health.CheckTimeout <= 0 {
+		verr.add("health.check_timeout", "", c.Health.CheckTimeout.String(), "must be positive")
+	}
+	if c.Health.CacheTTL < 0 {
+		verr.add("health.cache_ttl", "", c.Health.CacheTTL.String(), "must not be negative")
+	}
+	if c.Health.MinFreeMB < 0 {
+		verr.add("health.min_free_mb", "", fmt.Sprint(c.Health.MinFreeMB), "must not be negative")
+	}
+	if c.Health.MaxFrameAge < 0 {
+		verr.add("health.max_frame_age", "", c.Health.MaxFrameAge.String(), "must not be negative")
+	}
+	c.TLS.validate(verr)
+	c.Auth.validate(verr)
+	c.Limits.validate(verr)
+	c.Frames.validate(verr)
+	c.Detector.validate(verr)
+	c.Tracking.validate(verr)
+	validateZones(c.Zones, verr)
+	if c.Admin.Enabled {
+		if host, port, err := net.SplitHostPort(c.Admin.Addr); err != nil || port == "" {
+			verr.add("admin.addr", "", c.Admin.Addr, "must be host:port, such as 127.0.0.1:6060")
+		} else if port == fmt.Sprint(c.Port) {
+			verr.add("admin.addr", "", c.Admin.Addr, "must not use the service port")
+		} else if !c.TLS.Enabled && !isLoopback(host) {
+			// Credentials and profiles would cross the network in the clear
+			verr.add("admin.addr", "", c.Admin.Addr, "must be a loopback address unless tls.enabled is set")
+		}
+	}
+}
+
+// isLoopback reports whether host only accepts connections from this machine
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (t *TLSConfig) validate(verr *ValidationError) {
+	if t.MinVersion != "1.2" && t.MinVersion != "1.3" {
+		verr.add("tls.min_version", "", t.MinVersion, "must be 1.2 or 1.3")
+	}
+	secure := make(map[string]bool)
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = true
+	}
+	for _, name := range t.CipherSuites {
+		if !secure[name] {
+			verr.add("tls.cipher_suites", "", name, "must be a secure TLS 1.2 cipher suite name such as TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+		}
+	}
+	switch t.ClientAuth {
+	case "none":
+	case "verify_if_given", "require":
+		if t.ClientCAFile == "" {
+			verr.add("tls.client_auth", "", t.ClientAuth, "requires tls.client_ca_file")
+		}
+	default:
+		verr.add("tls.client_auth", "", t.ClientAuth, "must be none, verify_if_given or require")
+	}
+	if t.ReloadInterval < 0 {
+		verr.add("tls.reload_interval", "", t.ReloadInterval.String(), "must not be negative")
+	}
+	if !t.Enabled {
+		return
+	}
+	if t.CertFile == "" || t.KeyFile == "" {
+		verr.add("tls.cert_file", "", t.CertFile, "tls.cert_file and tls.key_file are required when TLS is enabled")
+	}
+}
+
+func (a *AuthConfig) validate(verr *ValidationError) {
+	for name, hash := range a.APIKeys {
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != 32 {
+			verr.add("auth.api_keys."+name, "", redactedValue, "must be a hex encoded SHA-256 hash of the key")
+		}
+	}
+	for name, role := range a.KeyRoles {
+		if _, ok := a.APIKeys[name]; !ok {
+			verr.add("auth.key_roles."+name, "", role, "names no API key in auth.api_keys")
+		} else if a.PolicyFile == "" && !builtinRoles[role] {
+			verr.add("auth.key_roles."+name, "", role, "must be viewer, integrator or operator without auth.policy_file")
+		}
+	}
+	// Without a policy file, key_roles is the only way a key gets a role
+	if a.Enabled && a.PolicyFile == "" {
+		for name := range a.APIKeys {
+			if _, ok := a.KeyRoles[name]; !ok {
+				verr.add("auth.api_keys."+name, "", redactedValue, "needs a role in auth.key_roles, or subjects in auth.policy_file")
+			}
+		}
+	}
+	if a.ClockSkew < 0 {
+		verr.add("auth.clock_skew", "", a.ClockSkew.String(), "must not be negative")
+	}
+	for _, path := range a.ExemptPaths {
+		if !strings.HasPrefix(path, "/") {
+			verr.add("auth.exempt_paths", "", path, "must start with /")
+		}
+	}
+	if a.Enabled && len(a.APIKeys) == 0 && a.JWKSFile == "" {
+		verr.add("auth.enabled", "", "true", "requires auth.api_keys or auth.jwks_file")
+	}
+}
+
+func (l *LimitsConfig) validate(verr *ValidationError) {
+	if l.RateLimit < 0 {
+		verr.add("limits.rate_limit", "", fmt.Sprint(l.RateLimit), "must not be negative")
+	}
+	if l.RateBurst < 0 {
+		verr.add("limits.rate_burst", "", fmt.Sprint(l.RateBurst), "must not be negative")
+	}
+	if l.MaxInFlight < 0 {
+		verr.add("limits.max_in_flight", "", fmt.Sprint(l.MaxInFlight), "must not be negative")
+	}
+	if l.MaxQueueDepth < 0 {
+		verr.add("limits.max_queue_depth", "", fmt.Sprint(l.MaxQueueDepth), "must not be negative")
+	}
+	if l.AuthFailureLimit < 0 {
+		verr.add("limits.auth_failure_limit", "", fmt.Sprint(l.AuthFailureLimit), "must not be negative")
+	}
+	if l.AuthFailureBurst < 0 {
+		verr.add("limits.auth_failure_burst", "", fmt.Sprint(l.AuthFailureBurst), "must not be negative")
+	}
+	if l.RetryAfter < time.Second {
+		verr.add("limits.retry_after", "", l.RetryAfter.String(), "must be at least 1s")
+	}
+	for _, path := range l.ExemptPaths {
+		if !strings.HasPrefix(path, "/") {
+			verr.add("limits.exempt_paths", "", path, "must start with /")
+		}
+	}
+}
+
+func (f *FramesConfig) validate(verr *ValidationError) {
+	if f.MaxBytes < 1 {
+		verr.add("frames.max_bytes", "", fmt.Sprint(f.MaxBytes), "must be positive")
+	}
+	if f.MaxPixels < 1 {
+		verr.add("frames.max_pixels", "", fmt.Sprint(f.MaxPixels), "must be positive")
+	}
+	if f.MaxDimension < 1 {
+		verr.add("frames.max_dimension", "", fmt.Sprint(f.MaxDimension), "must be positive")
+	}
+	if f.Workers < 1 {
+		verr.add("frames.workers", "", fmt.Sprint(f.Workers), "must be at least 1")
+	}
+	if f.QueueSize < 1 {
+		verr.add("frames.queue_size", "", fmt.Sprint(f.QueueSize), "must be at least 1")
+	}
+	if f.SyncTimeout < 0 {
+		verr.add("frames.sync_timeout", "", f.SyncTimeout.String(), "must not be negative")
+	}
+	if f.ResultTTL <= 0 {
+		verr.add("frames.result_ttl", "", f.ResultTTL.String(), "must be positive")
+	}
+	if f.MaxResults < 1 {
+		verr.add("frames.max_results", "", fmt.Sprint(f.MaxResults), "must be at least 1")
+	}
+	if f.MaxCameras < 1 {
+		verr.add("frames.max_cameras", "", fmt.Sprint(f.MaxCameras), "must be at least 1")
+	}
+}
+
+func (d *DetectorConfig) validate(verr *ValidationError) {
+	switch d.Type {
+	case "background", "none":
+	case "plugin":
+		if d.Plugin.Command == "" {
+			verr.add("detector.plugin.command", "", d.Plugin.Command, "is required when detector.type is plugin")
+		}
+	case "kserve":
+		if u, err := url.Parse(d.KServe.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			verr.add("detector.kserve.url", "", d.KServe.URL, "must be an http or https URL when detector.type is kserve")
+		}
+		if d.KServe.Model == "" {
+			verr.add("detector.kserve.model", "", d.KServe.Model, "is required when detector.type is kserve")
+		}
+	default:
+		verr.add("detector.type", "", d.Type, "must be background, plugin, kserve or none")
+	}
+	b := d.Background
+	if b.LearningRate <= 0 || b.LearningRate > 1 {
+		verr.add("detector.background.learning_rate", "", fmt.Sprint(b.LearningRate), "must be greater than 0 and at most 1")
+	}
+	if b.Threshold < 1 || b.Threshold > 255 {
+		verr.add("detector.background.threshold", "", fmt.Sprint(b.Threshold), "must be between 1 and 255")
+	}
+	if b.MinArea < 1 {
+		verr.add("detector.background.min_area", "", fmt.Sprint(b.MinArea), "must be positive")
+	}
+	if b.Downscale < 1 || b.Downscale > 16 {
+		verr.add("detector.background.downscale", "", fmt.Sprint(b.Downscale), "must be between 1 and 16")
+	}
+	p := d.Plugin
+	if p.Transport != "stdio" && p.Transport != "socket" {
+		verr.add("detector.plugin.transport", "", p.Transport, "must be stdio or socket")
+	}
+	if p.Timeout <= 0 {
+		verr.add("detector.plugin.timeout", "", p.Timeout.String(), "must be positive")
+	}
+	if p.StartTimeout <= 0 {
+		verr.add("detector.plugin.start_timeout", "", p.StartTimeout.String(), "must be positive")
+	}
+	if p.HealthInterval <= 0 {
+		verr.add("detector.plugin.health_interval", "", p.HealthInterval.String(), "must be positive")
+	}
+	k := d.KServe
+	if k.InputName == "" || k.OutputName == "" {
+		verr.add("detector.kserve.input_name", "", k.InputName, "input_name and output_name must not be empty")
+	}
+	if k.InputWidth < 1 || k.InputHeight < 1 {
+		verr.add("detector.kserve.input_width", "", fmt.Sprintf("%dx%d", k.InputWidth, k.InputHeight), "input_width and input_height must be positive")
+	}
+	if k.Timeout <= 0 {
+		verr.add("detector.kserve.timeout", "", k.Timeout.String(), "must be positive")
+	}
+	if k.MaxBatch < 1 {
+		verr.add("detector.kserve.max_batch", "", fmt.Sprint(k.MaxBatch), "must be at least 1")
+	}
+	if k.BatchWait < 0 {
+		verr.add("detector.kserve.batch_wait", "", k.BatchWait.String(), "must not be negative")
+	}
+	if k.FailureThreshold < 1 {
+		verr.add("detector.kserve.failure_threshold", "", fmt.Sprint(k.FailureThreshold), "must be at least 1")
+	}
+	if k.OpenTimeout <= 0 {
+		verr.add("detector.kserve.open_timeout", "", k.OpenTimeout.String(), "must be positive")
+	}
+	k.Preprocess.validate("detector.kserve.preprocess", verr)
+	d.Postprocess.validate(verr)
+}
+
+func (p *PostprocessConfig) validate(verr *ValidationError) {
+	if p.MinConfidence < 0 || p.MinConfidence > 1 {
+		verr.add("detector.postprocess.min_confidence", "", fmt.Sprint(p.MinConfidence), "must be between 0 and 1")
+	}
+	for class, threshold := range p.ClassThresholds {
+		if threshold < 0 || threshold > 1 {
+			verr.add("detector.postprocess.class_thresholds."+class, "", fmt.Sprint(threshold), "must be between 0 and 1")
+		}
+	}
+	for camera, c := range p.Cameras {
+		field := "detector.postprocess.cameras." + camera
+		if c.MinConfidence != nil && (*c.MinConfidence < 0 || *c.MinConfidence > 1) {
+			verr.add(field+".min_confidence", "", fmt.Sprint(*c.MinConfidence), "must be between 0 and 1")
+		}
+		for class, threshold := range c.ClassThresholds {
+			if threshold < 0 || threshold > 1 {
+				verr.add(field+".class_thresholds."+class, "", fmt.Sprint(threshold), "must be between 0 and 1")
+			}
+		}
+	}
+	switch p.NMS {
+	case "hard", "soft", "none":
+	default:
+		verr.add("detector.postprocess.nms", "", p.NMS, "must be hard, soft or none")
+	}
+	if p.IoUThreshold <= 0 || p.IoUThreshold > 1 {
+		verr.add("detector.postprocess.iou_threshold", "", fmt.Sprint(p.IoUThreshold), "must be greater than 0 and at most 1")
+	}
+	if p.SoftNMSSigma <= 0 {
+		verr.add("detector.postprocess.soft_nms_sigma", "", fmt.Sprint(p.SoftNMSSigma), "must be positive")
+	}
+	if p.MaxDetections < 0 {
+		verr.add("detector.postprocess.max_detections", "", fmt.Sprint(p.MaxDetections), "must not be negative")
+	}
+}
+
+func (p *PreprocessConfig) validate(field string, verr *ValidationError) {
+	if p.PadValue < 0 || p.PadValue > 255 {
+		verr.add(field+".pad_value", "", fmt.Sprint(p.PadValue), "must be between 0 and 255")
+	}
+	if p.ChannelOrder != "rgb" && p.ChannelOrder != "bgr" {
+		verr.add(field+".channel_order", "", p.ChannelOrder, "must be rgb or bgr")
+	}
+	if p.Layout != "nchw" && p.Layout != "nhwc" {
+		verr.add(field+".layout", "", p.Layout, "must be nchw or nhwc")
+	}
+	if len(p.Mean) != 0 && len(p.Mean) != 3 {
+		verr.add(field+".mean", "", fmt.Sprint(p.Mean), "must be empty or have one value per channel")
+	}
+	if len(p.Std) != 0 && len(p.Std) != 3 {
+		verr.add(field+".std", "", fmt.Sprint(p.Std), "must be empty or have one value per channel")
+	}
+	for _, v := range p.Std {
+		if v == 0 {
+			verr.add(field+".std", "", fmt.Sprint(p.Std), "must not contain zero")
+			break
+		}
+	}
+}
+
+func (t *TrackingConfig) validate(verr *ValidationError) {
+	if t.IoUThreshold <= 0 || t.IoUThreshold > 1 {
+		verr.add("tracking.iou_threshold", "", fmt.Sprint(t.IoUThreshold), "must be greater than 0 and at most 1")
+	}
+	if t.MinHits < 1 {
+		verr.add("tracking.min_hits", "", fmt.Sprint(t.MinHits), "must be at least 1")
+	}
+	if t.MaxAge <= 0 {
+		verr.add("tracking.max_age", "", t.MaxAge.String(), "must be positive")
+	}
+}
+
+func validateZones(zones []ZoneConfig, verr *ValidationError) {
+	seen := make(map[[2]string]bool)
+	for i, z := range zones {
+		field := fmt.Sprintf("zones[%d]", i)
+		if z.Camera == "" || z.ID == "" {
+			verr.add(field, "", z.Camera+"/"+z.ID, "camera and id are required")
+		}
+		key := [2]string{z.Camera, z.ID}
+		if seen[key] {
+			verr.add(field+".id", "", z.ID, "is already used by another zone on camera "+z.Camera)
+		}
+		seen[key] = true
+		switch z.Kind {
+		case "polygon":
+			if len(z.Points) < 3 {
+				verr.add(field+".points", "", fmt.Sprint(len(z.Points)), "a polygon needs at least 3 points")
+			}
+		case "line":
+			if len(z.Points) < 2 {
+				verr.add(field+".points", "", fmt.Sprint(len(z.Points)), "a line needs at least 2 points")
+			}
+		default:
+			verr.add(field+".kind", "", z.Kind, "must be polygon or line")
+		}
+	}
+}
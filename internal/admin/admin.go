@@ -0,0 +1,113 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/httpjson"
+)
+
+// PprofPrefix is where PprofHandler expects to be mounted
+const PprofPrefix = "/debug/pprof/"
+
+// PprofHandler serves the net/http/pprof profiles under PprofPrefix
+func PprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PprofPrefix, pprof.Index)
+	mux.HandleFunc(PprofPrefix+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(PprofPrefix+"profile", pprof.Profile)
+	mux.HandleFunc(PprofPrefix+"symbol", pprof.Symbol)
+	mux.HandleFunc(PprofPrefix+"trace", pprof.Trace)
+	return mux
+}
+
+// GoroutineHandler writes the stack of every goroutine as plain text.
+// ?debug=1 groups identical stacks instead of listing each goroutine.
+func GoroutineHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		debug := 2
+		if v := r.URL.Query().Get("debug"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 2 {
+				httpjson.WriteError(w, http.StatusBadRequest, "invalid_request", "debug must be 1 or 2")
+				return
+			}
+			debug = n
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Goroutine-Count", strconv.Itoa(runtime.NumGoroutine()))
+		runtimepprof.Lookup("goroutine").WriteTo(w, debug)
+	})
+}
+
+// logLevel is the body of log level requests and responses
+type logLevel struct {
+	Level    string `json:"level"`
+	Previous string `json:"previous,omitempty"`
+}
+
+// LogLevelHandler reports the log level on GET and changes it on PUT with
+// a body such as {"level":"debug"}. The change lasts until the next
+// restart or config reload.
+type LogLevelHandler struct {
+	logger *logrus.Logger
+	set    func(level string) error
+}
+
+// NewLogLevelHandler creates a handler reporting logger's level and
+// changing it with set, which must apply a valid level to logger
+func NewLogLevelHandler(logger *logrus.Logger, set func(level string) error) *LogLevelHandler {
+	return &LogLevelHandler{logger: logger, set: set}
+}
+
+func (h *LogLevelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	current := h.logger.GetLevel().String()
+	switch r.Method {
+	case http.MethodGet:
+		httpjson.Write(w, http.StatusOK, logLevel{Level: current})
+	case http.MethodPut:
+		var req logLevel
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+			httpjson.WriteError(w, http.StatusBadRequest, "invalid_request", `body must be JSON such as {"level":"debug"}`)
+			return
+		}
+		if _, err := logrus.ParseLevel(req.Level); err != nil {
+			httpjson.WriteError(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		// Log before switching so the entry is kept when raising the level
+		h.logger.WithContext(r.Context()).WithFields(logrus.Fields{"from": current, "to": req.Level}).Warn("Log level changed through the admin API")
+		if err := h.set(req.Level); err != nil {
+			httpjson.WriteError(w, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		httpjson.Write(w, http.StatusOK, logLevel{Level: h.logger.GetLevel().String(), Previous: current})
+	default:
+		httpjson.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+	}
+}
+
+// ConfigHandler serves the effective configuration with secrets redacted
+type ConfigHandler struct {
+	current func() *config.Config
+}
+
+// NewConfigHandler creates a handler serving the configuration current returns
+func NewConfigHandler(current func() *config.Config) *ConfigHandler {
+	return &ConfigHandler{current: current}
+}
+
+func (h *ConfigHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, h.current().Redacted().Values())
+}
@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/httpjson"
+)
+
+// APIKeyHeader carries static API keys
+const APIKeyHeader = "X-API-Key"
+
+// Authentication methods recorded on the Principal and in span attributes
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// Errors returned by Authenticate. Token errors wrap ErrInvalidToken with
+// the reason the token was rejected.
+var (
+	ErrNoCredentials = errors.New("no credentials provided")
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrInvalidToken  = errors.New("invalid bearer token")
+)
+
+// Principal identifies an authenticated caller
+type Principal struct {
+	// Subject is the API key name or the token's sub claim
+	Subject string
+	Method  string
+	// Claims holds every claim of a JWT; nil for API keys
+	Claims map[string]interface{}
+}
+
+type principalKey struct{}
+
+type exemptKey struct{}
+
+// NewContext returns ctx carrying p
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the caller authenticated for a request, if any
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// IsExempt reports whether the request skipped authentication because its
+// path is exempt
+func IsExempt(ctx context.Context) bool {
+	exempt, _ := ctx.Value(exemptKey{}).(bool)
+	return exempt
+}
+
+// apiKey is a configured key name and the SHA-256 hash of the key
+type apiKey struct {
+	name string
+	hash []byte
+}
+
+// Authenticator verifies API keys and JWT bearer tokens for incoming requests
+type Authenticator struct {
+	apiKeys  []apiKey
+	keys     *KeySet
+	issuer   string
+	audience string
+	skew     time.Duration
+	exempt   []string
+	now      func() time.Time
+}
+
+// Option customizes an Authenticator
+type Option func(*Authenticator)
+
+// WithClock replaces time.Now when checking token expiry, for tests
+func WithClock(now func() time.Time) Option {
+	return func(a *Authenticator) {
+		a.now = now
+	}
+}
+
+// New creates an Authenticator for cfg, loading the JWKS file if one is set
+func New(cfg config.AuthConfig, opts ...Option) (*Authenticator, error) {
+	a := &Authenticator{
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		skew:     cfg.ClockSkew,
+		exempt:   cfg.ExemptPaths,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	// Sort by name so the key matched first is stable
+	names := make([]string, 0, len(cfg.APIKeys))
+	for name := range cfg.APIKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hash, err := hex.DecodeString(cfg.APIKeys[name])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("API key %q must be a hex encoded SHA-256 hash", name)
+		}
+		a.apiKeys = append(a.apiKeys, apiKey{name: name, hash: hash})
+	}
+
+	if cfg.JWKSFile != "" {
+		keys, err := LoadKeySet(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = keys
+	}
+	return a, nil
+}
+
+// HashAPIKey returns the value to configure in auth.api_keys for key
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate identifies the caller of r from its API key or bearer token
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return a.authenticateAPIKey(key)
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return nil, ErrNoCredentials
+	}
+	if a.keys == nil {
+		return nil, fmt.Errorf("%w: bearer tokens are not accepted", ErrInvalidToken)
+	}
+	subject, claims, err := a.verifyToken(strings.TrimSpace(token))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return &Principal{Subject: subject, Method: MethodJWT, Claims: claims}, nil
+}
+
+// authenticateAPIKey compares the key's hash against every configured hash
+// in constant time so timing does not reveal which key nearly matched
+func (a *Authenticator) authenticateAPIKey(key string) (*Principal, error) {
+	sum := sha256.Sum256([]byte(key))
+	match := ""
+	for _, k := range a.apiKeys {
+		if subtle.ConstantTimeCompare(sum[:], k.hash) == 1 && match == "" {
+			match = k.name
+		}
+	}
+	if match == "" {
+		return nil, ErrInvalidAPIKey
+	}
+	return &Principal{Subject: match, Method: MethodAPIKey}, nil
+}
+
+// Exempt reports whether path skips authentication. An exempt path also
+// covers everything below it, so "/health" exempts "/health/ready".
+func (a *Authenticator) Exempt(path string) bool {
+	for _, p := range a.exempt {
+		p = strings.TrimSuffix(p, "/")
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware is a mux.MiddlewareFunc. It rejects unauthenticated requests
+// with a JSON 401 and records the outcome on the request span.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		if a.Exempt(r.URL.Path) {
+			span.SetAttributes(attribute.String("auth.result", "exempt"))
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), exemptKey{}, true)))
+			return
+		}
+
+		principal, err := a.Authenticate(r)
+		if err != nil {
+			span.SetAttributes(
+				attribute.String("auth.result", "failure"),
+				attribute.String("auth.method", attemptedMethod(r)),
+				attribute.String("auth.error", err.Error()),
+			)
+			writeUnauthorized(w, err)
+			return
+		}
+
+		span.SetAttributes(
+			attribute.String("auth.result", "success"),
+			attribute.String("auth.method", principal.Method),
+			semconv.EnduserID(principal.Subject),
+		)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), principal)))
+	})
+}
+
+// attemptedMethod names the kind of credential a rejected request carried
+func attemptedMethod(r *http.Request) string {
+	switch {
+	case r.Header.Get(APIKeyHeader) != "":
+		return MethodAPIKey
+	case r.Header.Get("Authorization") != "":
+		return MethodJWT
+	default:
+		return "none"
+	}
+}
+
+// Error is the JSON body of 401 and 403 responses
+type Error = httpjson.Error
+
+func writeUnauthorized(w http.ResponseWriter, err error) {
+	// RFC 6750 challenge; error codes are only sent when a token was presented
+	challenge := `Bearer realm="vision-service"`
+	if errors.Is(err, ErrInvalidToken) {
+		challenge += `, error="invalid_token"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized", err.Error())
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// minRSABits rejects RSA keys too short to trust
+const minRSABits = 2048
+
+// jwk is a single JSON Web Key as found in a JWKS document
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verificationKey is a public key and the algorithm it verifies
+type verificationKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// KeySet holds the signing keys from a local JWKS file. The file is read
+// again when a token names a key ID that is not loaded, so rotated keys
+// are picked up without a restart.
+type KeySet struct {
+	path string
+
+	mu       sync.RWMutex
+	keys     []verificationKey
+	modTime  time.Time
+	size     int64
+	lastStat time.Time
+}
+
+// LoadKeySet reads the JWKS file at path. Keys that are not RSA or P-256
+// signing keys are skipped; a file with no usable keys is an error.
+func LoadKeySet(path string) (*KeySet, error) {
+	ks := &KeySet{path: path}
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// lookup returns the keys that may verify a token with the given header
+func (ks *KeySet) lookup(alg, kid string) []verificationKey {
+	keys := ks.match(alg, kid)
+	if len(keys) == 0 && kid != "" && ks.changed() {
+		if err := ks.load(); err == nil {
+			keys = ks.match(alg, kid)
+		}
+	}
+	return keys
+}
+
+func (ks *KeySet) match(alg, kid string) []verificationKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	var keys []verificationKey
+	for _, k := range ks.keys {
+		if k.alg == alg && (kid == "" || k.kid == kid) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// changed reports whether the file differs from the loaded version. Unknown
+// key IDs are attacker controlled, so the file is checked at most once a second.
+func (ks *KeySet) changed() bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if time.Since(ks.lastStat) < time.Second {
+		return false
+	}
+	ks.lastStat = time.Now()
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return false
+	}
+	return !info.ModTime().Equal(ks.modTime) || info.Size() != ks.size
+}
+
+func (ks *KeySet) load() error {
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+	data, err := os.ReadFile(ks.path)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse JWKS file: %w", err)
+	}
+
+	var keys []verificationKey
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.verificationKey()
+		if err != nil {
+			return fmt.Errorf("JWKS key %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			keys = append(keys, *key)
+		}
+	}
+	if len(keys) == 0 {
+		return errors.New("JWKS file contains no RS256 or ES256 signing keys")
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	ks.modTime = info.ModTime()
+	ks.size = info.Size()
+	return nil
+}
+
+// verificationKey decodes k, returning nil for key types this package does not verify
+func (k jwk) verificationKey() (*verificationKey, error) {
+	switch k.Kty {
+	case "RSA":
+		if k.Alg != "" && k.Alg != algRS256 {
+			return nil, nil
+		}
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		if n.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key is %d bits, need at least %d", n.BitLen(), minRSABits)
+		}
+		return &verificationKey{kid: k.Kid, alg: algRS256, key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case "EC":
+		if k.Crv != "P-256" || (k.Alg != "" && k.Alg != algES256) {
+			return nil, nil
+		}
+		x, err := decodeFixed(k.X, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decodeFixed(k.Y, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		// crypto/ecdh rejects points that are not on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, errors.New("point is not on P-256")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		return &verificationKey{kid: k.Kid, alg: algES256, key: pub}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func decodeFixed(s string, size int) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("must be %d bytes, got %d", size, len(b))
+	}
+	return b, nil
+}
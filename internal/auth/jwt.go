@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Supported JWS algorithms. Symmetric and "none" algorithms are rejected.
+const (
+	algRS256 = "RS256"
+	algES256 = "ES256"
+)
+
+// audience accepts the "aud" claim as a single string or a list
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("aud must be a string or a list of strings")
+	}
+	*a = list
+	return nil
+}
+
+// registeredClaims are the claims checked during verification
+type registeredClaims struct {
+	Issuer    string       `json:"iss"`
+	Subject   string       `json:"sub"`
+	Audience  audience     `json:"aud"`
+	ExpiresAt *json.Number `json:"exp"`
+	NotBefore *json.Number `json:"nbf"`
+}
+
+// verifyToken checks the signature and registered claims of a compact JWS
+// and returns its subject and full claim set.
+func (a *Authenticator) verifyToken(token string) (string, map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	if header.Alg != algRS256 && header.Alg != algES256 {
+		return "", nil, fmt.Errorf("unsupported signing algorithm %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, errors.New("malformed token signature")
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	verified := false
+	for _, key := range a.keys.lookup(header.Alg, header.Kid) {
+		if verifySignature(key, digest[:], sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return "", nil, errors.New("invalid token signature")
+	}
+
+	var claims registeredClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if err := a.checkClaims(claims); err != nil {
+		return "", nil, err
+	}
+
+	var all map[string]interface{}
+	if err := decodeSegment(parts[1], &all); err != nil {
+		return "", nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	return claims.Subject, all, nil
+}
+
+func (a *Authenticator) checkClaims(claims registeredClaims) error {
+	now := a.now()
+	if claims.ExpiresAt == nil {
+		return errors.New("token has no expiry")
+	}
+	exp, err := numericDate(*claims.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	if now.After(exp.Add(a.skew)) {
+		return errors.New("token is expired")
+	}
+	if claims.NotBefore != nil {
+		nbf, err := numericDate(*claims.NotBefore)
+		if err != nil {
+			return err
+		}
+		if now.Add(a.skew).Before(nbf) {
+			return errors.New("token is not valid yet")
+		}
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+	if a.audience != "" {
+		found := false
+		for _, aud := range claims.Audience {
+			if aud == a.audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("token is not intended for this audience")
+		}
+	}
+	if claims.Subject == "" {
+		return errors.New("token has no subject")
+	}
+	return nil
+}
+
+func verifySignature(key verificationKey, digest, sig []byte) bool {
+	switch pub := key.key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		// JWS encodes ES256 signatures as the fixed-size concatenation r || s
+		if len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, digest, r, s)
+	default:
+		return false
+	}
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// numericDate converts a JWT NumericDate, seconds since the epoch, to a time
+func numericDate(n json.Number) (time.Time, error) {
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, errors.New("malformed token date")
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), nil
+}
@@ -0,0 +1,114 @@
+package authz
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Entry records one privileged action or denied request
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Subject    string    `json:"subject"`
+	AuthMethod string    `json:"auth_method,omitempty"`
+	Roles      []string  `json:"roles,omitempty"`
+	// Action is the permission checked, or console:<command> for console commands
+	Action string `json:"action"`
+	// Target is the request method and path, or the command arguments
+	Target     string `json:"target,omitempty"`
+	Allowed    bool   `json:"allowed"`
+	Status     int    `json:"status,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	TraceID    string `json:"trace_id,omitempty"`
+}
+
+// Auditor records audit entries
+type Auditor interface {
+	Record(e Entry)
+}
+
+// LogAuditor writes audit entries to the service log, marked with audit=true
+type LogAuditor struct {
+	logger *logrus.Logger
+}
+
+// NewLogAuditor creates an Auditor writing to logger's output, formatter
+// and hooks. Entries are written whatever logger's level is, so raising
+// the level to warn or error does not turn the audit trail off.
+func NewLogAuditor(logger *logrus.Logger) *LogAuditor {
+	audit := logrus.New()
+	audit.Out = logger.Out
+	audit.Formatter = logger.Formatter
+	// Shared rather than copied so hooks added later, such as the OTLP
+	// exporter, also receive audit entries
+	audit.Hooks = logger.Hooks
+	audit.SetLevel(logrus.InfoLevel)
+	return &LogAuditor{logger: audit}
+}
+
+// Record logs e at info level
+func (a *LogAuditor) Record(e Entry) {
+	fields := logrus.Fields{
+		"audit":   true,
+		"subject": e.Subject,
+		"action":  e.Action,
+		"allowed": e.Allowed,
+	}
+	if e.AuthMethod != "" {
+		fields["auth_method"] = e.AuthMethod
+	}
+	if len(e.Roles) > 0 {
+		fields["roles"] = e.Roles
+	}
+	if e.Target != "" {
+		fields["target"] = e.Target
+	}
+	if e.Status != 0 {
+		fields["status"] = e.Status
+	}
+	if e.RemoteAddr != "" {
+		fields["remote_addr"] = e.RemoteAddr
+	}
+	if e.TraceID != "" {
+		fields["trace_id"] = e.TraceID
+	}
+	a.logger.WithFields(fields).Info("Audit")
+}
+
+// FileAuditor appends audit entries to a file as JSON lines
+type FileAuditor struct {
+	mu     sync.Mutex
+	file   *os.File
+	enc    *json.Encoder
+	logger *logrus.Logger
+}
+
+// NewFileAuditor opens path for appending, creating it readable only by the service user
+func NewFileAuditor(path string, logger *logrus.Logger) (*FileAuditor, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &FileAuditor{file: f, enc: json.NewEncoder(f), logger: logger}, nil
+}
+
+// Record appends e to the file. Write failures are logged with the entry
+// so the action is not lost.
+func (a *FileAuditor) Record(e Entry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(e); err != nil {
+		a.logger.WithField("entry", e).Errorf("Failed to write audit log: %v", err)
+	}
+}
+
+// Close closes the file
+func (a *FileAuditor) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
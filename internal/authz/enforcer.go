@@ -0,0 +1,168 @@
+package authz
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/adron/golang-services-build-base/internal/auth"
+	"github.com/adron/golang-services-build-base/internal/httpjson"
+	"github.com/adron/golang-services-build-base/internal/middleware"
+)
+
+// RolesClaim is the JWT claim holding the caller's roles, as a list or a
+// space-separated string
+const RolesClaim = "roles"
+
+// anonymousSubject is recorded for requests allowed without authentication
+const anonymousSubject = "anonymous"
+
+// Enforcer checks the authenticated caller's roles against the policy for
+// each protected route
+type Enforcer struct {
+	policy    *Policy
+	auditor   Auditor
+	anonymous bool
+	denials   metric.Int64Counter
+}
+
+// Option customizes an Enforcer
+type Option func(*Enforcer)
+
+// WithAuditor records privileged actions and denials through a
+func WithAuditor(a Auditor) Option {
+	return func(e *Enforcer) {
+		e.auditor = a
+	}
+}
+
+// WithAnonymous allows requests that carry no principal, for running with
+// authentication disabled. Privileged actions are still audited.
+func WithAnonymous() Option {
+	return func(e *Enforcer) {
+		e.anonymous = true
+	}
+}
+
+// New creates an Enforcer for policy
+func New(policy *Policy, opts ...Option) *Enforcer {
+	denials, _ := noop.NewMeterProvider().Meter("").Int64Counter("authz.denials")
+	e := &Enforcer{policy: policy, denials: denials}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// RegisterMetrics counts denied requests on meter
+func (e *Enforcer) RegisterMetrics(meter metric.Meter) error {
+	denials, err := meter.Int64Counter("authz.denials",
+		metric.WithDescription("Requests denied for lacking a permission"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create denial counter: %w", err)
+	}
+	e.denials = denials
+	return nil
+}
+
+// Roles returns the caller's roles from the policy's subjects and, for
+// tokens, the roles claim
+func (e *Enforcer) Roles(p *auth.Principal) []string {
+	set := make(map[string]bool)
+	for _, role := range e.policy.SubjectRoles(p.Subject) {
+		set[role] = true
+	}
+	switch claim := p.Claims[RolesClaim].(type) {
+	case []interface{}:
+		for _, v := range claim {
+			if role, ok := v.(string); ok {
+				set[role] = true
+			}
+		}
+	case string:
+		for _, role := range strings.Fields(claim) {
+			set[role] = true
+		}
+	}
+
+	roles := make([]string, 0, len(set))
+	for role := range set {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// Require wraps next so it only runs for callers granted permission.
+// Requests on paths exempt from authentication are allowed. Denials get a
+// JSON 403; privileged actions and denials are audited.
+func (e *Enforcer) Require(permission string, next http.Handler) http.Handler {
+	privileged := Privileged(permission)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := Entry{
+			Time:       time.Now(),
+			Subject:    anonymousSubject,
+			Action:     permission,
+			Target:     r.Method + " " + r.URL.Path,
+			RemoteAddr: r.RemoteAddr,
+		}
+		span := trace.SpanFromContext(r.Context())
+		if sc := span.SpanContext(); sc.HasTraceID() {
+			entry.TraceID = sc.TraceID().String()
+		}
+
+		principal, ok := auth.FromContext(r.Context())
+		switch {
+		case ok:
+			entry.Subject = principal.Subject
+			entry.AuthMethod = principal.Method
+			entry.Roles = e.Roles(principal)
+			entry.Allowed = e.policy.Allows(entry.Roles, permission)
+		case e.anonymous || auth.IsExempt(r.Context()):
+			entry.Allowed = true
+		}
+		span.SetAttributes(
+			attribute.String("authz.permission", permission),
+			attribute.Bool("authz.allowed", entry.Allowed),
+		)
+
+		if !entry.Allowed {
+			e.denials.Add(r.Context(), 1, metric.WithAttributes(
+				attribute.String("permission", permission),
+				attribute.String("auth.method", entry.AuthMethod),
+			))
+			entry.Status = http.StatusForbidden
+			e.audit(entry)
+			writeForbidden(w, fmt.Sprintf("%s lacks permission %s", entry.Subject, permission))
+			return
+		}
+		if !privileged {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := middleware.NewStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+		entry.Status = rec.Status
+		e.audit(entry)
+	})
+}
+
+func (e *Enforcer) audit(entry Entry) {
+	if e.auditor != nil {
+		e.auditor.Record(entry)
+	}
+}
+
+func writeForbidden(w http.ResponseWriter, message string) {
+	httpjson.WriteError(w, http.StatusForbidden, "forbidden", message)
+}
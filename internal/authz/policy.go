@@ -0,0 +1,209 @@
+package authz
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Built-in roles
+const (
+	RoleOperator   = "operator"
+	RoleIntegrator = "integrator"
+	RoleViewer     = "viewer"
+)
+
+// Permissions checked by the service's routes. A permission is
+// "resource:action"; any action other than read is privileged and audited.
+const (
+	PermMetricsRead  = "metrics:read"
+	PermServiceRead  = "service:read"
+	PermServiceWrite = "service:write"
+	PermConfigRead   = "config:read"
+	PermConfigWrite  = "config:write"
+	PermZonesRead    = "zones:read"
+	PermZonesWrite   = "zones:write"
+	PermFramesRead   = "frames:read"
+	PermFramesWrite  = "frames:write"
+	PermDebugRead    = "debug:read"
+)
+
+// Role grants permissions directly and through the roles it inherits
+type Role struct {
+	Inherits    []string `yaml:"inherits"`
+	Permissions []string `yaml:"permissions"`
+}
+
+// Policy maps roles to permissions and callers to roles. Subjects assigns
+// roles to API key names and token subjects; tokens may also carry roles
+// in a "roles" claim.
+type Policy struct {
+	Roles    map[string]Role     `yaml:"roles"`
+	Subjects map[string][]string `yaml:"subjects"`
+
+	// granted is each role's permissions with inheritance resolved
+	granted map[string][]string
+}
+
+// DefaultPolicy is used when no policy file is configured. Viewers read
+// metrics, status and zones, integrators also feed frames and set up
+// zones, and operators may do anything.
+func DefaultPolicy() *Policy {
+	p := &Policy{
+		Roles: map[string]Role{
+			RoleViewer: {Permissions: []string{
+				PermMetricsRead, PermServiceRead, PermZonesRead,
+			}},
+			RoleIntegrator: {Inherits: []string{RoleViewer}, Permissions: []string{
+				PermFramesRead, PermFramesWrite, PermZonesWrite,
+			}},
+			RoleOperator: {Permissions: []string{"*"}},
+		},
+	}
+	if err := p.resolve(); err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// LoadPolicy reads a YAML policy file. Unknown keys, undefined roles,
+// inheritance cycles and malformed permissions are errors.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	p := &Policy{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse policy file %s: %w", path, err)
+	}
+	if err := p.resolve(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Allows reports whether any of roles grants permission
+func (p *Policy) Allows(roles []string, permission string) bool {
+	for _, role := range roles {
+		for _, granted := range p.granted[role] {
+			if matches(granted, permission) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Permissions returns the permissions role grants, including inherited ones
+func (p *Policy) Permissions(role string) []string {
+	return p.granted[role]
+}
+
+// Assign gives subject a role in addition to those in the policy
+func (p *Policy) Assign(subject, role string) error {
+	if _, ok := p.Roles[role]; !ok {
+		return fmt.Errorf("subject %s: undefined role %q", subject, role)
+	}
+	for _, r := range p.Subjects[subject] {
+		if r == role {
+			return nil
+		}
+	}
+	if p.Subjects == nil {
+		p.Subjects = make(map[string][]string)
+	}
+	p.Subjects[subject] = append(p.Subjects[subject], role)
+	return nil
+}
+
+// SubjectRoles returns the roles assigned to subject in the policy
+func (p *Policy) SubjectRoles(subject string) []string {
+	return p.Subjects[subject]
+}
+
+// resolve validates the policy and flattens role inheritance
+func (p *Policy) resolve() error {
+	for role, r := range p.Roles {
+		for _, perm := range r.Permissions {
+			if !validPermission(perm) {
+				return fmt.Errorf("role %s: permission %q must be resource:action, resource:* or *", role, perm)
+			}
+		}
+	}
+	for subject, roles := range p.Subjects {
+		for _, role := range roles {
+			if _, ok := p.Roles[role]; !ok {
+				return fmt.Errorf("subject %s: undefined role %q", subject, role)
+			}
+		}
+	}
+
+	p.granted = make(map[string][]string, len(p.Roles))
+	for role := range p.Roles {
+		set := make(map[string]bool)
+		if err := p.collect(role, set, nil); err != nil {
+			return err
+		}
+		perms := make([]string, 0, len(set))
+		for perm := range set {
+			perms = append(perms, perm)
+		}
+		sort.Strings(perms)
+		p.granted[role] = perms
+	}
+	return nil
+}
+
+func (p *Policy) collect(role string, set map[string]bool, path []string) error {
+	for _, seen := range path {
+		if seen == role {
+			return fmt.Errorf("role inheritance cycle: %s", strings.Join(append(path, role), " -> "))
+		}
+	}
+	r, ok := p.Roles[role]
+	if !ok {
+		return fmt.Errorf("role %s inherits undefined role %q", path[len(path)-1], role)
+	}
+	for _, perm := range r.Permissions {
+		set[perm] = true
+	}
+	for _, parent := range r.Inherits {
+		if err := p.collect(parent, set, append(path, role)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Privileged reports whether permission changes state and must be audited
+func Privileged(permission string) bool {
+	_, action, _ := strings.Cut(permission, ":")
+	return action != "read"
+}
+
+func validPermission(perm string) bool {
+	if perm == "*" {
+		return true
+	}
+	resource, action, ok := strings.Cut(perm, ":")
+	return ok && resource != "" && action != "" && !strings.Contains(action, ":")
+}
+
+// matches reports whether a granted permission covers the requested one
+func matches(granted, requested string) bool {
+	if granted == "*" || granted == requested {
+		return true
+	}
+	resource, action, _ := strings.Cut(granted, ":")
+	return action == "*" && strings.HasPrefix(requested, resource+":")
+}
@@ -0,0 +1,207 @@
+package console
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adron/golang-services-build-base/internal/service"
+)
+
+// command is one console command. Aliases keep the single-letter keys of
+// the original control loop working.
+type command struct {
+	name    string
+	aliases []string
+	usage   string
+	help    string
+	// privileged commands change the service and are passed to the audit hook
+	privileged bool
+	run        func(c *Console, ctx context.Context, args []string) (time.Duration, bool)
+}
+
+// commands lists the console commands in help order. It is filled in init
+// because help refers back to it.
+var commands []command
+
+var commandIndex = map[string]command{}
+
+func init() {
+	commands = []command{
+		{name: "status", usage: "status", help: "Show the status panel (also shown on an empty line)", run: (*Console).cmdStatus},
+		{name: "watch", usage: "watch [seconds]", help: "Refresh the status panel until Enter is pressed", run: (*Console).cmdWatch},
+		{name: "start", aliases: []string{"s"}, usage: "start", help: "Start the service", privileged: true, run: (*Console).cmdStart},
+		{name: "stop", aliases: []string{"q"}, usage: "stop", help: "Drain requests and stop the service", privileged: true, run: (*Console).cmdStop},
+		{name: "restart", aliases: []string{"r"}, usage: "restart", help: "Stop and start the service", privileged: true, run: (*Console).cmdRestart},
+		{name: "reload", usage: "reload", help: "Reload the configuration file and environment", privileged: true, run: (*Console).cmdReload},
+		{name: "loglevel", usage: "loglevel <level>", help: "Change the log level (trace, debug, info, warn, error)", privileged: true, run: (*Console).cmdLogLevel},
+		{name: "logs", usage: "logs [n]", help: "Show the last n log lines", run: (*Console).cmdLogs},
+		{name: "snapshot", usage: "snapshot", help: "Write the status panel to a JSON file", run: (*Console).cmdSnapshot},
+		{name: "history", usage: "history", help: "List previous commands; rerun with !n or !!", run: (*Console).cmdHistory},
+		{name: "help", aliases: []string{"?"}, usage: "help", help: "Show this help", run: (*Console).cmdHelp},
+		{name: "exit", aliases: []string{"x", "quit"}, usage: "exit", help: "Stop the service and exit", privileged: true, run: (*Console).cmdExit},
+	}
+
+	for _, cmd := range commands {
+		commandIndex[cmd.name] = cmd
+		for _, alias := range cmd.aliases {
+			commandIndex[alias] = cmd
+		}
+	}
+}
+
+func (c *Console) cmdStatus(ctx context.Context, args []string) (time.Duration, bool) {
+	c.render(false)
+	return 0, false
+}
+
+func (c *Console) cmdWatch(ctx context.Context, args []string) (time.Duration, bool) {
+	interval := 2 * time.Second
+	if len(args) > 0 {
+		seconds, err := strconv.Atoi(args[0])
+		if err != nil || seconds < 1 {
+			c.printf("Usage: watch [seconds]\n")
+			return 0, false
+		}
+		interval = time.Duration(seconds) * time.Second
+	}
+	return interval, false
+}
+
+func (c *Console) cmdStart(ctx context.Context, args []string) (time.Duration, bool) {
+	if err := c.svc.Start(ctx); err != nil {
+		c.printf("Failed to start service: %v\n", err)
+		return 0, false
+	}
+	c.printf("Service started successfully\n")
+	return 0, false
+}
+
+func (c *Console) cmdStop(ctx context.Context, args []string) (time.Duration, bool) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	err := c.stop(ctx)
+	if errors.Is(err, service.ErrNotRunning) {
+		c.printf("Service is not running\n")
+		return 0, false
+	}
+	if err != nil {
+		c.printf("Failed to stop service: %v\n", err)
+		return 0, false
+	}
+	c.printf("Service stopped successfully\n")
+	return 0, false
+}
+
+func (c *Console) cmdRestart(ctx context.Context, args []string) (time.Duration, bool) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	if err := c.svc.Restart(ctx); err != nil {
+		c.printf("Failed to restart service: %v\n", err)
+		return 0, false
+	}
+	c.printf("Service restarted successfully\n")
+	return 0, false
+}
+
+func (c *Console) cmdReload(ctx context.Context, args []string) (time.Duration, bool) {
+	if c.reload == nil {
+		c.printf("Reload is not available\n")
+		return 0, false
+	}
+	result := c.reload("console")
+	if !result.Success {
+		c.printf("Reload failed: %s\n", result.Error)
+		return 0, false
+	}
+	if len(result.Applied) == 0 && len(result.Rejected) == 0 {
+		c.printf("Configuration unchanged\n")
+	}
+	if len(result.Applied) > 0 {
+		c.printf("Applied: %s\n", strings.Join(result.Applied, ", "))
+	}
+	for _, rejected := range result.Rejected {
+		c.printf("Not applied: %s (%s)\n", rejected.Field, rejected.Reason)
+	}
+	return 0, false
+}
+
+func (c *Console) cmdLogLevel(ctx context.Context, args []string) (time.Duration, bool) {
+	if c.setLogLevel == nil {
+		c.printf("Changing the log level is not available\n")
+		return 0, false
+	}
+	if len(args) != 1 {
+		c.printf("Usage: loglevel <level>\n")
+		return 0, false
+	}
+	if err := c.setLogLevel(args[0]); err != nil {
+		c.printf("Failed to set log level: %v\n", err)
+		return 0, false
+	}
+	c.printf("Log level set to %s\n", args[0])
+	return 0, false
+}
+
+func (c *Console) cmdLogs(ctx context.Context, args []string) (time.Duration, bool) {
+	if c.logs == nil {
+		c.printf("Log tail is not available\n")
+		return 0, false
+	}
+	n := 50
+	if len(args) > 0 {
+		parsed, err := strconv.Atoi(args[0])
+		if err != nil || parsed < 1 {
+			c.printf("Usage: logs [n]\n")
+			return 0, false
+		}
+		n = parsed
+	}
+	for _, line := range c.logs.Lines(n) {
+		c.printf("%s\n", line)
+	}
+	return 0, false
+}
+
+func (c *Console) cmdSnapshot(ctx context.Context, args []string) (time.Duration, bool) {
+	path, err := c.writeSnapshot()
+	if err != nil {
+		c.printf("Failed to write snapshot: %v\n", err)
+		return 0, false
+	}
+	c.printf("Snapshot written to %s\n", path)
+	return 0, false
+}
+
+func (c *Console) cmdHistory(ctx context.Context, args []string) (time.Duration, bool) {
+	for i, line := range c.history {
+		c.printf("%4d  %s\n", i+1, line)
+	}
+	return 0, false
+}
+
+func (c *Console) cmdHelp(ctx context.Context, args []string) (time.Duration, bool) {
+	c.printf("Commands:\n")
+	for _, cmd := range commands {
+		usage := cmd.usage
+		if len(cmd.aliases) > 0 {
+			usage += " (" + strings.Join(cmd.aliases, ", ") + ")"
+		}
+		c.printf("  %-26s %s\n", usage, cmd.help)
+	}
+	return 0, false
+}
+
+func (c *Console) cmdExit(ctx context.Context, args []string) (time.Duration, bool) {
+	if c.svc.Status().State == service.Running {
+		ctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+		if err := c.stop(ctx); err != nil {
+			c.printf("Failed to stop service: %v\n", err)
+		}
+	}
+	c.printf("Exiting...\n")
+	return 0, true
+}
@@ -0,0 +1,385 @@
+package console
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adron/golang-services-build-base/internal/reload"
+	"github.com/adron/golang-services-build-base/internal/service"
+)
+
+// Service is the lifecycle controlled from the console
+type Service interface {
+	Status() service.Status
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+	Restart(ctx context.Context) error
+	Errors() <-chan error
+}
+
+// Component is one camera, queue or other unit of work shown in the status panel
+type Component struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	State  string `json:"state"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ComponentSource reports the current status of a group of components
+type ComponentSource func() []Component
+
+// LogTail returns up to n recent log lines, oldest first
+type LogTail interface {
+	Lines(n int) []string
+}
+
+// Snapshot is the status panel as written by the snapshot command
+type Snapshot struct {
+	Time        time.Time      `json:"time"`
+	Service     service.Status `json:"service"`
+	Uptime      string         `json:"uptime"`
+	Requests    uint64         `json:"requests"`
+	RequestRate float64        `json:"request_rate"`
+	Components  []Component    `json:"components"`
+	Logs        []string       `json:"logs"`
+}
+
+// Option customizes a Console
+type Option func(*Console)
+
+// WithReload enables the reload command
+func WithReload(fn func(trigger string) reload.Result) Option {
+	return func(c *Console) {
+		c.reload = fn
+	}
+}
+
+// WithLogLevel enables the loglevel command
+func WithLogLevel(fn func(level string) error) Option {
+	return func(c *Console) {
+		c.setLogLevel = fn
+	}
+}
+
+// WithRequestCount provides the total request count used for the request rate
+func WithRequestCount(fn func() uint64) Option {
+	return func(c *Console) {
+		c.requests = fn
+	}
+}
+
+// WithLogTail shows recent log lines in the status panel
+func WithLogTail(tail LogTail) Option {
+	return func(c *Console) {
+		c.logs = tail
+	}
+}
+
+// WithComponents adds a source of camera or queue status to the panel
+func WithComponents(source ComponentSource) Option {
+	return func(c *Console) {
+		c.sources = append(c.sources, source)
+	}
+}
+
+// WithSnapshotDir sets where snapshot files are written
+func WithSnapshotDir(dir string) Option {
+	return func(c *Console) {
+		c.snapshotDir = dir
+	}
+}
+
+// WithTimeout bounds how long stop and restart wait for requests to drain
+func WithTimeout(d time.Duration) Option {
+	return func(c *Console) {
+		c.timeout = d
+	}
+}
+
+// WithStop makes the stop and exit commands call fn instead of stopping
+// the service directly, so the shutdown steps the service takes on a
+// signal also run when an operator stops it
+func WithStop(fn func(ctx context.Context) error) Option {
+	return func(c *Console) {
+		c.stop = fn
+	}
+}
+
+// WithAudit calls fn after each command that changes the service, such as
+// stop or loglevel, so console actions reach the audit log
+func WithAudit(fn func(command string, args []string)) Option {
+	return func(c *Console) {
+		c.audit = fn
+	}
+}
+
+// Console is a line-oriented control panel for running the service
+// interactively. It shows a status panel and accepts commands with history.
+type Console struct {
+	svc         Service
+	reload      func(trigger string) reload.Result
+	setLogLevel func(level string) error
+	requests    func() uint64
+	logs        LogTail
+	sources     []ComponentSource
+	snapshotDir string
+	timeout     time.Duration
+	stop        func(ctx context.Context) error
+	audit       func(command string, args []string)
+
+	started     time.Time
+	lastCount   uint64
+	lastSampled time.Time
+	history     []string
+	out         io.Writer
+}
+
+// logLines is how many log lines the status panel shows
+const logLines = 10
+
+// New creates a Console controlling svc
+func New(svc Service, opts ...Option) *Console {
+	c := &Console{
+		svc:         svc,
+		snapshotDir: ".",
+		timeout:     10 * time.Second,
+		stop:        svc.Stop,
+		started:     time.Now(),
+		out:         os.Stdout,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.lastSampled = c.started
+	return c
+}
+
+// Run reads commands from in until exit, end of input or ctx is cancelled
+func (c *Console) Run(ctx context.Context, in io.Reader, out io.Writer) error {
+	c.out = out
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	c.render(false)
+	c.printf("Type 'help' for commands.\n")
+	c.prompt()
+
+	var watch <-chan time.Time
+	var ticker *time.Ticker
+	stopWatch := func() {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, watch = nil, nil
+		}
+	}
+	defer stopWatch()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-c.svc.Errors():
+			c.printf("\nService failed: %v\n", err)
+			c.prompt()
+		case <-watch:
+			c.render(true)
+			c.printf("Press Enter to stop watching.\n")
+		case line, ok := <-lines:
+			if !ok {
+				return nil
+			}
+			if ticker != nil {
+				stopWatch()
+				c.prompt()
+				continue
+			}
+			interval, exit := c.Execute(ctx, line)
+			if exit {
+				return nil
+			}
+			if interval > 0 {
+				ticker = time.NewTicker(interval)
+				watch = ticker.C
+				c.render(true)
+				c.printf("Press Enter to stop watching.\n")
+				continue
+			}
+			c.prompt()
+		}
+	}
+}
+
+// Execute runs one command line. It returns a refresh interval when the
+// command starts watching the status panel, and exit when the console
+// should close.
+func (c *Console) Execute(ctx context.Context, line string) (watch time.Duration, exit bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		c.render(false)
+		return 0, false
+	}
+
+	if strings.HasPrefix(line, "!") {
+		recalled, err := c.recall(line[1:])
+		if err != nil {
+			c.printf("%v\n", err)
+			return 0, false
+		}
+		c.printf("%s\n", recalled)
+		line = recalled
+	}
+	c.history = append(c.history, line)
+
+	fields := strings.Fields(line)
+	name, args := fields[0], fields[1:]
+	cmd, ok := commandIndex[name]
+	if !ok {
+		c.printf("Unknown command %q. Type 'help' for commands.\n", name)
+		return 0, false
+	}
+	if cmd.privileged && c.audit != nil {
+		defer c.audit(cmd.name, args)
+	}
+	return cmd.run(c, ctx, args)
+}
+
+// recall resolves "!!" and "!n" against the command history
+func (c *Console) recall(ref string) (string, error) {
+	if len(c.history) == 0 {
+		return "", fmt.Errorf("history is empty")
+	}
+	if ref == "!" {
+		return c.history[len(c.history)-1], nil
+	}
+	n, err := strconv.Atoi(ref)
+	if err != nil || n < 1 || n > len(c.history) {
+		return "", fmt.Errorf("no history entry %q", ref)
+	}
+	return c.history[n-1], nil
+}
+
+// History returns the commands entered so far, oldest first
+func (c *Console) History() []string {
+	return append([]string(nil), c.history...)
+}
+
+// Snapshot captures the current status panel. It also advances the sample
+// used for the request rate.
+func (c *Console) Snapshot() Snapshot {
+	now := time.Now()
+	snap := Snapshot{
+		Time:       now,
+		Service:    c.svc.Status(),
+		Uptime:     now.Sub(c.started).Round(time.Second).String(),
+		Components: []Component{},
+		Logs:       []string{},
+	}
+
+	if c.requests != nil {
+		snap.Requests = c.requests()
+		if elapsed := now.Sub(c.lastSampled).Seconds(); elapsed > 0 {
+			snap.RequestRate = float64(snap.Requests-c.lastCount) / elapsed
+		}
+		c.lastCount = snap.Requests
+		c.lastSampled = now
+	}
+
+	for _, source := range c.sources {
+		snap.Components = append(snap.Components, source()...)
+	}
+	sort.SliceStable(snap.Components, func(i, j int) bool {
+		if snap.Components[i].Kind != snap.Components[j].Kind {
+			return snap.Components[i].Kind < snap.Components[j].Kind
+		}
+		return snap.Components[i].Name < snap.Components[j].Name
+	})
+
+	if c.logs != nil {
+		snap.Logs = c.logs.Lines(logLines)
+	}
+	return snap
+}
+
+// render prints the status panel, clearing the screen first when asked
+func (c *Console) render(clear bool) {
+	snap := c.Snapshot()
+	if clear {
+		c.printf("\033[H\033[2J")
+	}
+
+	c.printf("Computer Vision Service Control\n")
+	c.printf("==============================\n")
+	c.printf("State:    %s (for %s)\n", snap.Service.State, snap.Time.Sub(snap.Service.Since).Round(time.Second))
+	if snap.Service.Addr != "" {
+		c.printf("Address:  %s\n", snap.Service.Addr)
+	}
+	if snap.Service.LastError != "" {
+		c.printf("Error:    %s\n", snap.Service.LastError)
+	}
+	c.printf("Uptime:   %s\n", snap.Uptime)
+	if c.requests != nil {
+		c.printf("Requests: %d (%.1f/s)\n", snap.Requests, snap.RequestRate)
+	}
+
+	c.printf("\nComponents\n")
+	if len(snap.Components) == 0 {
+		c.printf("  (none registered)\n")
+	} else {
+		tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+		for _, comp := range snap.Components {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", comp.Kind, comp.Name, comp.State, comp.Detail)
+		}
+		tw.Flush()
+	}
+
+	if c.logs != nil {
+		c.printf("\nRecent logs\n")
+		for _, line := range snap.Logs {
+			c.printf("  %s\n", line)
+		}
+	}
+	c.printf("==============================\n")
+}
+
+// writeSnapshot saves the status panel as JSON and returns the file path
+func (c *Console) writeSnapshot() (string, error) {
+	snap := c.Snapshot()
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(c.snapshotDir, "snapshot-"+snap.Time.Format("20060102-150405")+".json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (c *Console) prompt() {
+	c.printf("> ")
+}
+
+func (c *Console) printf(format string, args ...interface{}) {
+	fmt.Fprintf(c.out, format, args...)
+}
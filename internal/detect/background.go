@@ -0,0 +1,230 @@
+package detect
+
+import (
+	"context"
+	"image"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/frames"
+)
+
+// ClassMotion is the class of everything the background detector reports,
+// since it finds change rather than recognizing objects
+const ClassMotion = "motion"
+
+// Background detects moving objects by comparing each frame with a running
+// average of the camera's earlier frames. It needs no model or GPU, so the
+// service can run end to end on any machine, and is the reference for
+// what a Detector returns.
+type Background struct {
+	cfg config.BackgroundConfig
+
+	mu     sync.Mutex
+	models map[string]*backgroundModel
+}
+
+// backgroundModel is one camera's background at the downscaled size
+type backgroundModel struct {
+	mu            sync.Mutex
+	width, height int
+	mean          []float32
+	updated       time.Time
+}
+
+// NewBackground creates a background subtraction detector
+func NewBackground(cfg config.BackgroundConfig) *Background {
+	return &Background{cfg: cfg, models: make(map[string]*backgroundModel)}
+}
+
+// Detect reports connected foreground regions as motion detections with
+// the fraction of their bounding box they fill as confidence. The first
+// frame from a camera, and the first after its resolution changes, only
+// seeds the background and yields no detections.
+func (b *Background) Detect(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+	scale := b.cfg.Downscale
+	gray, w, h := downscale(f.Image, scale)
+	if w == 0 || h == 0 {
+		return nil, nil
+	}
+
+	m := b.model(f.Camera)
+	m.mu.Lock()
+	m.updated = time.Now()
+	if m.width != w || m.height != h {
+		m.width, m.height, m.mean = w, h, gray
+		m.mu.Unlock()
+		return nil, nil
+	}
+	threshold := float32(b.cfg.Threshold)
+	rate := float32(b.cfg.LearningRate)
+	mask := make([]bool, len(gray))
+	for i, v := range gray {
+		d := v - m.mean[i]
+		mask[i] = d > threshold || d < -threshold
+		m.mean[i] += rate * d
+	}
+	m.mu.Unlock()
+
+	bounds := f.Image.Bounds()
+	minCells := (b.cfg.MinArea + scale*scale - 1) / (scale * scale)
+	var detections []frames.Detection
+	for _, blob := range findBlobs(mask, w, h) {
+		if blob.cells < minCells {
+			continue
+		}
+		box := image.Rect(blob.minX*scale, blob.minY*scale, (blob.maxX+1)*scale, (blob.maxY+1)*scale).
+			Add(bounds.Min).Intersect(bounds)
+		cellArea := (blob.maxX - blob.minX + 1) * (blob.maxY - blob.minY + 1)
+		detections = append(detections, frames.Detection{
+			Class: ClassMotion,
+			Box: frames.Box{
+				X:      float64(box.Min.X - bounds.Min.X),
+				Y:      float64(box.Min.Y - bounds.Min.Y),
+				Width:  float64(box.Dx()),
+				Height: float64(box.Dy()),
+			},
+			Confidence: float64(blob.cells) / float64(cellArea),
+		})
+	}
+	sort.SliceStable(detections, func(i, j int) bool {
+		return detections[i].Box.Width*detections[i].Box.Height > detections[j].Box.Width*detections[j].Box.Height
+	})
+	return detections, nil
+}
+
+// Reset forgets a camera's background, for when it has been moved
+func (b *Background) Reset(camera string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.models, camera)
+}
+
+// Expire forgets the backgrounds of cameras that have sent no frames for
+// maxAge. A camera that returns seeds a new background from its next frame.
+func (b *Background) Expire(now time.Time, maxAge time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for camera, m := range b.models {
+		m.mu.Lock()
+		idle := now.Sub(m.updated) > maxAge
+		m.mu.Unlock()
+		if idle {
+			delete(b.models, camera)
+		}
+	}
+}
+
+// Watch calls Expire every interval until ctx is done
+func (b *Background) Watch(ctx context.Context, interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.Expire(now, maxAge)
+		}
+	}
+}
+
+func (b *Background) model(camera string) *backgroundModel {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.models[camera]
+	if !ok {
+		m = &backgroundModel{}
+		b.models[camera] = m
+	}
+	return m
+}
+
+// downscale averages scale x scale blocks of luma, which also smooths out
+// sensor noise. Partial blocks at the right and bottom edges are dropped.
+func downscale(img image.Image, scale int) ([]float32, int, int) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx()/scale, bounds.Dy()/scale
+	out := make([]float32, w*h)
+	luma := lumaFunc(img)
+	norm := float32(scale * scale)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum float32
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					sum += luma(bounds.Min.X+x*scale+dx, bounds.Min.Y+y*scale+dy)
+				}
+			}
+			out[y*w+x] = sum / norm
+		}
+	}
+	return out, w, h
+}
+
+// lumaFunc returns a fast luma lookup for the image types frames decode to
+func lumaFunc(img image.Image) func(x, y int) float32 {
+	switch img := img.(type) {
+	case *image.YCbCr:
+		return func(x, y int) float32 { return float32(img.Y[img.YOffset(x, y)]) }
+	case *image.Gray:
+		return func(x, y int) float32 { return float32(img.Pix[img.PixOffset(x, y)]) }
+	case *image.RGBA:
+		return func(x, y int) float32 {
+			i := img.PixOffset(x, y)
+			return 0.299*float32(img.Pix[i]) + 0.587*float32(img.Pix[i+1]) + 0.114*float32(img.Pix[i+2])
+		}
+	case *image.NRGBA:
+		return func(x, y int) float32 {
+			i := img.PixOffset(x, y)
+			return 0.299*float32(img.Pix[i]) + 0.587*float32(img.Pix[i+1]) + 0.114*float32(img.Pix[i+2])
+		}
+	default:
+		return func(x, y int) float32 {
+			r, g, b, _ := img.At(x, y).RGBA()
+			return (0.299*float32(r) + 0.587*float32(g) + 0.114*float32(b)) / 257
+		}
+	}
+}
+
+// blob is a connected region of foreground cells
+type blob struct {
+	minX, minY, maxX, maxY int
+	cells                  int
+}
+
+// findBlobs labels 8-connected foreground regions of mask
+func findBlobs(mask []bool, w, h int) []blob {
+	seen := make([]bool, len(mask))
+	var blobs []blob
+	var stack []int
+	for start, fg := range mask {
+		if !fg || seen[start] {
+			continue
+		}
+		b := blob{minX: w, minY: h, maxX: -1, maxY: -1}
+		seen[start] = true
+		stack = append(stack[:0], start)
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%w, i/w
+			b.cells++
+			b.minX, b.maxX = min(b.minX, x), max(b.maxX, x)
+			b.minY, b.maxY = min(b.minY, y), max(b.maxY, y)
+			for ny := max(y-1, 0); ny <= min(y+1, h-1); ny++ {
+				for nx := max(x-1, 0); nx <= min(x+1, w-1); nx++ {
+					j := ny*w + nx
+					if mask[j] && !seen[j] {
+						seen[j] = true
+						stack = append(stack, j)
+					}
+				}
+			}
+		}
+		blobs = append(blobs, b)
+	}
+	return blobs
+}
@@ -0,0 +1,66 @@
+package detect
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/frames"
+	"github.com/adron/golang-services-build-base/internal/kserve"
+	"github.com/adron/golang-services-build-base/internal/plugin"
+)
+
+// Detector finds objects in a decoded frame. Implementations are called
+// from several pipeline workers at once and must be safe for concurrent
+// use. Detectors holding processes or connections also implement
+// io.Closer, and are closed when the service stops.
+type Detector interface {
+	Detect(ctx context.Context, f *frames.Frame) ([]frames.Detection, error)
+}
+
+// Func adapts a function to the Detector interface
+type Func func(ctx context.Context, f *frames.Frame) ([]frames.Detection, error)
+
+// Detect calls fn
+func (fn Func) Detect(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+	return fn(ctx, f)
+}
+
+// None accepts every frame and detects nothing
+var None Detector = Func(func(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+	return nil, nil
+})
+
+// New creates the detector cfg selects, with its metrics on meter. Plugin
+// detectors log the plugin's stderr to logger.
+func New(cfg config.DetectorConfig, logger *logrus.Logger, meter metric.Meter) (Detector, error) {
+	switch cfg.Type {
+	case "background":
+		return NewBackground(cfg.Background), nil
+	case "plugin":
+		host, err := plugin.Start(cfg.Plugin, logger)
+		if err != nil {
+			return nil, err
+		}
+		if err := host.RegisterMetrics(meter); err != nil {
+			logger.Errorf("Failed to register plugin metrics: %v", err)
+		}
+		return host, nil
+	case "kserve":
+		client, err := kserve.New(cfg.KServe)
+		if err != nil {
+			return nil, err
+		}
+		if err := client.RegisterMetrics(meter); err != nil {
+			logger.Errorf("Failed to register model server metrics: %v", err)
+		}
+		return client, nil
+	case "none":
+		return None, nil
+	default:
+		return nil, fmt.Errorf("unknown detector type %q", cfg.Type)
+	}
+}
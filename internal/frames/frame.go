@@ -0,0 +1,155 @@
+package frames
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register the JPEG decoder
+	_ "image/png"  // register the PNG decoder
+	"math"
+	"time"
+
+	"github.com/adron/golang-services-build-base/config"
+)
+
+// Formats accepted for uploads
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatRGB  = "rgb"
+)
+
+// Media types accepted for uploads. Raw RGB is packed 8-bit R, G, B
+// samples, row by row, with no header.
+const (
+	MediaTypeJPEG = "image/jpeg"
+	MediaTypePNG  = "image/png"
+	MediaTypeRGB  = "image/x-raw-rgb"
+)
+
+var (
+	// ErrTooLarge is returned for uploads over the byte, pixel or dimension limits
+	ErrTooLarge = errors.New("frame too large")
+	// ErrUnsupportedFormat is returned for media types other than JPEG, PNG and raw RGB
+	ErrUnsupportedFormat = errors.New("unsupported frame format")
+	// ErrMalformed is returned for uploads that do not decode
+	ErrMalformed = errors.New("malformed frame")
+)
+
+// Frame is a decoded image from a camera. Sequence counts the frames
+// accepted from the camera since the service started, starting at 1.
+type Frame struct {
+	Camera     string
+	Sequence   uint64
+	CapturedAt time.Time
+	ReceivedAt time.Time
+	Format     string
+	Image      image.Image
+}
+
+// Box is an axis-aligned rectangle in frame pixel coordinates, measured
+// from the top-left corner of the frame's image bounds
+type Box struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Area returns the box's area, or 0 if it is empty
+func (b Box) Area() float64 {
+	if b.Width <= 0 || b.Height <= 0 {
+		return 0
+	}
+	return b.Width * b.Height
+}
+
+// IoU returns the intersection over union of b and o, from 0 for disjoint
+// boxes to 1 for identical ones
+func (b Box) IoU(o Box) float64 {
+	w := math.Min(b.X+b.Width, o.X+o.Width) - math.Max(b.X, o.X)
+	h := math.Min(b.Y+b.Height, o.Y+o.Height) - math.Max(b.Y, o.Y)
+	if w <= 0 || h <= 0 {
+		return 0
+	}
+	inter := w * h
+	return inter / (b.Area() + o.Area() - inter)
+}
+
+// Detection is an object found in a frame. TrackID identifies the object
+// across the camera's frames once the tracker has confirmed it.
+type Detection struct {
+	Class      string  `json:"class"`
+	Box        Box     `json:"box"`
+	Confidence float64 `json:"confidence"`
+	TrackID    uint64  `json:"track_id,omitempty"`
+}
+
+// Decode decodes an upload of the given media type. Dimensions are checked
+// against cfg from the image header before any pixels are decoded, so a
+// small file declaring a huge image is rejected cheaply. Raw RGB has no
+// header, so its width and height must be given.
+func Decode(data []byte, mediaType string, width, height int, cfg config.FramesConfig) (image.Image, string, error) {
+	if len(data) > cfg.MaxBytes {
+		return nil, "", fmt.Errorf("%w: %d bytes exceeds the limit of %d", ErrTooLarge, len(data), cfg.MaxBytes)
+	}
+
+	switch mediaType {
+	case MediaTypeRGB:
+		img, err := decodeRGB(data, width, height, cfg)
+		return img, FormatRGB, err
+	case MediaTypeJPEG, MediaTypePNG:
+	default:
+		return nil, "", fmt.Errorf("%w: %q, use %s, %s or %s", ErrUnsupportedFormat, mediaType, MediaTypeJPEG, MediaTypePNG, MediaTypeRGB)
+	}
+
+	header, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if format != FormatJPEG && format != FormatPNG {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+	if err := checkDimensions(header.Width, header.Height, cfg); err != nil {
+		return nil, "", err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return img, format, nil
+}
+
+// decodeRGB wraps packed RGB samples in an RGBA image
+func decodeRGB(data []byte, width, height int, cfg config.FramesConfig) (image.Image, error) {
+	if width < 1 || height < 1 {
+		return nil, fmt.Errorf("%w: raw RGB frames need a positive width and height", ErrMalformed)
+	}
+	if err := checkDimensions(width, height, cfg); err != nil {
+		return nil, err
+	}
+	if want := width * height * 3; len(data) != want {
+		return nil, fmt.Errorf("%w: %dx%d RGB needs %d bytes, got %d", ErrMalformed, width, height, want, len(data))
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, j := 0, 0; i < len(data); i, j = i+3, j+4 {
+		img.Pix[j] = data[i]
+		img.Pix[j+1] = data[i+1]
+		img.Pix[j+2] = data[i+2]
+		img.Pix[j+3] = 0xff
+	}
+	return img, nil
+}
+
+// checkDimensions rejects frames wider, taller or with more pixels than cfg allows
+func checkDimensions(width, height int, cfg config.FramesConfig) error {
+	if width > cfg.MaxDimension || height > cfg.MaxDimension {
+		return fmt.Errorf("%w: %dx%d exceeds the maximum side of %d pixels", ErrTooLarge, width, height, cfg.MaxDimension)
+	}
+	if width*height > cfg.MaxPixels {
+		return fmt.Errorf("%w: %dx%d exceeds the limit of %d pixels", ErrTooLarge, width, height, cfg.MaxPixels)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package frames
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/adron/golang-services-build-base/internal/httpjson"
+)
+
+// CaptureTimeHeader carries when the camera captured an uploaded frame, in
+// RFC 3339 format. Frames without it are stamped with the time received.
+const CaptureTimeHeader = "X-Capture-Time"
+
+// ResultPath is the prefix of the URL a pending frame's result is polled at
+const ResultPath = "/v1/frames/"
+
+// CameraPattern is what a camera ID must match. Camera IDs label metrics,
+// so they are kept short and free of characters that need escaping.
+var CameraPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// IngestHandler accepts uploads at a route with a {camera} variable. Raw
+// RGB uploads give their size in width and height query parameters. The
+// response carries the detections if processing finishes within the sync
+// timeout; otherwise, or when the request has "Prefer: respond-async", it
+// is a 202 whose Location header is where to poll for the result.
+func (p *Pipeline) IngestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received := time.Now()
+		camera := mux.Vars(r)["camera"]
+		if !CameraPattern.MatchString(camera) {
+			p.fail(w, r, http.StatusBadRequest, "invalid_request", "camera ID must be 1 to 64 letters, digits, '.', '_' or '-'")
+			return
+		}
+
+		capturedAt := received
+		if v := r.Header.Get(CaptureTimeHeader); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				p.fail(w, r, http.StatusBadRequest, "invalid_request", CaptureTimeHeader+" must be an RFC 3339 timestamp")
+				return
+			}
+			capturedAt = t
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var width, height int
+		if mediaType == MediaTypeRGB {
+			var err error
+			width, height, err = rawDimensions(r)
+			if err == nil {
+				err = checkDimensions(width, height, p.cfg)
+			}
+			if err != nil {
+				p.failWith(w, r, err)
+				return
+			}
+		}
+
+		if r.ContentLength > int64(p.cfg.MaxBytes) {
+			p.failWith(w, r, fmt.Errorf("%w: %d bytes exceeds the limit of %d", ErrTooLarge, r.ContentLength, p.cfg.MaxBytes))
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(p.cfg.MaxBytes)))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				err = fmt.Errorf("%w: body exceeds the limit of %d bytes", ErrTooLarge, p.cfg.MaxBytes)
+			} else {
+				err = fmt.Errorf("%w: %v", ErrMalformed, err)
+			}
+			p.failWith(w, r, err)
+			return
+		}
+
+		img, format, err := Decode(data, mediaType, width, height, p.cfg)
+		if err != nil {
+			p.failWith(w, r, err)
+			return
+		}
+
+		job, err := p.Submit(r.Context(), &Frame{
+			Camera:     camera,
+			CapturedAt: capturedAt,
+			ReceivedAt: received,
+			Format:     format,
+			Image:      img,
+		})
+		if err != nil {
+			w.Header().Set("Retry-After", "1")
+			httpjson.WriteError(w, http.StatusServiceUnavailable, "overloaded", err.Error())
+			return
+		}
+
+		if !preferAsync(r) && p.cfg.SyncTimeout > 0 {
+			timer := time.NewTimer(p.cfg.SyncTimeout)
+			defer timer.Stop()
+			select {
+			case <-job.Done():
+				result, _ := p.Result(job.ID())
+				httpjson.Write(w, http.StatusOK, result)
+				return
+			case <-timer.C:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		result, _ := p.Result(job.ID())
+		w.Header().Set("Location", ResultPath+job.ID())
+		httpjson.Write(w, http.StatusAccepted, result)
+	})
+}
+
+// ResultHandler serves a frame's result at a route with an {id} variable
+func (p *Pipeline) ResultHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, ok := p.Result(mux.Vars(r)["id"])
+		if !ok {
+			httpjson.WriteError(w, http.StatusNotFound, "not_found", "no result for this frame, it may have expired")
+			return
+		}
+		httpjson.Write(w, http.StatusOK, result)
+	})
+}
+
+// rawDimensions reads the width and height query parameters of a raw upload
+func rawDimensions(r *http.Request) (int, int, error) {
+	q := r.URL.Query()
+	width, werr := strconv.Atoi(q.Get("width"))
+	height, herr := strconv.Atoi(q.Get("height"))
+	if werr != nil || herr != nil || width < 1 || height < 1 {
+		return 0, 0, fmt.Errorf("%w: raw RGB frames need positive width and height query parameters", ErrMalformed)
+	}
+	return width, height, nil
+}
+
+// preferAsync reports whether the caller asked not to wait for detections
+func preferAsync(r *http.Request) bool {
+	for _, v := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// failWith rejects an upload with the status matching a decode error
+func (p *Pipeline) failWith(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrTooLarge):
+		p.fail(w, r, http.StatusRequestEntityTooLarge, "too_large", err.Error())
+	case errors.Is(err, ErrUnsupportedFormat):
+		p.fail(w, r, http.StatusUnsupportedMediaType, "unsupported_format", err.Error())
+	default:
+		p.fail(w, r, http.StatusBadRequest, "malformed", err.Error())
+	}
+}
+
+func (p *Pipeline) fail(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	p.reject(r.Context(), code)
+	httpjson.WriteError(w, status, code, message)
+}
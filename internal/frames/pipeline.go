@@ -0,0 +1,410 @@
+package frames
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/adron/golang-services-build-base/config"
+)
+
+// Processing states reported for a frame
+const (
+	StatusPending = "pending"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+var (
+	// ErrQueueFull is returned by Submit while every queue slot is taken
+	ErrQueueFull = errors.New("frame queue is full")
+	// ErrClosed is returned by Submit once the pipeline is closing
+	ErrClosed = errors.New("frame pipeline is closed")
+	// ErrTooManyCameras is returned by Submit for a new camera while
+	// MaxCameras others have sent frames within the result TTL
+	ErrTooManyCameras = errors.New("too many cameras")
+)
+
+// ProcessFunc finds objects in a frame
+type ProcessFunc func(ctx context.Context, f *Frame) ([]Detection, error)
+
+// Result reports a frame and, once processed, its detections
+type Result struct {
+	ID         string      `json:"id"`
+	Camera     string      `json:"camera"`
+	Sequence   uint64      `json:"sequence"`
+	CapturedAt time.Time   `json:"captured_at"`
+	ReceivedAt time.Time   `json:"received_at"`
+	Format     string      `json:"format"`
+	Width      int         `json:"width"`
+	Height     int         `json:"height"`
+	Status     string      `json:"status"`
+	Detections []Detection `json:"detections"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// Job tracks a submitted frame through the pipeline. The frame is let go
+// once processed, so only the result is kept.
+type Job struct {
+	ctx  context.Context
+	done chan struct{}
+
+	// Guarded by the pipeline's mutex
+	frame   *Frame
+	camera  *camera
+	result  Result
+	expires time.Time
+}
+
+// ID returns the reference used to look up the job's result
+func (j *Job) ID() string {
+	return j.result.ID
+}
+
+// Done is closed once the frame has been processed
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// camera is the sequence state of one camera. While a worker is busy with
+// one of its frames, frames of the camera that other workers take off the
+// queue wait in backlog for that worker, so each camera's frames are
+// processed one at a time and in sequence.
+type camera struct {
+	sequence uint64
+	seen     time.Time
+	busy     bool
+	backlog  []*Job
+	// pending counts the camera's frames not yet processed
+	pending int
+}
+
+// Pipeline queues frames for a pool of workers running a ProcessFunc and
+// keeps each result for later lookup until it expires. Finished results
+// are kept for ResultTTL, and at most MaxResults of them. Frames from
+// different cameras are processed in parallel, and frames from the same
+// camera in the order they were submitted.
+type Pipeline struct {
+	cfg     config.FramesConfig
+	process ProcessFunc
+	logger  *logrus.Logger
+	queue   chan *Job
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	cameras map[string]*camera
+	jobs    map[string]*Job
+	// finished holds the IDs of processed jobs, oldest first
+	finished     []string
+	lastAccepted time.Time
+	// backlogged counts the jobs waiting in camera backlogs
+	backlogged int
+
+	processed metric.Int64Counter
+	rejected  metric.Int64Counter
+	duration  metric.Float64Histogram
+}
+
+// NewPipeline starts cfg.Workers workers running process on submitted frames
+func NewPipeline(cfg config.FramesConfig, process ProcessFunc, logger *logrus.Logger) *Pipeline {
+	meter := noop.NewMeterProvider().Meter("")
+	processed, _ := meter.Int64Counter("frames.processed")
+	rejected, _ := meter.Int64Counter("frames.rejected")
+	duration, _ := meter.Float64Histogram("frames.processing.duration")
+
+	p := &Pipeline{
+		cfg:       cfg,
+		process:   process,
+		logger:    logger,
+		queue:     make(chan *Job, cfg.QueueSize),
+		cameras:   make(map[string]*camera),
+		jobs:      make(map[string]*Job),
+		processed: processed,
+		rejected:  rejected,
+		duration:  duration,
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// RegisterMetrics records processed and rejected frames, processing time
+// and the queue depth on meter
+func (p *Pipeline) RegisterMetrics(meter metric.Meter) error {
+	processed, err := meter.Int64Counter("frames.processed",
+		metric.WithDescription("Frames processed by the pipeline, by status"),
+		metric.WithUnit("{frame}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create processed frame counter: %w", err)
+	}
+	rejected, err := meter.Int64Counter("frames.rejected",
+		metric.WithDescription("Frame uploads rejected before processing, by reason"),
+		metric.WithUnit("{frame}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create rejected frame counter: %w", err)
+	}
+	duration, err := meter.Float64Histogram("frames.processing.duration",
+		metric.WithDescription("Time from a frame being queued to its detections being ready"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create frame duration histogram: %w", err)
+	}
+	_, err = meter.Int64ObservableGauge("frames.queue.depth",
+		metric.WithDescription("Frames waiting for a pipeline worker"),
+		metric.WithUnit("{frame}"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(p.Depth()))
+			return nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create queue depth gauge: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.processed, p.rejected, p.duration = processed, rejected, duration
+	return nil
+}
+
+// reject counts an upload rejected for reason
+func (p *Pipeline) reject(ctx context.Context, reason string) {
+	p.mu.Lock()
+	rejected := p.rejected
+	p.mu.Unlock()
+	rejected.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", reason)))
+}
+
+// LastAccepted returns when a frame was last queued, or the zero time if
+// none has been
+func (p *Pipeline) LastAccepted() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastAccepted
+}
+
+// Depth returns the number of frames waiting for a worker
+func (p *Pipeline) Depth() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue) + p.backlogged
+}
+
+// Submit stamps f with the camera's next sequence number and queues it.
+// It never blocks: a full queue returns ErrQueueFull so the caller can
+// retry later. ctx carries the trace the frame is processed under.
+func (p *Pipeline) Submit(ctx context.Context, f *Frame) (*Job, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrClosed
+	}
+	now := time.Now()
+	p.sweep(now)
+
+	cam := p.cameras[f.Camera]
+	if cam == nil {
+		if len(p.cameras) >= p.cfg.MaxCameras {
+			p.forgetIdle(now)
+		}
+		if len(p.cameras) >= p.cfg.MaxCameras {
+			p.rejected.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", "too_many_cameras")))
+			return nil, fmt.Errorf("%w: %d cameras have sent frames recently", ErrTooManyCameras, len(p.cameras))
+		}
+		cam = &camera{}
+	}
+
+	f.Sequence = cam.sequence + 1
+	bounds := f.Image.Bounds()
+	job := &Job{
+		ctx:    context.WithoutCancel(ctx),
+		frame:  f,
+		camera: cam,
+		done:   make(chan struct{}),
+		result: Result{
+			ID:         newID(),
+			Camera:     f.Camera,
+			Sequence:   f.Sequence,
+			CapturedAt: f.CapturedAt,
+			ReceivedAt: f.ReceivedAt,
+			Format:     f.Format,
+			Width:      bounds.Dx(),
+			Height:     bounds.Dy(),
+			Status:     StatusPending,
+		},
+	}
+
+	// Backlogged jobs have left the queue but still count against its size
+	full := len(p.queue)+p.backlogged >= p.cfg.QueueSize
+	if !full {
+		select {
+		case p.queue <- job:
+		default:
+			full = true
+		}
+	}
+	if full {
+		p.rejected.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", "queue_full")))
+		return nil, ErrQueueFull
+	}
+	cam.sequence, cam.seen = f.Sequence, now
+	cam.pending++
+	p.lastAccepted = now
+	p.cameras[f.Camera] = cam
+	p.jobs[job.ID()] = job
+	return job, nil
+}
+
+// Result returns the result for a job ID while it is pending or has not
+// expired
+func (p *Pipeline) Result(id string) (Result, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	job, ok := p.jobs[id]
+	if !ok || (!job.expires.IsZero() && time.Now().After(job.expires)) {
+		return Result{}, false
+	}
+	return job.result, true
+}
+
+// Close stops accepting frames and waits for queued frames to be
+// processed, or for ctx to end
+func (p *Pipeline) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("frame pipeline did not drain: %w", ctx.Err())
+	}
+}
+
+// work takes frames off the queue. A frame whose camera another worker is
+// busy with is handed to that worker; otherwise this worker processes it
+// and then the camera's backlog.
+func (p *Pipeline) work() {
+	defer p.wg.Done()
+	for job := range p.queue {
+		cam := job.camera
+		p.mu.Lock()
+		if cam.busy {
+			cam.backlog = append(cam.backlog, job)
+			p.backlogged++
+			p.mu.Unlock()
+			continue
+		}
+		cam.busy = true
+		p.mu.Unlock()
+
+		for job != nil {
+			p.run(job)
+			p.mu.Lock()
+			cam.pending--
+			job = nil
+			if len(cam.backlog) > 0 {
+				job, cam.backlog = cam.backlog[0], cam.backlog[1:]
+				p.backlogged--
+			} else {
+				cam.busy = false
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// run processes one frame, publishes its result and lets the frame go
+func (p *Pipeline) run(job *Job) {
+	f := job.frame
+	detections, err := p.process(job.ctx, f)
+
+	p.mu.Lock()
+	job.frame = nil
+	if err != nil {
+		job.result.Status = StatusFailed
+		job.result.Error = err.Error()
+	} else {
+		if detections == nil {
+			detections = []Detection{}
+		}
+		job.result.Status = StatusDone
+		job.result.Detections = detections
+	}
+	now := time.Now()
+	job.expires = now.Add(p.cfg.ResultTTL)
+	p.finished = append(p.finished, job.ID())
+	p.sweep(now)
+	status := job.result.Status
+	processed, duration := p.processed, p.duration
+	p.mu.Unlock()
+
+	if err != nil {
+		p.logger.WithContext(job.ctx).WithFields(logrus.Fields{
+			"camera":   f.Camera,
+			"sequence": f.Sequence,
+		}).Warnf("Frame processing failed: %v", err)
+	}
+	close(job.done)
+	processed.Add(job.ctx, 1, metric.WithAttributes(attribute.String("status", status)))
+	duration.Record(job.ctx, time.Since(f.ReceivedAt).Seconds())
+}
+
+// sweep drops finished results that have expired, and the oldest beyond
+// MaxResults. Results finish in the order they expire, so only the front
+// of p.finished is visited. Pending jobs, at most the queue plus one per
+// worker, are never dropped. The caller holds p.mu.
+func (p *Pipeline) sweep(now time.Time) {
+	n := 0
+	for n < len(p.finished) {
+		job := p.jobs[p.finished[n]]
+		if len(p.finished)-n <= p.cfg.MaxResults && !now.After(job.expires) {
+			break
+		}
+		delete(p.jobs, p.finished[n])
+		n++
+	}
+	p.finished = p.finished[n:]
+}
+
+// forgetIdle drops the sequence state of cameras that have sent nothing
+// for ResultTTL and have no frames waiting or being processed. A camera
+// that returns starts again at sequence 1. The caller holds p.mu.
+func (p *Pipeline) forgetIdle(now time.Time) {
+	for id, cam := range p.cameras {
+		if now.Sub(cam.seen) > p.cfg.ResultTTL && cam.pending == 0 {
+			delete(p.cameras, id)
+		}
+	}
+}
+
+// newID returns a random reference for a job
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
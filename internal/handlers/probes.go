@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/adron/golang-services-build-base/internal/health"
+)
+
+// ProbeHandler serves a health report as JSON. It responds 503 when any
+// component is down so orchestrators stop routing traffic to the instance.
+type ProbeHandler struct {
+	probe func(ctx context.Context) health.Report
+}
+
+// NewLivenessHandler serves the registry's liveness checks
+func NewLivenessHandler(registry *health.Registry) *ProbeHandler {
+	return &ProbeHandler{probe: registry.Live}
+}
+
+// NewReadinessHandler serves the registry's readiness checks
+func NewReadinessHandler(registry *health.Registry) *ProbeHandler {
+	return &ProbeHandler{probe: registry.Ready}
+}
+
+func (h *ProbeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := h.probe(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !report.Healthy() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(report)
+}
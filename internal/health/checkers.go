@@ -0,0 +1,77 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+type checkerFunc struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+func (c checkerFunc) Name() string                    { return c.name }
+func (c checkerFunc) Check(ctx context.Context) error { return c.fn(ctx) }
+
+// NewChecker adapts a function to the Checker interface
+func NewChecker(name string, fn func(ctx context.Context) error) Checker {
+	return checkerFunc{name: name, fn: fn}
+}
+
+// NewCollectorChecker checks that the OTLP collector at endpoint accepts TCP connections
+func NewCollectorChecker(endpoint string) Checker {
+	return NewChecker("collector", func(ctx context.Context) error {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid collector endpoint: %w", err)
+		}
+		host := u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			host = net.JoinHostPort(u.Hostname(), port)
+		}
+
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", host)
+		if err != nil {
+			return fmt.Errorf("collector unreachable: %w", err)
+		}
+		return conn.Close()
+	})
+}
+
+// NewDiskSpaceChecker checks that the filesystem holding path has at least minFree bytes available
+func NewDiskSpaceChecker(path string, minFree uint64) Checker {
+	return NewChecker("disk", func(ctx context.Context) error {
+		free, err := freeBytes(path)
+		if err != nil {
+			return fmt.Errorf("failed to read free space for %s: %w", path, err)
+		}
+		if free < minFree {
+			return fmt.Errorf("%d MiB free on %s, need %d MiB", free>>20, path, minFree>>20)
+		}
+		return nil
+	})
+}
+
+// NewFreshnessChecker checks that lastSeen reports a time within maxAge,
+// for example the newest frame received from a camera.
+func NewFreshnessChecker(name string, maxAge time.Duration, lastSeen func() time.Time) Checker {
+	return NewChecker(name, func(ctx context.Context) error {
+		last := lastSeen()
+		if last.IsZero() {
+			return errors.New("nothing received yet")
+		}
+		if age := time.Since(last); age > maxAge {
+			return fmt.Errorf("last update %s ago exceeds %s", age.Round(time.Second), maxAge)
+		}
+		return nil
+	})
+}
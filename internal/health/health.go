@@ -0,0 +1,181 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Status values reported for components and for the overall report
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Checker reports whether one component of the service is healthy
+type Checker interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+// ComponentStatus is the latest result of a single Checker
+type ComponentStatus struct {
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	LatencyMS   float64    `json:"latency_ms"`
+	CheckedAt   time.Time  `json:"checked_at"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Report aggregates the status of every checker in a probe
+type Report struct {
+	Status     string            `json:"status"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Components []ComponentStatus `json:"components"`
+}
+
+// Healthy reports whether every component is up
+func (r Report) Healthy() bool {
+	return r.Status == StatusUp
+}
+
+// Registry runs named checkers for the liveness and readiness probes. Each
+// check is bounded by a timeout and its result is cached for a TTL so that
+// frequent probes don't hammer dependencies.
+type Registry struct {
+	mu        sync.RWMutex
+	timeout   time.Duration
+	ttl       time.Duration
+	liveness  []*entry
+	readiness []*entry
+}
+
+// NewRegistry creates a Registry with the given per-check timeout and result TTL
+func NewRegistry(timeout, ttl time.Duration) *Registry {
+	return &Registry{timeout: timeout, ttl: ttl}
+}
+
+// RegisterLiveness adds a checker to the liveness probe. Liveness checks
+// should only fail when restarting the process would help.
+func (r *Registry) RegisterLiveness(c Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.liveness = append(r.liveness, &entry{checker: c})
+}
+
+// RegisterReadiness adds a checker to the readiness probe
+func (r *Registry) RegisterReadiness(c Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readiness = append(r.readiness, &entry{checker: c})
+}
+
+// Live runs the liveness checkers
+func (r *Registry) Live(ctx context.Context) Report {
+	r.mu.RLock()
+	entries := r.liveness
+	r.mu.RUnlock()
+	return r.run(ctx, entries)
+}
+
+// Ready runs the readiness checkers
+func (r *Registry) Ready(ctx context.Context) Report {
+	r.mu.RLock()
+	entries := r.readiness
+	r.mu.RUnlock()
+	return r.run(ctx, entries)
+}
+
+func (r *Registry) run(ctx context.Context, entries []*entry) Report {
+	report := Report{
+		Status:     StatusUp,
+		Timestamp:  time.Now(),
+		Components: make([]ComponentStatus, len(entries)),
+	}
+
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		go func(i int, e *entry) {
+			defer wg.Done()
+			report.Components[i] = e.status(ctx, r.timeout, r.ttl)
+		}(i, e)
+	}
+	wg.Wait()
+
+	for _, c := range report.Components {
+		if c.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+// entry caches the latest result of one checker
+type entry struct {
+	checker Checker
+
+	mu          sync.Mutex
+	last        ComponentStatus
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// status returns the cached result if it is younger than ttl, otherwise it
+// runs the check. Holding the lock while checking collapses concurrent
+// probes into a single call.
+func (e *entry) status(ctx context.Context, timeout, ttl time.Duration) ComponentStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.last.CheckedAt.IsZero() && time.Since(e.last.CheckedAt) < ttl {
+		return e.last
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := runCheck(ctx, e.checker)
+	status := ComponentStatus{
+		Name:      e.checker.Name(),
+		Status:    StatusUp,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt: time.Now(),
+	}
+	if err != nil {
+		status.Status = StatusDown
+		e.lastError = err.Error()
+		e.lastErrorAt = status.CheckedAt
+	}
+	if e.lastError != "" {
+		lastErrorAt := e.lastErrorAt
+		status.LastError = e.lastError
+		status.LastErrorAt = &lastErrorAt
+	}
+
+	e.last = status
+	return status
+}
+
+// runCheck enforces the context deadline even for checkers that ignore it
+func runCheck(ctx context.Context, c Checker) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("check panicked: %v", p)
+			}
+		}()
+		done <- c.Check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("check timed out: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,31 @@
+// Package httpjson writes JSON responses and the error body shared by
+// the authentication, authorization, rate limiting, admin, frame and zone
+// handlers, so clients see one error format whichever of them failed the
+// request.
+package httpjson
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error is the JSON body of every error response. Error is a stable code
+// such as "not_found" and Message explains it to a person.
+type Error struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// Write sends v as JSON with status. Responses describe live state, so
+// they are marked as not cacheable.
+func Write(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// WriteError sends an Error body with status
+func WriteError(w http.ResponseWriter, status int, code, message string) {
+	Write(w, status, Error{Error: code, Message: message})
+}
@@ -0,0 +1,85 @@
+package kserve
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half_open"
+)
+
+// breaker stops calls to a failing server. It opens after threshold
+// consecutive failures, and once openFor has passed lets a single trial
+// call through: success closes it, failure opens it again.
+type breaker struct {
+	threshold int
+	openFor   time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func newBreaker(threshold int, openFor time.Duration, now func() time.Time) *breaker {
+	return &breaker{threshold: threshold, openFor: openFor, now: now, state: StateClosed}
+}
+
+// allow reports whether a call may go ahead
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case StateOpen:
+		if b.now().Sub(b.openedAt) < b.openFor {
+			return false
+		}
+		b.state = StateHalfOpen
+		b.trial = true
+		return true
+	case StateHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record reports the outcome of an allowed call
+func (b *breaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ok {
+		b.state, b.failures, b.trial = StateClosed, 0, false
+		return
+	}
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.threshold {
+		b.state, b.openedAt, b.trial = StateOpen, b.now(), false
+	}
+}
+
+// release gives back an allowed call that was never made
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateHalfOpen {
+		b.trial = false
+	}
+}
+
+// State returns the breaker's current state
+func (b *breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
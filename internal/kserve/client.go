@@ -0,0 +1,430 @@
+package kserve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/frames"
+	"github.com/adron/golang-services-build-base/internal/preprocess"
+	"github.com/adron/golang-services-build-base/internal/telemetry"
+)
+
+var (
+	// ErrCircuitOpen is returned without calling the server while the
+	// circuit breaker is open
+	ErrCircuitOpen = errors.New("model server circuit breaker is open")
+	// ErrClosed is returned once the client is closed
+	ErrClosed = errors.New("model server client is closed")
+)
+
+// maxResponseBytes bounds how much of a response is read
+const maxResponseBytes = 64 << 20
+
+// Option customizes a Client
+type Option func(*Client)
+
+// WithHTTPClient sends requests with hc instead of a default client
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// WithTracer creates request spans with tracer instead of the global provider's
+func WithTracer(tracer trace.Tracer) Option {
+	return func(c *Client) {
+		c.tracer = tracer
+	}
+}
+
+// Client implements detect.Detector by calling a model server over the
+// KServe v2 inference protocol. Frames arriving together are sent in one
+// batched request, and a circuit breaker fails frames fast while the
+// server is down.
+type Client struct {
+	cfg      config.KServeConfig
+	inferURL string
+	readyURL string
+	http     *http.Client
+	tracer   trace.Tracer
+	pre      *preprocess.Preprocessor
+	breaker  *breaker
+	queue    chan *call
+	done     chan struct{}
+	stopped  chan struct{}
+	wg       sync.WaitGroup
+	once     sync.Once
+
+	mu        sync.Mutex
+	duration  metric.Float64Histogram
+	batchSize metric.Int64Histogram
+	rejected  metric.Int64Counter
+}
+
+// call is a frame waiting for its detections
+type call struct {
+	ctx    context.Context
+	frame  *frames.Frame
+	result chan result
+}
+
+type result struct {
+	detections []frames.Detection
+	err        error
+}
+
+// New creates a client for cfg.Model on the server at cfg.URL
+func New(cfg config.KServeConfig, opts ...Option) (*Client, error) {
+	base, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid model server URL: %w", err)
+	}
+	model := base.JoinPath("v2", "models", cfg.Model)
+	if cfg.Version != "" {
+		model = model.JoinPath("versions", cfg.Version)
+	}
+
+	meter := noop.NewMeterProvider().Meter("")
+	duration, _ := meter.Float64Histogram("kserve.request.duration")
+	batchSize, _ := meter.Int64Histogram("kserve.batch.size")
+	rejected, _ := meter.Int64Counter("kserve.rejected")
+
+	c := &Client{
+		cfg:       cfg,
+		inferURL:  model.JoinPath("infer").String(),
+		readyURL:  model.JoinPath("ready").String(),
+		http:      &http.Client{},
+		tracer:    otel.Tracer("github.com/adron/golang-services-build-base/internal/kserve"),
+		pre:       preprocess.New(cfg.InputWidth, cfg.InputHeight, cfg.Preprocess),
+		breaker:   newBreaker(cfg.FailureThreshold, cfg.OpenTimeout, time.Now),
+		queue:     make(chan *call, cfg.MaxBatch),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+		duration:  duration,
+		batchSize: batchSize,
+		rejected:  rejected,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.wg.Add(1)
+	go c.batch()
+	return c, nil
+}
+
+// RegisterMetrics records request latency, batch sizes, frames rejected by
+// the open breaker and the breaker's state on meter
+func (c *Client) RegisterMetrics(meter metric.Meter) error {
+	duration, err := meter.Float64Histogram("kserve.request.duration",
+		metric.WithDescription("Duration of inference requests to the model server, by outcome"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create inference duration histogram: %w", err)
+	}
+	batchSize, err := meter.Int64Histogram("kserve.batch.size",
+		metric.WithDescription("Frames sent in each inference request"),
+		metric.WithUnit("{frame}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create batch size histogram: %w", err)
+	}
+	rejected, err := meter.Int64Counter("kserve.rejected",
+		metric.WithDescription("Frames failed without a request while the circuit breaker was open"),
+		metric.WithUnit("{frame}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create rejected frame counter: %w", err)
+	}
+	_, err = meter.Int64ObservableGauge("kserve.breaker.state",
+		metric.WithDescription("1 for the circuit breaker's current state, 0 otherwise"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			current := c.breaker.State()
+			for _, state := range []string{StateClosed, StateOpen, StateHalfOpen} {
+				var value int64
+				if state == current {
+					value = 1
+				}
+				o.Observe(value, metric.WithAttributes(attribute.String("state", state)))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create breaker state gauge: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.duration, c.batchSize, c.rejected = duration, batchSize, rejected
+	return nil
+}
+
+// BreakerState returns the circuit breaker's state
+func (c *Client) BreakerState() string {
+	return c.breaker.State()
+}
+
+// Detect queues f for the next batch and waits for its detections
+func (c *Client) Detect(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+	if !c.breaker.allow() {
+		c.mu.Lock()
+		rejected := c.rejected
+		c.mu.Unlock()
+		rejected.Add(ctx, 1, metric.WithAttributes(attribute.String("model", c.cfg.Model)))
+		return nil, ErrCircuitOpen
+	}
+
+	cl := &call{ctx: ctx, frame: f, result: make(chan result, 1)}
+	select {
+	case c.queue <- cl:
+	case <-ctx.Done():
+		c.breaker.release()
+		return nil, ctx.Err()
+	case <-c.done:
+		c.breaker.release()
+		return nil, ErrClosed
+	}
+
+	select {
+	case r := <-cl.result:
+		return r.detections, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.stopped:
+		select {
+		case r := <-cl.result:
+			return r.detections, r.err
+		default:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// Ready reports whether the server has the model loaded
+func (c *Client) Ready(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.readyURL, nil)
+	if err != nil {
+		return err
+	}
+	telemetry.Propagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("model server unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("model %s is not ready: HTTP %d", c.cfg.Model, resp.StatusCode)
+	}
+	return nil
+}
+
+// Close stops batching, waits for requests in flight and fails frames
+// still queued
+func (c *Client) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+		for len(c.queue) > 0 {
+			cl := <-c.queue
+			cl.result <- result{err: ErrClosed}
+		}
+		close(c.stopped)
+	})
+	return nil
+}
+
+// batch groups queued frames into requests of up to MaxBatch frames,
+// waiting up to BatchWait after the first for more to arrive
+func (c *Client) batch() {
+	defer c.wg.Done()
+	for {
+		var first *call
+		select {
+		case first = <-c.queue:
+		case <-c.done:
+			return
+		}
+
+		calls := []*call{first}
+		timer := time.NewTimer(c.cfg.BatchWait)
+	fill:
+		for len(calls) < c.cfg.MaxBatch {
+			select {
+			case next := <-c.queue:
+				calls = append(calls, next)
+			case <-timer.C:
+				break fill
+			case <-c.done:
+				break fill
+			}
+		}
+		timer.Stop()
+
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.send(calls)
+		}()
+	}
+}
+
+// send makes one inference request for calls and hands out the results.
+// The request continues the first frame's trace and links the others.
+func (c *Client) send(calls []*call) {
+	links := make([]trace.Link, 0, len(calls)-1)
+	for _, cl := range calls[1:] {
+		links = append(links, trace.Link{SpanContext: trace.SpanContextFromContext(cl.ctx)})
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(calls[0].ctx), c.cfg.Timeout)
+	defer cancel()
+	ctx, span := c.tracer.Start(ctx, "kserve infer",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("model", c.cfg.Model),
+			attribute.Int("batch.size", len(calls)),
+		),
+	)
+	defer span.End()
+
+	start := time.Now()
+	detections, err := c.infer(ctx, calls)
+	c.breaker.record(err == nil)
+
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+		if errors.Is(err, context.DeadlineExceeded) {
+			outcome = "timeout"
+			err = fmt.Errorf("model server did not answer within %s: %w", c.cfg.Timeout, err)
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	c.mu.Lock()
+	duration, batchSize := c.duration, c.batchSize
+	c.mu.Unlock()
+	attrs := metric.WithAttributes(attribute.String("model", c.cfg.Model))
+	duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+		attribute.String("model", c.cfg.Model),
+		attribute.String("outcome", outcome),
+	))
+	batchSize.Record(ctx, int64(len(calls)), attrs)
+
+	for i, cl := range calls {
+		if err != nil {
+			cl.result <- result{err: err}
+		} else {
+			cl.result <- result{detections: detections[i]}
+		}
+	}
+}
+
+// infer encodes the frames as one tensor, posts it and decodes the
+// detections for each frame
+func (c *Client) infer(ctx context.Context, calls []*call) ([][]frames.Detection, error) {
+	size := c.pre.Size()
+	data := make([]float32, len(calls)*size)
+	transforms := make([]preprocess.Transform, len(calls))
+	for i, cl := range calls {
+		transforms[i] = c.pre.Tensor(cl.frame.Image, data[i*size:(i+1)*size])
+	}
+	body, err := json.Marshal(InferRequest{
+		ID:      calls[0].frame.Camera + "-" + strconv.FormatUint(calls[0].frame.Sequence, 10),
+		Inputs:  []Tensor{{Name: c.cfg.InputName, Shape: c.pre.Shape(len(calls)), Datatype: "FP32", Data: data}},
+		Outputs: []OutputRequest{{Name: c.cfg.OutputName}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode inference request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.inferURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	telemetry.Propagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("inference request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes))
+	if resp.StatusCode != http.StatusOK {
+		var e ErrorResponse
+		dec.Decode(&e)
+		return nil, fmt.Errorf("model server returned HTTP %d: %s", resp.StatusCode, e.Error)
+	}
+	var out InferResponse
+	if err := dec.Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode inference response: %w", err)
+	}
+	return c.decode(&out, transforms)
+}
+
+// decode splits the [batch, detections, 6] output into each frame's
+// detections, mapping boxes from model input to frame coordinates with the
+// frame's transform. Rows with a score of zero or less are padding.
+func (c *Client) decode(out *InferResponse, transforms []preprocess.Transform) ([][]frames.Detection, error) {
+	t, ok := out.output(c.cfg.OutputName)
+	if !ok {
+		return nil, fmt.Errorf("inference response has no %s output", c.cfg.OutputName)
+	}
+	if len(t.Shape) != 3 || t.Shape[0] != len(transforms) || t.Shape[2] < 6 || len(t.Data) != t.Shape[0]*t.Shape[1]*t.Shape[2] {
+		return nil, fmt.Errorf("output %s has shape %v with %d values, want [%d, N, 6]", t.Name, t.Shape, len(t.Data), len(transforms))
+	}
+
+	rows, width := t.Shape[1], t.Shape[2]
+	all := make([][]frames.Detection, len(transforms))
+	for i, transform := range transforms {
+		detections := []frames.Detection{}
+		for r := 0; r < rows; r++ {
+			row := t.Data[(i*rows+r)*width:]
+			if row[4] <= 0 {
+				continue
+			}
+			x1, y1, x2, y2 := float64(row[0]), float64(row[1]), float64(row[2]), float64(row[3])
+			box := transform.Box(frames.Box{X: x1, Y: y1, Width: x2 - x1, Height: y2 - y1})
+			// Nothing is left of a box that only covered the padding
+			if box.Area() == 0 {
+				continue
+			}
+			detections = append(detections, frames.Detection{
+				Class:      c.className(int(row[5])),
+				Box:        box,
+				Confidence: float64(row[4]),
+			})
+		}
+		all[i] = detections
+	}
+	return all, nil
+}
+
+// className names a class index, falling back to the index itself
+func (c *Client) className(i int) string {
+	if i >= 0 && i < len(c.cfg.Classes) {
+		return c.cfg.Classes[i]
+	}
+	return strconv.Itoa(i)
+}
@@ -0,0 +1,45 @@
+package kserve
+
+// Tensor is an input or output tensor in the v2 inference protocol's JSON
+// encoding. Data holds the elements flattened in row-major order.
+type Tensor struct {
+	Name     string    `json:"name"`
+	Shape    []int     `json:"shape"`
+	Datatype string    `json:"datatype"`
+	Data     []float32 `json:"data"`
+}
+
+// OutputRequest names an output the server should return
+type OutputRequest struct {
+	Name string `json:"name"`
+}
+
+// InferRequest is the body of POST /v2/models/{model}/infer
+type InferRequest struct {
+	ID      string          `json:"id,omitempty"`
+	Inputs  []Tensor        `json:"inputs"`
+	Outputs []OutputRequest `json:"outputs,omitempty"`
+}
+
+// InferResponse is the body of a successful inference
+type InferResponse struct {
+	ModelName    string   `json:"model_name"`
+	ModelVersion string   `json:"model_version,omitempty"`
+	ID           string   `json:"id,omitempty"`
+	Outputs      []Tensor `json:"outputs"`
+}
+
+// ErrorResponse is the body of a failed request
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// output returns the named output tensor
+func (r *InferResponse) output(name string) (*Tensor, bool) {
+	for i := range r.Outputs {
+		if r.Outputs[i].Name == name {
+			return &r.Outputs[i], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,204 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// New creates the service's JSON logger. Entries logged with WithContext
+// carry the trace_id and span_id of the span in that context.
+func New(out io.Writer) *logrus.Logger {
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetOutput(out)
+	logger.SetLevel(logrus.InfoLevel)
+	logger.AddHook(TraceHook{})
+	return logger
+}
+
+// SetLevel parses a level name such as "debug" and applies it to logger
+func SetLevel(logger *logrus.Logger, level string) error {
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	logger.SetLevel(parsed)
+	return nil
+}
+
+// TraceHook adds the span context of the entry's context to every entry
+type TraceHook struct{}
+
+// Levels returns all levels
+func (TraceHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire adds trace_id and span_id fields when the entry has a valid span context
+func (TraceHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	sc := trace.SpanContextFromContext(entry.Context)
+	if !sc.IsValid() {
+		return nil
+	}
+	entry.Data["trace_id"] = sc.TraceID().String()
+	entry.Data["span_id"] = sc.SpanID().String()
+	return nil
+}
+
+// OTLPHook forwards log entries to an OpenTelemetry logger
+type OTLPHook struct {
+	logger otellog.Logger
+}
+
+// NewOTLPHook creates a hook emitting to a logger from lp named after the service
+func NewOTLPHook(lp otellog.LoggerProvider, name string) *OTLPHook {
+	return &OTLPHook{logger: lp.Logger(name)}
+}
+
+// Levels returns all levels
+func (h *OTLPHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire converts the entry to a log record. The record is emitted with the
+// entry's context so the SDK attaches the active span context itself.
+func (h *OTLPHook) Fire(entry *logrus.Entry) error {
+	var record otellog.Record
+	record.SetTimestamp(entry.Time)
+	record.SetSeverity(severity(entry.Level))
+	record.SetSeverityText(entry.Level.String())
+	record.SetBody(otellog.StringValue(entry.Message))
+
+	for key, value := range entry.Data {
+		if key == "trace_id" || key == "span_id" {
+			continue
+		}
+		record.AddAttributes(attribute(key, value))
+	}
+
+	ctx := entry.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	h.logger.Emit(ctx, record)
+	return nil
+}
+
+func severity(level logrus.Level) otellog.Severity {
+	switch level {
+	case logrus.TraceLevel:
+		return otellog.SeverityTrace
+	case logrus.DebugLevel:
+		return otellog.SeverityDebug
+	case logrus.InfoLevel:
+		return otellog.SeverityInfo
+	case logrus.WarnLevel:
+		return otellog.SeverityWarn
+	case logrus.ErrorLevel:
+		return otellog.SeverityError
+	case logrus.FatalLevel:
+		return otellog.SeverityFatal
+	default:
+		return otellog.SeverityFatal4
+	}
+}
+
+func attribute(key string, value interface{}) otellog.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return otellog.String(key, v)
+	case bool:
+		return otellog.Bool(key, v)
+	case int:
+		return otellog.Int(key, v)
+	case int64:
+		return otellog.Int64(key, v)
+	case float64:
+		return otellog.Float64(key, v)
+	case error:
+		return otellog.String(key, v.Error())
+	default:
+		return otellog.String(key, fmt.Sprint(v))
+	}
+}
+
+// RingHook keeps the most recent log entries as formatted lines, for
+// display in the operator console.
+type RingHook struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+// NewRingHook creates a hook that retains the last size entries
+func NewRingHook(size int) *RingHook {
+	if size < 1 {
+		size = 1
+	}
+	return &RingHook{lines: make([]string, size)}
+}
+
+// Levels returns all levels
+func (h *RingHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire stores the entry as "15:04:05 LEVEL message key=value ..."
+func (h *RingHook) Fire(entry *logrus.Entry) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s %s", entry.Time.Format("15:04:05"), strings.ToUpper(entry.Level.String()), entry.Message)
+
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%v", key, entry.Data[key])
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lines[h.next] = b.String()
+	h.next = (h.next + 1) % len(h.lines)
+	if h.next == 0 {
+		h.full = true
+	}
+	return nil
+}
+
+// Lines returns up to n of the most recent lines, oldest first
+func (h *RingHook) Lines(n int) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	count := h.next
+	if h.full {
+		count = len(h.lines)
+	}
+	if n > count || n < 0 {
+		n = count
+	}
+
+	out := make([]string, 0, n)
+	for i := count - n; i < count; i++ {
+		idx := i
+		if h.full {
+			idx = (h.next + i) % len(h.lines)
+		}
+		out = append(out, h.lines[idx])
+	}
+	return out
+}
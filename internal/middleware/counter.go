@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// RequestCounter counts requests served so local tools such as the
+// operator console can show a request rate without scraping metrics.
+type RequestCounter struct {
+	count atomic.Uint64
+}
+
+// Middleware is a mux.MiddlewareFunc
+func (c *RequestCounter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.count.Add(1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Count returns the number of requests seen so far
+func (c *RequestCounter) Count() uint64 {
+	return c.count.Load()
+}
@@ -0,0 +1,246 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/auth"
+	"github.com/adron/golang-services-build-base/internal/httpjson"
+)
+
+// sweepInterval is how often buckets of idle clients are dropped
+const sweepInterval = time.Minute
+
+// RateLimiter gives each client a token bucket. Authenticated callers are
+// keyed by API key name or token subject, everyone else by IP address, so
+// clients behind one NAT share a bucket only until they authenticate.
+type RateLimiter struct {
+	rate      float64
+	burst     float64
+	exempt    func(path string) bool
+	throttled metric.Int64Counter
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter for cfg.RateLimit requests per second. A
+// zero RateBurst allows one second's worth of requests in a burst.
+func NewRateLimiter(cfg config.LimitsConfig, meter metric.Meter) (*RateLimiter, error) {
+	if cfg.RateLimit <= 0 {
+		return nil, fmt.Errorf("rate limit must be positive, got %g", cfg.RateLimit)
+	}
+	throttled, err := newThrottledCounter(meter)
+	if err != nil {
+		return nil, err
+	}
+	burst := float64(cfg.RateBurst)
+	if burst == 0 {
+		burst = math.Max(1, math.Ceil(cfg.RateLimit))
+	}
+	return &RateLimiter{
+		rate:      cfg.RateLimit,
+		burst:     burst,
+		exempt:    pathMatcher(cfg.ExemptPaths),
+		throttled: throttled,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}, nil
+}
+
+// Allow takes a token from key's bucket. When the bucket is empty it
+// returns false and how long until the next token.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.refill(key, time.Now())
+	if b.tokens < 1 {
+		return false, l.wait(b)
+	}
+	b.tokens--
+	return true, 0
+}
+
+// empty reports whether key's bucket is empty, without taking a token, and
+// how long until the next token
+func (l *RateLimiter) empty(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.buckets[key]; !ok {
+		return false, 0
+	}
+	b := l.refill(key, time.Now())
+	if b.tokens < 1 {
+		return true, l.wait(b)
+	}
+	return false, 0
+}
+
+// refill returns key's bucket topped up to now. The caller holds l.mu.
+func (l *RateLimiter) refill(key string, now time.Time) *bucket {
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	return b
+}
+
+// wait returns how long until b holds a whole token
+func (l *RateLimiter) wait(b *bucket) time.Duration {
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely, since a new bucket
+// for the same client would start out identical
+func (l *RateLimiter) sweep(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Clients returns the number of clients currently tracked
+func (l *RateLimiter) Clients() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// Middleware is a mux.MiddlewareFunc. It must run after authentication so
+// callers are keyed by identity rather than address.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.exempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		allowed, wait := l.Allow(clientKey(r))
+		if !allowed {
+			l.throttled.Add(r.Context(), 1, metric.WithAttributes(attribute.String("reason", "rate_limit")))
+			writeThrottled(w, http.StatusTooManyRequests, wait, "rate_limited",
+				fmt.Sprintf("rate limit of %g requests per second exceeded", l.rate))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientKey identifies the caller for rate limiting
+func clientKey(r *http.Request) string {
+	if p, ok := auth.FromContext(r.Context()); ok {
+		return p.Method + ":" + p.Subject
+	}
+	return addressKey(r)
+}
+
+// addressKey identifies the caller by IP address
+func addressKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// AuthFailureLimiter slows down credential guessing. Each IP address may
+// fail authentication AuthFailureLimit times per second, up to
+// AuthFailureBurst at once; beyond that its requests are refused before
+// any credential is checked. Callers that authenticate are not slowed.
+type AuthFailureLimiter struct {
+	failures  *RateLimiter
+	throttled metric.Int64Counter
+}
+
+// NewAuthFailureLimiter creates a limiter for cfg.AuthFailureLimit failed
+// authentications per second per IP address
+func NewAuthFailureLimiter(cfg config.LimitsConfig, meter metric.Meter) (*AuthFailureLimiter, error) {
+	limits := cfg
+	limits.RateLimit, limits.RateBurst = cfg.AuthFailureLimit, cfg.AuthFailureBurst
+	failures, err := NewRateLimiter(limits, meter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth failure limit: %w", err)
+	}
+	return &AuthFailureLimiter{failures: failures, throttled: failures.throttled}, nil
+}
+
+// Middleware is a mux.MiddlewareFunc. It must run before authentication,
+// whose 401 responses it counts.
+func (f *AuthFailureLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.failures.exempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := addressKey(r)
+		if empty, wait := f.failures.empty(key); empty {
+			f.throttled.Add(r.Context(), 1, metric.WithAttributes(attribute.String("reason", "auth_failures")))
+			writeThrottled(w, http.StatusTooManyRequests, wait, "rate_limited", "too many failed authentication attempts")
+			return
+		}
+		rec := NewStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+		if rec.Status == http.StatusUnauthorized {
+			f.failures.Allow(key)
+		}
+	})
+}
+
+func newThrottledCounter(meter metric.Meter) (metric.Int64Counter, error) {
+	throttled, err := meter.Int64Counter("http.server.throttled",
+		metric.WithDescription("Requests rejected by rate limiting or load shedding, by reason"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create throttled counter: %w", err)
+	}
+	return throttled, nil
+}
+
+// writeThrottled rejects a request with a JSON body and a Retry-After
+// header rounded up to whole seconds
+func writeThrottled(w http.ResponseWriter, status int, retryAfter time.Duration, code, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	httpjson.WriteError(w, status, code, message)
+}
+
+// pathMatcher reports whether a path equals, or is below, one of paths
+func pathMatcher(paths []string) func(string) bool {
+	return func(path string) bool {
+		for _, p := range paths {
+			p = strings.TrimSuffix(p, "/")
+			if path == p || strings.HasPrefix(path, p+"/") {
+				return true
+			}
+		}
+		return false
+	}
+}
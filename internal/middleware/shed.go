@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/adron/golang-services-build-base/config"
+)
+
+// LoadShedder rejects requests with a 503 while the service is saturated,
+// so callers back off instead of queueing behind work that will time out.
+type LoadShedder struct {
+	maxInFlight   int64
+	maxQueueDepth int
+	queueDepth    func() int
+	retryAfter    time.Duration
+	exempt        func(path string) bool
+	throttled     metric.Int64Counter
+
+	inFlight atomic.Int64
+}
+
+// NewLoadShedder creates a shedder for cfg's in-flight and queue depth
+// limits. queueDepth reports the frame pipeline's backlog and may be nil
+// until a pipeline is running.
+func NewLoadShedder(cfg config.LimitsConfig, queueDepth func() int, meter metric.Meter) (*LoadShedder, error) {
+	throttled, err := newThrottledCounter(meter)
+	if err != nil {
+		return nil, err
+	}
+	return &LoadShedder{
+		maxInFlight:   int64(cfg.MaxInFlight),
+		maxQueueDepth: cfg.MaxQueueDepth,
+		queueDepth:    queueDepth,
+		retryAfter:    cfg.RetryAfter,
+		exempt:        pathMatcher(cfg.ExemptPaths),
+		throttled:     throttled,
+	}, nil
+}
+
+// InFlight returns the number of requests currently being served
+func (s *LoadShedder) InFlight() int64 {
+	return s.inFlight.Load()
+}
+
+// Middleware is a mux.MiddlewareFunc. It runs before authentication so an
+// overloaded service does not spend time verifying tokens.
+func (s *LoadShedder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.exempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		n := s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+
+		reason, message := "", ""
+		switch {
+		case s.maxInFlight > 0 && n > s.maxInFlight:
+			reason, message = "in_flight", "too many requests in flight"
+		case s.maxQueueDepth > 0 && s.queueDepth != nil && s.queueDepth() >= s.maxQueueDepth:
+			reason, message = "queue_depth", "frame pipeline queue is full"
+		}
+		if reason != "" {
+			s.throttled.Add(r.Context(), 1, metric.WithAttributes(attribute.String("reason", reason)))
+			writeThrottled(w, http.StatusServiceUnavailable, s.retryAfter, "overloaded", message)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
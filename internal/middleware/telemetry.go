@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Telemetry instruments every request routed through a mux router with a
+// server span, a duration histogram, an in-flight gauge and a status-code
+// counter. Instruments are created once in NewTelemetry.
+type Telemetry struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	duration   metric.Float64Histogram
+	inFlight   metric.Int64UpDownCounter
+	requests   metric.Int64Counter
+}
+
+// NewTelemetry creates the middleware's instruments on meter
+func NewTelemetry(tracer trace.Tracer, meter metric.Meter, propagator propagation.TextMapPropagator) (*Telemetry, error) {
+	duration, err := meter.Float64Histogram("http.server.request.duration",
+		metric.WithDescription("Duration of HTTP server requests"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create duration histogram: %w", err)
+	}
+
+	inFlight, err := meter.Int64UpDownCounter("http.server.active_requests",
+		metric.WithDescription("Number of HTTP server requests in flight"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create in-flight gauge: %w", err)
+	}
+
+	requests, err := meter.Int64Counter("http.server.requests",
+		metric.WithDescription("Number of HTTP server requests by status code"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request counter: %w", err)
+	}
+
+	return &Telemetry{
+		tracer:     tracer,
+		propagator: propagator,
+		duration:   duration,
+		inFlight:   inFlight,
+		requests:   requests,
+	}, nil
+}
+
+// Middleware is a mux.MiddlewareFunc
+func (t *Telemetry) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := routeTemplate(r)
+
+		ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := t.tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		routeAttrs := metric.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.HTTPRoute(route),
+		)
+		t.inFlight.Add(ctx, 1, routeAttrs)
+		defer t.inFlight.Add(ctx, -1, routeAttrs)
+
+		rec := NewStatusRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.Status))
+		if rec.Status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.Status))
+		}
+
+		statusAttrs := metric.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.HTTPRoute(route),
+			attribute.Int("http.response.status_code", rec.Status),
+		)
+		t.duration.Record(ctx, time.Since(start).Seconds(), statusAttrs)
+		t.requests.Add(ctx, 1, statusAttrs)
+	})
+}
+
+// routeTemplate returns the matched route's path template so span names and
+// metric attributes stay low-cardinality, falling back to the raw path.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return r.URL.Path
+}
+
+// StatusRecorder captures the status code written by the wrapped handler,
+// for middleware that acts on the response's status
+type StatusRecorder struct {
+	http.ResponseWriter
+	// Status is the status written, or 200 when the handler wrote none
+	Status      int
+	wroteHeader bool
+}
+
+// NewStatusRecorder wraps w
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *StatusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.Status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *StatusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
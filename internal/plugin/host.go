@@ -0,0 +1,581 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/frames"
+)
+
+// ErrUnavailable is returned while the plugin is down and being restarted
+var ErrUnavailable = errors.New("detector plugin is not running")
+
+const (
+	// minBackoff and maxBackoff bound the wait between restart attempts
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+
+	// stopGrace is how long a plugin has to exit after its input is closed
+	stopGrace = 2 * time.Second
+)
+
+// Host runs a detector plugin as a child process and implements
+// detect.Detector by sending it frames. Requests are multiplexed by ID, so
+// a plugin may answer them out of order. A supervisor restarts the plugin
+// when it exits or fails a health check.
+type Host struct {
+	cfg     config.PluginConfig
+	logger  *logrus.Logger
+	done    chan struct{}
+	stopped chan struct{}
+
+	mu       sync.Mutex
+	current  *process
+	name     string
+	closed   bool
+	duration metric.Float64Histogram
+	errors   metric.Int64Counter
+	restarts metric.Int64Counter
+}
+
+// Start launches the plugin and waits for its hello, failing if it does
+// not answer within cfg.StartTimeout or speaks another protocol version
+func Start(cfg config.PluginConfig, logger *logrus.Logger) (*Host, error) {
+	meter := noop.NewMeterProvider().Meter("")
+	duration, _ := meter.Float64Histogram("plugin.request.duration")
+	errs, _ := meter.Int64Counter("plugin.errors")
+	restarts, _ := meter.Int64Counter("plugin.restarts")
+
+	h := &Host{
+		cfg:      cfg,
+		logger:   logger,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		name:     filepath.Base(cfg.Command),
+		duration: duration,
+		errors:   errs,
+		restarts: restarts,
+	}
+	p, err := h.launch()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start detector plugin %s: %w", cfg.Command, err)
+	}
+	h.current = p
+	logger.Infof("Detector plugin %s started", h.Name())
+	go h.supervise()
+	return h, nil
+}
+
+// RegisterMetrics records request latency, errors by kind, restarts and
+// whether the plugin is up on meter
+func (h *Host) RegisterMetrics(meter metric.Meter) error {
+	duration, err := meter.Float64Histogram("plugin.request.duration",
+		metric.WithDescription("Time for the detector plugin to answer a frame, by outcome"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create plugin duration histogram: %w", err)
+	}
+	errs, err := meter.Int64Counter("plugin.errors",
+		metric.WithDescription("Detector plugin failures, by kind"),
+		metric.WithUnit("{error}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create plugin error counter: %w", err)
+	}
+	restarts, err := meter.Int64Counter("plugin.restarts",
+		metric.WithDescription("Times the detector plugin was restarted"),
+		metric.WithUnit("{restart}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create plugin restart counter: %w", err)
+	}
+	_, err = meter.Int64ObservableGauge("plugin.up",
+		metric.WithDescription("1 while the detector plugin is running, 0 otherwise"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			var up int64
+			if h.current != nil {
+				up = 1
+			}
+			o.Observe(up, metric.WithAttributes(attribute.String("plugin", h.name)))
+			return nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create plugin up gauge: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.duration, h.errors, h.restarts = duration, errs, restarts
+	return nil
+}
+
+// Name returns the name the plugin gave in its hello
+func (h *Host) Name() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.name
+}
+
+// Ready reports whether the plugin is running. It fails while the plugin
+// is down and being restarted, when every frame would fail, including
+// the moment between a crash and the supervisor noticing it.
+func (h *Host) Ready(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.current == nil || h.current.failure() != nil {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// Detect sends f to the plugin as packed RGB and waits up to the
+// configured timeout for its detections
+func (h *Host) Detect(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+	h.mu.Lock()
+	p := h.current
+	h.mu.Unlock()
+	if p == nil {
+		h.countError(ctx, "unavailable")
+		return nil, ErrUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+	bounds := f.Image.Bounds()
+	start := time.Now()
+	resp, err := p.call(ctx, &Message{
+		Type:       TypeDetect,
+		Camera:     f.Camera,
+		Sequence:   f.Sequence,
+		CapturedAt: f.CapturedAt.Format(time.RFC3339Nano),
+		Width:      bounds.Dx(),
+		Height:     bounds.Dy(),
+	}, packRGB(f.Image))
+
+	outcome := "ok"
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		outcome = "timeout"
+		err = fmt.Errorf("detector plugin did not answer within %s", h.cfg.Timeout)
+	case err != nil:
+		outcome = "error"
+	case resp.Type == TypeError:
+		outcome = "error"
+		err = fmt.Errorf("detector plugin: %s", resp.Error)
+	case resp.Type != TypeResult:
+		outcome = "error"
+		err = fmt.Errorf("detector plugin answered a frame with a %s message", resp.Type)
+	}
+
+	h.mu.Lock()
+	duration, name := h.duration, h.name
+	h.mu.Unlock()
+	duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+		attribute.String("plugin", name),
+		attribute.String("outcome", outcome),
+	))
+	if err != nil {
+		h.countError(ctx, outcome)
+		return nil, err
+	}
+	return resp.Detections, nil
+}
+
+// Close stops supervising and shuts the plugin down
+func (h *Host) Close() error {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil
+	}
+	h.closed = true
+	close(h.done)
+	h.mu.Unlock()
+
+	<-h.stopped
+	h.mu.Lock()
+	p := h.current
+	h.current = nil
+	h.mu.Unlock()
+	if p != nil {
+		p.stop()
+	}
+	return nil
+}
+
+func (h *Host) countError(ctx context.Context, kind string) {
+	h.mu.Lock()
+	errs, name := h.errors, h.name
+	h.mu.Unlock()
+	errs.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("plugin", name),
+		attribute.String("kind", kind),
+	))
+}
+
+// supervise health checks the running plugin and restarts it when it
+// exits or stops answering, until the host is closed
+func (h *Host) supervise() {
+	defer close(h.stopped)
+	ticker := time.NewTicker(h.cfg.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		h.mu.Lock()
+		p := h.current
+		h.mu.Unlock()
+		if p == nil {
+			if !h.restart() {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-h.done:
+			return
+		case <-p.dead:
+			h.logger.Warnf("Detector plugin %s stopped: %v", h.Name(), p.failure())
+			h.countError(context.Background(), "crash")
+			h.drop(p)
+		case <-ticker.C:
+			if err := h.check(p); err != nil {
+				h.logger.Warnf("Detector plugin %s failed its health check: %v", h.Name(), err)
+				h.countError(context.Background(), "health")
+				h.drop(p)
+			}
+		}
+	}
+}
+
+// check pings p unless it answered a request within the last interval.
+// Plugins answer one message at a time, so a ping sent to a busy plugin
+// waits behind the frames queued before it; any answer that arrives while
+// it waits shows the plugin is still working through them.
+func (h *Host) check(p *process) error {
+	sent := time.Now()
+	if p.answeredSince(sent.Add(-h.cfg.HealthInterval)) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.cfg.Timeout)
+	defer cancel()
+	resp, err := p.call(ctx, &Message{Type: TypePing}, nil)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) && p.answeredSince(sent):
+		return nil
+	case err != nil:
+		return err
+	case resp.Type != TypePong:
+		return fmt.Errorf("answered a ping with a %s message", resp.Type)
+	}
+	return nil
+}
+
+// drop stops p and marks the plugin down
+func (h *Host) drop(p *process) {
+	h.mu.Lock()
+	if h.current == p {
+		h.current = nil
+	}
+	h.mu.Unlock()
+	p.stop()
+}
+
+// restart launches the plugin again, backing off exponentially between
+// attempts. It returns false if the host was closed first.
+func (h *Host) restart() bool {
+	backoff := minBackoff
+	for {
+		select {
+		case <-h.done:
+			return false
+		case <-time.After(backoff):
+		}
+
+		p, err := h.launch()
+		if err != nil {
+			h.logger.Warnf("Failed to restart detector plugin %s, retrying in %s: %v", h.Name(), backoff, err)
+			h.countError(context.Background(), "start")
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+
+		h.mu.Lock()
+		if h.closed {
+			h.mu.Unlock()
+			p.stop()
+			return false
+		}
+		h.current = p
+		restarts, name := h.restarts, h.name
+		h.mu.Unlock()
+		restarts.Add(context.Background(), 1, metric.WithAttributes(attribute.String("plugin", name)))
+		h.logger.Infof("Detector plugin %s restarted", name)
+		return true
+	}
+}
+
+// launch starts the plugin process, connects to it over the configured
+// transport and exchanges hellos
+func (h *Host) launch() (*process, error) {
+	cmd := exec.Command(h.cfg.Command, h.cfg.Args...)
+	stderr := h.logger.WithField("plugin", filepath.Base(h.cfg.Command)).WriterLevel(logrus.WarnLevel)
+	cmd.Stderr = stderr
+	p := &process{
+		cmd:     cmd,
+		exited:  make(chan struct{}),
+		dead:    make(chan struct{}),
+		pending: make(map[uint64]chan reply),
+	}
+
+	var err error
+	if h.cfg.Transport == "socket" {
+		err = p.startSocket(h.cfg.StartTimeout)
+	} else {
+		err = p.startStdio()
+	}
+	if err != nil {
+		stderr.Close()
+		return nil, err
+	}
+	go func() {
+		cmd.Wait()
+		stderr.Close()
+		close(p.exited)
+	}()
+	go p.readLoop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.cfg.StartTimeout)
+	defer cancel()
+	resp, err := p.call(ctx, &Message{Type: TypeHello, Version: ProtocolVersion}, nil)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("no hello from plugin: %w", err)
+	case resp.Type != TypeHello:
+		err = fmt.Errorf("plugin answered hello with a %s message", resp.Type)
+	case resp.Version != ProtocolVersion:
+		err = fmt.Errorf("plugin speaks protocol version %d, want %d", resp.Version, ProtocolVersion)
+	}
+	if err != nil {
+		p.stop()
+		return nil, err
+	}
+
+	if resp.Name != "" {
+		h.mu.Lock()
+		h.name = resp.Name
+		h.mu.Unlock()
+	}
+	return p, nil
+}
+
+// process is one running instance of the plugin
+type process struct {
+	cmd     *exec.Cmd
+	r       *bufio.Reader
+	w       deadlineWriter
+	closers []io.Closer
+	cleanup func()
+	exited  chan struct{}
+	dead    chan struct{}
+
+	wmu sync.Mutex
+
+	mu       sync.Mutex
+	err      error
+	nextID   uint64
+	pending  map[uint64]chan reply
+	answered time.Time
+}
+
+// deadlineWriter is the host's end of either transport
+type deadlineWriter interface {
+	io.Writer
+	SetWriteDeadline(t time.Time) error
+}
+
+type reply struct {
+	msg *Message
+	err error
+}
+
+// startStdio starts the plugin with pipes for its stdin and stdout
+func (p *process) startStdio() error {
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		return fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	p.cmd.Stdin, p.cmd.Stdout = inR, outW
+	err = p.cmd.Start()
+	// The child has its own copies of these ends
+	inR.Close()
+	outW.Close()
+	if err != nil {
+		inW.Close()
+		outR.Close()
+		return err
+	}
+	p.r, p.w = bufio.NewReader(outR), inW
+	p.closers = []io.Closer{inW, outR}
+	return nil
+}
+
+// startSocket listens on a Unix socket in a private directory, starts the
+// plugin with the socket's path in SocketEnv and waits for it to connect
+func (p *process) startSocket(timeout time.Duration) error {
+	dir, err := os.MkdirTemp("", "vision-plugin-")
+	if err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	path := filepath.Join(dir, "plugin.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	defer l.Close()
+
+	p.cmd.Env = append(os.Environ(), SocketEnv+"="+path)
+	if err := p.cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	l.(*net.UnixListener).SetDeadline(time.Now().Add(timeout))
+	conn, err := l.Accept()
+	if err != nil {
+		p.cmd.Process.Kill()
+		p.cmd.Wait()
+		os.RemoveAll(dir)
+		return fmt.Errorf("plugin did not connect to %s: %w", path, err)
+	}
+	p.r, p.w = bufio.NewReader(conn), conn.(*net.UnixConn)
+	p.closers = []io.Closer{conn}
+	p.cleanup = func() { os.RemoveAll(dir) }
+	return nil
+}
+
+// call sends a request and waits for the answer with the same ID
+func (p *process) call(ctx context.Context, m *Message, payload []byte) (*Message, error) {
+	ch := make(chan reply, 1)
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		return nil, p.err
+	}
+	p.nextID++
+	m.ID = p.nextID
+	p.pending[m.ID] = ch
+	p.mu.Unlock()
+
+	// A plugin that stops reading must not block the caller past its deadline
+	p.wmu.Lock()
+	deadline, _ := ctx.Deadline()
+	p.w.SetWriteDeadline(deadline)
+	err := WriteMessage(p.w, m, payload)
+	p.wmu.Unlock()
+	if err != nil {
+		// A partly written message leaves the stream unusable
+		p.fail(fmt.Errorf("failed to write to plugin: %w", err))
+	}
+
+	select {
+	case r := <-ch:
+		return r.msg, r.err
+	case <-ctx.Done():
+		p.mu.Lock()
+		delete(p.pending, m.ID)
+		p.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// readLoop hands each answer to the request waiting for it. Answers to
+// requests that already timed out are dropped.
+func (p *process) readLoop() {
+	for {
+		m, _, err := ReadMessage(p.r)
+		if err != nil {
+			p.fail(fmt.Errorf("plugin connection closed: %w", err))
+			return
+		}
+		p.mu.Lock()
+		ch, ok := p.pending[m.ID]
+		delete(p.pending, m.ID)
+		p.answered = time.Now()
+		p.mu.Unlock()
+		if ok {
+			ch <- reply{msg: m}
+		}
+	}
+}
+
+// fail marks the connection broken and fails every waiting request
+func (p *process) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return
+	}
+	p.err = err
+	for id, ch := range p.pending {
+		ch <- reply{err: err}
+		delete(p.pending, id)
+	}
+	close(p.dead)
+}
+
+// answeredSince reports whether the plugin has sent anything since t
+func (p *process) answeredSince(t time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.answered.Before(t)
+}
+
+func (p *process) failure() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// stop closes the plugin's input so it can exit on its own, and kills it
+// if it has not within stopGrace
+func (p *process) stop() {
+	p.fail(errors.New("plugin stopped"))
+	for _, c := range p.closers {
+		c.Close()
+	}
+	select {
+	case <-p.exited:
+	case <-time.After(stopGrace):
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+	if p.cleanup != nil {
+		p.cleanup()
+	}
+}
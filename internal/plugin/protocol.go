@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/adron/golang-services-build-base/internal/frames"
+)
+
+// ProtocolVersion is exchanged in the hello messages. A plugin answering
+// with another version is not used.
+const ProtocolVersion = 1
+
+// SocketEnv names the environment variable holding the Unix socket path a
+// plugin started with the socket transport must connect to
+const SocketEnv = "VISION_PLUGIN_SOCKET"
+
+// Message types. The host sends hello, detect and ping; the plugin answers
+// with hello, result or error, and pong, echoing the request's ID.
+const (
+	TypeHello  = "hello"
+	TypeDetect = "detect"
+	TypeResult = "result"
+	TypeError  = "error"
+	TypePing   = "ping"
+	TypePong   = "pong"
+)
+
+// Limits on a single message, so a misbehaving peer cannot make the other
+// side allocate without bound
+const (
+	maxHeaderBytes  = 1 << 20
+	maxPayloadBytes = 1 << 28
+)
+
+// Message is the JSON header of a protocol message. On the wire each
+// message is a 4-byte big-endian header length, a 4-byte big-endian
+// payload length, the header and the payload. Only detect requests carry
+// a payload: the frame as packed 8-bit RGB, Width by Height.
+type Message struct {
+	Type       string             `json:"type"`
+	ID         uint64             `json:"id,omitempty"`
+	Version    int                `json:"version,omitempty"`
+	Name       string             `json:"name,omitempty"`
+	Camera     string             `json:"camera,omitempty"`
+	Sequence   uint64             `json:"sequence,omitempty"`
+	CapturedAt string             `json:"captured_at,omitempty"`
+	Width      int                `json:"width,omitempty"`
+	Height     int                `json:"height,omitempty"`
+	Detections []frames.Detection `json:"detections,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// WriteMessage writes m and payload as one framed message
+func WriteMessage(w io.Writer, m *Message, payload []byte) error {
+	header, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s message: %w", m.Type, err)
+	}
+	var prefix [8]byte
+	binary.BigEndian.PutUint32(prefix[:4], uint32(len(header)))
+	binary.BigEndian.PutUint32(prefix[4:], uint32(len(payload)))
+	if _, err := w.Write(prefix[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(payload)
+	return err
+}
+
+// ReadMessage reads one framed message
+func ReadMessage(r io.Reader) (*Message, []byte, error) {
+	var prefix [8]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, nil, err
+	}
+	headerLen := binary.BigEndian.Uint32(prefix[:4])
+	payloadLen := binary.BigEndian.Uint32(prefix[4:])
+	if headerLen > maxHeaderBytes || payloadLen > maxPayloadBytes {
+		return nil, nil, fmt.Errorf("message of %d+%d bytes exceeds the protocol limits", headerLen, payloadLen)
+	}
+
+	buf := make([]byte, int(headerLen)+int(payloadLen))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, nil, fmt.Errorf("truncated message: %w", err)
+	}
+	m := &Message{}
+	if err := json.Unmarshal(buf[:headerLen], m); err != nil {
+		return nil, nil, fmt.Errorf("invalid message header: %w", err)
+	}
+	return m, buf[headerLen:], nil
+}
+
+// packRGB returns img as packed 8-bit RGB
+func packRGB(img image.Image) []byte {
+	b := img.Bounds()
+	out := make([]byte, 0, b.Dx()*b.Dy()*3)
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := rgba.Pix[rgba.PixOffset(b.Min.X, y):rgba.PixOffset(b.Max.X, y)]
+			for i := 0; i < len(row); i += 4 {
+				out = append(out, row[i], row[i+1], row[i+2])
+			}
+		}
+		return out
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			out = append(out, byte(r>>8), byte(g>>8), byte(bl>>8))
+		}
+	}
+	return out
+}
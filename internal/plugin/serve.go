@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/adron/golang-services-build-base/internal/frames"
+)
+
+// Run is the plugin side of the protocol for detectors written in Go. It
+// serves on the socket named by SocketEnv when set, and otherwise on stdin
+// and stdout, until the host hangs up.
+func Run(ctx context.Context, name string, detect frames.ProcessFunc) error {
+	if path := os.Getenv(SocketEnv); path != "" {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			return fmt.Errorf("failed to connect to host: %w", err)
+		}
+		defer conn.Close()
+		return Serve(ctx, conn, conn, name, detect)
+	}
+	return Serve(ctx, os.Stdin, os.Stdout, name, detect)
+}
+
+// Serve answers the host's messages on r with detect, one at a time,
+// writing replies to w. It returns nil once r is closed.
+func Serve(ctx context.Context, r io.Reader, w io.Writer, name string, detect frames.ProcessFunc) error {
+	for {
+		m, payload, err := ReadMessage(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		reply := &Message{ID: m.ID}
+		switch m.Type {
+		case TypeHello:
+			reply.Type, reply.Version, reply.Name = TypeHello, ProtocolVersion, name
+		case TypePing:
+			reply.Type = TypePong
+		case TypeDetect:
+			detections, err := serveDetect(ctx, m, payload, detect)
+			if err != nil {
+				reply.Type, reply.Error = TypeError, err.Error()
+			} else {
+				reply.Type, reply.Detections = TypeResult, detections
+			}
+		default:
+			reply.Type, reply.Error = TypeError, fmt.Sprintf("unknown message type %q", m.Type)
+		}
+		if err := WriteMessage(w, reply, nil); err != nil {
+			return fmt.Errorf("failed to reply to host: %w", err)
+		}
+	}
+}
+
+// serveDetect rebuilds the frame from a detect request and runs detect on it
+func serveDetect(ctx context.Context, m *Message, payload []byte, detect frames.ProcessFunc) ([]frames.Detection, error) {
+	if m.Width < 1 || m.Height < 1 || len(payload) != m.Width*m.Height*3 {
+		return nil, fmt.Errorf("payload of %d bytes does not match %dx%d RGB", len(payload), m.Width, m.Height)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, m.Width, m.Height))
+	for i, j := 0, 0; i < len(payload); i, j = i+3, j+4 {
+		img.Pix[j], img.Pix[j+1], img.Pix[j+2], img.Pix[j+3] = payload[i], payload[i+1], payload[i+2], 0xff
+	}
+	capturedAt, _ := time.Parse(time.RFC3339Nano, m.CapturedAt)
+	return detect(ctx, &frames.Frame{
+		Camera:     m.Camera,
+		Sequence:   m.Sequence,
+		CapturedAt: capturedAt,
+		Format:     frames.FormatRGB,
+		Image:      img,
+	})
+}
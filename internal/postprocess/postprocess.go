@@ -0,0 +1,213 @@
+// Package postprocess filters detector output with per-class confidence
+// thresholds, non-maximum suppression and a cap on detections per frame.
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/frames"
+)
+
+// Stages that drop detections, recorded as the stage attribute of
+// detections.dropped
+const (
+	StageConfidence    = "confidence"
+	StageNMS           = "nms"
+	StageMaxDetections = "max_detections"
+)
+
+// NMS methods
+const (
+	NMSHard = "hard"
+	NMSSoft = "soft"
+	NMSNone = "none"
+)
+
+// maxCameraLabels bounds the camera.id values detections.dropped is
+// recorded with, since camera IDs come from clients. Cameras seen after
+// the first maxCameraLabels are recorded as OtherCameras.
+const maxCameraLabels = 1024
+
+// OtherCameras is the camera.id of detections dropped for cameras beyond
+// the label limit. It cannot be a camera ID, which starts with a letter or
+// digit.
+const OtherCameras = "_other"
+
+// softFloor is the lowest confidence a box keeps through Soft-NMS, so that
+// with no threshold boxes decayed to nearly nothing are still dropped
+const softFloor = 0.001
+
+// Filter applies the post-processing cfg describes. It is safe for
+// concurrent use.
+type Filter struct {
+	cfg config.PostprocessConfig
+
+	mu      sync.Mutex
+	dropped metric.Int64Counter
+	labels  map[string]bool
+}
+
+// New creates a filter for cfg
+func New(cfg config.PostprocessConfig) *Filter {
+	dropped, _ := noop.NewMeterProvider().Meter("").Int64Counter("detections.dropped")
+	return &Filter{cfg: cfg, dropped: dropped, labels: make(map[string]bool)}
+}
+
+// RegisterMetrics records the detections each stage drops on meter, by
+// stage and camera
+func (f *Filter) RegisterMetrics(meter metric.Meter) error {
+	dropped, err := meter.Int64Counter("detections.dropped",
+		metric.WithDescription("Detections dropped by post-processing, by stage and camera"),
+		metric.WithUnit("{detection}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create dropped detection counter: %w", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dropped = dropped
+	return nil
+}
+
+// Threshold returns the confidence a detection of class from camera needs.
+// A class threshold for the camera comes first, then the global one for
+// the class, then the camera's minimum and finally the global minimum.
+func (f *Filter) Threshold(camera, class string) float64 {
+	override := f.cfg.Cameras[camera]
+	if t, ok := override.ClassThresholds[class]; ok {
+		return t
+	}
+	if t, ok := f.cfg.ClassThresholds[class]; ok {
+		return t
+	}
+	if override.MinConfidence != nil {
+		return *override.MinConfidence
+	}
+	return f.cfg.MinConfidence
+}
+
+// Apply filters the detections found in a frame from camera and returns
+// the survivors, most confident first. detections is not modified.
+func (f *Filter) Apply(ctx context.Context, camera string, detections []frames.Detection) []frames.Detection {
+	kept := make([]frames.Detection, 0, len(detections))
+	for _, d := range detections {
+		if d.Confidence >= f.Threshold(camera, d.Class) {
+			kept = append(kept, d)
+		}
+	}
+	f.record(ctx, camera, StageConfidence, len(detections)-len(kept))
+
+	n := len(kept)
+	switch f.cfg.NMS {
+	case NMSHard:
+		kept = NMS(kept, f.cfg.IoUThreshold, f.cfg.ClassAgnostic)
+	case NMSSoft:
+		kept = SoftNMS(kept, f.cfg.SoftNMSSigma, f.cfg.ClassAgnostic, func(class string) float64 {
+			return f.Threshold(camera, class)
+		})
+	default:
+		sortByConfidence(kept)
+	}
+	f.record(ctx, camera, StageNMS, n-len(kept))
+
+	if f.cfg.MaxDetections > 0 && len(kept) > f.cfg.MaxDetections {
+		f.record(ctx, camera, StageMaxDetections, len(kept)-f.cfg.MaxDetections)
+		kept = kept[:f.cfg.MaxDetections]
+	}
+	return kept
+}
+
+func (f *Filter) record(ctx context.Context, camera, stage string, n int) {
+	if n == 0 {
+		return
+	}
+	f.mu.Lock()
+	dropped := f.dropped
+	if !f.labels[camera] {
+		if len(f.labels) < maxCameraLabels {
+			f.labels[camera] = true
+		} else {
+			camera = OtherCameras
+		}
+	}
+	f.mu.Unlock()
+	dropped.Add(ctx, int64(n), metric.WithAttributes(
+		attribute.String("stage", stage),
+		attribute.String("camera.id", camera),
+	))
+}
+
+// NMS keeps the most confident of each group of boxes overlapping by more
+// than iouThreshold. Only boxes of the same class are compared unless
+// agnostic is set. detections is sorted in place by confidence, most
+// confident first, and the survivors are returned in that order.
+func NMS(detections []frames.Detection, iouThreshold float64, agnostic bool) []frames.Detection {
+	sortByConfidence(detections)
+	kept := detections[:0]
+	for _, d := range detections {
+		suppressed := false
+		for _, k := range kept {
+			if (agnostic || k.Class == d.Class) && k.Box.IoU(d.Box) > iouThreshold {
+				suppressed = true
+				break
+			}
+		}
+		if !suppressed {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// SoftNMS is Gaussian Soft-NMS: rather than dropping boxes that overlap a
+// more confident one, it scales their confidence by exp(-IoU²/sigma), and
+// drops boxes only once they fall below threshold for their class.
+// detections is reused, and the survivors are returned most confident
+// first with their decayed confidence.
+func SoftNMS(detections []frames.Detection, sigma float64, agnostic bool, threshold func(class string) float64) []frames.Detection {
+	rest := detections
+	var kept []frames.Detection
+	for len(rest) > 0 {
+		best := 0
+		for i := range rest {
+			if rest[i].Confidence > rest[best].Confidence {
+				best = i
+			}
+		}
+		top := rest[best]
+		kept = append(kept, top)
+		rest[best] = rest[len(rest)-1]
+		rest = rest[:len(rest)-1]
+
+		n := 0
+		for _, d := range rest {
+			if agnostic || d.Class == top.Class {
+				iou := top.Box.IoU(d.Box)
+				d.Confidence *= math.Exp(-iou * iou / sigma)
+			}
+			if d.Confidence >= math.Max(threshold(d.Class), softFloor) {
+				rest[n] = d
+				n++
+			}
+		}
+		rest = rest[:n]
+	}
+	return kept
+}
+
+// sortByConfidence sorts detections most confident first, keeping the
+// order of ties
+func sortByConfidence(detections []frames.Detection) {
+	sort.SliceStable(detections, func(i, j int) bool {
+		return detections[i].Confidence > detections[j].Confidence
+	})
+}
@@ -0,0 +1,73 @@
+package preprocess
+
+import (
+	"fmt"
+	"image"
+)
+
+// Channel orders
+const (
+	RGB = "rgb"
+	BGR = "bgr"
+)
+
+// FromPacked converts width by height pixels of packed 8-bit RGB or BGR,
+// as order says, to an image
+func FromPacked(data []byte, width, height int, order string) (*image.RGBA, error) {
+	if width < 1 || height < 1 || len(data) != width*height*3 {
+		return nil, fmt.Errorf("packed %dx%d frame needs %d bytes, got %d", width, height, width*height*3, len(data))
+	}
+	r, b := 0, 2
+	switch order {
+	case RGB:
+	case BGR:
+		r, b = 2, 0
+	default:
+		return nil, fmt.Errorf("unknown channel order %q", order)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, j := 0, 0; i < len(data); i, j = i+3, j+4 {
+		img.Pix[j], img.Pix[j+1], img.Pix[j+2], img.Pix[j+3] = data[i+r], data[i+1], data[i+b], 0xff
+	}
+	return img, nil
+}
+
+// FromNV12 converts an NV12 frame to an image. NV12 is a full resolution
+// plane of luma followed by a half resolution plane of interleaved Cb and
+// Cr samples, using BT.601 limited range as most cameras and hardware
+// decoders produce.
+func FromNV12(data []byte, width, height int) (*image.RGBA, error) {
+	if width < 2 || height < 2 || width%2 != 0 || height%2 != 0 {
+		return nil, fmt.Errorf("NV12 frame must have even dimensions, got %dx%d", width, height)
+	}
+	luma := width * height
+	if len(data) != luma*3/2 {
+		return nil, fmt.Errorf("NV12 %dx%d frame needs %d bytes, got %d", width, height, luma*3/2, len(data))
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		yRow := data[y*width:]
+		cRow := data[luma+(y/2)*width:]
+		out := img.Pix[y*img.Stride:]
+		for x := 0; x < width; x++ {
+			c := 298 * (int32(yRow[x]) - 16)
+			d := int32(cRow[x&^1]) - 128
+			e := int32(cRow[x|1]) - 128
+			out[x*4] = clampByte((c + 409*e + 128) >> 8)
+			out[x*4+1] = clampByte((c - 100*d - 208*e + 128) >> 8)
+			out[x*4+2] = clampByte((c + 516*d + 128) >> 8)
+			out[x*4+3] = 0xff
+		}
+	}
+	return img, nil
+}
+
+func clampByte(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
@@ -0,0 +1,116 @@
+// Package preprocess prepares frames as model input: bilinear resizing,
+// letterboxing, colour conversion, normalization and float32 tensor
+// layout, along with the transform that maps model output back to frame
+// coordinates.
+package preprocess
+
+import (
+	"image"
+
+	"github.com/adron/golang-services-build-base/config"
+)
+
+// Tensor layouts
+const (
+	NCHW = "nchw"
+	NHWC = "nhwc"
+)
+
+// Preprocessor writes frames into the fixed-size, normalized float32
+// tensor cfg describes. It is safe for concurrent use.
+type Preprocessor struct {
+	width, height int
+	letterbox     bool
+	// source is the RGBA byte read for each tensor channel
+	source [3]int
+	// Tensor values are byte*scale + offset for each tensor channel
+	scale, offset [3]float32
+	pad           [3]float32
+	// Value (x, y, c) is at (y*width+x)*pixelStride + c*channelStride
+	pixelStride, channelStride int
+}
+
+// New creates a preprocessor for a model taking width by height input
+func New(width, height int, cfg config.PreprocessConfig) *Preprocessor {
+	p := &Preprocessor{
+		width:         width,
+		height:        height,
+		letterbox:     cfg.Letterbox,
+		source:        [3]int{0, 1, 2},
+		pixelStride:   1,
+		channelStride: width * height,
+	}
+	if cfg.ChannelOrder == BGR {
+		p.source = [3]int{2, 1, 0}
+	}
+	if cfg.Layout == NHWC {
+		p.pixelStride, p.channelStride = 3, 1
+	}
+	for c := range 3 {
+		mean, std := 0.0, 1.0
+		if len(cfg.Mean) == 3 {
+			mean = cfg.Mean[c]
+		}
+		if len(cfg.Std) == 3 {
+			std = cfg.Std[c]
+		}
+		p.scale[c] = float32(1 / (255 * std))
+		p.offset[c] = float32(-mean / std)
+		p.pad[c] = float32(cfg.PadValue)*p.scale[c] + p.offset[c]
+	}
+	return p
+}
+
+// Shape returns the tensor shape for a batch of n frames
+func (p *Preprocessor) Shape(n int) []int {
+	if p.pixelStride == 3 {
+		return []int{n, p.height, p.width, 3}
+	}
+	return []int{n, 3, p.height, p.width}
+}
+
+// Size returns the number of values in one frame's tensor
+func (p *Preprocessor) Size() int {
+	return 3 * p.width * p.height
+}
+
+// Tensor resizes img, writes it to dst, which must hold Size values, and
+// returns the transform from tensor coordinates back to img
+func (p *Preprocessor) Tensor(img image.Image, dst []float32) Transform {
+	full := image.Rect(0, 0, p.width, p.height)
+	content := full
+	if p.letterbox {
+		content = fit(img.Bounds(), p.width, p.height)
+	}
+	if content != full {
+		for i := 0; i < p.width*p.height; i++ {
+			for c := range 3 {
+				dst[i*p.pixelStride+c*p.channelStride] = p.pad[c]
+			}
+		}
+	}
+
+	src := toRGBA(img)
+	b := src.Bounds()
+	xs := newAxis(content.Dx(), b.Dx()).scaled(4)
+	ys := newAxis(content.Dy(), b.Dy()).scaled(src.Stride)
+	base := src.PixOffset(b.Min.X, b.Min.Y)
+	var v [4]float32
+	for y := 0; y < content.Dy(); y++ {
+		row0, row1, wy := src.Pix[base+ys.i0[y]:], src.Pix[base+ys.i1[y]:], ys.w[y]
+		i := ((content.Min.Y+y)*p.width + content.Min.X) * p.pixelStride
+		for x := 0; x < content.Dx(); x++ {
+			x0, x1, wx := xs.i0[x], xs.i1[x], xs.w[x]
+			for c := range 3 {
+				top := float32(row0[x0+c]) + (float32(row0[x1+c])-float32(row0[x0+c]))*wx
+				bottom := float32(row1[x0+c]) + (float32(row1[x1+c])-float32(row1[x0+c]))*wx
+				v[c] = top + (bottom-top)*wy
+			}
+			for c := range 3 {
+				dst[i+c*p.channelStride] = v[p.source[c]]*p.scale[c] + p.offset[c]
+			}
+			i += p.pixelStride
+		}
+	}
+	return newTransform(b, content)
+}
@@ -0,0 +1,106 @@
+package preprocess
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// axis holds, for each destination coordinate along one dimension, the two
+// neighbouring source coordinates it blends and the weight of the second
+type axis struct {
+	i0, i1 []int
+	w      []float32
+}
+
+// newAxis maps dst coordinates onto src ones by pixel centres, clamping
+// at the edges
+func newAxis(dst, src int) axis {
+	a := axis{i0: make([]int, dst), i1: make([]int, dst), w: make([]float32, dst)}
+	ratio := float64(src) / float64(dst)
+	for d := 0; d < dst; d++ {
+		s := (float64(d)+0.5)*ratio - 0.5
+		if s < 0 {
+			s = 0
+		}
+		i := int(s)
+		if i > src-1 {
+			i = src - 1
+		}
+		a.i0[d], a.w[d] = i, float32(s-float64(i))
+		a.i1[d] = min(i+1, src-1)
+	}
+	return a
+}
+
+// scaled scales the i0 and i1 coordinates by stride, turning them into
+// byte offsets
+func (a axis) scaled(stride int) axis {
+	out := axis{i0: make([]int, len(a.i0)), i1: make([]int, len(a.i1)), w: a.w}
+	for d := range a.i0 {
+		out.i0[d], out.i1[d] = a.i0[d]*stride, a.i1[d]*stride
+	}
+	return out
+}
+
+// toRGBA returns img as an *image.RGBA, converting it if needed
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, img, b.Min, draw.Src)
+	return rgba
+}
+
+// fit returns the rectangle src's content covers when scaled to fit inside
+// width by height, keeping its aspect ratio and centred
+func fit(src image.Rectangle, width, height int) image.Rectangle {
+	scale := math.Min(float64(width)/float64(src.Dx()), float64(height)/float64(src.Dy()))
+	w := max(1, min(width, int(math.Round(float64(src.Dx())*scale))))
+	h := max(1, min(height, int(math.Round(float64(src.Dy())*scale))))
+	x, y := (width-w)/2, (height-h)/2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// Resize stretches img to width by height with bilinear interpolation
+func Resize(img image.Image, width, height int) (*image.RGBA, Transform) {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	content := dst.Bounds()
+	resizeInto(dst, content, toRGBA(img))
+	return dst, newTransform(img.Bounds(), content)
+}
+
+// Letterbox scales img to fit inside width by height with bilinear
+// interpolation, keeping its aspect ratio, and fills the borders with pad
+func Letterbox(img image.Image, width, height int, pad color.Color) (*image.RGBA, Transform) {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	content := fit(img.Bounds(), width, height)
+	if content != dst.Bounds() {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(pad), image.Point{}, draw.Src)
+	}
+	resizeInto(dst, content, toRGBA(img))
+	return dst, newTransform(img.Bounds(), content)
+}
+
+// resizeInto draws all of src into the r part of dst
+func resizeInto(dst *image.RGBA, r image.Rectangle, src *image.RGBA) {
+	b := src.Bounds()
+	xs := newAxis(r.Dx(), b.Dx()).scaled(4)
+	ys := newAxis(r.Dy(), b.Dy()).scaled(src.Stride)
+	base := src.PixOffset(b.Min.X, b.Min.Y)
+	for y := 0; y < r.Dy(); y++ {
+		row0, row1, wy := src.Pix[base+ys.i0[y]:], src.Pix[base+ys.i1[y]:], ys.w[y]
+		out := dst.Pix[dst.PixOffset(r.Min.X, r.Min.Y+y):]
+		for x := 0; x < r.Dx(); x++ {
+			x0, x1, wx := xs.i0[x], xs.i1[x], xs.w[x]
+			for c := 0; c < 4; c++ {
+				top := float32(row0[x0+c]) + (float32(row0[x1+c])-float32(row0[x0+c]))*wx
+				bottom := float32(row1[x0+c]) + (float32(row1[x1+c])-float32(row1[x0+c]))*wx
+				out[x*4+c] = uint8(top + (bottom-top)*wy + 0.5)
+			}
+		}
+	}
+}
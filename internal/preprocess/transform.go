@@ -0,0 +1,52 @@
+package preprocess
+
+import (
+	"image"
+	"math"
+
+	"github.com/adron/golang-services-build-base/internal/frames"
+)
+
+// Transform maps coordinates in a resized or letterboxed image back to the
+// frame it was made from
+type Transform struct {
+	// ScaleX and ScaleY are frame pixels per resized pixel
+	ScaleX, ScaleY float64
+	// PadX and PadY are the resized image's left and top borders
+	PadX, PadY float64
+	// Source is the frame's bounds
+	Source image.Rectangle
+}
+
+// newTransform maps content, the part of the resized image src was drawn
+// into, back to src
+func newTransform(src, content image.Rectangle) Transform {
+	return Transform{
+		ScaleX: float64(src.Dx()) / float64(content.Dx()),
+		ScaleY: float64(src.Dy()) / float64(content.Dy()),
+		PadX:   float64(content.Min.X),
+		PadY:   float64(content.Min.Y),
+		Source: src,
+	}
+}
+
+// Point maps a point to frame coordinates, which like those of a Box are
+// relative to the top-left corner of the frame's bounds
+func (t Transform) Point(x, y float64) (float64, float64) {
+	return (x - t.PadX) * t.ScaleX, (y - t.PadY) * t.ScaleY
+}
+
+// Box maps a box to frame coordinates, clipping off any part that falls in
+// the padding or outside the frame. A box entirely in the padding comes
+// back empty.
+func (t Transform) Box(b frames.Box) frames.Box {
+	x1, y1 := t.Point(b.X, b.Y)
+	x2, y2 := t.Point(b.X+b.Width, b.Y+b.Height)
+	x1, x2 = clamp(x1, t.Source.Dx()), clamp(x2, t.Source.Dx())
+	y1, y2 = clamp(y1, t.Source.Dy()), clamp(y2, t.Source.Dy())
+	return frames.Box{X: x1, Y: y1, Width: x2 - x1, Height: y2 - y1}
+}
+
+func clamp(v float64, max int) float64 {
+	return math.Max(0, math.Min(float64(max), v))
+}
@@ -0,0 +1,221 @@
+package reload
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/adron/golang-services-build-base/config"
+)
+
+// LoadFunc produces a freshly loaded configuration
+type LoadFunc func() (*config.Config, error)
+
+// ApplyFunc applies a changed field to the running service. Returning an
+// error rejects the change and keeps the old value.
+type ApplyFunc func(old, new *config.Config) error
+
+// Rejection records a changed field that was not applied
+type Rejection struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Result describes the outcome of a reload attempt
+type Result struct {
+	Trigger  string      `json:"trigger"`
+	Time     time.Time   `json:"time"`
+	Success  bool        `json:"success"`
+	Applied  []string    `json:"applied,omitempty"`
+	Rejected []Rejection `json:"rejected,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// Reloader re-runs config loading and applies fields that can change live
+type Reloader struct {
+	mu       sync.Mutex
+	load     LoadFunc
+	logger   *logrus.Logger
+	current  *config.Config
+	appliers map[string]ApplyFunc
+	last     *Result
+}
+
+// New creates a Reloader starting from the given configuration
+func New(current *config.Config, load LoadFunc, logger *logrus.Logger) *Reloader {
+	return &Reloader{
+		load:     load,
+		logger:   logger,
+		current:  current,
+		appliers: make(map[string]ApplyFunc),
+	}
+}
+
+// Handle marks a config field (as named by config.Diff) as live-reloadable
+func (r *Reloader) Handle(field string, apply ApplyFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.appliers[field] = apply
+}
+
+// Current returns the effective configuration after the last reload
+func (r *Reloader) Current() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Last returns the result of the most recent reload, if any
+func (r *Reloader) Last() (Result, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.last == nil {
+		return Result{}, false
+	}
+	return *r.last, true
+}
+
+// Reload loads the configuration, applies live fields and rejects the rest
+func (r *Reloader) Reload(trigger string) Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := Result{Trigger: trigger, Time: time.Now()}
+	defer func() {
+		r.last = &result
+	}()
+
+	loaded, err := r.load()
+	if err != nil {
+		result.Error = err.Error()
+		r.logger.WithField("trigger", trigger).Errorf("Config reload failed: %v", err)
+		return result
+	}
+	r.apply(loaded, &result)
+	return result
+}
+
+// Update applies change to a copy of the current configuration, as a
+// reload would. Changes made through the admin API or console go through
+// it so the next reload compares against them. The result is not kept as
+// the last reload.
+func (r *Reloader) Update(trigger string, change func(c *config.Config)) Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := Result{Trigger: trigger, Time: time.Now()}
+	updated := *r.current
+	change(&updated)
+	if err := updated.Validate(); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	r.apply(&updated, &result)
+	return result
+}
+
+// apply applies the live fields of loaded and rejects the rest. The
+// caller holds r.mu.
+func (r *Reloader) apply(loaded *config.Config, result *Result) {
+	effective := *r.current
+	for _, field := range config.Diff(r.current, loaded) {
+		apply, ok := r.appliers[field]
+		if !ok {
+			result.Rejected = append(result.Rejected, Rejection{Field: field, Reason: "requires restart"})
+			continue
+		}
+		if err := apply(r.current, loaded); err != nil {
+			result.Rejected = append(result.Rejected, Rejection{Field: field, Reason: err.Error()})
+			continue
+		}
+		config.CopyField(&effective, loaded, field)
+		result.Applied = append(result.Applied, field)
+	}
+	r.current = &effective
+	result.Success = true
+
+	for _, rej := range result.Rejected {
+		r.logger.WithFields(logrus.Fields{"trigger": result.Trigger, "field": rej.Field}).
+			Warnf("Config change not applied: %s", rej.Reason)
+	}
+	r.logger.WithFields(logrus.Fields{"trigger": result.Trigger, "applied": result.Applied}).Info("Config reloaded")
+}
+
+// WatchSignals reloads on SIGHUP until ctx is done
+func (r *Reloader) WatchSignals(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.Reload("signal")
+		}
+	}
+}
+
+// WatchFile polls path and reloads whenever its modification time or size changes
+func (r *Reloader) WatchFile(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastMod, lastSize := statFile(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mod, size := statFile(path)
+			if mod.Equal(lastMod) && size == lastSize {
+				continue
+			}
+			lastMod, lastSize = mod, size
+			r.Reload("file")
+		}
+	}
+}
+
+func statFile(path string) (time.Time, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, -1
+	}
+	return info.ModTime(), info.Size()
+}
+
+// ServeHTTP returns the last reload result on GET and triggers a reload on POST
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var result Result
+	switch req.Method {
+	case http.MethodGet:
+		last, ok := r.Last()
+		if !ok {
+			http.Error(w, "No reload has been attempted", http.StatusNotFound)
+			return
+		}
+		result = last
+	case http.MethodPost:
+		result = r.Reload("api")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Success {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		r.logger.WithContext(req.Context()).Errorf("Failed to encode reload result: %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// State is a step in the service lifecycle
+type State int
+
+const (
+	Stopped State = iota
+	Starting
+	Running
+	Draining
+	Failed
+)
+
+var stateNames = map[State]string{
+	Stopped:  "stopped",
+	Starting: "starting",
+	Running:  "running",
+	Draining: "draining",
+	Failed:   "failed",
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// MarshalText encodes the state by name
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ErrNotRunning is returned when stopping a service that is not running
+var ErrNotRunning = errors.New("service is not running")
+
+// Status is a snapshot of the service lifecycle
+type Status struct {
+	State     State     `json:"state"`
+	Since     time.Time `json:"since"`
+	Addr      string    `json:"addr,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Option customizes a Service
+type Option func(*Service)
+
+// WithListener replaces the default TCP listener, for example with a
+// socket handed over by the init system or a TLS listener.
+func WithListener(listen func(addr string) (net.Listener, error)) Option {
+	return func(s *Service) {
+		s.listen = listen
+	}
+}
+
+// WithTLS serves HTTPS with cfg on whichever listener the service uses
+func WithTLS(cfg *tls.Config) Option {
+	return func(s *Service) {
+		s.tlsConfig = cfg
+	}
+}
+
+// WithTimeouts sets the HTTP read and write timeouts
+func WithTimeouts(read, write time.Duration) Option {
+	return func(s *Service) {
+		s.readTimeout = read
+		s.writeTimeout = write
+	}
+}
+
+// Service runs the HTTP server through explicit lifecycle states:
+// stopped -> starting -> running -> draining -> stopped, with failed
+// reachable from starting and running.
+type Service struct {
+	addr         string
+	newHandler   func() http.Handler
+	logger       *logrus.Logger
+	listen       func(addr string) (net.Listener, error)
+	tlsConfig    *tls.Config
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// lifecycle serializes Start, Stop and Restart
+	lifecycle sync.Mutex
+
+	mu          sync.RWMutex
+	state       State
+	since       time.Time
+	server      *http.Server
+	boundAddr   string
+	lastErr     error
+	errs        chan error
+	transitions []func(from, to State)
+}
+
+// New creates a stopped Service. newHandler is called on every start so
+// routes are rebuilt with the current configuration.
+func New(addr string, newHandler func() http.Handler, logger *logrus.Logger, opts ...Option) *Service {
+	s := &Service{
+		addr:       addr,
+		newHandler: newHandler,
+		logger:     logger,
+		listen: func(addr string) (net.Listener, error) {
+			return net.Listen("tcp", addr)
+		},
+		readTimeout:  10 * time.Second,
+		writeTimeout: 10 * time.Second,
+		state:        Stopped,
+		since:        time.Now(),
+		errs:         make(chan error, 1),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// OnTransition registers fn to be called after every state change
+func (s *Service) OnTransition(fn func(from, to State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transitions = append(s.transitions, fn)
+}
+
+// State returns the current state
+func (s *Service) State() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
+
+// Status returns a snapshot of the current state
+func (s *Service) Status() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status := Status{State: s.state, Since: s.since, Addr: s.boundAddr}
+	if s.lastErr != nil {
+		status.LastError = s.lastErr.Error()
+	}
+	return status
+}
+
+// Errors delivers errors from the serve loop after Start has returned,
+// such as the listener failing while running.
+func (s *Service) Errors() <-chan error {
+	return s.errs
+}
+
+// Start binds the listener and begins serving. Listen errors are returned
+// to the caller and leave the service in the failed state.
+func (s *Service) Start(ctx context.Context) error {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	return s.start()
+}
+
+func (s *Service) start() error {
+	if st := s.State(); st != Stopped && st != Failed {
+		return fmt.Errorf("cannot start service while %s", st)
+	}
+	s.transition(Starting, nil)
+
+	listener, err := s.listen(s.addr)
+	if err != nil {
+		err = fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+		s.transition(Failed, err)
+		return err
+	}
+
+	server := &http.Server{
+		Handler:      s.newHandler(),
+		TLSConfig:    s.tlsConfig,
+		ReadTimeout:  s.readTimeout,
+		WriteTimeout: s.writeTimeout,
+	}
+
+	s.mu.Lock()
+	s.server = server
+	s.boundAddr = listener.Addr().String()
+	s.mu.Unlock()
+	s.transition(Running, nil)
+	if s.tlsConfig != nil {
+		s.logger.Infof("Service listening on %s with TLS", listener.Addr())
+	} else {
+		s.logger.Infof("Service listening on %s", listener.Addr())
+	}
+
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err == nil || errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+		err = fmt.Errorf("server failed: %w", err)
+		s.logger.Error(err)
+		s.transition(Failed, err)
+		select {
+		case s.errs <- err:
+		default:
+		}
+	}()
+	return nil
+}
+
+// Stop drains in-flight requests until ctx is done and then stops the listener
+func (s *Service) Stop(ctx context.Context) error {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	return s.stop(ctx)
+}
+
+func (s *Service) stop(ctx context.Context) error {
+	if s.State() != Running {
+		return ErrNotRunning
+	}
+	s.transition(Draining, nil)
+
+	s.mu.RLock()
+	server := s.server
+	s.mu.RUnlock()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		server.Close()
+		err = fmt.Errorf("forced shutdown after drain deadline: %w", err)
+		s.logger.Warn(err)
+	}
+
+	s.mu.Lock()
+	s.server = nil
+	s.boundAddr = ""
+	s.mu.Unlock()
+	s.transition(Stopped, nil)
+	return err
+}
+
+// Restart stops the service if it is running and starts it again
+func (s *Service) Restart(ctx context.Context) error {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	if s.State() == Running {
+		if err := s.stop(ctx); err != nil {
+			s.logger.Warnf("Restart continuing after unclean stop: %v", err)
+		}
+	}
+	return s.start()
+}
+
+func (s *Service) transition(to State, err error) {
+	s.mu.Lock()
+	from := s.state
+	s.state = to
+	s.since = time.Now()
+	if err != nil {
+		s.lastErr = err
+	}
+	callbacks := append([]func(from, to State){}, s.transitions...)
+	s.mu.Unlock()
+
+	s.logger.WithFields(logrus.Fields{"from": from.String(), "to": to.String()}).Debug("Service state changed")
+	for _, fn := range callbacks {
+		fn(from, to)
+	}
+}
+
+// RegisterMetrics exports the current state as a gauge and counts transitions
+func (s *Service) RegisterMetrics(meter metric.Meter) error {
+	transitions, err := meter.Int64Counter("service.state.transitions",
+		metric.WithDescription("Number of service lifecycle state transitions"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create transition counter: %w", err)
+	}
+	s.OnTransition(func(from, to State) {
+		transitions.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String("from", from.String()),
+			attribute.String("to", to.String()),
+		))
+	})
+
+	_, err = meter.Int64ObservableGauge("service.state",
+		metric.WithDescription("1 for the current service lifecycle state, 0 otherwise"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			current := s.State()
+			for state := Stopped; state <= Failed; state++ {
+				var value int64
+				if state == current {
+					value = 1
+				}
+				o.Observe(value, metric.WithAttributes(attribute.String("state", state.String())))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create state gauge: %w", err)
+	}
+	return nil
+}
+
+// StatusHandler serves the current Status as JSON
+func (s *Service) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Status())
+	})
+}
+
+// RestartHandler accepts a restart request and performs it after the
+// response is written, since draining waits for this request to finish.
+func (s *Service) RestartHandler(timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := s.Restart(ctx); err != nil {
+				s.logger.Errorf("Restart failed: %v", err)
+			}
+		}()
+	})
+}
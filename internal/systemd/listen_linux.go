@@ -0,0 +1,42 @@
+//go:build linux
+
+package systemd
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed by socket activation
+const listenFdsStart = 3
+
+// ListenFiles returns the sockets passed with LISTEN_FDS, or nil when the
+// process was not socket activated. The environment is cleared so child
+// processes don't inherit the sockets. Pass a file to net.FileListener to
+// get a listener; each call duplicates the descriptor, so the service can
+// be stopped and started again on the same socket.
+func ListenFiles() []*os.File {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil
+	}
+
+	names := splitNames(os.Getenv("LISTEN_FDNAMES"), count)
+	files := make([]*os.File, 0, count)
+	for fd := listenFdsStart; fd < listenFdsStart+count; fd++ {
+		syscall.CloseOnExec(fd)
+		files = append(files, os.NewFile(uintptr(fd), names[fd-listenFdsStart]))
+	}
+	return files
+}
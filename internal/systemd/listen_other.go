@@ -0,0 +1,10 @@
+//go:build !linux
+
+package systemd
+
+import "os"
+
+// ListenFiles always returns nil; socket activation is only supported on linux
+func ListenFiles() []*os.File {
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package systemd
+
+import "errors"
+
+func send(socket, msg string) error {
+	return errors.New("sd_notify is only supported on linux")
+}
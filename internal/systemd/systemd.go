@@ -0,0 +1,110 @@
+package systemd
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification states understood by the service manager
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Status returns a STATUS= notification shown by systemctl status
+func Status(msg string) string {
+	return "STATUS=" + msg
+}
+
+// Notifier sends sd_notify messages to the socket named by NOTIFY_SOCKET.
+// A nil Notifier, or one created outside systemd, drops every message.
+type Notifier struct {
+	socket string
+}
+
+// NewNotifier returns a Notifier for NOTIFY_SOCKET, or nil when the
+// process was not started by systemd with Type=notify.
+func NewNotifier() *Notifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	return &Notifier{socket: socket}
+}
+
+// Notify sends the given states as a single message
+func (n *Notifier) Notify(states ...string) error {
+	if n == nil {
+		return nil
+	}
+	return send(n.socket, strings.Join(states, "\n"))
+}
+
+// WatchdogInterval returns the watchdog timeout requested with WatchdogSec,
+// or zero when the watchdog is disabled or meant for another process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog pings the watchdog at half the timeout while check passes.
+// Failing checks pause the pings, so systemd restarts the service if it
+// stays unhealthy past the timeout. It returns when ctx is done.
+func (n *Notifier) RunWatchdog(ctx context.Context, timeout time.Duration, check func(ctx context.Context) error) {
+	if n == nil || timeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	paused := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := check(ctx); err != nil {
+				if !paused {
+					n.Notify(Status("Watchdog paused: " + err.Error()))
+					paused = true
+				}
+				continue
+			}
+			if paused {
+				n.Notify(Watchdog, Status("Healthy"))
+				paused = false
+				continue
+			}
+			n.Notify(Watchdog)
+		}
+	}
+}
+
+// splitNames returns count socket names from LISTEN_FDNAMES, defaulting
+// missing names to "LISTEN_FD_<n>" as sd_listen_fds_with_names does.
+func splitNames(env string, count int) []string {
+	var given []string
+	if env != "" {
+		given = strings.Split(env, ":")
+	}
+	names := make([]string, count)
+	for i := range names {
+		if i < len(given) && given[i] != "" {
+			names[i] = given[i]
+		} else {
+			names[i] = "LISTEN_FD_" + strconv.Itoa(i+3)
+		}
+	}
+	return names
+}
@@ -0,0 +1,213 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/adron/golang-services-build-base/config"
+)
+
+// endpoint is the collector address split into the parts the exporters take
+type endpoint struct {
+	host     string
+	path     string
+	insecure bool
+}
+
+func parseEndpoint(raw string) (endpoint, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return endpoint{}, fmt.Errorf("invalid OTLP endpoint %q: %w", raw, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return endpoint{}, fmt.Errorf("invalid OTLP endpoint %q: must be an http or https URL", raw)
+	}
+	return endpoint{
+		host:     u.Host,
+		path:     strings.TrimSuffix(u.Path, "/"),
+		insecure: u.Scheme == "http",
+	}, nil
+}
+
+// tlsConfig builds the client TLS settings from the CA bundle and client
+// certificate in cfg. It returns nil when the system defaults suffice.
+func tlsConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.OtelCACert == "" && cfg.OtelClientCert == "" {
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.OtelCACert != "" {
+		pem, err := os.ReadFile(cfg.OtelCACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OTLP CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in OTLP CA bundle %s", cfg.OtelCACert)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.OtelClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.OtelClientCert, cfg.OtelClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load OTLP client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+// NewTraceExporter creates an OTLP span exporter using the protocol,
+// endpoint, headers, TLS, compression and timeout settings in cfg.
+func NewTraceExporter(ctx context.Context, cfg *config.Config) (sdktrace.SpanExporter, error) {
+	ep, err := parseEndpoint(cfg.OtelEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.OtelProtocol == "grpc" {
+		opts := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(ep.host),
+			otlptracegrpc.WithHeaders(cfg.OtelHeaders),
+			otlptracegrpc.WithTimeout(cfg.OtelTimeout),
+		}
+		if ep.insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		} else if tlsCfg != nil {
+			opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsCfg)))
+		}
+		if cfg.OtelCompression == "gzip" {
+			opts = append(opts, otlptracegrpc.WithCompressor("gzip"))
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	}
+
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(ep.host),
+		otlptracehttp.WithURLPath(ep.path + "/v1/traces"),
+		otlptracehttp.WithHeaders(cfg.OtelHeaders),
+		otlptracehttp.WithTimeout(cfg.OtelTimeout),
+	}
+	if ep.insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	} else if tlsCfg != nil {
+		opts = append(opts, otlptracehttp.WithTLSClientConfig(tlsCfg))
+	}
+	if cfg.OtelCompression == "gzip" {
+		opts = append(opts, otlptracehttp.WithCompression(otlptracehttp.GzipCompression))
+	}
+	return otlptracehttp.New(ctx, opts...)
+}
+
+// NewMetricExporter creates an OTLP metric exporter with the same settings
+// as NewTraceExporter.
+func NewMetricExporter(ctx context.Context, cfg *config.Config) (sdkmetric.Exporter, error) {
+	ep, err := parseEndpoint(cfg.OtelEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.OtelProtocol == "grpc" {
+		opts := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpoint(ep.host),
+			otlpmetricgrpc.WithHeaders(cfg.OtelHeaders),
+			otlpmetricgrpc.WithTimeout(cfg.OtelTimeout),
+		}
+		if ep.insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		} else if tlsCfg != nil {
+			opts = append(opts, otlpmetricgrpc.WithTLSCredentials(credentials.NewTLS(tlsCfg)))
+		}
+		if cfg.OtelCompression == "gzip" {
+			opts = append(opts, otlpmetricgrpc.WithCompressor("gzip"))
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	}
+
+	opts := []otlpmetrichttp.Option{
+		otlpmetrichttp.WithEndpoint(ep.host),
+		otlpmetrichttp.WithURLPath(ep.path + "/v1/metrics"),
+		otlpmetrichttp.WithHeaders(cfg.OtelHeaders),
+		otlpmetrichttp.WithTimeout(cfg.OtelTimeout),
+	}
+	if ep.insecure {
+		opts = append(opts, otlpmetrichttp.WithInsecure())
+	} else if tlsCfg != nil {
+		opts = append(opts, otlpmetrichttp.WithTLSClientConfig(tlsCfg))
+	}
+	if cfg.OtelCompression == "gzip" {
+		opts = append(opts, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+	}
+	return otlpmetrichttp.New(ctx, opts...)
+}
+
+// NewLogExporter creates an OTLP log exporter with the same settings as
+// NewTraceExporter.
+func NewLogExporter(ctx context.Context, cfg *config.Config) (sdklog.Exporter, error) {
+	ep, err := parseEndpoint(cfg.OtelEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.OtelProtocol == "grpc" {
+		opts := []otlploggrpc.Option{
+			otlploggrpc.WithEndpoint(ep.host),
+			otlploggrpc.WithHeaders(cfg.OtelHeaders),
+			otlploggrpc.WithTimeout(cfg.OtelTimeout),
+		}
+		if ep.insecure {
+			opts = append(opts, otlploggrpc.WithInsecure())
+		} else if tlsCfg != nil {
+			opts = append(opts, otlploggrpc.WithTLSCredentials(credentials.NewTLS(tlsCfg)))
+		}
+		if cfg.OtelCompression == "gzip" {
+			opts = append(opts, otlploggrpc.WithCompressor("gzip"))
+		}
+		return otlploggrpc.New(ctx, opts...)
+	}
+
+	opts := []otlploghttp.Option{
+		otlploghttp.WithEndpoint(ep.host),
+		otlploghttp.WithURLPath(ep.path + "/v1/logs"),
+		otlploghttp.WithHeaders(cfg.OtelHeaders),
+		otlploghttp.WithTimeout(cfg.OtelTimeout),
+	}
+	if ep.insecure {
+		opts = append(opts, otlploghttp.WithInsecure())
+	} else if tlsCfg != nil {
+		opts = append(opts, otlploghttp.WithTLSClientConfig(tlsCfg))
+	}
+	if cfg.OtelCompression == "gzip" {
+		opts = append(opts, otlploghttp.WithCompression(otlploghttp.GzipCompression))
+	}
+	return otlploghttp.New(ctx, opts...)
+}
@@ -0,0 +1,290 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/global"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/adron/golang-services-build-base/config"
+)
+
+// Option customizes a Provider
+type Option func(*options)
+
+type options struct {
+	spanExporter  sdktrace.SpanExporter
+	metricReaders []sdkmetric.Reader
+	logExporter   sdklog.Exporter
+	global        bool
+}
+
+// WithSpanExporter exports spans to exp instead of the configured OTLP collector
+func WithSpanExporter(exp sdktrace.SpanExporter) Option {
+	return func(o *options) {
+		o.spanExporter = exp
+	}
+}
+
+// WithMetricReader reads metrics with r instead of the periodic OTLP reader
+func WithMetricReader(r sdkmetric.Reader) Option {
+	return func(o *options) {
+		o.metricReaders = append(o.metricReaders, r)
+	}
+}
+
+// WithLogExporter exports logs to exp, whether or not OTLP log export is enabled
+func WithLogExporter(exp sdklog.Exporter) Option {
+	return func(o *options) {
+		o.logExporter = exp
+	}
+}
+
+// WithGlobal registers the providers as the OpenTelemetry globals on Start
+func WithGlobal() Option {
+	return func(o *options) {
+		o.global = true
+	}
+}
+
+// Provider owns the tracer and meter providers for the service. Tracer and
+// Meter return no-op implementations until Start succeeds.
+type Provider struct {
+	mu      sync.RWMutex
+	cfg     *config.Config
+	opts    options
+	sampler *ratioSampler
+	tp      *sdktrace.TracerProvider
+	mp      *sdkmetric.MeterProvider
+	lp      *sdklog.LoggerProvider
+
+	metricsHandler http.Handler
+}
+
+// New creates a Provider for cfg without starting any exporters
+func New(cfg *config.Config, opts ...Option) *Provider {
+	p := &Provider{cfg: cfg, sampler: newRatioSampler(cfg.Traces.SampleRatio)}
+	for _, opt := range opts {
+		opt(&p.opts)
+	}
+	return p
+}
+
+// SetSampleRatio changes the fraction of new traces recorded. Spans
+// already started keep their decision.
+func (p *Provider) SetSampleRatio(ratio float64) {
+	p.sampler.set(ratio)
+}
+
+// Start creates the exporters and providers
+func (p *Provider) Start(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.tp != nil {
+		return errors.New("telemetry provider already started")
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName(p.cfg.ServiceName),
+			semconv.ServiceVersion(p.cfg.ServiceVersion),
+			semconv.ServiceNamespace(p.cfg.ServiceNamespace),
+		),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	spanExporter := p.opts.spanExporter
+	if spanExporter == nil && p.cfg.Traces.OTLPEnabled {
+		spanExporter, err = NewTraceExporter(ctx, p.cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create trace exporter: %w", err)
+		}
+	}
+
+	readers := p.opts.metricReaders
+	if len(readers) == 0 && p.cfg.Metrics.OTLPEnabled {
+		metricExporter, err := NewMetricExporter(ctx, p.cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create metric exporter: %w", err)
+		}
+		readers = []sdkmetric.Reader{sdkmetric.NewPeriodicReader(metricExporter)}
+	}
+
+	var metricsHandler http.Handler
+	if p.cfg.Metrics.PrometheusEnabled {
+		registry := prom.NewRegistry()
+		promExporter, err := prometheus.New(
+			prometheus.WithRegisterer(registry),
+			prometheus.WithNamespace(p.cfg.Metrics.PrometheusPrefix),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create prometheus exporter: %w", err)
+		}
+		readers = append(readers, promExporter)
+		metricsHandler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	}
+
+	logExporter := p.opts.logExporter
+	if logExporter == nil && p.cfg.Logs.OTLPEnabled {
+		logExporter, err = NewLogExporter(ctx, p.cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create log exporter: %w", err)
+		}
+	}
+
+	tpOpts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(p.sampler)),
+	}
+	if spanExporter != nil {
+		tpOpts = append(tpOpts, sdktrace.WithBatcher(spanExporter))
+	}
+	p.tp = sdktrace.NewTracerProvider(tpOpts...)
+
+	mpOpts := []sdkmetric.Option{sdkmetric.WithResource(res)}
+	for _, r := range readers {
+		mpOpts = append(mpOpts, sdkmetric.WithReader(r))
+	}
+	p.mp = sdkmetric.NewMeterProvider(mpOpts...)
+	p.metricsHandler = metricsHandler
+
+	if logExporter != nil {
+		p.lp = sdklog.NewLoggerProvider(
+			sdklog.WithProcessor(sdklog.NewBatchProcessor(logExporter)),
+			sdklog.WithResource(res),
+		)
+	}
+
+	if p.opts.global {
+		otel.SetTracerProvider(p.tp)
+		otel.SetMeterProvider(p.mp)
+		otel.SetTextMapPropagator(Propagator())
+		if p.lp != nil {
+			global.SetLoggerProvider(p.lp)
+		}
+	}
+	return nil
+}
+
+// ratioSampler samples a fraction of traces that can change while the
+// tracer provider is in use
+type ratioSampler struct {
+	current atomic.Pointer[sdktrace.Sampler]
+}
+
+func newRatioSampler(ratio float64) *ratioSampler {
+	s := &ratioSampler{}
+	s.set(ratio)
+	return s
+}
+
+func (s *ratioSampler) set(ratio float64) {
+	sampler := sdktrace.TraceIDRatioBased(ratio)
+	s.current.Store(&sampler)
+}
+
+// ShouldSample defers to the sampler for the current ratio
+func (s *ratioSampler) ShouldSample(params sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	return (*s.current.Load()).ShouldSample(params)
+}
+
+// Description describes the sampler for the current ratio
+func (s *ratioSampler) Description() string {
+	return (*s.current.Load()).Description()
+}
+
+// Propagator returns the W3C trace-context and baggage propagator used for
+// incoming and outgoing requests
+func Propagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// Tracer returns the service tracer
+func (p *Provider) Tracer() trace.Tracer {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.tp == nil {
+		return tracenoop.NewTracerProvider().Tracer(p.cfg.ServiceName)
+	}
+	return p.tp.Tracer(p.cfg.ServiceName)
+}
+
+// Meter returns the service meter
+func (p *Provider) Meter() metric.Meter {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.mp == nil {
+		return metricnoop.NewMeterProvider().Meter(p.cfg.ServiceName)
+	}
+	return p.mp.Meter(p.cfg.ServiceName)
+}
+
+// LoggerProvider returns the OTLP logger provider, or nil when log export is
+// disabled or the provider has not been started.
+func (p *Provider) LoggerProvider() otellog.LoggerProvider {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.lp == nil {
+		return nil
+	}
+	return p.lp
+}
+
+// MetricsHandler returns the Prometheus scrape handler, or nil when the
+// Prometheus endpoint is disabled or the provider has not been started.
+func (p *Provider) MetricsHandler() http.Handler {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.metricsHandler
+}
+
+// ForceFlush exports all buffered spans and metrics without stopping the providers
+func (p *Provider) ForceFlush(ctx context.Context) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.tp == nil {
+		return nil
+	}
+	err := errors.Join(p.tp.ForceFlush(ctx), p.mp.ForceFlush(ctx))
+	if p.lp != nil {
+		err = errors.Join(err, p.lp.ForceFlush(ctx))
+	}
+	return err
+}
+
+// Shutdown flushes and stops the providers. It is safe to call more than once.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tp == nil {
+		return nil
+	}
+	err := errors.Join(p.tp.Shutdown(ctx), p.mp.Shutdown(ctx))
+	if p.lp != nil {
+		err = errors.Join(err, p.lp.Shutdown(ctx))
+	}
+	p.tp, p.mp, p.lp, p.metricsHandler = nil, nil, nil, nil
+	return err
+}
@@ -0,0 +1,233 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/adron/golang-services-build-base/config"
+)
+
+var minVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":            tls.NoClientCert,
+	"verify_if_given": tls.VerifyClientCertIfGiven,
+	"require":         tls.RequireAndVerifyClientCert,
+}
+
+// Reloader holds the server certificate and client CA pool loaded from disk
+// and swaps in new ones when the files change. Handshakes after a reload
+// use the new files; established connections are left alone.
+type Reloader struct {
+	cfg    config.TLSConfig
+	base   *tls.Config
+	logger *logrus.Logger
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	stamps   map[string]fileStamp
+}
+
+// fileStamp identifies one version of a file on disk
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// New loads the certificate, key and client CA bundle named in cfg and
+// returns a Reloader serving them.
+func New(cfg config.TLSConfig, logger *logrus.Logger) (*Reloader, error) {
+	minVersion, ok := minVersions[cfg.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported TLS minimum version %q", cfg.MinVersion)
+	}
+	clientAuth, ok := clientAuthTypes[cfg.ClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("unsupported TLS client auth %q", cfg.ClientAuth)
+	}
+	suites, err := cipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reloader{
+		cfg:    cfg,
+		logger: logger,
+		base: &tls.Config{
+			MinVersion:   minVersion,
+			CipherSuites: suites,
+			ClientAuth:   clientAuth,
+			NextProtos:   []string{"h2", "http/1.1"},
+		},
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Config returns the server TLS configuration. Every handshake picks up
+// the current certificate and client CA pool.
+func (r *Reloader) Config() *tls.Config {
+	cfg := r.base.Clone()
+	cfg.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.cert, nil
+	}
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		conn := r.base.Clone()
+		conn.Certificates = []tls.Certificate{*r.cert}
+		conn.ClientCAs = r.clientCA
+		return conn, nil
+	}
+	return cfg
+}
+
+// Certificate returns the leaf certificate currently served
+func (r *Reloader) Certificate() *x509.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert.Leaf
+}
+
+// Reload re-reads the files if any of them changed. A failed reload keeps
+// serving the previous certificate, so a half-written rotation is retried
+// on the next call.
+func (r *Reloader) Reload() (bool, error) {
+	r.mu.RLock()
+	changed := false
+	for path, stamp := range r.stamps {
+		if current, err := stat(path); err != nil || current != stamp {
+			changed = true
+			break
+		}
+	}
+	r.mu.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+	if err := r.load(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Watch calls Reload every interval until ctx is done
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				r.logger.Errorf("Failed to reload TLS certificate, keeping the current one: %v", err)
+				continue
+			}
+			if reloaded {
+				leaf := r.Certificate()
+				r.logger.WithFields(logrus.Fields{
+					"subject":   leaf.Subject.String(),
+					"not_after": leaf.NotAfter,
+				}).Info("Reloaded TLS certificate")
+			}
+		}
+	}
+}
+
+func (r *Reloader) load() error {
+	paths := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		paths = append(paths, r.cfg.ClientCAFile)
+	}
+
+	// Stat before reading so a rotation during the read is seen next time
+	stamps := make(map[string]fileStamp, len(paths))
+	for _, path := range paths {
+		stamp, err := stat(path)
+		if err != nil {
+			return err
+		}
+		stamps[path] = stamp
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS key pair: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse TLS certificate: %w", err)
+		}
+	}
+
+	var pool *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA bundle: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("client CA bundle contains no PEM certificates")
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.clientCA = pool
+	r.stamps = stamps
+	return nil
+}
+
+func stat(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// cipherSuites maps suite names to IDs. An empty list keeps Go's defaults.
+// The list only applies to TLS 1.2; TLS 1.3 suites are not configurable.
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	ids := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		ids[suite.Name] = suite.ID
+	}
+	suites := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := ids[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
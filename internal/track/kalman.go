@@ -0,0 +1,101 @@
+package track
+
+import "github.com/adron/golang-services-build-base/internal/frames"
+
+// Noise levels, relative to the box's height so that near and far objects
+// are filtered alike
+const (
+	// measurementNoise is the standard deviation of a detection's edges
+	measurementNoise = 0.05
+	// accelerationNoise is the standard deviation of changes in velocity,
+	// per second
+	accelerationNoise = 0.5
+	// initialVelocityNoise is the standard deviation of a new track's
+	// unknown velocity, per second
+	initialVelocityNoise = 1.0
+)
+
+// axis is a constant-velocity Kalman filter over one coordinate. With a
+// diagonal measurement and process noise, a filter over a box's centre and
+// size splits exactly into one such filter per coordinate.
+type axis struct {
+	pos, vel float64
+	// p is the covariance of (pos, vel)
+	p00, p01, p11 float64
+}
+
+func newAxis(pos, scale float64) axis {
+	return axis{
+		pos: pos,
+		p00: sq(measurementNoise * scale),
+		p11: sq(initialVelocityNoise * scale),
+	}
+}
+
+// predict advances the filter dt seconds
+func (a *axis) predict(dt, scale float64) {
+	if dt <= 0 {
+		return
+	}
+	a.pos += a.vel * dt
+	q := sq(accelerationNoise * scale)
+	p00 := a.p00 + 2*dt*a.p01 + dt*dt*a.p11 + q*dt*dt*dt*dt/4
+	p01 := a.p01 + dt*a.p11 + q*dt*dt*dt/2
+	p11 := a.p11 + q*dt*dt
+	a.p00, a.p01, a.p11 = p00, p01, p11
+}
+
+// update corrects the filter with a measured position
+func (a *axis) update(z, scale float64) {
+	s := a.p00 + sq(measurementNoise*scale)
+	k0, k1 := a.p00/s, a.p01/s
+	y := z - a.pos
+	a.pos += k0 * y
+	a.vel += k1 * y
+	a.p00, a.p01, a.p11 = (1-k0)*a.p00, (1-k0)*a.p01, a.p11-k1*a.p01
+}
+
+// filter tracks a box as its centre and size
+type filter struct {
+	cx, cy, w, h axis
+}
+
+func newFilter(b frames.Box) filter {
+	return filter{
+		cx: newAxis(b.X+b.Width/2, b.Height),
+		cy: newAxis(b.Y+b.Height/2, b.Height),
+		w:  newAxis(b.Width, b.Height),
+		h:  newAxis(b.Height, b.Height),
+	}
+}
+
+func (f *filter) predict(dt float64) {
+	scale := f.h.pos
+	f.cx.predict(dt, scale)
+	f.cy.predict(dt, scale)
+	f.w.predict(dt, scale)
+	f.h.predict(dt, scale)
+}
+
+func (f *filter) update(b frames.Box) {
+	scale := b.Height
+	f.cx.update(b.X+b.Width/2, scale)
+	f.cy.update(b.Y+b.Height/2, scale)
+	f.w.update(b.Width, scale)
+	f.h.update(b.Height, scale)
+}
+
+// box returns the filtered box
+func (f *filter) box() frames.Box {
+	w, h := max(f.w.pos, 0), max(f.h.pos, 0)
+	return frames.Box{X: f.cx.pos - w/2, Y: f.cy.pos - h/2, Width: w, Height: h}
+}
+
+// velocity returns the centre's velocity in pixels per second
+func (f *filter) velocity() Velocity {
+	return Velocity{X: f.cx.vel, Y: f.cy.vel}
+}
+
+func sq(v float64) float64 {
+	return v * v
+}
@@ -0,0 +1,375 @@
+// Package track follows objects across a camera's frames, SORT style:
+// each track's box is predicted forward with a Kalman filter and matched
+// to the frame's detections by overlap.
+package track
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/frames"
+)
+
+// Track lifecycle event types
+const (
+	// EventCreated is sent when a track is confirmed
+	EventCreated = "created"
+	// EventUpdated is sent for each later frame the track is matched in
+	EventUpdated = "updated"
+	// EventLost is sent when a track has gone unmatched for too long
+	EventLost = "lost"
+)
+
+// Velocity is a track's motion in pixels per second
+type Velocity struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Track is an object followed across a camera's frames
+type Track struct {
+	ID         uint64     `json:"id"`
+	Camera     string     `json:"camera"`
+	Class      string     `json:"class"`
+	Box        frames.Box `json:"box"`
+	Velocity   Velocity   `json:"velocity"`
+	Confidence float64    `json:"confidence"`
+	Hits       int        `json:"hits"`
+	FirstSeen  time.Time  `json:"first_seen"`
+	LastSeen   time.Time  `json:"last_seen"`
+}
+
+// Event reports a change in a track's lifecycle. Time is the capture time
+// of the frame that caused it.
+type Event struct {
+	Type  string    `json:"type"`
+	Track Track     `json:"track"`
+	Time  time.Time `json:"time"`
+}
+
+// EventFunc receives track events. It is called from the frame's worker,
+// in order for each camera, and must not call back into the tracker.
+type EventFunc func(ctx context.Context, e Event)
+
+// track is a Track with its filter and matching state
+type track struct {
+	Track
+	filter    filter
+	streak    int
+	confirmed bool
+}
+
+func (t *track) snapshot() Track {
+	s := t.Track
+	s.Box = t.filter.box()
+	s.Velocity = t.filter.velocity()
+	return s
+}
+
+// camera is the tracker state for one camera
+type camera struct {
+	mu       sync.Mutex
+	tracks   []*track
+	sequence uint64
+	captured time.Time
+	updated  time.Time
+	removed  bool
+}
+
+// Tracker assigns persistent IDs to detections, keeping separate tracks
+// for each camera. It is safe for concurrent use.
+type Tracker struct {
+	cfg    config.TrackingConfig
+	nextID atomic.Uint64
+
+	// mu guards cameras and is taken before any camera's lock
+	mu      sync.Mutex
+	cameras map[string]*camera
+
+	// lmu guards the listeners and metrics, and is taken last
+	lmu       sync.Mutex
+	listeners []EventFunc
+	events    metric.Int64Counter
+}
+
+// New creates a tracker for cfg
+func New(cfg config.TrackingConfig) *Tracker {
+	events, _ := noop.NewMeterProvider().Meter("").Int64Counter("tracks.events")
+	return &Tracker{cfg: cfg, cameras: make(map[string]*camera), events: events}
+}
+
+// RegisterMetrics records lifecycle events and the number of confirmed
+// tracks on meter
+func (t *Tracker) RegisterMetrics(meter metric.Meter) error {
+	events, err := meter.Int64Counter("tracks.events",
+		metric.WithDescription("Track lifecycle events, by type"),
+		metric.WithUnit("{event}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create track event counter: %w", err)
+	}
+	_, err = meter.Int64ObservableGauge("tracks.active",
+		metric.WithDescription("Confirmed tracks being followed, by camera"),
+		metric.WithUnit("{track}"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			for id, n := range t.Active() {
+				o.Observe(int64(n), metric.WithAttributes(attribute.String("camera.id", id)))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create active track gauge: %w", err)
+	}
+	t.lmu.Lock()
+	defer t.lmu.Unlock()
+	t.events = events
+	return nil
+}
+
+// Subscribe calls fn with every later event
+func (t *Tracker) Subscribe(fn EventFunc) {
+	t.lmu.Lock()
+	defer t.lmu.Unlock()
+	t.listeners = append(t.listeners, fn)
+}
+
+// Active returns the number of confirmed tracks on each camera
+func (t *Tracker) Active() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	active := make(map[string]int, len(t.cameras))
+	for id, cam := range t.cameras {
+		cam.mu.Lock()
+		for _, tr := range cam.tracks {
+			if tr.confirmed {
+				active[id]++
+			}
+		}
+		cam.mu.Unlock()
+	}
+	return active
+}
+
+// Tracks returns the confirmed tracks on a camera
+func (t *Tracker) Tracks(cameraID string) []Track {
+	t.mu.Lock()
+	cam := t.cameras[cameraID]
+	t.mu.Unlock()
+	if cam == nil {
+		return nil
+	}
+	cam.mu.Lock()
+	defer cam.mu.Unlock()
+	var tracks []Track
+	for _, tr := range cam.tracks {
+		if tr.confirmed {
+			tracks = append(tracks, tr.snapshot())
+		}
+	}
+	return tracks
+}
+
+// camera returns the state for id, creating it if needed, locked
+func (t *Tracker) camera(id string) *camera {
+	for {
+		t.mu.Lock()
+		cam := t.cameras[id]
+		if cam == nil {
+			cam = &camera{}
+			t.cameras[id] = cam
+		}
+		t.mu.Unlock()
+
+		cam.mu.Lock()
+		if !cam.removed {
+			return cam
+		}
+		cam.mu.Unlock()
+	}
+}
+
+// Update matches the detections found in f to the camera's tracks and
+// returns them with the IDs of confirmed tracks set. The pipeline hands
+// over each camera's frames in order; frames older than one already
+// tracked, from any other caller, are returned unchanged.
+func (t *Tracker) Update(ctx context.Context, f *frames.Frame, detections []frames.Detection) []frames.Detection {
+	cam := t.camera(f.Camera)
+	defer cam.mu.Unlock()
+	if f.Sequence <= cam.sequence {
+		return detections
+	}
+	dt := 0.0
+	if !cam.captured.IsZero() {
+		dt = f.CapturedAt.Sub(cam.captured).Seconds()
+	}
+	cam.sequence, cam.captured, cam.updated = f.Sequence, f.CapturedAt, time.Now()
+	for _, tr := range cam.tracks {
+		tr.filter.predict(dt)
+	}
+
+	out := append([]frames.Detection(nil), detections...)
+	matched := make([]bool, len(cam.tracks))
+	used := make([]bool, len(out))
+	var events []Event
+	for _, m := range t.associate(cam.tracks, out) {
+		tr, d := cam.tracks[m.track], out[m.detection]
+		matched[m.track], used[m.detection] = true, true
+		tr.filter.update(d.Box)
+		tr.Confidence, tr.LastSeen = d.Confidence, f.CapturedAt
+		tr.Hits++
+		tr.streak++
+		switch {
+		case tr.confirmed:
+			events = append(events, Event{Type: EventUpdated, Track: tr.snapshot(), Time: f.CapturedAt})
+		case tr.streak >= t.cfg.MinHits:
+			t.confirm(tr)
+			events = append(events, Event{Type: EventCreated, Track: tr.snapshot(), Time: f.CapturedAt})
+		}
+		if tr.confirmed {
+			out[m.detection].TrackID = tr.ID
+		}
+	}
+
+	// Tentative tracks must be matched in every frame until confirmed;
+	// confirmed ones coast until MaxAge
+	kept := cam.tracks[:0]
+	for i, tr := range cam.tracks {
+		if !matched[i] {
+			tr.streak = 0
+			if !tr.confirmed {
+				continue
+			}
+			if f.CapturedAt.Sub(tr.LastSeen) > t.cfg.MaxAge {
+				events = append(events, Event{Type: EventLost, Track: tr.snapshot(), Time: f.CapturedAt})
+				continue
+			}
+		}
+		kept = append(kept, tr)
+	}
+	cam.tracks = kept
+
+	for j, d := range out {
+		if used[j] {
+			continue
+		}
+		tr := &track{
+			Track:  Track{Camera: f.Camera, Class: d.Class, Confidence: d.Confidence, Hits: 1, FirstSeen: f.CapturedAt, LastSeen: f.CapturedAt},
+			filter: newFilter(d.Box),
+			streak: 1,
+		}
+		if t.cfg.MinHits <= 1 {
+			t.confirm(tr)
+			out[j].TrackID = tr.ID
+			events = append(events, Event{Type: EventCreated, Track: tr.snapshot(), Time: f.CapturedAt})
+		}
+		cam.tracks = append(cam.tracks, tr)
+	}
+
+	t.emit(ctx, events)
+	return out
+}
+
+// Expire loses the tracks of cameras that have sent no frames for MaxAge
+// and forgets those cameras
+func (t *Tracker) Expire(ctx context.Context, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, cam := range t.cameras {
+		cam.mu.Lock()
+		if now.Sub(cam.updated) > t.cfg.MaxAge {
+			var events []Event
+			for _, tr := range cam.tracks {
+				if tr.confirmed {
+					events = append(events, Event{Type: EventLost, Track: tr.snapshot(), Time: now})
+				}
+			}
+			cam.removed = true
+			delete(t.cameras, id)
+			t.emit(ctx, events)
+		}
+		cam.mu.Unlock()
+	}
+}
+
+// Watch calls Expire every interval until ctx is done
+func (t *Tracker) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.Expire(ctx, now)
+		}
+	}
+}
+
+func (t *Tracker) confirm(tr *track) {
+	tr.ID = t.nextID.Add(1)
+	tr.confirmed = true
+}
+
+// emit sends events to the listeners. The caller holds the camera's lock,
+// so each camera's events arrive in order.
+func (t *Tracker) emit(ctx context.Context, events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	t.lmu.Lock()
+	defer t.lmu.Unlock()
+	for _, e := range events {
+		t.events.Add(ctx, 1, metric.WithAttributes(attribute.String("type", e.Type)))
+		for _, fn := range t.listeners {
+			fn(ctx, e)
+		}
+	}
+}
+
+// match pairs a track with a detection
+type match struct {
+	track, detection int
+	iou              float64
+}
+
+// associate pairs tracks with detections of the same class, best overlap
+// first, ignoring pairs overlapping by less than IoUThreshold
+func (t *Tracker) associate(tracks []*track, detections []frames.Detection) []match {
+	var candidates []match
+	for i, tr := range tracks {
+		predicted := tr.filter.box()
+		for j, d := range detections {
+			if d.Class != tr.Class {
+				continue
+			}
+			if iou := predicted.IoU(d.Box); iou >= t.cfg.IoUThreshold {
+				candidates = append(candidates, match{track: i, detection: j, iou: iou})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return candidates[a].iou > candidates[b].iou
+	})
+
+	trackUsed := make([]bool, len(tracks))
+	detectionUsed := make([]bool, len(detections))
+	var matches []match
+	for _, c := range candidates {
+		if trackUsed[c.track] || detectionUsed[c.detection] {
+			continue
+		}
+		trackUsed[c.track], detectionUsed[c.detection] = true, true
+		matches = append(matches, c)
+	}
+	return matches
+}
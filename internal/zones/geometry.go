@@ -0,0 +1,76 @@
+package zones
+
+import "math"
+
+// Point is an [x, y] position in frame pixels
+type Point [2]float64
+
+// Crossing directions of a line zone, as seen looking along the line from
+// its first point to its last in the image
+const (
+	LeftToRight = "left_to_right"
+	RightToLeft = "right_to_left"
+)
+
+// shape is a zone's points prepared for fast tests
+type shape struct {
+	points                 []Point
+	minX, minY, maxX, maxY float64
+}
+
+func newShape(points []Point) shape {
+	s := shape{points: points, minX: math.Inf(1), minY: math.Inf(1), maxX: math.Inf(-1), maxY: math.Inf(-1)}
+	for _, p := range points {
+		s.minX, s.maxX = math.Min(s.minX, p[0]), math.Max(s.maxX, p[0])
+		s.minY, s.maxY = math.Min(s.minY, p[1]), math.Max(s.maxY, p[1])
+	}
+	return s
+}
+
+// contains reports whether p is inside the polygon, by the even-odd rule.
+// Points outside the bounding box are rejected without visiting the edges.
+func (s *shape) contains(p Point) bool {
+	x, y := p[0], p[1]
+	if x < s.minX || x > s.maxX || y < s.minY || y > s.maxY {
+		return false
+	}
+	inside := false
+	n := len(s.points)
+	for i, j := 0, n-1; i < n; j, i = i, i+1 {
+		a, b := s.points[i], s.points[j]
+		if (a[1] > y) != (b[1] > y) && x < (b[0]-a[0])*(y-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// crossing reports whether moving from p to q crosses the polyline, and in
+// which direction. A point on the line counts as being on its right.
+func (s *shape) crossing(p, q Point) (string, bool) {
+	if math.Max(p[0], q[0]) < s.minX || math.Min(p[0], q[0]) > s.maxX ||
+		math.Max(p[1], q[1]) < s.minY || math.Min(p[1], q[1]) > s.maxY {
+		return "", false
+	}
+	for i := 0; i+1 < len(s.points); i++ {
+		a, b := s.points[i], s.points[i+1]
+		fromRight, toRight := cross(a, b, p) >= 0, cross(a, b, q) >= 0
+		if fromRight == toRight {
+			continue
+		}
+		if cross(p, q, a)*cross(p, q, b) > 0 {
+			continue
+		}
+		if toRight {
+			return LeftToRight, true
+		}
+		return RightToLeft, true
+	}
+	return "", false
+}
+
+// cross returns the z component of (b-a) x (p-a), which is positive when
+// p is to the right of a→b in image coordinates, where y points down
+func cross(a, b, p Point) float64 {
+	return (b[0]-a[0])*(p[1]-a[1]) - (b[1]-a[1])*(p[0]-a[0])
+}
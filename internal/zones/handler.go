@@ -0,0 +1,98 @@
+package zones
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/adron/golang-services-build-base/internal/httpjson"
+)
+
+// maxBodyBytes bounds a zone upload
+const maxBodyBytes = 64 << 10
+
+// ListHandler serves the zones of the camera in the route's {camera}
+// variable
+func (r *Registry) ListHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httpjson.Write(w, http.StatusOK, r.List(mux.Vars(req)["camera"]))
+	})
+}
+
+// GetHandler serves the zone in the route's {camera} and {zone} variables,
+// with its version as the ETag
+func (r *Registry) GetHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		status, ok := r.Get(vars["camera"], vars["zone"])
+		if !ok {
+			httpjson.WriteError(w, http.StatusNotFound, "not_found", "no such zone on this camera")
+			return
+		}
+		w.Header().Set("ETag", etag(status.Version))
+		httpjson.Write(w, http.StatusOK, status)
+	})
+}
+
+// PutHandler creates or replaces the zone in the route's {camera} and
+// {zone} variables from a JSON body of its name, kind and points. An
+// If-Match header makes the change conditional on the zone's current
+// version.
+func (r *Registry) PutHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		var body struct {
+			Name   string  `json:"name"`
+			Kind   string  `json:"kind"`
+			Points []Point `json:"points"`
+		}
+		dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxBodyBytes))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			httpjson.WriteError(w, http.StatusBadRequest, "invalid_request", "body must be a JSON zone: "+err.Error())
+			return
+		}
+
+		z := Zone{Camera: vars["camera"], ID: vars["zone"], Name: body.Name, Kind: body.Kind, Points: body.Points}
+		z, created, err := r.put(req.Context(), z, req.Header.Get("If-Match"))
+		if errors.Is(err, errPrecondition) {
+			httpjson.WriteError(w, http.StatusPreconditionFailed, "precondition_failed", err.Error())
+			return
+		}
+		if err != nil {
+			httpjson.WriteError(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		w.Header().Set("ETag", etag(z.Version))
+		httpjson.Write(w, status, z)
+	})
+}
+
+// DeleteHandler removes the zone in the route's {camera} and {zone}
+// variables, honouring If-Match as PutHandler does
+func (r *Registry) DeleteHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		existed, err := r.delete(req.Context(), vars["camera"], vars["zone"], req.Header.Get("If-Match"))
+		if err != nil {
+			httpjson.WriteError(w, http.StatusPreconditionFailed, "precondition_failed", err.Error())
+			return
+		}
+		if !existed {
+			httpjson.WriteError(w, http.StatusNotFound, "not_found", "no such zone on this camera")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func etag(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
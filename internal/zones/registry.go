@@ -0,0 +1,430 @@
+// Package zones keeps each camera's zones, such as a drive-thru lane or a
+// walk-in line, and follows which tracks are in them.
+package zones
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/frames"
+	"github.com/adron/golang-services-build-base/internal/track"
+)
+
+// Zone kinds
+const (
+	KindPolygon = "polygon"
+	KindLine    = "line"
+)
+
+// maxPoints bounds a zone's points, keeping membership tests cheap
+const maxPoints = 256
+
+// ErrInvalid is returned for zones that cannot be stored
+var ErrInvalid = errors.New("invalid zone")
+
+// errPrecondition is returned when a change's If-Match does not name the
+// zone's current version
+var errPrecondition = errors.New("the zone has changed since the version in If-Match")
+
+// idPattern is what camera and zone IDs must match, the same as for
+// uploaded frames
+var idPattern = frames.CameraPattern
+
+// Zone is an area or line in a camera's image. Version changes on every
+// change to any zone, so each version of a zone is distinct even across
+// deletion and re-creation.
+type Zone struct {
+	Camera    string    `json:"camera"`
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Kind      string    `json:"kind"`
+	Points    []Point   `json:"points"`
+	Version   uint64    `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks that z can be stored
+func (z *Zone) Validate() error {
+	if !idPattern.MatchString(z.Camera) || !idPattern.MatchString(z.ID) {
+		return fmt.Errorf("%w: camera and zone IDs must be 1 to 64 letters, digits, '.', '_' or '-'", ErrInvalid)
+	}
+	least := 3
+	switch z.Kind {
+	case KindPolygon:
+	case KindLine:
+		least = 2
+	default:
+		return fmt.Errorf("%w: kind must be %s or %s", ErrInvalid, KindPolygon, KindLine)
+	}
+	if len(z.Points) < least || len(z.Points) > maxPoints {
+		return fmt.Errorf("%w: a %s needs %d to %d points, got %d", ErrInvalid, z.Kind, least, maxPoints, len(z.Points))
+	}
+	for _, p := range z.Points {
+		if math.IsNaN(p[0]) || math.IsNaN(p[1]) || math.IsInf(p[0], 0) || math.IsInf(p[1], 0) {
+			return fmt.Errorf("%w: points must be finite", ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// Status is a zone with the tracks in it, for polygons, or the tracks that
+// have crossed it by direction, for lines, since its current version
+type Status struct {
+	Zone
+	Occupancy int              `json:"occupancy"`
+	Tracks    []uint64         `json:"tracks"`
+	Crossings map[string]int64 `json:"crossings,omitempty"`
+}
+
+// zone is a Zone with its prepared shape and counts
+type zone struct {
+	Zone
+	shape     shape
+	members   map[uint64]bool
+	crossings map[string]int64
+}
+
+func (z *zone) status() Status {
+	s := Status{Zone: z.Zone, Occupancy: len(z.members), Tracks: []uint64{}}
+	for id := range z.members {
+		s.Tracks = append(s.Tracks, id)
+	}
+	sort.Slice(s.Tracks, func(i, j int) bool { return s.Tracks[i] < s.Tracks[j] })
+	if z.Kind == KindLine {
+		s.Crossings = map[string]int64{LeftToRight: z.crossings[LeftToRight], RightToLeft: z.crossings[RightToLeft]}
+	}
+	return s
+}
+
+// attrs labels a zone's measurements. The version is left off so that
+// editing a zone does not start new series; zones.info carries it.
+func (z *zone) attrs(extra ...attribute.KeyValue) metric.MeasurementOption {
+	return metric.WithAttributes(append([]attribute.KeyValue{
+		attribute.String("camera.id", z.Camera),
+		attribute.String("zone.id", z.ID),
+	}, extra...)...)
+}
+
+// camera holds a camera's zones and the last foot-point of each of its
+// tracks. Only cameras with zones have one, so tracks on other cameras
+// are not followed; a track already inside a camera's first zone enters
+// it on its next update.
+type camera struct {
+	zones map[string]*zone
+	feet  map[uint64]Point
+}
+
+// Registry stores zones and counts the tracks in them. It is safe for
+// concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	cameras  map[string]*camera
+	revision uint64
+	now      func() time.Time
+
+	transitions metric.Int64Counter
+	crossings   metric.Int64Counter
+	changes     metric.Int64Counter
+}
+
+// New creates a registry holding the zones in cfg
+func New(cfg []config.ZoneConfig) (*Registry, error) {
+	meter := noop.NewMeterProvider().Meter("")
+	transitions, _ := meter.Int64Counter("zones.transitions")
+	crossings, _ := meter.Int64Counter("zones.crossings")
+	changes, _ := meter.Int64Counter("zones.changes")
+	r := &Registry{
+		cameras:     make(map[string]*camera),
+		now:         time.Now,
+		transitions: transitions,
+		crossings:   crossings,
+		changes:     changes,
+	}
+	for _, zc := range cfg {
+		z := Zone{Camera: zc.Camera, ID: zc.ID, Name: zc.Name, Kind: zc.Kind}
+		for _, p := range zc.Points {
+			z.Points = append(z.Points, Point(p))
+		}
+		if _, _, err := r.Put(context.Background(), z); err != nil {
+			return nil, fmt.Errorf("zone %s on camera %s: %w", zc.ID, zc.Camera, err)
+		}
+	}
+	return r, nil
+}
+
+// RegisterMetrics records occupancy, entries and exits, line crossings and
+// zone changes on meter, labelled with the camera and zone, and each
+// zone's current version and kind as zones.info.
+func (r *Registry) RegisterMetrics(meter metric.Meter) error {
+	transitions, err := meter.Int64Counter("zones.transitions",
+		metric.WithDescription("Tracks entering and leaving polygon zones"),
+		metric.WithUnit("{track}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create zone transition counter: %w", err)
+	}
+	crossings, err := meter.Int64Counter("zones.crossings",
+		metric.WithDescription("Tracks crossing line zones, by direction"),
+		metric.WithUnit("{track}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create zone crossing counter: %w", err)
+	}
+	changes, err := meter.Int64Counter("zones.changes",
+		metric.WithDescription("Zones created, updated and deleted"),
+		metric.WithUnit("{change}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create zone change counter: %w", err)
+	}
+	_, err = meter.Int64ObservableGauge("zones.occupancy",
+		metric.WithDescription("Tracks inside each polygon zone"),
+		metric.WithUnit("{track}"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			for _, cam := range r.cameras {
+				for _, z := range cam.zones {
+					if z.Kind == KindPolygon {
+						o.Observe(int64(len(z.members)), z.attrs())
+					}
+				}
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create zone occupancy gauge: %w", err)
+	}
+	_, err = meter.Int64ObservableGauge("zones.info",
+		metric.WithDescription("1 for each zone, labelled with its current version and kind"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			for _, cam := range r.cameras {
+				for _, z := range cam.zones {
+					o.Observe(1, z.attrs(
+						attribute.Int64("zone.version", int64(z.Version)),
+						attribute.String("zone.kind", z.Kind),
+					))
+				}
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create zone info gauge: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transitions, r.crossings, r.changes = transitions, crossings, changes
+	return nil
+}
+
+// List returns the status of each of a camera's zones, ordered by ID
+func (r *Registry) List(cameraID string) []Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := []Status{}
+	if cam := r.cameras[cameraID]; cam != nil {
+		for _, z := range cam.zones {
+			statuses = append(statuses, z.status())
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// All returns the status of every zone, ordered by camera and ID
+func (r *Registry) All() []Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := []Status{}
+	for _, cam := range r.cameras {
+		for _, z := range cam.zones {
+			statuses = append(statuses, z.status())
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Camera != statuses[j].Camera {
+			return statuses[i].Camera < statuses[j].Camera
+		}
+		return statuses[i].ID < statuses[j].ID
+	})
+	return statuses
+}
+
+// Get returns the status of a zone
+func (r *Registry) Get(cameraID, zoneID string) (Status, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cam := r.cameras[cameraID]; cam != nil {
+		if z := cam.zones[zoneID]; z != nil {
+			return z.status(), true
+		}
+	}
+	return Status{}, false
+}
+
+// Put creates or replaces a zone, giving it a new version, and reports
+// whether it was created. A replaced polygon's occupancy is worked out
+// again from the tracks' last positions, and a line's crossings start from
+// zero.
+func (r *Registry) Put(ctx context.Context, z Zone) (Zone, bool, error) {
+	return r.put(ctx, z, "")
+}
+
+// put is Put, conditional on an If-Match value when it is not empty
+func (r *Registry) put(ctx context.Context, z Zone, ifMatch string) (Zone, bool, error) {
+	if err := z.Validate(); err != nil {
+		return Zone{}, false, err
+	}
+	z.Points = append([]Point(nil), z.Points...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cam := r.camera(z.Camera)
+	current, exists := cam.zones[z.ID]
+	if !matches(current, ifMatch) {
+		return Zone{}, false, errPrecondition
+	}
+	r.revision++
+	z.Version, z.UpdatedAt = r.revision, r.now()
+
+	stored := &zone{Zone: z, shape: newShape(z.Points), members: make(map[uint64]bool), crossings: make(map[string]int64)}
+	if z.Kind == KindPolygon {
+		for id, foot := range cam.feet {
+			if stored.shape.contains(foot) {
+				stored.members[id] = true
+			}
+		}
+	}
+	cam.zones[z.ID] = stored
+
+	op := "created"
+	if exists {
+		op = "updated"
+	}
+	r.changes.Add(ctx, 1, stored.attrs(attribute.String("operation", op)))
+	return z, !exists, nil
+}
+
+// Delete removes a zone, reporting whether it existed
+func (r *Registry) Delete(ctx context.Context, cameraID, zoneID string) bool {
+	existed, _ := r.delete(ctx, cameraID, zoneID, "")
+	return existed
+}
+
+// delete is Delete, conditional on an If-Match value when it is not empty
+func (r *Registry) delete(ctx context.Context, cameraID, zoneID, ifMatch string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var current *zone
+	cam := r.cameras[cameraID]
+	if cam != nil {
+		current = cam.zones[zoneID]
+	}
+	if current == nil {
+		return false, nil
+	}
+	if !matches(current, ifMatch) {
+		return false, errPrecondition
+	}
+	delete(cam.zones, zoneID)
+	if len(cam.zones) == 0 {
+		delete(r.cameras, cameraID)
+	}
+	// Versions are not reused, so a stale If-Match cannot match a zone
+	// created again under the same ID
+	r.revision++
+	r.changes.Add(ctx, 1, current.attrs(attribute.String("operation", "deleted")))
+	return true, nil
+}
+
+// HandleEvent updates zone membership from a track event. Tracks are
+// placed by their foot-point, the bottom centre of their box.
+func (r *Registry) HandleEvent(ctx context.Context, e track.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cam := r.cameras[e.Track.Camera]
+	if cam == nil {
+		return
+	}
+	id := e.Track.ID
+
+	if e.Type == track.EventLost {
+		for _, z := range cam.zones {
+			if z.members[id] {
+				delete(z.members, id)
+				r.transitions.Add(ctx, 1, z.attrs(attribute.String("transition", "exited")))
+			}
+		}
+		delete(cam.feet, id)
+		return
+	}
+
+	b := e.Track.Box
+	foot := Point{b.X + b.Width/2, b.Y + b.Height}
+	prev, moved := cam.feet[id]
+	cam.feet[id] = foot
+	for _, z := range cam.zones {
+		switch z.Kind {
+		case KindPolygon:
+			inside := z.shape.contains(foot)
+			if inside == z.members[id] {
+				continue
+			}
+			transition := "entered"
+			if inside {
+				z.members[id] = true
+			} else {
+				delete(z.members, id)
+				transition = "exited"
+			}
+			r.transitions.Add(ctx, 1, z.attrs(attribute.String("transition", transition)))
+		case KindLine:
+			if !moved {
+				continue
+			}
+			if direction, ok := z.shape.crossing(prev, foot); ok {
+				z.crossings[direction]++
+				r.crossings.Add(ctx, 1, z.attrs(attribute.String("direction", direction)))
+			}
+		}
+	}
+}
+
+// matches reports whether an If-Match value names z's version. An empty
+// value matches anything and "*" matches any existing zone.
+func matches(z *zone, ifMatch string) bool {
+	switch {
+	case ifMatch == "":
+		return true
+	case z == nil:
+		return false
+	default:
+		return ifMatch == "*" || ifMatch == etag(z.Version)
+	}
+}
+
+// camera returns the state for id, creating it if needed. The caller holds
+// r.mu.
+func (r *Registry) camera(id string) *camera {
+	cam := r.cameras[id]
+	if cam == nil {
+		cam = &camera{zones: make(map[string]*zone), feet: make(map[uint64]Point)}
+		r.cameras[id] = cam
+	}
+	return cam
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"os/user"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,220 +16,626 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	otelmetric "go.opentelemetry.io/otel/metric"
-	"go.opentelemetry.io/otel/sdk/metric"
-	"go.opentelemetry.io/otel/sdk/resource"
-	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"go.opentelemetry.io/otel/trace"
 
 	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/admin"
+	"github.com/adron/golang-services-build-base/internal/auth"
+	"github.com/adron/golang-services-build-base/internal/authz"
+	"github.com/adron/golang-services-build-base/internal/console"
+	"github.com/adron/golang-services-build-base/internal/detect"
+	"github.com/adron/golang-services-build-base/internal/frames"
+	"github.com/adron/golang-services-build-base/internal/handlers"
+	"github.com/adron/golang-services-build-base/internal/health"
+	"github.com/adron/golang-services-build-base/internal/logging"
+	"github.com/adron/golang-services-build-base/internal/middleware"
+	"github.com/adron/golang-services-build-base/internal/postprocess"
+	"github.com/adron/golang-services-build-base/internal/reload"
+	"github.com/adron/golang-services-build-base/internal/service"
+	"github.com/adron/golang-services-build-base/internal/systemd"
+	"github.com/adron/golang-services-build-base/internal/telemetry"
+	"github.com/adron/golang-services-build-base/internal/track"
+	"github.com/adron/golang-services-build-base/internal/zones"
 )
 
 var (
-	logger *logrus.Logger
-	server *http.Server
-	cfg    *config.Config
-	meter  otelmetric.Meter
-	tracer trace.Tracer
+	logger       *logrus.Logger
+	logTail      *logging.RingHook
+	requests     = &middleware.RequestCounter{}
+	svc          *service.Service
+	adminSvc     *service.Service
+	detector     detect.Detector
+	pipeline     *frames.Pipeline
+	tracker      *track.Tracker
+	zoneRegistry *zones.Registry
+	registry     *health.Registry
+	authn        *auth.Authenticator
+	enforcer     *authz.Enforcer
+	auditor      authz.Auditor
+	notifier     = systemd.NewNotifier()
+	cfg          *config.Config
+	reloader     *reload.Reloader
+	provider     *telemetry.Provider
+	meter        otelmetric.Meter
+	tracer       trace.Tracer
 )
 
 func init() {
-	// Load configuration
-	cfg = config.LoadConfig()
-
 	// Initialize logger
-	logger = logrus.New()
-	logger.SetFormatter(&logrus.JSONFormatter{})
-	logger.SetOutput(os.Stdout)
-	logger.SetLevel(logrus.InfoLevel)
-
-	// Initialize OpenTelemetry
-	ctx := context.Background()
-
-	// Create OTLP trace exporter
-	traceExporter, err := otlptracehttp.New(ctx,
-		otlptracehttp.WithEndpoint("localhost:4318"),
-		otlptracehttp.WithInsecure(),
-	)
+	logger = logging.New(os.Stdout)
+	logTail = logging.NewRingHook(200)
+	logger.AddHook(logTail)
+
+	// Load configuration from the config file and environment. Flags are not
+	// parsed yet, so the root command reloads and validates it before serving.
+	loaded, err := config.LoadConfig()
 	if err != nil {
-		logger.Fatalf("Failed to create trace exporter: %v", err)
+		logger.Warnf("Falling back to default configuration: %v", err)
+		loaded = config.Default()
 	}
+	cfg = loaded
+	_ = logging.SetLevel(logger, cfg.LogLevel) // validated by LoadConfig
+
+	// Use the global providers until the telemetry provider is started
+	tracer = otel.Tracer(cfg.ServiceName)
+	meter = otel.Meter(cfg.ServiceName)
+
+	registry = newHealthRegistry()
+	svc = newService()
+}
+
+// shutdownTimeout bounds how long in-flight requests may drain on stop
+const shutdownTimeout = 10 * time.Second
+
+// newService creates the HTTP service for the current configuration
+func newService(opts ...service.Option) *service.Service {
+	return service.New(fmt.Sprintf(":%d", cfg.Port), newRouter, logger, opts...)
+}
+
+// newRouter builds the routes served by the service. It runs on every start
+// so routes reflect the telemetry provider and configuration at that time.
+func newRouter() http.Handler {
+	// Create a new router
+	router := mux.NewRouter()
+
+	// Count requests for the console's request rate
+	router.Use(requests.Middleware)
 
-	// Create OTLP metric exporter
-	metricExporter, err := otlpmetrichttp.New(ctx,
-		otlpmetrichttp.WithEndpoint("localhost:4318"),
-		otlpmetrichttp.WithInsecure(),
-	)
+	// Instrument every route with spans and request metrics
+	instrumentation, err := middleware.NewTelemetry(tracer, meter, telemetry.Propagator())
 	if err != nil {
-		logger.Fatalf("Failed to create metric exporter: %v", err)
+		logger.Errorf("Failed to create HTTP instrumentation: %v", err)
+	} else {
+		router.Use(instrumentation.Middleware)
 	}
 
-	// Create resource with service information
-	res, err := resource.New(ctx,
-		resource.WithAttributes(
-			semconv.ServiceName(cfg.ServiceName),
-			semconv.ServiceVersion(cfg.ServiceVersion),
-			semconv.ServiceNamespace(cfg.ServiceNamespace),
-		),
-	)
+	// Shed load before doing any authentication work, including while the
+	// frame pipeline is backed up
+	var queueDepth func() int
+	if pipeline != nil {
+		queueDepth = pipeline.Depth
+	}
+	shedder, err := middleware.NewLoadShedder(cfg.Limits, queueDepth, meter)
 	if err != nil {
-		logger.Fatalf("Failed to create resource: %v", err)
+		logger.Errorf("Failed to create load shedder: %v", err)
+	} else {
+		router.Use(shedder.Middleware)
 	}
 
-	// Create trace provider
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(traceExporter),
-		sdktrace.WithResource(res),
-	)
-	otel.SetTracerProvider(tp)
-	tracer = tp.Tracer(cfg.ServiceName)
+	// Require an API key or bearer token outside the exempt health endpoints,
+	// refusing addresses that keep failing before checking their credentials
+	if authn != nil {
+		if cfg.Limits.AuthFailureLimit > 0 {
+			failures, err := middleware.NewAuthFailureLimiter(cfg.Limits, meter)
+			if err != nil {
+				logger.Errorf("Failed to create auth failure limiter: %v", err)
+			} else {
+				router.Use(failures.Middleware)
+			}
+		}
+		router.Use(authn.Middleware)
+	}
 
-	// Create meter provider
-	mp := metric.NewMeterProvider(
-		metric.WithReader(metric.NewPeriodicReader(metricExporter)),
-		metric.WithResource(res),
-	)
-	otel.SetMeterProvider(mp)
-	meter = mp.Meter(cfg.ServiceName)
+	// Limit each client's request rate, keyed by identity once authenticated
+	if cfg.Limits.RateLimit > 0 {
+		limiter, err := middleware.NewRateLimiter(cfg.Limits, meter)
+		if err != nil {
+			logger.Errorf("Failed to create rate limiter: %v", err)
+		} else {
+			router.Use(limiter.Middleware)
+		}
+	}
+
+	// Health check endpoints
+	router.Handle("/health", handlers.NewHealthHandler()).Methods("GET")
+	router.Handle("/health/live", handlers.NewLivenessHandler(registry)).Methods("GET")
+	router.Handle("/health/ready", handlers.NewReadinessHandler(registry)).Methods("GET")
+
+	// Prometheus scrape endpoint
+	if provider != nil {
+		if metricsHandler := provider.MetricsHandler(); metricsHandler != nil {
+			router.Handle("/metrics", protect(authz.PermMetricsRead, metricsHandler)).Methods("GET")
+		}
+	}
+
+	// Frame uploads and results of frames still being processed
+	if pipeline != nil {
+		router.Handle("/v1/cameras/{camera}/frames", protect(authz.PermFramesWrite, pipeline.IngestHandler())).Methods("POST")
+		router.Handle(frames.ResultPath+"{id}", protect(authz.PermFramesRead, pipeline.ResultHandler())).Methods("GET")
+	}
+
+	// Zones and lines drawn on each camera's image, and what is in them
+	if zoneRegistry != nil {
+		router.Handle("/v1/cameras/{camera}/zones", protect(authz.PermZonesRead, zoneRegistry.ListHandler())).Methods("GET")
+		router.Handle("/v1/cameras/{camera}/zones/{zone}", protect(authz.PermZonesRead, zoneRegistry.GetHandler())).Methods("GET")
+		router.Handle("/v1/cameras/{camera}/zones/{zone}", protect(authz.PermZonesWrite, zoneRegistry.PutHandler())).Methods("PUT")
+		router.Handle("/v1/cameras/{camera}/zones/{zone}", protect(authz.PermZonesWrite, zoneRegistry.DeleteHandler())).Methods("DELETE")
+	}
+
+	return router
 }
 
-func startServer() {
-	// Create a new router
+// newPipeline starts the workers that run d on uploaded frames, each
+// under a span in the upload's trace, filter its output with post and,
+// unless tracking is disabled, link it to tracks
+func newPipeline(d detect.Detector, post *postprocess.Filter) *frames.Pipeline {
+	p := frames.NewPipeline(cfg.Frames, func(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+		ctx, span := tracer.Start(ctx, "detect", trace.WithAttributes(
+			attribute.String("camera.id", f.Camera),
+			attribute.Int64("frame.sequence", int64(f.Sequence)),
+		))
+		defer span.End()
+		detections, err := d.Detect(ctx, f)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		kept := post.Apply(ctx, f.Camera, detections)
+		if tracker != nil {
+			kept = tracker.Update(ctx, f, kept)
+		}
+		span.SetAttributes(
+			attribute.Int("detections.raw", len(detections)),
+			attribute.Int("detections", len(kept)),
+		)
+		return kept, nil
+	}, logger)
+	if err := p.RegisterMetrics(meter); err != nil {
+		logger.Errorf("Failed to register frame pipeline metrics: %v", err)
+	}
+	return p
+}
+
+// newTracker creates the tracker that links detections across frames,
+// logging track lifecycle events at debug level
+func newTracker() *track.Tracker {
+	t := track.New(cfg.Tracking)
+	if err := t.RegisterMetrics(meter); err != nil {
+		logger.Errorf("Failed to register tracker metrics: %v", err)
+	}
+	t.Subscribe(func(ctx context.Context, e track.Event) {
+		logger.WithContext(ctx).WithFields(logrus.Fields{
+			"camera":   e.Track.Camera,
+			"track_id": e.Track.ID,
+			"class":    e.Track.Class,
+		}).Debugf("Track %s", e.Type)
+	})
+	return t
+}
+
+// newZoneRegistry loads the configured zones and, when tracking is on,
+// follows the tracks moving through them
+func newZoneRegistry() (*zones.Registry, error) {
+	z, err := zones.New(cfg.Zones)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load zones: %w", err)
+	}
+	if err := z.RegisterMetrics(meter); err != nil {
+		logger.Errorf("Failed to register zone metrics: %v", err)
+	}
+	if tracker != nil {
+		tracker.Subscribe(z.HandleEvent)
+	}
+	return z, nil
+}
+
+// adminWriteTimeout leaves room for CPU profiles and execution traces,
+// which stream for as many seconds as requested
+const adminWriteTimeout = 2 * time.Minute
+
+// newAdminService creates the admin listener, which keeps running while
+// the public service is stopped or restarted from the console
+func newAdminService(opts ...service.Option) *service.Service {
+	opts = append(opts, service.WithTimeouts(10*time.Second, adminWriteTimeout))
+	return service.New(cfg.Admin.Addr, newAdminRouter, logger, opts...)
+}
+
+// newAdminRouter builds the diagnostic and control routes served only on
+// the admin listener
+func newAdminRouter() http.Handler {
 	router := mux.NewRouter()
+	if authn != nil {
+		router.Use(authn.Middleware)
+	}
 
-	// Health check endpoint
-	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		ctx, span := tracer.Start(r.Context(), "health-check")
-		defer span.End()
+	// Profiles and goroutine dumps
+	router.PathPrefix(admin.PprofPrefix).Handler(protect(authz.PermDebugRead, admin.PprofHandler()))
+	router.Handle("/admin/goroutines", protect(authz.PermDebugRead, admin.GoroutineHandler())).Methods("GET")
 
-		// Record metrics
-		counter, err := meter.Int64Counter("health_check_count")
+	// Runtime log level
+	logLevel := admin.NewLogLevelHandler(logger, func(level string) error { return setLogLevel("api", level) })
+	router.Handle("/admin/loglevel", protect(authz.PermConfigRead, logLevel)).Methods("GET")
+	router.Handle("/admin/loglevel", protect(authz.PermConfigWrite, logLevel)).Methods("PUT")
+
+	// Effective configuration, reload status and trigger
+	router.Handle("/admin/config", protect(authz.PermConfigRead, admin.NewConfigHandler(effectiveConfig))).Methods("GET")
+	if reloader != nil {
+		router.Handle("/admin/reload", protect(authz.PermConfigRead, reloader)).Methods("GET")
+		router.Handle("/admin/reload", protect(authz.PermConfigWrite, reloader)).Methods("POST")
+	}
+
+	// Service lifecycle state and restart
+	router.Handle("/admin/service", protect(authz.PermServiceRead, svc.StatusHandler())).Methods("GET")
+	router.Handle("/admin/service/restart", protect(authz.PermServiceWrite, svc.RestartHandler(shutdownTimeout))).Methods("POST")
+
+	return router
+}
+
+// effectiveConfig returns the configuration after the last reload
+func effectiveConfig() *config.Config {
+	if reloader != nil {
+		return reloader.Current()
+	}
+	return cfg
+}
+
+// protect requires permission for a route once access control is set up
+func protect(permission string, h http.Handler) http.Handler {
+	if enforcer == nil {
+		return h
+	}
+	return enforcer.Require(permission, h)
+}
+
+// setupAccessControl creates the authenticator, when enabled, and the
+// role-based enforcer with its audit log. The returned func closes the log.
+func setupAccessControl() (func(), error) {
+	closeAudit := func() {}
+	if cfg.Auth.Enabled {
+		a, err := auth.New(cfg.Auth)
 		if err != nil {
-			logger.Errorf("Failed to create counter: %v", err)
-		} else {
-			counter.Add(ctx, 1)
+			return closeAudit, err
 		}
+		authn = a
+	}
 
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "Service is healthy")
-	}).Methods("GET")
-
-	// Create HTTP server
-	server = &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.Port),
-		Handler:      router,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+	policy := authz.DefaultPolicy()
+	if cfg.Auth.PolicyFile != "" {
+		loaded, err := authz.LoadPolicy(cfg.Auth.PolicyFile)
+		if err != nil {
+			return closeAudit, err
+		}
+		policy = loaded
+	}
+	for name, role := range cfg.Auth.KeyRoles {
+		if err := policy.Assign(name, role); err != nil {
+			return closeAudit, fmt.Errorf("invalid auth.key_roles: %w", err)
+		}
 	}
 
-	// Start server in a goroutine
-	go func() {
-		logger.Infof("Starting service on port %d", cfg.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatalf("Failed to start server: %v", err)
+	auditor = authz.NewLogAuditor(logger)
+	if cfg.Auth.AuditFile != "" {
+		file, err := authz.NewFileAuditor(cfg.Auth.AuditFile, logger)
+		if err != nil {
+			return closeAudit, err
 		}
-	}()
+		auditor = file
+		closeAudit = func() { file.Close() }
+	}
+
+	opts := []authz.Option{authz.WithAuditor(auditor)}
+	if !cfg.Auth.Enabled {
+		opts = append(opts, authz.WithAnonymous())
+	}
+	enforcer = authz.New(policy, opts...)
+	if err := enforcer.RegisterMetrics(meter); err != nil {
+		logger.Errorf("Failed to register authorization metrics: %v", err)
+	}
+	return closeAudit, nil
 }
 
-func stopServer() {
-	if server != nil {
-		logger.Info("Shutting down server...")
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+// auditConsole records a privileged console command under the local user
+func auditConsole(command string, args []string) {
+	subject := "console"
+	if u, err := user.Current(); err == nil {
+		subject = "console:" + u.Username
+	}
+	auditor.Record(authz.Entry{
+		Time:    time.Now(),
+		Subject: subject,
+		Action:  "console:" + command,
+		Target:  strings.Join(args, " "),
+		Allowed: true,
+	})
+}
+
+// startServer starts the service, returning listen errors to the caller
+func startServer() error {
+	return svc.Start(context.Background())
+}
 
-		if err := server.Shutdown(ctx); err != nil {
-			logger.Fatalf("Server forced to shutdown: %v", err)
+// newHealthRegistry registers the checkers behind the liveness and readiness probes
+func newHealthRegistry() *health.Registry {
+	r := health.NewRegistry(cfg.Health.CheckTimeout, cfg.Health.CacheTTL)
+	// A failed listener only recovers by restarting the process
+	r.RegisterLiveness(health.NewChecker("service", func(ctx context.Context) error {
+		if status := svc.Status(); status.State == service.Failed {
+			return fmt.Errorf("service failed: %s", status.LastError)
+		}
+		return nil
+	}))
+	// The collector only gates readiness when something is exported to it
+	if cfg.OTLPEnabled() {
+		r.RegisterReadiness(health.NewCollectorChecker(cfg.OtelEndpoint))
+	}
+	r.RegisterReadiness(health.NewDiskSpaceChecker(cfg.Health.DiskPath, uint64(cfg.Health.MinFreeMB)<<20))
+	// Report not ready while draining so balancers stop sending traffic
+	r.RegisterReadiness(health.NewChecker("service", func(ctx context.Context) error {
+		if state := svc.State(); state != service.Running {
+			return fmt.Errorf("service is %s", state)
 		}
-		logger.Info("Server stopped")
+		return nil
+	}))
+	// Opt-in, since a balancer that stops routing uploads to an unready
+	// instance would keep it from ever receiving a frame again
+	if cfg.Health.MaxFrameAge > 0 && pipeline != nil {
+		started := time.Now()
+		r.RegisterReadiness(health.NewFreshnessChecker("frames", cfg.Health.MaxFrameAge, func() time.Time {
+			if last := pipeline.LastAccepted(); !last.IsZero() {
+				return last
+			}
+			return started
+		}))
+	}
+	// Frames cannot be processed until the detector is constructed. The
+	// background and none detectors have no model to load, remote
+	// detectors are not ready until their model server is, and plugins
+	// are not ready while they are being restarted.
+	r.RegisterReadiness(health.NewChecker("detector", func(ctx context.Context) error {
+		if detector == nil {
+			return errors.New("detector is not loaded")
+		}
+		if ready, ok := detector.(interface{ Ready(context.Context) error }); ok {
+			return ready.Ready(ctx)
+		}
+		return nil
+	}))
+	return r
+}
+
+// stopServer tells systemd the service is stopping, drains it within ctx
+// and flushes buffered telemetry. It returns service.ErrNotRunning when
+// there is nothing to stop. The console stops the service through it
+// too, so an operator's stop is not mistaken for a crash.
+func stopServer(ctx context.Context) error {
+	if svc.State() != service.Running {
+		return service.ErrNotRunning
+	}
+	logger.Info("Shutting down server...")
+	if err := notifier.Notify(systemd.Stopping); err != nil {
+		logger.Warnf("Failed to notify systemd: %v", err)
+	}
+
+	err := svc.Stop(ctx)
+	if err != nil {
+		logger.Errorf("Server did not stop cleanly: %v", err)
+	}
+	logger.Info("Server stopped")
+
+	flushTelemetry()
+	return err
+}
+
+// startTelemetry starts the OpenTelemetry provider for the loaded configuration
+func startTelemetry(ctx context.Context) error {
+	provider = telemetry.New(cfg, telemetry.WithGlobal())
+	if err := provider.Start(ctx); err != nil {
+		return err
+	}
+	tracer = provider.Tracer()
+	meter = provider.Meter()
+
+	// Send a copy of every log entry to the collector when log export is on
+	if lp := provider.LoggerProvider(); lp != nil {
+		logger.AddHook(logging.NewOTLPHook(lp, cfg.ServiceName))
+	}
+	return nil
+}
+
+// flushTelemetry exports buffered spans and metrics, giving up after a deadline
+func flushTelemetry() {
+	if provider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := provider.ForceFlush(ctx); err != nil {
+		logger.Errorf("Failed to flush telemetry: %v", err)
+	}
+}
+
+// shutdownTelemetry flushes and stops the telemetry provider before exit
+func shutdownTelemetry() {
+	if provider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := provider.Shutdown(ctx); err != nil {
+		logger.Errorf("Failed to shut down telemetry: %v", err)
 	}
 }
 
-func runService(headless bool) {
+func runService(headless bool) error {
 	if headless {
 		// Start server in headless mode
-		startServer()
+		if err := startServer(); err != nil {
+			return err
+		}
 		logger.Info("Service running in headless mode")
 
-		// Wait for interrupt signal
+		// Tell systemd the listener is bound and keep its watchdog fed
+		// while the liveness checks pass
+		if err := notifier.Notify(systemd.Ready, systemd.Status("Serving on "+svc.Status().Addr)); err != nil {
+			logger.Warnf("Failed to notify systemd: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if timeout := systemd.WatchdogInterval(); timeout > 0 {
+			go notifier.RunWatchdog(ctx, timeout, func(ctx context.Context) error {
+				if report := registry.Live(ctx); !report.Healthy() {
+					return errors.New("liveness checks failing")
+				}
+				return nil
+			})
+		}
+
+		// Wait for interrupt signal or a server failure
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-		<-quit
+		defer signal.Stop(quit)
+		select {
+		case <-quit:
+		case err := <-svc.Errors():
+			return err
+		}
 
-		stopServer()
-		return
+		stopCtx, stopCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer stopCancel()
+		stopServer(stopCtx)
+		return nil
 	}
 
 	// Interactive mode
-	fmt.Print("\033[H\033[2J")
-	fmt.Println("Computer Vision Service Control")
-	fmt.Println("==============================")
-	fmt.Println("Press 's' to start the service")
-	fmt.Println("Press 'q' to stop the service")
-	fmt.Println("Press 'x' to exit")
-	fmt.Println("==============================")
-
-	// Channel to receive keyboard input
-	inputChan := make(chan string)
-	go func() {
-		var input string
-		for {
-			fmt.Scanln(&input)
-			inputChan <- input
-		}
-	}()
-
-	// Main control loop
-	for {
-		select {
-		case cmd := <-inputChan:
-			switch cmd {
-			case "s":
-				if server == nil {
-					startServer()
-					fmt.Println("Service started successfully")
-				} else {
-					fmt.Println("Service is already running")
-				}
-			case "q":
-				if server != nil {
-					stopServer()
-					server = nil
-					fmt.Println("Service stopped successfully")
-				} else {
-					fmt.Println("Service is not running")
-				}
-			case "x":
-				if server != nil {
-					stopServer()
+	opts := []console.Option{
+		console.WithLogLevel(func(level string) error { return setLogLevel("console", level) }),
+		console.WithRequestCount(requests.Count),
+		console.WithLogTail(logTail),
+		console.WithTimeout(shutdownTimeout),
+		console.WithStop(stopServer),
+	}
+	if reloader != nil {
+		opts = append(opts, console.WithReload(reloader.Reload))
+	}
+	if auditor != nil {
+		opts = append(opts, console.WithAudit(auditConsole))
+	}
+	opts = append(opts, consoleComponents()...)
+	return console.New(svc, opts...).Run(context.Background(), os.Stdin, os.Stdout)
+}
+
+// consoleComponents shows the frame queue, tracked cameras and zone
+// occupancy in the console's status panel
+func consoleComponents() []console.Option {
+	var opts []console.Option
+	if pipeline != nil {
+		opts = append(opts, console.WithComponents(func() []console.Component {
+			depth, state := pipeline.Depth(), "ok"
+			if depth >= cfg.Frames.QueueSize {
+				state = "full"
+			}
+			return []console.Component{{
+				Kind:   "queue",
+				Name:   "frames",
+				State:  state,
+				Detail: fmt.Sprintf("depth %d/%d", depth, cfg.Frames.QueueSize),
+			}}
+		}))
+	}
+	if tracker != nil {
+		opts = append(opts, console.WithComponents(func() []console.Component {
+			var comps []console.Component
+			for camera, active := range tracker.Active() {
+				comps = append(comps, console.Component{
+					Kind:   "camera",
+					Name:   camera,
+					State:  "tracking",
+					Detail: fmt.Sprintf("%d active tracks", active),
+				})
+			}
+			return comps
+		}))
+	}
+	if zoneRegistry != nil {
+		opts = append(opts, console.WithComponents(func() []console.Component {
+			var comps []console.Component
+			for _, z := range zoneRegistry.All() {
+				detail := fmt.Sprintf("%d inside", z.Occupancy)
+				if z.Kind == zones.KindLine {
+					detail = fmt.Sprintf("%d left to right, %d right to left",
+						z.Crossings[zones.LeftToRight], z.Crossings[zones.RightToLeft])
 				}
-				fmt.Println("Exiting...")
-				return
-			default:
-				fmt.Println("Invalid command. Use 's' to start, 'q' to stop, or 'x' to exit")
+				comps = append(comps, console.Component{
+					Kind:   "zone",
+					Name:   z.Camera + "/" + z.ID,
+					State:  z.Kind,
+					Detail: detail,
+				})
 			}
-		}
+			return comps
+		}))
 	}
+	return opts
 }
 
-func main() {
-	var headless bool
+// newReloader re-runs config loading with the command's flags and applies
+// the fields that can change without restarting the listener: the log
+// level and the trace sample ratio. Telemetry exporters, and the
+// instruments every component registered on them, are built once at
+// startup, so other changes to the OTLP, metrics, traces and logs settings
+// are rejected until the next restart.
+func newReloader(cmd *cobra.Command) *reload.Reloader {
+	r := reload.New(cfg, func() (*config.Config, error) {
+		return config.LoadConfig(config.WithFlags(cmd.Flags()))
+	}, logger)
 
-	rootCmd := &cobra.Command{
-		Use:   "vision-service",
-		Short: "Computer Vision Service for line detection",
-		Long: `A Windows-based service for computer vision capabilities to identify lines of people 
-and vehicles for order processing.`,
-		Run: func(cmd *cobra.Command, args []string) {
-			runService(headless)
-		},
-	}
+	r.Handle("log_level", func(old, new *config.Config) error {
+		return logging.SetLevel(logger, new.LogLevel)
+	})
+	r.Handle("traces.sample_ratio", func(old, new *config.Config) error {
+		if provider == nil {
+			return errors.New("telemetry is not started")
+		}
+		provider.SetSampleRatio(new.Traces.SampleRatio)
+		return nil
+	})
+
+	return r
+}
 
-	rootCmd.Flags().BoolVarP(&headless, "headless", "H", false, "Run service in headless mode")
+// setLogLevel changes the log level for the admin API and console. With a
+// reloader the change is made through it, so a later reload compares the
+// file against the level in effect rather than the one loaded at startup.
+func setLogLevel(trigger, level string) error {
+	if reloader == nil {
+		return logging.SetLevel(logger, level)
+	}
+	result := reloader.Update(trigger, func(c *config.Config) { c.LogLevel = level })
+	if !result.Success {
+		return errors.New(result.Error)
+	}
+	if len(result.Rejected) > 0 {
+		return fmt.Errorf("%s: %s", result.Rejected[0].Field, result.Rejected[0].Reason)
+	}
+	return nil
+}
 
-	if err := rootCmd.Execute(); err != nil {
+func main() {
+	if err := newRootCmd().Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
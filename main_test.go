@@ -1,18 +1,38 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/adron/golang-services-build-base/internal/console"
+	"github.com/adron/golang-services-build-base/internal/detect"
+	"github.com/adron/golang-services-build-base/internal/health"
+	"github.com/adron/golang-services-build-base/internal/logging"
+	"github.com/adron/golang-services-build-base/internal/postprocess"
+	"github.com/adron/golang-services-build-base/internal/reload"
+	"github.com/adron/golang-services-build-base/internal/service"
+	"github.com/adron/golang-services-build-base/internal/telemetry"
+	"github.com/adron/golang-services-build-base/internal/zones"
 )
 
 func setupTestTracer() (*trace.TracerProvider, error) {
@@ -172,7 +192,7 @@ func BenchmarkHealthCheckParallel(b *testing.B) {
 
 func TestStartServer(t *testing.T) {
 	// Create a test server
-	startServer()
+	assert.NoError(t, startServer())
 
 	// Verify server is running
 	resp, err := http.Get("http://localhost:8080/health")
@@ -181,19 +201,22 @@ func TestStartServer(t *testing.T) {
 	resp.Body.Close()
 
 	// Clean up
-	stopServer()
+	stopServer(context.Background())
 }
 
 func TestStopServer(t *testing.T) {
 	// Start server
-	startServer()
+	assert.NoError(t, startServer())
 
 	// Stop server
-	stopServer()
+	assert.NoError(t, stopServer(context.Background()))
 
 	// Verify server is stopped
 	_, err := http.Get("http://localhost:8080/health")
 	assert.Error(t, err)
+
+	// Stopping again reports that there was nothing to stop
+	assert.ErrorIs(t, stopServer(context.Background()), service.ErrNotRunning)
 }
 
 func TestRunService(t *testing.T) {
@@ -210,7 +233,7 @@ func TestRunService(t *testing.T) {
 	resp.Body.Close()
 
 	// Clean up
-	stopServer()
+	stopServer(context.Background())
 }
 
 func TestMain(m *testing.M) {
@@ -233,3 +256,298 @@ func TestMain(m *testing.M) {
 
 	os.Exit(code)
 }
+
+// executeCommand runs the command tree with args and returns its output
+func executeCommand(args ...string) (string, error) {
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestVersionCommand(t *testing.T) {
+	out, err := executeCommand("version", "--json")
+	assert.NoError(t, err)
+
+	var info map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(out), &info))
+	assert.Equal(t, "dev", info["version"])
+	assert.NotEmpty(t, info["go_version"])
+}
+
+func TestConfigPrintRedactsSecrets(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "authorization=Bearer secret-token")
+
+	out, err := executeCommand("config", "print", "--format", "json", "--port", "9191")
+	assert.NoError(t, err)
+	assert.NotContains(t, out, "secret-token")
+
+	var values map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(out), &values))
+	assert.Equal(t, float64(9191), values["port"])
+	assert.Equal(t, map[string]interface{}{"authorization": "REDACTED"}, values["otel_headers"])
+	assert.Equal(t, "10s", values["otel_timeout"])
+}
+
+func TestConfigValidateCommand(t *testing.T) {
+	out, err := executeCommand("config", "validate")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Configuration is valid")
+
+	out, err = executeCommand("config", "validate", "--log-level", "loud")
+	assert.Error(t, err)
+	assert.Contains(t, out, "log_level")
+}
+
+func TestHealthcheckCommand(t *testing.T) {
+	status := http.StatusOK
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`{"status":"up","components":[{"name":"disk","status":"up"}]}`))
+			return
+		}
+		w.Write([]byte(`{"status":"down","components":[{"name":"collector","status":"down","last_error":"collector unreachable"}]}`))
+	}))
+	defer probe.Close()
+
+	out, err := executeCommand("healthcheck", "--url", probe.URL)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "ready: up")
+
+	status = http.StatusServiceUnavailable
+	out, err = executeCommand("healthcheck", "--url", probe.URL)
+	assert.Error(t, err)
+	assert.Contains(t, out, "collector: collector unreachable")
+
+	_, err = executeCommand("healthcheck", "--url", "http://127.0.0.1:1", "--timeout", "500ms")
+	assert.Error(t, err)
+}
+
+func TestHealthcheckClientCertificate(t *testing.T) {
+	probe := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"up"}`))
+	}))
+	probe.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	probe.StartTLS()
+	defer probe.Close()
+
+	// Present the test server's own certificate as the client's
+	cert := probe.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if !assert.NoError(t, err) {
+		return
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600))
+
+	_, err = executeCommand("healthcheck", "--url", probe.URL, "--insecure")
+	assert.Error(t, err, "refused without a client certificate")
+	out, err := executeCommand("healthcheck", "--url", probe.URL, "--insecure", "--cert", certFile, "--key", keyFile)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "ready: up")
+
+	_, err = executeCommand("healthcheck", "--url", probe.URL, "--cert", certFile)
+	assert.ErrorContains(t, err, "--cert and --key must be given together")
+}
+
+func TestAdminRoutesOffPublicPort(t *testing.T) {
+	public := newRouter()
+	adminRouter := newAdminRouter()
+
+	for _, path := range []string{"/admin/service", "/admin/config", "/admin/loglevel", "/admin/goroutines", "/debug/pprof/"} {
+		rec := httptest.NewRecorder()
+		public.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code, "public %s", path)
+
+		rec = httptest.NewRecorder()
+		adminRouter.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, "admin %s", path)
+	}
+}
+
+func TestFrameRoutes(t *testing.T) {
+	pipeline = newPipeline(detect.None, postprocess.New(cfg.Detector.Postprocess))
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		pipeline.Close(ctx)
+		pipeline = nil
+	}()
+	router := newRouter()
+
+	req := httptest.NewRequest("POST", "/v1/cameras/lane-1/frames?width=1&height=1", bytes.NewReader([]byte{1, 2, 3}))
+	req.Header.Set("Content-Type", "image/x-raw-rgb")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/frames/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestZoneRoutes(t *testing.T) {
+	var err error
+	zoneRegistry, err = newZoneRegistry()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { zoneRegistry = nil }()
+	router := newRouter()
+
+	req := httptest.NewRequest("PUT", "/v1/cameras/lane-1/zones/entry", bytes.NewBufferString(`{"kind":"line","points":[[0,0],[0,100]]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/cameras/lane-1/zones", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"id":"entry"`)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("DELETE", "/v1/cameras/lane-1/zones/entry", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
+
+// readinessChecks returns the names of the components in a readiness report
+func readinessChecks(r *health.Registry) []string {
+	var names []string
+	for _, c := range r.Ready(context.Background()).Components {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func TestHealthRegistryCollectorCheck(t *testing.T) {
+	saved := *cfg
+	defer func() { *cfg = saved }()
+
+	assert.Contains(t, readinessChecks(newHealthRegistry()), "collector")
+
+	// Prometheus-only sites do not depend on a collector
+	cfg.Metrics.OTLPEnabled, cfg.Traces.OTLPEnabled, cfg.Logs.OTLPEnabled = false, false, false
+	assert.NotContains(t, readinessChecks(newHealthRegistry()), "collector")
+}
+
+func TestHealthRegistryFrameFreshness(t *testing.T) {
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	pipeline = newPipeline(detect.None, postprocess.New(cfg.Detector.Postprocess))
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		pipeline.Close(ctx)
+		pipeline = nil
+	}()
+
+	assert.NotContains(t, readinessChecks(newHealthRegistry()), "frames", "off by default")
+
+	cfg.Health.MaxFrameAge = time.Minute
+	report := newHealthRegistry().Ready(context.Background())
+	for _, c := range report.Components {
+		if c.Name == "frames" {
+			assert.Equal(t, health.StatusUp, c.Status, "within the grace period after startup")
+			return
+		}
+	}
+	t.Error("no frames check registered")
+}
+
+// notReadyDetector is a detector whose model is still loading
+type notReadyDetector struct{ detect.Detector }
+
+func (notReadyDetector) Ready(ctx context.Context) error { return errors.New("model loading") }
+
+func TestHealthRegistryDetectorCheck(t *testing.T) {
+	saved := detector
+	defer func() { detector = saved }()
+
+	status := func() string {
+		for _, c := range newHealthRegistry().Ready(context.Background()).Components {
+			if c.Name == "detector" {
+				return c.Status
+			}
+		}
+		t.Fatal("no detector check registered")
+		return ""
+	}
+
+	detector = nil
+	assert.Equal(t, health.StatusDown, status(), "before the detector is constructed")
+	detector = detect.NewBackground(cfg.Detector.Background)
+	assert.Equal(t, health.StatusUp, status(), "background has no model to load")
+	detector = notReadyDetector{detect.None}
+	assert.Equal(t, health.StatusDown, status())
+}
+
+func TestConsoleComponents(t *testing.T) {
+	pipeline = newPipeline(detect.None, postprocess.New(cfg.Detector.Postprocess))
+	tracker = newTracker()
+	var err error
+	zoneRegistry, err = newZoneRegistry()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		pipeline.Close(ctx)
+		pipeline, tracker, zoneRegistry = nil, nil, nil
+	}()
+	_, _, err = zoneRegistry.Put(context.Background(), zones.Zone{Camera: "lane-1", ID: "door", Kind: zones.KindLine, Points: []zones.Point{{0, 0}, {0, 100}}})
+	assert.NoError(t, err)
+
+	snap := console.New(newService(), consoleComponents()...).Snapshot()
+	assert.Equal(t, []console.Component{
+		{Kind: "queue", Name: "frames", State: "ok", Detail: fmt.Sprintf("depth 0/%d", cfg.Frames.QueueSize)},
+		{Kind: "zone", Name: "lane-1/door", State: "line", Detail: "0 left to right, 0 right to left"},
+	}, snap.Components)
+}
+
+func TestReloaderAppliesLiveFields(t *testing.T) {
+	saved, savedProvider := *cfg, provider
+	defer func() {
+		*cfg, provider = saved, savedProvider
+		logging.SetLevel(logger, saved.LogLevel)
+	}()
+	provider = telemetry.New(cfg)
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("TRACES_SAMPLE_RATIO", "0.5")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector.example:4318")
+
+	result := newReloader(newRootCmd()).Reload("test")
+	assert.True(t, result.Success, result.Error)
+	assert.Equal(t, []string{"log_level", "traces.sample_ratio"}, result.Applied)
+	assert.Contains(t, result.Rejected, reload.Rejection{Field: "otel_endpoint", Reason: "requires restart"})
+	assert.Equal(t, logrus.DebugLevel, logger.GetLevel())
+}
+
+func TestSetLogLevelGoesThroughReloader(t *testing.T) {
+	saved, savedReloader := *cfg, reloader
+	defer func() {
+		*cfg, reloader = saved, savedReloader
+		logging.SetLevel(logger, saved.LogLevel)
+	}()
+	t.Setenv("LOG_LEVEL", cfg.LogLevel)
+	reloader = newReloader(newRootCmd())
+
+	require.NoError(t, setLogLevel("api", "warn"))
+	assert.Equal(t, logrus.WarnLevel, logger.GetLevel())
+	assert.Equal(t, "warn", reloader.Current().LogLevel)
+	_, reloaded := reloader.Last()
+	assert.False(t, reloaded, "an update is not a reload")
+
+	// Reloading the unchanged environment restores its level
+	result := reloader.Reload("test")
+	assert.Equal(t, []string{"log_level"}, result.Applied)
+	assert.Equal(t, saved.LogLevel, logger.GetLevel().String())
+
+	assert.Error(t, setLogLevel("api", "loud"))
+}
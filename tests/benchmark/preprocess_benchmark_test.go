@@ -0,0 +1,74 @@
+package benchmark
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/preprocess"
+)
+
+// hdFrame returns a 1920x1080 gradient
+func hdFrame() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 1920, 1080))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = uint8(i), uint8(i>>8), uint8(i>>16), 0xff
+	}
+	return img
+}
+
+func benchmarkTensor(b *testing.B, img image.Image, cfg config.PreprocessConfig) {
+	p := preprocess.New(640, 640, cfg)
+	dst := make([]float32, p.Size())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.Tensor(img, dst)
+	}
+}
+
+func BenchmarkPreprocessLetterboxNCHW(b *testing.B) {
+	benchmarkTensor(b, hdFrame(), config.Default().Detector.KServe.Preprocess)
+}
+
+func BenchmarkPreprocessLetterboxNHWC(b *testing.B) {
+	cfg := config.Default().Detector.KServe.Preprocess
+	cfg.Layout = preprocess.NHWC
+	benchmarkTensor(b, hdFrame(), cfg)
+}
+
+func BenchmarkPreprocessStretchNormalized(b *testing.B) {
+	cfg := config.Default().Detector.KServe.Preprocess
+	cfg.Letterbox = false
+	cfg.Mean = []float64{0.485, 0.456, 0.406}
+	cfg.Std = []float64{0.229, 0.224, 0.225}
+	benchmarkTensor(b, hdFrame(), cfg)
+}
+
+// BenchmarkPreprocessYCbCr includes the conversion a decoded JPEG needs
+func BenchmarkPreprocessYCbCr(b *testing.B) {
+	img := image.NewYCbCr(image.Rect(0, 0, 1920, 1080), image.YCbCrSubsampleRatio420)
+	benchmarkTensor(b, img, config.Default().Detector.KServe.Preprocess)
+}
+
+func BenchmarkLetterbox(b *testing.B) {
+	img := hdFrame()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		preprocess.Letterbox(img, 640, 640, color.Gray{Y: 114})
+	}
+}
+
+func BenchmarkFromNV12(b *testing.B) {
+	data := make([]byte, 1920*1080*3/2)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := preprocess.FromNV12(data, 1920, 1080); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
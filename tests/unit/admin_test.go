@@ -0,0 +1,111 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/admin"
+	"github.com/adron/golang-services-build-base/internal/httpjson"
+	"github.com/adron/golang-services-build-base/internal/logging"
+)
+
+func TestAdminLogLevelHandler(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	h := admin.NewLogLevelHandler(logger, func(level string) error { return logging.SetLevel(logger, level) })
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/loglevel", nil))
+	assert.JSONEq(t, `{"level":"info"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(`{"level":"debug"}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"level":"debug","previous":"info"}`, rec.Body.String())
+	assert.Equal(t, logrus.DebugLevel, logger.GetLevel())
+
+	for _, body := range []string{`{"level":"loud"}`, `debug`} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+		var errBody httpjson.Error
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&errBody), body)
+		assert.Equal(t, "invalid_request", errBody.Error)
+	}
+	assert.Equal(t, logrus.DebugLevel, logger.GetLevel())
+}
+
+func TestAdminConfigHandlerRedactsSecrets(t *testing.T) {
+	cfg := config.Default()
+	cfg.OtelHeaders = map[string]string{"api-key": "collector-secret"}
+	h := admin.NewConfigHandler(func() *config.Config { return cfg })
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "collector-secret")
+
+	var values map[string]interface{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&values))
+	assert.Equal(t, "REDACTED", values["otel_headers"].(map[string]interface{})["api-key"])
+	assert.Equal(t, "10s", values["otel_timeout"])
+}
+
+func TestAdminGoroutineHandler(t *testing.T) {
+	h := admin.GoroutineHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/goroutines", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "goroutine ")
+	assert.Contains(t, rec.Body.String(), "TestAdminGoroutineHandler")
+	assert.NotEmpty(t, rec.Header().Get("X-Goroutine-Count"))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/goroutines?debug=7", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAdminPprofHandler(t *testing.T) {
+	h := admin.PprofHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, admin.PprofPrefix, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "heap")
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, admin.PprofPrefix+"heap", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestAdminLogLevelChangeCarriesTraceContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf)
+	h := admin.NewLogLevelHandler(logger, func(level string) error { return logging.SetLevel(logger, level) })
+
+	tp := sdktrace.NewTracerProvider()
+	defer tp.Shutdown(context.Background())
+	ctx, span := tp.Tracer("test").Start(context.Background(), "admin")
+	defer span.End()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(`{"level":"warn"}`)).WithContext(ctx))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry), buf.String())
+	assert.Equal(t, span.SpanContext().TraceID().String(), entry["trace_id"])
+}
@@ -0,0 +1,362 @@
+package unit
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/auth"
+)
+
+// testSigner signs JWTs with a key published in a JWKS file
+type testSigner struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+func newRSASigner(t *testing.T, kid string) *testSigner {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return &testSigner{kid: kid, alg: "RS256", key: key}
+}
+
+func newECSigner(t *testing.T, kid string) *testSigner {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &testSigner{kid: kid, alg: "ES256", key: key}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (s *testSigner) jwk() map[string]string {
+	switch pub := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": s.kid, "use": "sig", "n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": s.kid, "crv": "P-256", "x": b64(pub.X.FillBytes(make([]byte, 32))), "y": b64(pub.Y.FillBytes(make([]byte, 32)))}
+	}
+	return nil
+}
+
+func (s *testSigner) sign(t *testing.T, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": s.alg, "kid": s.kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	input := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		require.NoError(t, err)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + b64(sig)
+}
+
+func writeJWKS(t *testing.T, path string, signers ...*testSigner) {
+	keys := make([]map[string]string, len(signers))
+	for i, s := range signers {
+		keys[i] = s.jwk()
+	}
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub": "edge-box-7",
+		"iss": "https://auth.example.com",
+		"aud": []string{"vision-service"},
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func newTestAuthenticator(t *testing.T, signers ...*testSigner) (*auth.Authenticator, config.AuthConfig) {
+	cfg := config.Default().Auth
+	cfg.Enabled = true
+	cfg.APIKeys = map[string]string{"line-display": auth.HashAPIKey("s3cret-key")}
+	cfg.JWKSFile = filepath.Join(t.TempDir(), "jwks.json")
+	cfg.Issuer = "https://auth.example.com"
+	cfg.Audience = "vision-service"
+	writeJWKS(t, cfg.JWKSFile, signers...)
+
+	a, err := auth.New(cfg)
+	require.NoError(t, err)
+	return a, cfg
+}
+
+func authRequest(header, value string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/v1/cameras", nil)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	return req
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	a, _ := newTestAuthenticator(t, newECSigner(t, "k1"))
+
+	p, err := a.Authenticate(authRequest(auth.APIKeyHeader, "s3cret-key"))
+	require.NoError(t, err)
+	assert.Equal(t, "line-display", p.Subject)
+	assert.Equal(t, auth.MethodAPIKey, p.Method)
+
+	_, err = a.Authenticate(authRequest(auth.APIKeyHeader, "guess"))
+	assert.ErrorIs(t, err, auth.ErrInvalidAPIKey)
+
+	_, err = a.Authenticate(authRequest("", ""))
+	assert.ErrorIs(t, err, auth.ErrNoCredentials)
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	rsaSigner := newRSASigner(t, "rsa-1")
+	ecSigner := newECSigner(t, "ec-1")
+	a, _ := newTestAuthenticator(t, rsaSigner, ecSigner)
+
+	for _, signer := range []*testSigner{rsaSigner, ecSigner} {
+		token := signer.sign(t, validClaims())
+		p, err := a.Authenticate(authRequest("Authorization", "Bearer "+token))
+		require.NoError(t, err, signer.alg)
+		assert.Equal(t, "edge-box-7", p.Subject)
+		assert.Equal(t, auth.MethodJWT, p.Method)
+		assert.Equal(t, "https://auth.example.com", p.Claims["iss"])
+	}
+
+	// A single audience string is accepted too
+	claims := validClaims()
+	claims["aud"] = "vision-service"
+	_, err := a.Authenticate(authRequest("Authorization", "Bearer "+ecSigner.sign(t, claims)))
+	assert.NoError(t, err)
+}
+
+func TestAuthenticateJWTRejections(t *testing.T) {
+	signer := newECSigner(t, "ec-1")
+	a, _ := newTestAuthenticator(t, signer)
+	stranger := newECSigner(t, "ec-1")
+
+	tests := []struct {
+		name   string
+		token  func() string
+		reason string
+	}{
+		{"expired", func() string {
+			c := validClaims()
+			c["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+			return signer.sign(t, c)
+		}, "expired"},
+		{"no expiry", func() string {
+			c := validClaims()
+			delete(c, "exp")
+			return signer.sign(t, c)
+		}, "no expiry"},
+		{"not yet valid", func() string {
+			c := validClaims()
+			c["nbf"] = time.Now().Add(10 * time.Minute).Unix()
+			return signer.sign(t, c)
+		}, "not valid yet"},
+		{"wrong issuer", func() string {
+			c := validClaims()
+			c["iss"] = "https://evil.example.com"
+			return signer.sign(t, c)
+		}, "issuer"},
+		{"wrong audience", func() string {
+			c := validClaims()
+			c["aud"] = []string{"billing"}
+			return signer.sign(t, c)
+		}, "audience"},
+		{"unknown signer", func() string {
+			return stranger.sign(t, validClaims())
+		}, "signature"},
+		{"alg none", func() string {
+			header := b64([]byte(`{"alg":"none"}`))
+			payload, _ := json.Marshal(validClaims())
+			return header + "." + b64(payload) + "."
+		}, "algorithm"},
+		{"malformed", func() string { return "not-a-jwt" }, "malformed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.Authenticate(authRequest("Authorization", "Bearer "+tt.token()))
+			require.ErrorIs(t, err, auth.ErrInvalidToken)
+			assert.Contains(t, err.Error(), tt.reason)
+		})
+	}
+}
+
+func TestAuthenticateJWTClockSkew(t *testing.T) {
+	signer := newECSigner(t, "ec-1")
+	_, cfg := newTestAuthenticator(t, signer)
+
+	claims := validClaims()
+	claims["exp"] = time.Now().Unix()
+	token := signer.sign(t, claims)
+
+	// Thirty seconds past expiry is within the default one minute of skew
+	a, err := auth.New(cfg, auth.WithClock(func() time.Time { return time.Now().Add(30 * time.Second) }))
+	require.NoError(t, err)
+	_, err = a.Authenticate(authRequest("Authorization", "Bearer "+token))
+	assert.NoError(t, err)
+
+	cfg.ClockSkew = 0
+	a, err = auth.New(cfg, auth.WithClock(func() time.Time { return time.Now().Add(30 * time.Second) }))
+	require.NoError(t, err)
+	_, err = a.Authenticate(authRequest("Authorization", "Bearer "+token))
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+}
+
+func TestAuthenticateJWTKeyRotation(t *testing.T) {
+	oldSigner := newECSigner(t, "2024-01")
+	a, cfg := newTestAuthenticator(t, oldSigner)
+
+	newSigner := newRSASigner(t, "2024-02")
+	writeJWKS(t, cfg.JWKSFile, oldSigner, newSigner)
+	future := time.Now().Add(2 * time.Second)
+	require.NoError(t, os.Chtimes(cfg.JWKSFile, future, future))
+
+	// The unknown key ID makes the authenticator read the file again
+	_, err := a.Authenticate(authRequest("Authorization", "Bearer "+newSigner.sign(t, validClaims())))
+	assert.NoError(t, err)
+}
+
+func TestNewAuthenticatorErrors(t *testing.T) {
+	cfg := config.Default().Auth
+	cfg.APIKeys = map[string]string{"bad": "plaintext-key"}
+	_, err := auth.New(cfg)
+	assert.Error(t, err)
+
+	cfg = config.Default().Auth
+	cfg.JWKSFile = filepath.Join(t.TempDir(), "missing.json")
+	_, err = auth.New(cfg)
+	assert.Error(t, err)
+
+	// RSA keys shorter than 2048 bits are refused
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	cfg.JWKSFile = filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, cfg.JWKSFile, &testSigner{kid: "small", alg: "RS256", key: small})
+	_, err = auth.New(cfg)
+	assert.Error(t, err)
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	signer := newECSigner(t, "ec-1")
+	a, _ := newTestAuthenticator(t, signer)
+
+	spans := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)).Tracer("test")
+
+	router := mux.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := tracer.Start(r.Context(), r.URL.Path)
+			defer span.End()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+	router.Use(a.Middleware)
+	router.HandleFunc("/health/ready", func(w http.ResponseWriter, r *http.Request) {
+		_, ok := auth.FromContext(r.Context())
+		assert.False(t, ok)
+	})
+	router.HandleFunc("/v1/cameras", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := auth.FromContext(r.Context())
+		require.True(t, ok)
+		w.Write([]byte(p.Subject))
+	})
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	lastSpan := func() map[attribute.Key]attribute.Value {
+		ended := spans.Ended()
+		require.NotEmpty(t, ended)
+		attrs := make(map[attribute.Key]attribute.Value)
+		for _, kv := range ended[len(ended)-1].Attributes() {
+			attrs[kv.Key] = kv.Value
+		}
+		return attrs
+	}
+
+	// Health endpoints stay open
+	rec := serve(httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "exempt", lastSpan()["auth.result"].AsString())
+
+	// Missing credentials get a structured 401 and a bearer challenge
+	rec = serve(authRequest("", ""))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `Bearer realm="vision-service"`, rec.Header().Get("WWW-Authenticate"))
+	var body auth.Error
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "unauthorized", body.Error)
+	assert.Equal(t, auth.ErrNoCredentials.Error(), body.Message)
+	attrs := lastSpan()
+	assert.Equal(t, "failure", attrs["auth.result"].AsString())
+	assert.Equal(t, "none", attrs["auth.method"].AsString())
+
+	// A bad token is reported as invalid_token
+	rec = serve(authRequest("Authorization", "Bearer not-a-jwt"))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+	assert.Equal(t, auth.MethodJWT, lastSpan()["auth.method"].AsString())
+
+	// Valid credentials reach the handler with the principal in context
+	rec = serve(authRequest("Authorization", "Bearer "+signer.sign(t, validClaims())))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "edge-box-7", rec.Body.String())
+	attrs = lastSpan()
+	assert.Equal(t, "success", attrs["auth.result"].AsString())
+	assert.Equal(t, auth.MethodJWT, attrs["auth.method"].AsString())
+	assert.Equal(t, "edge-box-7", attrs["enduser.id"].AsString())
+
+	rec = serve(authRequest(auth.APIKeyHeader, "s3cret-key"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "line-display", rec.Body.String())
+}
+
+func TestAuthExemptPaths(t *testing.T) {
+	cfg := config.Default().Auth
+	cfg.ExemptPaths = []string{"/health", "/metrics/"}
+	a, err := auth.New(cfg)
+	require.NoError(t, err)
+
+	assert.True(t, a.Exempt("/health"))
+	assert.True(t, a.Exempt("/health/live"))
+	assert.True(t, a.Exempt("/metrics"))
+	assert.False(t, a.Exempt("/healthz"))
+	assert.False(t, a.Exempt("/admin/reload"))
+}
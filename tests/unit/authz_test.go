@@ -0,0 +1,250 @@
+package unit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/auth"
+	"github.com/adron/golang-services-build-base/internal/authz"
+	"github.com/adron/golang-services-build-base/internal/logging"
+)
+
+// recordingAuditor keeps audit entries in memory
+type recordingAuditor struct {
+	mu      sync.Mutex
+	entries []authz.Entry
+}
+
+func (a *recordingAuditor) Record(e authz.Entry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, e)
+}
+
+func writePolicy(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+const testPolicy = `
+roles:
+  viewer:
+    permissions: [metrics:read, service:read]
+  integrator:
+    inherits: [viewer]
+    permissions: [frames:write, zones:*]
+  operator:
+    permissions: ["*"]
+subjects:
+  line-display: [viewer]
+  pos-bridge: [integrator]
+  site-admin: [operator]
+`
+
+func TestLoadPolicy(t *testing.T) {
+	policy, err := authz.LoadPolicy(writePolicy(t, testPolicy))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"frames:write", "metrics:read", "service:read", "zones:*"}, policy.Permissions("integrator"))
+	assert.Equal(t, []string{"integrator"}, policy.SubjectRoles("pos-bridge"))
+
+	assert.True(t, policy.Allows([]string{"integrator"}, authz.PermZonesWrite))
+	assert.True(t, policy.Allows([]string{"integrator"}, authz.PermMetricsRead), "inherited from viewer")
+	assert.False(t, policy.Allows([]string{"integrator"}, authz.PermConfigWrite))
+	assert.False(t, policy.Allows([]string{"viewer"}, authz.PermZonesRead))
+	assert.True(t, policy.Allows([]string{"operator"}, authz.PermConfigWrite))
+	assert.False(t, policy.Allows([]string{"unknown"}, authz.PermMetricsRead))
+}
+
+func TestLoadPolicyErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown key":      "roles:\n  viewer:\n    permission: [metrics:read]\n",
+		"bad permission":   "roles:\n  viewer:\n    permissions: [metrics]\n",
+		"undefined parent": "roles:\n  viewer:\n    inherits: [guest]\n",
+		"cycle":            "roles:\n  a:\n    inherits: [b]\n  b:\n    inherits: [a]\n",
+		"undefined role":   "roles:\n  viewer: {}\nsubjects:\n  kiosk: [admin]\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := authz.LoadPolicy(writePolicy(t, content))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	policy := authz.DefaultPolicy()
+	assert.True(t, policy.Allows([]string{authz.RoleViewer}, authz.PermMetricsRead))
+	assert.False(t, policy.Allows([]string{authz.RoleViewer}, authz.PermZonesWrite))
+	assert.True(t, policy.Allows([]string{authz.RoleIntegrator}, authz.PermZonesWrite))
+	assert.False(t, policy.Allows([]string{authz.RoleIntegrator}, authz.PermConfigWrite))
+	assert.True(t, policy.Allows([]string{authz.RoleOperator}, authz.PermServiceWrite))
+
+	// API keys are given roles from the key configuration
+	require.NoError(t, policy.Assign("line-display", authz.RoleViewer))
+	require.NoError(t, policy.Assign("line-display", authz.RoleViewer))
+	assert.Equal(t, []string{authz.RoleViewer}, policy.SubjectRoles("line-display"))
+	assert.Error(t, policy.Assign("line-display", "auditor"))
+}
+
+func TestEnforcerRoles(t *testing.T) {
+	policy, err := authz.LoadPolicy(writePolicy(t, testPolicy))
+	require.NoError(t, err)
+	e := authz.New(policy)
+
+	assert.Equal(t, []string{"viewer"}, e.Roles(&auth.Principal{Subject: "line-display", Method: auth.MethodAPIKey}))
+	assert.Equal(t, []string{"integrator", "operator"}, e.Roles(&auth.Principal{
+		Subject: "pos-bridge", Method: auth.MethodJWT,
+		Claims: map[string]interface{}{"roles": []interface{}{"operator"}},
+	}))
+	assert.Equal(t, []string{"operator", "viewer"}, e.Roles(&auth.Principal{
+		Subject: "someone", Method: auth.MethodJWT,
+		Claims: map[string]interface{}{"roles": "viewer operator"},
+	}))
+}
+
+// serveAs runs h with the given caller in the request context
+func serveAs(h http.Handler, method, path string, p *auth.Principal) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if p != nil {
+		req = req.WithContext(auth.NewContext(req.Context(), p))
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestEnforcerRequire(t *testing.T) {
+	policy, err := authz.LoadPolicy(writePolicy(t, testPolicy))
+	require.NoError(t, err)
+	auditor := &recordingAuditor{}
+	reader := sdkmetric.NewManualReader()
+	e := authz.New(policy, authz.WithAuditor(auditor))
+	require.NoError(t, e.RegisterMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")))
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	read := e.Require(authz.PermMetricsRead, ok)
+	write := e.Require(authz.PermConfigWrite, ok)
+
+	viewer := &auth.Principal{Subject: "line-display", Method: auth.MethodAPIKey}
+	operator := &auth.Principal{Subject: "site-admin", Method: auth.MethodJWT}
+
+	// Reads are allowed without an audit entry
+	assert.Equal(t, http.StatusAccepted, serveAs(read, "GET", "/metrics", viewer).Code)
+	assert.Empty(t, auditor.entries)
+
+	// Privileged actions are audited with the handler's status
+	assert.Equal(t, http.StatusAccepted, serveAs(write, "POST", "/admin/reload", operator).Code)
+	require.Len(t, auditor.entries, 1)
+	entry := auditor.entries[0]
+	assert.Equal(t, "site-admin", entry.Subject)
+	assert.Equal(t, authz.PermConfigWrite, entry.Action)
+	assert.Equal(t, "POST /admin/reload", entry.Target)
+	assert.Equal(t, []string{"operator"}, entry.Roles)
+	assert.True(t, entry.Allowed)
+	assert.Equal(t, http.StatusAccepted, entry.Status)
+
+	// Denials get a JSON 403, an audit entry and a metric
+	rec := serveAs(write, "POST", "/admin/reload", viewer)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	var body auth.Error
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "forbidden", body.Error)
+	assert.Equal(t, "line-display lacks permission config:write", body.Message)
+	require.Len(t, auditor.entries, 2)
+	assert.False(t, auditor.entries[1].Allowed)
+
+	// Without a principal the request is denied unless anonymous access is on
+	assert.Equal(t, http.StatusForbidden, serveAs(read, "GET", "/metrics", nil).Code)
+
+	denials := findMetric(t, reader, "authz.denials").Data.(metricdata.Sum[int64])
+	var total int64
+	for _, dp := range denials.DataPoints {
+		total += dp.Value
+	}
+	assert.Equal(t, int64(2), total)
+}
+
+func TestEnforcerAnonymous(t *testing.T) {
+	auditor := &recordingAuditor{}
+	e := authz.New(authz.DefaultPolicy(), authz.WithAuditor(auditor), authz.WithAnonymous())
+	h := e.Require(authz.PermServiceWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	assert.Equal(t, http.StatusOK, serveAs(h, "POST", "/admin/service/restart", nil).Code)
+	require.Len(t, auditor.entries, 1)
+	assert.Equal(t, "anonymous", auditor.entries[0].Subject)
+}
+
+func TestEnforcerAllowsExemptPaths(t *testing.T) {
+	cfg := config.Default().Auth
+	cfg.APIKeys = map[string]string{"line-display": auth.HashAPIKey("s3cret-key")}
+	cfg.ExemptPaths = []string{"/metrics"}
+	a, err := auth.New(cfg)
+	require.NoError(t, err)
+	e := authz.New(authz.DefaultPolicy())
+
+	h := a.Middleware(e.Require(authz.PermMetricsRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	assert.Equal(t, http.StatusOK, serveAs(h, "GET", "/metrics", nil).Code)
+}
+
+func TestFileAuditor(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	a, err := authz.NewFileAuditor(path, logger)
+	require.NoError(t, err)
+	a.Record(authz.Entry{Subject: "site-admin", Action: authz.PermConfigWrite, Allowed: true})
+	a.Record(authz.Entry{Subject: "console:ops", Action: "console:stop", Allowed: true})
+	require.NoError(t, a.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var actions []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e authz.Entry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{"config:write", "console:stop"}, actions)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestLogAuditorIgnoresLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf)
+	logger.SetLevel(logrus.ErrorLevel)
+	a := authz.NewLogAuditor(logger)
+
+	a.Record(authz.Entry{Subject: "site-admin", Action: authz.PermConfigWrite, Allowed: true})
+	logger.Warn("dropped at error level")
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry), buf.String())
+	assert.Equal(t, true, entry["audit"])
+	assert.Equal(t, "site-admin", entry["subject"])
+	assert.Equal(t, logrus.ErrorLevel, logger.GetLevel())
+}
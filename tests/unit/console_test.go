@@ -0,0 +1,273 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/adron/golang-services-build-base/internal/console"
+	"github.com/adron/golang-services-build-base/internal/reload"
+	"github.com/adron/golang-services-build-base/internal/service"
+)
+
+// fakeService records lifecycle calls made by the console
+type fakeService struct {
+	state    service.State
+	calls    []string
+	startErr error
+	errs     chan error
+}
+
+func newFakeService() *fakeService {
+	return &fakeService{state: service.Stopped, errs: make(chan error, 1)}
+}
+
+func (f *fakeService) Status() service.Status {
+	return service.Status{State: f.state, Since: time.Now().Add(-time.Minute)}
+}
+
+func (f *fakeService) Start(ctx context.Context) error {
+	f.calls = append(f.calls, "start")
+	if f.startErr != nil {
+		f.state = service.Failed
+		return f.startErr
+	}
+	f.state = service.Running
+	return nil
+}
+
+func (f *fakeService) Stop(ctx context.Context) error {
+	if f.state != service.Running {
+		return service.ErrNotRunning
+	}
+	f.calls = append(f.calls, "stop")
+	f.state = service.Stopped
+	return nil
+}
+
+func (f *fakeService) Restart(ctx context.Context) error {
+	f.calls = append(f.calls, "restart")
+	f.state = service.Running
+	return nil
+}
+
+func (f *fakeService) Errors() <-chan error { return f.errs }
+
+type staticTail []string
+
+func (s staticTail) Lines(n int) []string {
+	if n < len(s) {
+		return s[len(s)-n:]
+	}
+	return s
+}
+
+func TestConsoleRunCommands(t *testing.T) {
+	svc := newFakeService()
+	var level string
+	c := console.New(svc,
+		console.WithLogLevel(func(l string) error { level = l; return nil }),
+		console.WithReload(func(trigger string) reload.Result {
+			return reload.Result{Trigger: trigger, Success: true, Applied: []string{"log_level"}}
+		}),
+	)
+
+	in := strings.NewReader("start\nloglevel debug\nreload\nrestart\nbogus\nx\n")
+	var out bytes.Buffer
+	require.NoError(t, c.Run(context.Background(), in, &out))
+
+	assert.Equal(t, []string{"start", "restart", "stop"}, svc.calls)
+	assert.Equal(t, "debug", level)
+	assert.Contains(t, out.String(), "Service started successfully")
+	assert.Contains(t, out.String(), "Applied: log_level")
+	assert.Contains(t, out.String(), `Unknown command "bogus"`)
+	assert.Contains(t, out.String(), "Exiting...")
+}
+
+func TestConsoleLegacyKeys(t *testing.T) {
+	svc := newFakeService()
+	c := console.New(svc)
+
+	var out bytes.Buffer
+	require.NoError(t, c.Run(context.Background(), strings.NewReader("s\nq\n"), &out))
+	assert.Equal(t, []string{"start", "stop"}, svc.calls)
+}
+
+func TestConsoleStopsThroughStopper(t *testing.T) {
+	svc := newFakeService()
+	var stops int
+	c := console.New(svc, console.WithStop(func(ctx context.Context) error {
+		stops++
+		return svc.Stop(ctx)
+	}))
+	ctx := context.Background()
+
+	c.Execute(ctx, "start")
+	c.Execute(ctx, "stop")
+	c.Execute(ctx, "start")
+	c.Execute(ctx, "exit")
+	assert.Equal(t, 2, stops)
+	assert.Equal(t, []string{"start", "stop", "start", "stop"}, svc.calls)
+}
+
+func TestConsoleStopWhileStopped(t *testing.T) {
+	svc := newFakeService()
+	c := console.New(svc)
+
+	var out bytes.Buffer
+	require.NoError(t, c.Run(context.Background(), strings.NewReader("stop\n"), &out))
+	assert.Contains(t, out.String(), "Service is not running")
+	assert.NotContains(t, out.String(), "Service stopped successfully")
+	assert.Empty(t, svc.calls)
+}
+
+func TestConsoleStartErrorShown(t *testing.T) {
+	svc := newFakeService()
+	svc.startErr = errors.New("address already in use")
+	c := console.New(svc)
+
+	var out bytes.Buffer
+	require.NoError(t, c.Run(context.Background(), strings.NewReader("start\nstatus\n"), &out))
+	assert.Contains(t, out.String(), "Failed to start service: address already in use")
+	assert.Contains(t, out.String(), "State:    failed")
+}
+
+func TestConsoleHistory(t *testing.T) {
+	svc := newFakeService()
+	c := console.New(svc)
+	ctx := context.Background()
+
+	c.Execute(ctx, "start")
+	c.Execute(ctx, "help")
+	c.Execute(ctx, "!1")
+	c.Execute(ctx, "!!")
+	c.Execute(ctx, "!9")
+
+	assert.Equal(t, []string{"start", "help", "start", "start"}, c.History())
+	assert.Equal(t, []string{"start", "start", "start"}, svc.calls)
+}
+
+func TestConsoleAuditsPrivilegedCommands(t *testing.T) {
+	svc := newFakeService()
+	var audited []string
+	c := console.New(svc,
+		console.WithLogLevel(func(string) error { return nil }),
+		console.WithAudit(func(command string, args []string) {
+			audited = append(audited, strings.TrimSpace(command+" "+strings.Join(args, " ")))
+		}),
+	)
+	ctx := context.Background()
+
+	c.Execute(ctx, "s")
+	c.Execute(ctx, "status")
+	c.Execute(ctx, "loglevel debug")
+	c.Execute(ctx, "history")
+	c.Execute(ctx, "q")
+
+	assert.Equal(t, []string{"start", "loglevel debug", "stop"}, audited)
+}
+
+func TestConsoleStatusPanel(t *testing.T) {
+	svc := newFakeService()
+	svc.state = service.Running
+	var count uint64
+	c := console.New(svc,
+		console.WithRequestCount(func() uint64 { return count }),
+		console.WithLogTail(staticTail{"first", "second"}),
+		console.WithComponents(func() []console.Component {
+			return []console.Component{
+				{Kind: "queue", Name: "frames", State: "ok", Detail: "depth 3"},
+				{Kind: "camera", Name: "lane-1", State: "streaming"},
+			}
+		}),
+	)
+
+	count = 42
+	snap := c.Snapshot()
+	assert.Equal(t, service.Running, snap.Service.State)
+	assert.Equal(t, uint64(42), snap.Requests)
+	assert.Greater(t, snap.RequestRate, 0.0)
+	assert.Equal(t, "camera", snap.Components[0].Kind, "components are sorted by kind")
+	assert.Equal(t, []string{"first", "second"}, snap.Logs)
+
+	// The rate only counts requests since the previous sample
+	snap = c.Snapshot()
+	assert.Equal(t, 0.0, snap.RequestRate)
+
+	var out bytes.Buffer
+	require.NoError(t, c.Run(context.Background(), strings.NewReader(""), &out))
+	assert.Contains(t, out.String(), "lane-1")
+	assert.Contains(t, out.String(), "depth 3")
+	assert.Contains(t, out.String(), "second")
+}
+
+func TestConsoleSnapshotFile(t *testing.T) {
+	dir := t.TempDir()
+	c := console.New(newFakeService(), console.WithSnapshotDir(dir))
+
+	var out bytes.Buffer
+	require.NoError(t, c.Run(context.Background(), strings.NewReader("snapshot\n"), &out))
+
+	files, err := filepath.Glob(filepath.Join(dir, "snapshot-*.json"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	var snap map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &snap))
+	assert.Equal(t, "stopped", snap["service"].(map[string]interface{})["state"])
+}
+
+func TestConsoleReportsServiceErrors(t *testing.T) {
+	svc := newFakeService()
+	c := console.New(svc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var out syncBuffer
+	done := make(chan error)
+	go func() {
+		// A reader that never returns keeps the console waiting for input
+		r, w, _ := os.Pipe()
+		defer w.Close()
+		done <- c.Run(ctx, r, &out)
+	}()
+
+	svc.errs <- errors.New("listener closed")
+	assert.Eventually(t, func() bool {
+		return strings.Contains(out.String(), "Service failed: listener closed")
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+// syncBuffer is a bytes.Buffer safe for a writer and reader in different goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,127 @@
+package unit
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/detect"
+	"github.com/adron/golang-services-build-base/internal/frames"
+)
+
+// scene returns a grey frame with a white rectangle over each of rects
+func scene(w, h int, rects ...image.Rectangle) *frames.Frame {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{Y: 60}), image.Point{}, draw.Src)
+	for _, r := range rects {
+		draw.Draw(img, r, image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+	return &frames.Frame{Camera: "lane-1", Image: img}
+}
+
+func TestBackgroundDetector(t *testing.T) {
+	d := detect.NewBackground(config.Default().Detector.Background)
+	ctx := context.Background()
+
+	// The first frame seeds the background
+	detections, err := d.Detect(ctx, scene(160, 120))
+	require.NoError(t, err)
+	assert.Empty(t, detections)
+	detections, err = d.Detect(ctx, scene(160, 120))
+	require.NoError(t, err)
+	assert.Empty(t, detections, "an unchanged scene has no motion")
+
+	// A large new object is found; one smaller than min_area is not
+	detections, err = d.Detect(ctx, scene(160, 120, image.Rect(40, 20, 80, 60), image.Rect(120, 100, 128, 108)))
+	require.NoError(t, err)
+	require.Len(t, detections, 1)
+	assert.Equal(t, detect.ClassMotion, detections[0].Class)
+	assert.Equal(t, frames.Box{X: 40, Y: 20, Width: 40, Height: 40}, detections[0].Box)
+	assert.InDelta(t, 1.0, detections[0].Confidence, 0.001)
+
+	// Two separate objects are separate detections, largest first
+	detections, err = d.Detect(ctx, scene(160, 120, image.Rect(0, 0, 24, 24), image.Rect(100, 60, 160, 120)))
+	require.NoError(t, err)
+	require.Len(t, detections, 2)
+	assert.Equal(t, frames.Box{X: 100, Y: 60, Width: 60, Height: 60}, detections[0].Box)
+	assert.Equal(t, frames.Box{X: 0, Y: 0, Width: 24, Height: 24}, detections[1].Box)
+}
+
+func TestBackgroundDetectorAdapts(t *testing.T) {
+	cfg := config.Default().Detector.Background
+	cfg.LearningRate = 0.5
+	d := detect.NewBackground(cfg)
+	ctx := context.Background()
+	parked := image.Rect(40, 40, 80, 80)
+
+	d.Detect(ctx, scene(160, 120))
+	detections, _ := d.Detect(ctx, scene(160, 120, parked))
+	assert.Len(t, detections, 1)
+
+	// An object that stays put becomes part of the background
+	for i := 0; i < 10; i++ {
+		detections, _ = d.Detect(ctx, scene(160, 120, parked))
+	}
+	assert.Empty(t, detections)
+}
+
+func TestBackgroundDetectorPerCamera(t *testing.T) {
+	d := detect.NewBackground(config.Default().Detector.Background)
+	ctx := context.Background()
+	d.Detect(ctx, scene(160, 120))
+
+	// Another camera, or a new resolution, starts a new background
+	other := scene(160, 120, image.Rect(40, 20, 80, 60))
+	other.Camera = "lane-2"
+	detections, _ := d.Detect(ctx, other)
+	assert.Empty(t, detections)
+	detections, _ = d.Detect(ctx, scene(320, 240, image.Rect(40, 20, 80, 60)))
+	assert.Empty(t, detections)
+
+	d.Reset("lane-2")
+	detections, _ = d.Detect(ctx, other)
+	assert.Empty(t, detections)
+}
+
+func TestBackgroundDetectorExpiresIdleCameras(t *testing.T) {
+	d := detect.NewBackground(config.Default().Detector.Background)
+	ctx := context.Background()
+	moved := scene(160, 120, image.Rect(40, 20, 80, 60))
+
+	d.Detect(ctx, scene(160, 120))
+	d.Expire(time.Now(), time.Minute)
+	detections, _ := d.Detect(ctx, moved)
+	assert.Len(t, detections, 1, "a recent background is kept")
+
+	// An idle camera's background is dropped, so its next frame seeds a new one
+	d.Expire(time.Now().Add(2*time.Minute), time.Minute)
+	detections, _ = d.Detect(ctx, moved)
+	assert.Empty(t, detections)
+}
+
+func TestNewDetector(t *testing.T) {
+	cfg := config.Default().Detector
+	d, err := detect.New(cfg, logrus.New(), noop.NewMeterProvider().Meter(""))
+	require.NoError(t, err)
+	assert.IsType(t, &detect.Background{}, d)
+
+	cfg.Type = "none"
+	d, err = detect.New(cfg, logrus.New(), noop.NewMeterProvider().Meter(""))
+	require.NoError(t, err)
+	detections, err := d.Detect(context.Background(), scene(16, 16))
+	assert.NoError(t, err)
+	assert.Empty(t, detections)
+
+	cfg.Type = "yolo"
+	_, err = detect.New(cfg, logrus.New(), noop.NewMeterProvider().Meter(""))
+	assert.Error(t, err)
+}
@@ -0,0 +1,372 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/frames"
+	"github.com/adron/golang-services-build-base/internal/logging"
+)
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	img.Set(1, 1, color.RGBA{R: 200, A: 255})
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil))
+	return buf.Bytes()
+}
+
+func TestDecodeFrame(t *testing.T) {
+	cfg := config.Default().Frames
+
+	img, format, err := frames.Decode(encodePNG(t, 8, 6), frames.MediaTypePNG, 0, 0, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, frames.FormatPNG, format)
+	assert.Equal(t, image.Rect(0, 0, 8, 6), img.Bounds())
+
+	_, format, err = frames.Decode(encodeJPEG(t, 16, 16), frames.MediaTypeJPEG, 0, 0, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, frames.FormatJPEG, format)
+
+	raw := []byte{255, 0, 0, 0, 255, 0, 0, 0, 255, 10, 20, 30}
+	img, format, err = frames.Decode(raw, frames.MediaTypeRGB, 2, 2, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, frames.FormatRGB, format)
+	assert.Equal(t, color.RGBA{R: 10, G: 20, B: 30, A: 255}, img.At(1, 1))
+
+	_, _, err = frames.Decode(raw, frames.MediaTypeRGB, 3, 2, cfg)
+	assert.ErrorIs(t, err, frames.ErrMalformed)
+	_, _, err = frames.Decode([]byte("GIF89a"), "image/gif", 0, 0, cfg)
+	assert.ErrorIs(t, err, frames.ErrUnsupportedFormat)
+	_, _, err = frames.Decode([]byte("not an image"), frames.MediaTypePNG, 0, 0, cfg)
+	assert.ErrorIs(t, err, frames.ErrMalformed)
+}
+
+func TestDecodeFrameLimits(t *testing.T) {
+	cfg := config.Default().Frames
+	cfg.MaxPixels = 100 * 100
+	cfg.MaxDimension = 200
+
+	// A compressed image declaring more pixels than allowed is rejected
+	// from its header
+	_, _, err := frames.Decode(encodePNG(t, 150, 150), frames.MediaTypePNG, 0, 0, cfg)
+	assert.ErrorIs(t, err, frames.ErrTooLarge)
+	_, _, err = frames.Decode(encodePNG(t, 300, 10), frames.MediaTypePNG, 0, 0, cfg)
+	assert.ErrorIs(t, err, frames.ErrTooLarge)
+	_, _, err = frames.Decode(nil, frames.MediaTypeRGB, 1000, 1000, cfg)
+	assert.ErrorIs(t, err, frames.ErrTooLarge)
+
+	cfg.MaxBytes = 10
+	_, _, err = frames.Decode(encodePNG(t, 8, 8), frames.MediaTypePNG, 0, 0, cfg)
+	assert.ErrorIs(t, err, frames.ErrTooLarge)
+}
+
+func newTestPipeline(t *testing.T, cfg config.FramesConfig, process frames.ProcessFunc) *frames.Pipeline {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	p := frames.NewPipeline(cfg, process, logger)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		p.Close(ctx)
+	})
+	return p
+}
+
+func frameRouter(p *frames.Pipeline) *mux.Router {
+	router := mux.NewRouter()
+	router.Handle("/v1/cameras/{camera}/frames", p.IngestHandler()).Methods("POST")
+	router.Handle(frames.ResultPath+"{id}", p.ResultHandler()).Methods("GET")
+	return router
+}
+
+func upload(router http.Handler, camera, mediaType string, body []byte, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/v1/cameras/"+camera+"/frames", bytes.NewReader(body))
+	req.Header.Set("Content-Type", mediaType)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestFrameIngestSync(t *testing.T) {
+	p := newTestPipeline(t, config.Default().Frames, func(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+		return []frames.Detection{{Class: "person", Box: frames.Box{X: 1, Y: 2, Width: 3, Height: 4}, Confidence: 0.9}}, nil
+	})
+	router := frameRouter(p)
+
+	captured := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	rec := upload(router, "lane-1", frames.MediaTypePNG, encodePNG(t, 8, 6),
+		http.Header{frames.CaptureTimeHeader: {captured.Format(time.RFC3339Nano)}})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var result frames.Result
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+	assert.Equal(t, "lane-1", result.Camera)
+	assert.Equal(t, uint64(1), result.Sequence)
+	assert.True(t, captured.Equal(result.CapturedAt))
+	assert.Equal(t, frames.StatusDone, result.Status)
+	assert.Equal(t, 8, result.Width)
+	assert.Equal(t, "person", result.Detections[0].Class)
+
+	rec = upload(router, "lane-1", frames.MediaTypeRGB, nil, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "raw upload without dimensions")
+
+	// Sequence numbers count accepted frames per camera
+	rec = upload(router, "lane-1", frames.MediaTypePNG, encodePNG(t, 4, 4), nil)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+	assert.Equal(t, uint64(2), result.Sequence)
+	rec = upload(router, "lane-2", frames.MediaTypePNG, encodePNG(t, 4, 4), nil)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+	assert.Equal(t, uint64(1), result.Sequence)
+}
+
+func TestFrameIngestRawRGB(t *testing.T) {
+	p := newTestPipeline(t, config.Default().Frames, func(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+		return nil, nil
+	})
+	req := httptest.NewRequest("POST", "/v1/cameras/dock/frames?width=2&height=1", bytes.NewReader([]byte{1, 2, 3, 4, 5, 6}))
+	req.Header.Set("Content-Type", frames.MediaTypeRGB)
+	rec := httptest.NewRecorder()
+	frameRouter(p).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var result frames.Result
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+	assert.Equal(t, frames.FormatRGB, result.Format)
+	assert.NotNil(t, result.Detections, "processed frames report an empty list")
+}
+
+func TestFrameIngestAsync(t *testing.T) {
+	release := make(chan struct{})
+	p := newTestPipeline(t, config.Default().Frames, func(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+		<-release
+		return nil, errors.New("model unavailable")
+	})
+	router := frameRouter(p)
+
+	rec := upload(router, "lane-1", frames.MediaTypePNG, encodePNG(t, 4, 4), http.Header{"Prefer": {"respond-async"}})
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	location := rec.Header().Get("Location")
+	require.NotEmpty(t, location)
+	var result frames.Result
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+	assert.Equal(t, frames.StatusPending, result.Status)
+	assert.Equal(t, frames.ResultPath+result.ID, location)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", location, nil))
+		json.NewDecoder(rec.Body).Decode(&result)
+		return result.Status != frames.StatusPending
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, frames.StatusFailed, result.Status)
+	assert.Equal(t, "model unavailable", result.Error)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", frames.ResultPath+"unknown", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestFrameIngestRejects(t *testing.T) {
+	cfg := config.Default().Frames
+	cfg.Workers = 1
+	cfg.QueueSize = 1
+	cfg.SyncTimeout = 0
+	cfg.MaxPixels = 64 * 64
+	release := make(chan struct{})
+	defer close(release)
+	p := newTestPipeline(t, cfg, func(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+		<-release
+		return nil, nil
+	})
+	reader := sdkmetric.NewManualReader()
+	require.NoError(t, p.RegisterMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")))
+	router := frameRouter(p)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, upload(router, "lane-1", frames.MediaTypePNG, encodePNG(t, 65, 65), nil).Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, upload(router, "lane-1", "image/gif", []byte("GIF89a"), nil).Code)
+	assert.Equal(t, http.StatusBadRequest, upload(router, "bad%20camera", frames.MediaTypePNG, encodePNG(t, 4, 4), nil).Code)
+	assert.Equal(t, http.StatusBadRequest, upload(router, "lane-1", frames.MediaTypePNG, encodePNG(t, 4, 4),
+		http.Header{frames.CaptureTimeHeader: {"yesterday"}}).Code)
+
+	// One frame is being processed and one is queued, so the third is refused
+	assert.Equal(t, http.StatusAccepted, upload(router, "lane-1", frames.MediaTypePNG, encodePNG(t, 4, 4), nil).Code)
+	require.Eventually(t, func() bool { return p.Depth() == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, http.StatusAccepted, upload(router, "lane-1", frames.MediaTypePNG, encodePNG(t, 4, 4), nil).Code)
+	assert.Equal(t, 1, p.Depth())
+	rec := upload(router, "lane-1", frames.MediaTypePNG, encodePNG(t, 4, 4), nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	rejected := findMetric(t, reader, "frames.rejected").Data.(metricdata.Sum[int64])
+	reasons := map[string]int64{}
+	for _, dp := range rejected.DataPoints {
+		reason, _ := dp.Attributes.Value("reason")
+		reasons[reason.AsString()] = dp.Value
+	}
+	assert.Equal(t, map[string]int64{
+		"too_large": 1, "unsupported_format": 1, "invalid_request": 2, "queue_full": 1,
+	}, reasons)
+}
+
+func TestFramePipelineBounds(t *testing.T) {
+	cfg := config.Default().Frames
+	cfg.MaxResults = 2
+	cfg.MaxCameras = 2
+	p := newTestPipeline(t, cfg, func(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+		return nil, nil
+	})
+	submit := func(camera string) (*frames.Job, error) {
+		return p.Submit(context.Background(), &frames.Frame{Camera: camera, ReceivedAt: time.Now(), Image: image.NewRGBA(image.Rect(0, 0, 4, 4))})
+	}
+
+	// Only the newest results are kept
+	var ids []string
+	for i := 0; i < 3; i++ {
+		job, err := submit("lane-1")
+		require.NoError(t, err)
+		<-job.Done()
+		ids = append(ids, job.ID())
+	}
+	_, ok := p.Result(ids[0])
+	assert.False(t, ok, "oldest result dropped")
+	for _, id := range ids[1:] {
+		result, ok := p.Result(id)
+		assert.True(t, ok)
+		assert.Equal(t, frames.StatusDone, result.Status)
+	}
+
+	// A third camera is refused while the others are active
+	_, err := submit("lane-2")
+	require.NoError(t, err)
+	_, err = submit("lane-3")
+	assert.ErrorIs(t, err, frames.ErrTooManyCameras)
+	job, err := submit("lane-1")
+	require.NoError(t, err)
+	<-job.Done()
+	result, _ := p.Result(job.ID())
+	assert.Equal(t, uint64(4), result.Sequence)
+}
+
+func TestFramePipelineSlowJobOutlivesTTL(t *testing.T) {
+	cfg := config.Default().Frames
+	cfg.ResultTTL = 10 * time.Millisecond
+	p := newTestPipeline(t, cfg, func(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+		time.Sleep(50 * time.Millisecond)
+		return nil, nil
+	})
+	job, err := p.Submit(context.Background(), &frames.Frame{Camera: "lane-1", ReceivedAt: time.Now(), Image: image.NewRGBA(image.Rect(0, 0, 4, 4))})
+	require.NoError(t, err)
+
+	// The TTL starts once the frame is processed
+	time.Sleep(20 * time.Millisecond)
+	result, ok := p.Result(job.ID())
+	require.True(t, ok)
+	assert.Equal(t, frames.StatusPending, result.Status)
+	<-job.Done()
+	result, ok = p.Result(job.ID())
+	require.True(t, ok)
+	assert.Equal(t, frames.StatusDone, result.Status)
+}
+
+func TestFramePipelineOrdersEachCamera(t *testing.T) {
+	cfg := config.Default().Frames
+	cfg.Workers = 4
+	cfg.QueueSize = 40
+	var mu sync.Mutex
+	seen := map[string][]uint64{}
+	running := map[string]int{}
+	var overlapped, parallel bool
+	p := newTestPipeline(t, cfg, func(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+		mu.Lock()
+		running[f.Camera]++
+		overlapped = overlapped || running[f.Camera] > 1
+		parallel = parallel || len(running) > 1
+		seen[f.Camera] = append(seen[f.Camera], f.Sequence)
+		mu.Unlock()
+		time.Sleep(time.Duration(f.Sequence%3) * time.Millisecond)
+		mu.Lock()
+		if running[f.Camera]--; running[f.Camera] == 0 {
+			delete(running, f.Camera)
+		}
+		mu.Unlock()
+		return nil, nil
+	})
+
+	var jobs []*frames.Job
+	for i := 0; i < 20; i++ {
+		for _, camera := range []string{"lane-1", "lane-2"} {
+			job, err := p.Submit(context.Background(), &frames.Frame{Camera: camera, ReceivedAt: time.Now(), Image: image.NewRGBA(image.Rect(0, 0, 4, 4))})
+			require.NoError(t, err)
+			jobs = append(jobs, job)
+		}
+	}
+	for _, job := range jobs {
+		<-job.Done()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.False(t, overlapped, "one frame per camera at a time")
+	assert.True(t, parallel, "cameras are processed in parallel")
+	for camera, sequences := range seen {
+		assert.IsIncreasing(t, sequences, camera)
+		assert.Len(t, sequences, 20, camera)
+	}
+	assert.Zero(t, p.Depth())
+}
+
+func TestFrameFailureLogCarriesTraceContext(t *testing.T) {
+	var buf bytes.Buffer
+	p := frames.NewPipeline(config.Default().Frames, func(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+		return nil, errors.New("detector unavailable")
+	}, logging.New(&buf))
+	t.Cleanup(func() { p.Close(context.Background()) })
+
+	tp := sdktrace.NewTracerProvider()
+	defer tp.Shutdown(context.Background())
+	ctx, span := tp.Tracer("test").Start(context.Background(), "upload")
+	defer span.End()
+
+	req := httptest.NewRequest("POST", "/v1/cameras/lane-1/frames", bytes.NewReader(encodePNG(t, 4, 4))).WithContext(ctx)
+	req.Header.Set("Content-Type", frames.MediaTypePNG)
+	rec := httptest.NewRecorder()
+	frameRouter(p).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry), buf.String())
+	assert.Equal(t, "Frame processing failed: detector unavailable", entry["msg"])
+	assert.Equal(t, span.SpanContext().TraceID().String(), entry["trace_id"])
+}
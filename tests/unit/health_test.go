@@ -1,12 +1,17 @@
 package unit
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/adron/golang-services-build-base/internal/handlers"
+	"github.com/adron/golang-services-build-base/internal/health"
 	"github.com/adron/golang-services-build-base/tests/testutils"
 
 	"github.com/stretchr/testify/assert"
@@ -109,3 +114,114 @@ func TestHealthHandlerTimeout(t *testing.T) {
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "context deadline exceeded")
 }
+
+func TestHealthRegistryReadiness(t *testing.T) {
+	registry := health.NewRegistry(50*time.Millisecond, 0)
+	registry.RegisterReadiness(health.NewChecker("ok", func(ctx context.Context) error { return nil }))
+	registry.RegisterReadiness(health.NewChecker("broken", func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}))
+	registry.RegisterReadiness(health.NewChecker("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}))
+
+	start := time.Now()
+	report := registry.Ready(context.Background())
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "slow check should be cut off by the timeout")
+
+	assert.False(t, report.Healthy())
+	require.Len(t, report.Components, 3)
+	assert.Equal(t, health.StatusUp, report.Components[0].Status)
+	assert.Equal(t, health.StatusDown, report.Components[1].Status)
+	assert.Equal(t, "connection refused", report.Components[1].LastError)
+	assert.Equal(t, health.StatusDown, report.Components[2].Status)
+	assert.Contains(t, report.Components[2].LastError, "timed out")
+
+	// Liveness has no checkers registered and stays up
+	assert.True(t, registry.Live(context.Background()).Healthy())
+}
+
+func TestHealthRegistryCachesResults(t *testing.T) {
+	var calls atomic.Int32
+	fail := atomic.Bool{}
+	fail.Store(true)
+
+	registry := health.NewRegistry(time.Second, 100*time.Millisecond)
+	registry.RegisterReadiness(health.NewChecker("counted", func(ctx context.Context) error {
+		calls.Add(1)
+		if fail.Load() {
+			return errors.New("not yet")
+		}
+		return nil
+	}))
+
+	registry.Ready(context.Background())
+	registry.Ready(context.Background())
+	assert.Equal(t, int32(1), calls.Load(), "second probe should use the cached result")
+
+	// After the TTL the check runs again and keeps the last error
+	fail.Store(false)
+	time.Sleep(150 * time.Millisecond)
+	report := registry.Ready(context.Background())
+	assert.Equal(t, int32(2), calls.Load())
+	assert.True(t, report.Healthy())
+	assert.Equal(t, "not yet", report.Components[0].LastError)
+	assert.NotNil(t, report.Components[0].LastErrorAt)
+}
+
+func TestHealthCheckers(t *testing.T) {
+	ctx := context.Background()
+
+	collector := httptest.NewServer(http.NotFoundHandler())
+	assert.NoError(t, health.NewCollectorChecker(collector.URL).Check(ctx))
+	collector.Close()
+	assert.Error(t, health.NewCollectorChecker(collector.URL).Check(ctx))
+
+	assert.NoError(t, health.NewDiskSpaceChecker(t.TempDir(), 0).Check(ctx))
+	assert.Error(t, health.NewDiskSpaceChecker(t.TempDir(), 1<<62).Check(ctx))
+
+	var lastFrame time.Time
+	fresh := health.NewFreshnessChecker("frames", time.Minute, func() time.Time { return lastFrame })
+	assert.Error(t, fresh.Check(ctx), "no frames yet")
+	lastFrame = time.Now()
+	assert.NoError(t, fresh.Check(ctx))
+	lastFrame = time.Now().Add(-time.Hour)
+	assert.Error(t, fresh.Check(ctx))
+}
+
+func TestProbeHandlers(t *testing.T) {
+	registry := health.NewRegistry(time.Second, 0)
+	ready := atomic.Bool{}
+	registry.RegisterReadiness(health.NewChecker("startup", func(ctx context.Context) error {
+		if !ready.Load() {
+			return errors.New("starting")
+		}
+		return nil
+	}))
+
+	w := httptest.NewRecorder()
+	handlers.NewReadinessHandler(registry).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var report health.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, health.StatusDown, report.Status)
+	require.Len(t, report.Components, 1)
+	assert.Equal(t, "startup", report.Components[0].Name)
+	assert.Equal(t, "starting", report.Components[0].LastError)
+
+	ready.Store(true)
+	w = httptest.NewRecorder()
+	handlers.NewReadinessHandler(registry).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	handlers.NewLivenessHandler(registry).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	handlers.NewLivenessHandler(registry).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/health/live", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,256 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/detect"
+	"github.com/adron/golang-services-build-base/internal/frames"
+	"github.com/adron/golang-services-build-base/internal/kserve"
+)
+
+// modelServer is a KServe v2 stub. Each image gets one detection at
+// (8,4)-(24,20) in model input coordinates whose class is the image's red
+// level in tenths, followed by a padding row.
+type modelServer struct {
+	mu       sync.Mutex
+	requests []kserve.InferRequest
+	headers  []http.Header
+	status   int
+	delay    time.Duration
+}
+
+func (s *modelServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v2/models/yolo/ready":
+		return
+	case "/v2/models/yolo/infer", "/v2/models/yolo/versions/3/infer":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	var req kserve.InferRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.headers = append(s.headers, r.Header.Clone())
+	status, delay := s.status, s.delay
+	s.mu.Unlock()
+
+	time.Sleep(delay)
+	w.Header().Set("Content-Type", "application/json")
+	if status != 0 {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(kserve.ErrorResponse{Error: "model not loaded"})
+		return
+	}
+	in := req.Inputs[0]
+	n, size := in.Shape[0], in.Shape[1]*in.Shape[2]*in.Shape[3]
+	out := kserve.Tensor{Name: "detections", Shape: []int{n, 2, 6}, Datatype: "FP32"}
+	for i := 0; i < n; i++ {
+		class := float32(int(in.Data[i*size]*255/10 + 0.5))
+		out.Data = append(out.Data, 8, 4, 24, 20, 0.75, class, 0, 0, 0, 0, 0, 0)
+	}
+	json.NewEncoder(w).Encode(kserve.InferResponse{ModelName: "yolo", Outputs: []kserve.Tensor{out}})
+}
+
+func (s *modelServer) set(status int, delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status, s.delay = status, delay
+}
+
+func (s *modelServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+func startKServe(t *testing.T, tweak func(*config.KServeConfig)) (*kserve.Client, *modelServer, *sdkmetric.ManualReader) {
+	stub := &modelServer{}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	cfg := config.Default().Detector.KServe
+	cfg.URL = server.URL
+	cfg.Model = "yolo"
+	cfg.InputWidth, cfg.InputHeight = 64, 32
+	cfg.Classes = []string{"person", "car"}
+	cfg.BatchWait = 0
+	if tweak != nil {
+		tweak(&cfg)
+	}
+	c, err := kserve.New(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	reader := sdkmetric.NewManualReader()
+	require.NoError(t, c.RegisterMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")))
+	return c, stub, reader
+}
+
+// kserveFrame is a 128x64 frame with red level 10*class
+func kserveFrame(class int) *frames.Frame {
+	img := image.NewRGBA(image.Rect(0, 0, 128, 64))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{R: uint8(10 * class), A: 0xff}), image.Point{}, draw.Src)
+	return &frames.Frame{Camera: "lane-1", Sequence: uint64(class), Image: img}
+}
+
+func TestKServeDetect(t *testing.T) {
+	c, stub, _ := startKServe(t, func(cfg *config.KServeConfig) { cfg.Version = "3" })
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	detections, err := c.Detect(ctx, kserveFrame(1))
+	require.NoError(t, err)
+
+	// The box is scaled from the 64x32 input back to the 128x64 frame
+	assert.Equal(t, []frames.Detection{{
+		Class:      "car",
+		Box:        frames.Box{X: 16, Y: 8, Width: 32, Height: 32},
+		Confidence: 0.75,
+	}}, detections)
+
+	require.Len(t, stub.requests, 1)
+	in := stub.requests[0].Inputs[0]
+	assert.Equal(t, "images", in.Name)
+	assert.Equal(t, []int{1, 3, 32, 64}, in.Shape)
+	assert.Equal(t, "FP32", in.Datatype)
+	assert.Len(t, in.Data, 3*32*64)
+	assert.Equal(t, []kserve.OutputRequest{{Name: "detections"}}, stub.requests[0].Outputs)
+	assert.Contains(t, stub.headers[0].Get("traceparent"), sc.TraceID().String())
+
+	// Unknown class indexes are named by number
+	detections, err = c.Detect(context.Background(), kserveFrame(7))
+	require.NoError(t, err)
+	assert.Equal(t, "7", detections[0].Class)
+}
+
+func TestKServeDropsBoxesInPadding(t *testing.T) {
+	c, _, _ := startKServe(t, nil)
+
+	// A 32x128 frame fills only x=28 to 36 of the 64x32 input, so the
+	// model's box at x=8 to 24 lies in the padding
+	img := image.NewRGBA(image.Rect(0, 0, 32, 128))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{R: 10, A: 0xff}), image.Point{}, draw.Src)
+	detections, err := c.Detect(context.Background(), &frames.Frame{Camera: "lane-1", Image: img})
+	require.NoError(t, err)
+	assert.Empty(t, detections)
+}
+
+func TestKServeBatching(t *testing.T) {
+	c, stub, reader := startKServe(t, func(cfg *config.KServeConfig) {
+		cfg.MaxBatch = 4
+		cfg.BatchWait = time.Second
+	})
+
+	var wg sync.WaitGroup
+	classes := make([]string, 4)
+	for i := range classes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			detections, err := c.Detect(context.Background(), kserveFrame(i))
+			if assert.NoError(t, err) && assert.Len(t, detections, 1) {
+				classes[i] = detections[0].Class
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A full batch is sent without waiting out BatchWait, and each frame
+	// gets its own row of the output
+	require.Equal(t, 1, stub.count())
+	assert.Equal(t, 4, stub.requests[0].Inputs[0].Shape[0])
+	assert.Equal(t, []string{"person", "car", "2", "3"}, classes)
+
+	hist := findMetric(t, reader, "kserve.batch.size").Data.(metricdata.Histogram[int64])
+	require.Len(t, hist.DataPoints, 1)
+	assert.Equal(t, int64(4), hist.DataPoints[0].Sum)
+}
+
+func TestKServeCircuitBreaker(t *testing.T) {
+	c, stub, reader := startKServe(t, func(cfg *config.KServeConfig) {
+		cfg.FailureThreshold = 2
+		cfg.OpenTimeout = 100 * time.Millisecond
+	})
+	stub.set(http.StatusServiceUnavailable, 0)
+
+	for i := 0; i < 2; i++ {
+		_, err := c.Detect(context.Background(), kserveFrame(0))
+		assert.ErrorContains(t, err, "HTTP 503: model not loaded")
+	}
+	assert.Equal(t, kserve.StateOpen, c.BreakerState())
+
+	// While open, frames fail without reaching the server
+	_, err := c.Detect(context.Background(), kserveFrame(0))
+	assert.ErrorIs(t, err, kserve.ErrCircuitOpen)
+	assert.Equal(t, 2, stub.count())
+	assert.Equal(t, map[string]int64{"yolo": 1}, sumByAttr(t, reader, "kserve.rejected", "model"))
+
+	// After the open timeout a trial request closes it again
+	stub.set(0, 0)
+	time.Sleep(150 * time.Millisecond)
+	_, err = c.Detect(context.Background(), kserveFrame(0))
+	require.NoError(t, err)
+	assert.Equal(t, kserve.StateClosed, c.BreakerState())
+}
+
+func TestKServeTimeout(t *testing.T) {
+	c, stub, reader := startKServe(t, func(cfg *config.KServeConfig) { cfg.Timeout = 50 * time.Millisecond })
+	stub.set(0, 300*time.Millisecond)
+
+	_, err := c.Detect(context.Background(), kserveFrame(0))
+	assert.ErrorContains(t, err, "did not answer within 50ms")
+
+	hist := findMetric(t, reader, "kserve.request.duration").Data.(metricdata.Histogram[float64])
+	require.Len(t, hist.DataPoints, 1)
+	outcome, _ := hist.DataPoints[0].Attributes.Value("outcome")
+	assert.Equal(t, "timeout", outcome.AsString())
+}
+
+func TestKServeReady(t *testing.T) {
+	c, _, _ := startKServe(t, nil)
+	assert.NoError(t, c.Ready(context.Background()))
+
+	other, _, _ := startKServe(t, func(cfg *config.KServeConfig) { cfg.Version = "2" })
+	assert.ErrorContains(t, other.Ready(context.Background()), "not ready")
+}
+
+func TestKServeClosed(t *testing.T) {
+	c, _, _ := startKServe(t, nil)
+	require.NoError(t, c.Close())
+	_, err := c.Detect(context.Background(), kserveFrame(0))
+	assert.ErrorIs(t, err, kserve.ErrClosed)
+}
+
+func TestNewKServeDetector(t *testing.T) {
+	cfg := config.Default().Detector
+	cfg.Type = "kserve"
+	cfg.KServe.URL = "http://127.0.0.1:1"
+	cfg.KServe.Model = "yolo"
+	d, err := detect.New(cfg, logrus.New(), noop.NewMeterProvider().Meter(""))
+	require.NoError(t, err)
+	defer d.(*kserve.Client).Close()
+	assert.IsType(t, &kserve.Client{}, d)
+}
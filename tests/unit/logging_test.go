@@ -0,0 +1,129 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/adron/golang-services-build-base/internal/logging"
+)
+
+type recordingLogExporter struct {
+	mu      sync.Mutex
+	records []sdklog.Record
+}
+
+func (e *recordingLogExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range records {
+		e.records = append(e.records, r.Clone())
+	}
+	return nil
+}
+
+func (e *recordingLogExporter) Shutdown(ctx context.Context) error   { return nil }
+func (e *recordingLogExporter) ForceFlush(ctx context.Context) error { return nil }
+
+func TestLoggerAddsTraceContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf)
+
+	tp := sdktrace.NewTracerProvider()
+	defer tp.Shutdown(context.Background())
+	ctx, span := tp.Tracer("test").Start(context.Background(), "test-span")
+	defer span.End()
+
+	logger.WithContext(ctx).Info("with span")
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, span.SpanContext().TraceID().String(), entry["trace_id"])
+	assert.Equal(t, span.SpanContext().SpanID().String(), entry["span_id"])
+
+	// Entries without a span context are left alone
+	buf.Reset()
+	logger.Info("without span")
+	entry = nil
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.NotContains(t, entry, "trace_id")
+}
+
+func TestLoggerSetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf)
+
+	logger.Debug("hidden")
+	assert.Empty(t, buf.String())
+
+	require.NoError(t, logging.SetLevel(logger, "debug"))
+	logger.Debug("shown")
+	assert.Contains(t, buf.String(), "shown")
+
+	assert.Error(t, logging.SetLevel(logger, "verbose"))
+	assert.Equal(t, logrus.DebugLevel, logger.GetLevel())
+}
+
+func TestOTLPHook(t *testing.T) {
+	exporter := &recordingLogExporter{}
+	lp := sdklog.NewLoggerProvider(sdklog.WithProcessor(sdklog.NewSimpleProcessor(exporter)))
+	defer lp.Shutdown(context.Background())
+
+	var buf bytes.Buffer
+	logger := logging.New(&buf)
+	logger.AddHook(logging.NewOTLPHook(lp, "test-service"))
+
+	tp := sdktrace.NewTracerProvider()
+	defer tp.Shutdown(context.Background())
+	ctx, span := tp.Tracer("test").Start(context.Background(), "test-span")
+	defer span.End()
+
+	logger.WithContext(ctx).WithField("camera", "lane-1").Warn("frame dropped")
+
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	require.Len(t, exporter.records, 1)
+	record := exporter.records[0]
+	assert.Equal(t, "frame dropped", record.Body().AsString())
+	assert.Equal(t, otellog.SeverityWarn, record.Severity())
+	assert.Equal(t, span.SpanContext().TraceID(), record.TraceID())
+
+	attrs := map[string]string{}
+	record.WalkAttributes(func(kv otellog.KeyValue) bool {
+		attrs[kv.Key] = kv.Value.AsString()
+		return true
+	})
+	assert.Equal(t, map[string]string{"camera": "lane-1"}, attrs)
+}
+
+func TestRingHookKeepsRecentLines(t *testing.T) {
+	hook := logging.NewRingHook(3)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(hook)
+
+	assert.Empty(t, hook.Lines(5))
+
+	for i := 1; i <= 5; i++ {
+		logger.WithField("n", i).Infof("entry %d", i)
+	}
+
+	lines := hook.Lines(5)
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "INFO  entry 3 n=3")
+	assert.Contains(t, lines[2], "entry 5")
+
+	lines = hook.Lines(1)
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], "entry 5")
+}
@@ -0,0 +1,334 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/auth"
+	"github.com/adron/golang-services-build-base/internal/middleware"
+	"github.com/adron/golang-services-build-base/internal/telemetry"
+)
+
+func newInstrumentedRouter(t *testing.T) (*mux.Router, *tracetest.InMemoryExporter, *sdkmetric.ManualReader) {
+	spans := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	t.Cleanup(func() {
+		tp.Shutdown(context.Background())
+		mp.Shutdown(context.Background())
+	})
+
+	instrumentation, err := middleware.NewTelemetry(tp.Tracer("test"), mp.Meter("test"), telemetry.Propagator())
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	router.Use(instrumentation.Middleware)
+	return router, spans, reader
+}
+
+func findMetric(t *testing.T, reader *sdkmetric.ManualReader, name string) metricdata.Metrics {
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m
+			}
+		}
+	}
+	t.Fatalf("metric %s not recorded", name)
+	return metricdata.Metrics{}
+}
+
+func TestTelemetryMiddlewareSpans(t *testing.T) {
+	router, spans, _ := newInstrumentedRouter(t)
+
+	var handlerSpan trace.SpanContext
+	router.HandleFunc("/cameras/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}).Methods("GET")
+
+	req := httptest.NewRequest(http.MethodGet, "/cameras/lane-1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Len(t, spans.GetSpans(), 1)
+	span := spans.GetSpans()[0]
+	assert.Equal(t, "GET /cameras/{id}", span.Name)
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID().String())
+	assert.Equal(t, span.SpanContext.SpanID(), handlerSpan.SpanID(), "handler sees the server span")
+	assert.Contains(t, span.Attributes, attribute.String("http.route", "/cameras/{id}"))
+	assert.Contains(t, span.Attributes, attribute.Int("http.response.status_code", http.StatusOK))
+}
+
+func TestTelemetryMiddlewareMetrics(t *testing.T) {
+	router, spans, reader := newInstrumentedRouter(t)
+
+	router.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+	router.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+
+	requests := findMetric(t, reader, "http.server.requests").Data.(metricdata.Sum[int64])
+	counts := map[string]int64{}
+	for _, dp := range requests.DataPoints {
+		route, _ := dp.Attributes.Value("http.route")
+		status, _ := dp.Attributes.Value("http.response.status_code")
+		counts[route.AsString()+" "+status.Emit()] = dp.Value
+	}
+	assert.Equal(t, map[string]int64{"/ok 200": 2, "/fail 500": 1}, counts)
+
+	duration := findMetric(t, reader, "http.server.request.duration").Data.(metricdata.Histogram[float64])
+	assert.Len(t, duration.DataPoints, 2)
+
+	inFlight := findMetric(t, reader, "http.server.active_requests").Data.(metricdata.Sum[int64])
+	for _, dp := range inFlight.DataPoints {
+		assert.Equal(t, int64(0), dp.Value, "no requests remain in flight")
+	}
+
+	// Server errors mark the span as failed
+	for _, span := range spans.GetSpans() {
+		if span.Name == "GET /fail" {
+			assert.Equal(t, "Error", span.Status.Code.String())
+		}
+	}
+}
+
+func TestRequestCounter(t *testing.T) {
+	counter := &middleware.RequestCounter{}
+	router := mux.NewRouter()
+	router.Use(counter.Middleware)
+	router.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+
+	for i := 0; i < 3; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	}
+	assert.Equal(t, uint64(3), counter.Count())
+}
+
+// throttledCounts sums http.server.throttled by reason
+func throttledCounts(t *testing.T, reader *sdkmetric.ManualReader) map[string]int64 {
+	counts := make(map[string]int64)
+	sum := findMetric(t, reader, "http.server.throttled").Data.(metricdata.Sum[int64])
+	for _, dp := range sum.DataPoints {
+		reason, _ := dp.Attributes.Value("reason")
+		counts[reason.AsString()] += dp.Value
+	}
+	return counts
+}
+
+func TestRateLimiterBuckets(t *testing.T) {
+	cfg := config.Default().Limits
+	cfg.RateLimit = 50
+	cfg.RateBurst = 2
+	limiter, err := middleware.NewRateLimiter(cfg, noop.NewMeterProvider().Meter("test"))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		allowed, _ := limiter.Allow("a")
+		assert.True(t, allowed, "burst request %d", i)
+	}
+	allowed, wait := limiter.Allow("a")
+	assert.False(t, allowed)
+	assert.InDelta(t, 20*time.Millisecond, wait, float64(5*time.Millisecond))
+
+	// Other clients have their own bucket
+	allowed, _ = limiter.Allow("b")
+	assert.True(t, allowed)
+
+	// The bucket refills at the configured rate
+	time.Sleep(wait + 5*time.Millisecond)
+	allowed, _ = limiter.Allow("a")
+	assert.True(t, allowed)
+	assert.Equal(t, 2, limiter.Clients())
+
+	cfg.RateLimit = 0
+	_, err = middleware.NewRateLimiter(cfg, noop.NewMeterProvider().Meter("test"))
+	assert.Error(t, err)
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	reader := sdkmetric.NewManualReader()
+	cfg := config.Default().Limits
+	cfg.RateLimit = 0.5
+	limiter, err := middleware.NewRateLimiter(cfg, sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test"))
+	require.NoError(t, err)
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Stand in for the auth middleware
+			if key := r.Header.Get(auth.APIKeyHeader); key != "" {
+				r = r.WithContext(auth.NewContext(r.Context(), &auth.Principal{Subject: key, Method: auth.MethodAPIKey}))
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	router.Use(limiter.Middleware)
+	router.HandleFunc("/v1/cameras", func(w http.ResponseWriter, r *http.Request) {})
+	router.HandleFunc("/health/ready", func(w http.ResponseWriter, r *http.Request) {})
+
+	serve := func(path, remote, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remote
+		if key != "" {
+			req.Header.Set(auth.APIKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/v1/cameras", "10.0.0.1:5000", "").Code)
+	rec := serve("/v1/cameras", "10.0.0.1:5001", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code, "same IP, different port")
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), `"error":"rate_limited"`)
+
+	// Authenticated callers are limited by identity, not address
+	assert.Equal(t, http.StatusOK, serve("/v1/cameras", "10.0.0.1:5002", "line-display").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("/v1/cameras", "10.0.0.2:5000", "line-display").Code)
+
+	// Probes are never limited
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, serve("/health/ready", "10.0.0.1:5000", "").Code)
+	}
+
+	assert.Equal(t, map[string]int64{"rate_limit": 2}, throttledCounts(t, reader))
+}
+
+func TestAuthFailureLimiter(t *testing.T) {
+	router := mux.NewRouter()
+	reader := sdkmetric.NewManualReader()
+	cfg := config.Default().Limits
+	cfg.AuthFailureBurst = 2
+	failures, err := middleware.NewAuthFailureLimiter(cfg, sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test"))
+	require.NoError(t, err)
+	router.Use(failures.Middleware)
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Stand in for the auth middleware
+			if r.Header.Get(auth.APIKeyHeader) != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	router.HandleFunc("/v1/cameras", func(w http.ResponseWriter, r *http.Request) {})
+
+	serve := func(remote, key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/cameras", nil)
+		req.RemoteAddr = remote
+		req.Header.Set(auth.APIKeyHeader, key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Successful requests are never counted
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, serve("10.0.0.1:5000", "secret"))
+	}
+	assert.Equal(t, http.StatusUnauthorized, serve("10.0.0.1:5000", "guess-1"))
+	assert.Equal(t, http.StatusUnauthorized, serve("10.0.0.1:5001", "guess-2"))
+
+	// Once the address has used up its failures, even the right key waits
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1:5002", "guess-3"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1:5003", "secret"))
+	assert.Equal(t, http.StatusOK, serve("10.0.0.2:5000", "secret"), "other addresses are unaffected")
+
+	assert.Equal(t, map[string]int64{"auth_failures": 2}, throttledCounts(t, reader))
+}
+
+func TestLoadShedderInFlight(t *testing.T) {
+	router := mux.NewRouter()
+	reader := sdkmetric.NewManualReader()
+	cfg := config.Default().Limits
+	cfg.MaxInFlight = 2
+	cfg.RetryAfter = 3 * time.Second
+	shedder, err := middleware.NewLoadShedder(cfg, nil, sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test"))
+	require.NoError(t, err)
+	router.Use(shedder.Middleware)
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+	router.HandleFunc("/health/live", func(w http.ResponseWriter, r *http.Request) {})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+		}()
+		<-entered
+	}
+	assert.Equal(t, int64(2), shedder.InFlight())
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "3", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "too many requests in flight")
+
+	// Probes are answered even when saturated
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(0), shedder.InFlight())
+	assert.Equal(t, map[string]int64{"in_flight": 1}, throttledCounts(t, reader))
+}
+
+func TestLoadShedderQueueDepth(t *testing.T) {
+	cfg := config.Default().Limits
+	cfg.MaxQueueDepth = 10
+	var depth atomic.Int64
+	shedder, err := middleware.NewLoadShedder(cfg, func() int { return int(depth.Load()) }, noop.NewMeterProvider().Meter("test"))
+	require.NoError(t, err)
+	h := shedder.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/cameras/1/frames", nil))
+		return rec.Code
+	}
+
+	depth.Store(9)
+	assert.Equal(t, http.StatusOK, serve())
+	depth.Store(10)
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+}
@@ -0,0 +1,228 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"image"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/frames"
+	"github.com/adron/golang-services-build-base/internal/plugin"
+)
+
+// TestPluginHelper is the plugin process for the host tests. It only runs
+// when started by a test with PLUGIN_HELPER set to the behaviour wanted.
+func TestPluginHelper(t *testing.T) {
+	mode := os.Getenv("PLUGIN_HELPER")
+	if mode == "" {
+		return
+	}
+
+	if mode == "old-version" {
+		m, _, err := plugin.ReadMessage(os.Stdin)
+		if err == nil {
+			plugin.WriteMessage(os.Stdout, &plugin.Message{Type: plugin.TypeHello, ID: m.ID, Version: 0}, nil)
+		}
+		os.Exit(0)
+	}
+	if mode == "mute" {
+		// Say hello, then never answer again
+		m, _, _ := plugin.ReadMessage(os.Stdin)
+		plugin.WriteMessage(os.Stdout, &plugin.Message{Type: plugin.TypeHello, ID: m.ID, Version: plugin.ProtocolVersion}, nil)
+		io.Copy(io.Discard, os.Stdin)
+		os.Exit(0)
+	}
+
+	err := plugin.Run(context.Background(), "test-plugin", func(ctx context.Context, f *frames.Frame) ([]frames.Detection, error) {
+		switch f.Camera {
+		case "crash":
+			os.Exit(3)
+		case "fail":
+			return nil, errors.New("model not loaded")
+		case "slow":
+			time.Sleep(time.Second)
+		case "busy":
+			time.Sleep(100 * time.Millisecond)
+		}
+		r, _, _, _ := f.Image.At(0, 0).RGBA()
+		b := f.Image.Bounds()
+		return []frames.Detection{{
+			Class:      f.Camera,
+			Box:        frames.Box{Width: float64(b.Dx()), Height: float64(b.Dy())},
+			Confidence: float64(r>>8) / 255,
+		}}, nil
+	})
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func startPlugin(t *testing.T, mode string, tweak func(*config.PluginConfig)) (*plugin.Host, *sdkmetric.ManualReader, error) {
+	t.Setenv("PLUGIN_HELPER", mode)
+	cfg := config.Default().Detector.Plugin
+	cfg.Command = os.Args[0]
+	cfg.Args = []string{"-test.run=^TestPluginHelper$"}
+	cfg.Timeout = 500 * time.Millisecond
+	if tweak != nil {
+		tweak(&cfg)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	h, err := plugin.Start(cfg, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	t.Cleanup(func() { h.Close() })
+	reader := sdkmetric.NewManualReader()
+	require.NoError(t, h.RegisterMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")))
+	return h, reader, nil
+}
+
+// pluginFrame is a 3x2 frame whose top-left pixel has the given red level
+func pluginFrame(camera string, red uint8) *frames.Frame {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	img.Pix[0], img.Pix[3] = red, 0xff
+	return &frames.Frame{Camera: camera, Sequence: 7, CapturedAt: time.Now(), Image: img}
+}
+
+// sumByAttr adds up an int64 counter's points by the value of one attribute
+func sumByAttr(t *testing.T, reader *sdkmetric.ManualReader, name, key string) map[string]int64 {
+	out := map[string]int64{}
+	for _, dp := range findMetric(t, reader, name).Data.(metricdata.Sum[int64]).DataPoints {
+		v, _ := dp.Attributes.Value(attribute.Key(key))
+		out[v.AsString()] += dp.Value
+	}
+	return out
+}
+
+func TestPluginHostDetect(t *testing.T) {
+	for _, transport := range []string{"stdio", "socket"} {
+		t.Run(transport, func(t *testing.T) {
+			h, reader, err := startPlugin(t, "detect", func(cfg *config.PluginConfig) { cfg.Transport = transport })
+			require.NoError(t, err)
+			assert.Equal(t, "test-plugin", h.Name())
+
+			detections, err := h.Detect(context.Background(), pluginFrame("lane-1", 255))
+			require.NoError(t, err)
+			require.Len(t, detections, 1)
+			assert.Equal(t, frames.Detection{Class: "lane-1", Box: frames.Box{Width: 3, Height: 2}, Confidence: 1}, detections[0])
+
+			hist := findMetric(t, reader, "plugin.request.duration").Data.(metricdata.Histogram[float64])
+			require.Len(t, hist.DataPoints, 1)
+			outcome, _ := hist.DataPoints[0].Attributes.Value("outcome")
+			assert.Equal(t, "ok", outcome.AsString())
+		})
+	}
+}
+
+func TestPluginHostErrors(t *testing.T) {
+	h, reader, err := startPlugin(t, "detect", nil)
+	require.NoError(t, err)
+
+	_, err = h.Detect(context.Background(), pluginFrame("fail", 0))
+	assert.EqualError(t, err, "detector plugin: model not loaded")
+	_, err = h.Detect(context.Background(), pluginFrame("slow", 0))
+	assert.ErrorContains(t, err, "did not answer within")
+
+	// The late answer to the slow frame does not confuse the next request
+	time.Sleep(600 * time.Millisecond)
+	detections, err := h.Detect(context.Background(), pluginFrame("lane-1", 0))
+	require.NoError(t, err)
+	assert.Equal(t, "lane-1", detections[0].Class)
+
+	assert.Equal(t, map[string]int64{"error": 1, "timeout": 1}, sumByAttr(t, reader, "plugin.errors", "kind"))
+}
+
+func TestPluginHostRestartsCrashedPlugin(t *testing.T) {
+	h, reader, err := startPlugin(t, "detect", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, h.Ready(context.Background()))
+	_, err = h.Detect(context.Background(), pluginFrame("crash", 0))
+	assert.Error(t, err)
+	_, err = h.Detect(context.Background(), pluginFrame("lane-1", 0))
+	assert.Error(t, err, "down until restarted")
+	assert.ErrorIs(t, h.Ready(context.Background()), plugin.ErrUnavailable)
+
+	require.Eventually(t, func() bool {
+		_, err := h.Detect(context.Background(), pluginFrame("lane-1", 0))
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.NoError(t, h.Ready(context.Background()))
+	assert.Equal(t, map[string]int64{"test-plugin": 1}, sumByAttr(t, reader, "plugin.restarts", "plugin"))
+	assert.Equal(t, int64(1), sumByAttr(t, reader, "plugin.errors", "kind")["crash"])
+}
+
+func TestPluginHostHealthCheck(t *testing.T) {
+	_, reader, err := startPlugin(t, "mute", func(cfg *config.PluginConfig) {
+		cfg.HealthInterval = 50 * time.Millisecond
+		cfg.Timeout = 50 * time.Millisecond
+	})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		var rm metricdata.ResourceMetrics
+		reader.Collect(context.Background(), &rm)
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				if m.Name == "plugin.errors" {
+					return true
+				}
+			}
+		}
+		return false
+	}, 2*time.Second, 20*time.Millisecond)
+	assert.Equal(t, int64(1), sumByAttr(t, reader, "plugin.errors", "kind")["health"])
+}
+
+func TestPluginHostHealthCheckWhileBusy(t *testing.T) {
+	h, _, err := startPlugin(t, "detect", func(cfg *config.PluginConfig) {
+		cfg.HealthInterval = 100 * time.Millisecond
+		cfg.Timeout = 300 * time.Millisecond
+	})
+	require.NoError(t, err)
+
+	// Four callers keep the plugin busy, so a ping waits behind up to four
+	// 100ms frames, longer than the timeout. The plugin must not be
+	// restarted while it keeps answering.
+	var wg sync.WaitGroup
+	var unavailable atomic.Int64
+	deadline := time.Now().Add(time.Second)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				if _, err := h.Detect(context.Background(), pluginFrame("busy", 0)); errors.Is(err, plugin.ErrUnavailable) {
+					unavailable.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Zero(t, unavailable.Load())
+	assert.NoError(t, h.Ready(context.Background()))
+}
+
+func TestPluginHostStartFailures(t *testing.T) {
+	_, _, err := startPlugin(t, "old-version", nil)
+	assert.ErrorContains(t, err, "protocol version 0")
+
+	_, _, err = startPlugin(t, "detect", func(cfg *config.PluginConfig) { cfg.Command = "/nonexistent/detector" })
+	assert.Error(t, err)
+}
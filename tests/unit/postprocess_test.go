@@ -0,0 +1,156 @@
+package unit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/frames"
+	"github.com/adron/golang-services-build-base/internal/postprocess"
+)
+
+func det(class string, confidence, x, y, w, h float64) frames.Detection {
+	return frames.Detection{Class: class, Confidence: confidence, Box: frames.Box{X: x, Y: y, Width: w, Height: h}}
+}
+
+func classes(detections []frames.Detection) []string {
+	var out []string
+	for _, d := range detections {
+		out = append(out, d.Class)
+	}
+	return out
+}
+
+func TestBoxIoU(t *testing.T) {
+	a := frames.Box{Width: 2, Height: 2}
+	assert.Equal(t, 1.0, a.IoU(a))
+	assert.InDelta(t, 1.0/3, a.IoU(frames.Box{X: 1, Width: 2, Height: 2}), 1e-9)
+	assert.Equal(t, 0.0, a.IoU(frames.Box{X: 2, Width: 2, Height: 2}), "touching edges")
+	assert.Equal(t, 0.0, a.IoU(frames.Box{}))
+}
+
+func TestNMS(t *testing.T) {
+	input := func() []frames.Detection {
+		return []frames.Detection{
+			det("car", 0.6, 1, 0, 10, 10),
+			det("person", 0.9, 0, 0, 10, 10),
+			det("person", 0.7, 1, 1, 10, 10),
+			det("person", 0.8, 50, 50, 10, 10),
+		}
+	}
+
+	// The car overlaps the best person but is another class
+	kept := postprocess.NMS(input(), 0.5, false)
+	assert.Equal(t, []string{"person", "person", "car"}, classes(kept))
+	assert.Equal(t, []float64{0.9, 0.8, 0.6}, []float64{kept[0].Confidence, kept[1].Confidence, kept[2].Confidence})
+
+	kept = postprocess.NMS(input(), 0.5, true)
+	assert.Equal(t, []string{"person", "person"}, classes(kept))
+}
+
+func TestSoftNMS(t *testing.T) {
+	// IoU of 1/3
+	input := func() []frames.Detection {
+		return []frames.Detection{
+			det("person", 0.8, 5, 0, 10, 10),
+			det("person", 0.9, 0, 0, 10, 10),
+		}
+	}
+	decayed := 0.8 * math.Exp(-(1.0/9)/0.5)
+
+	kept := postprocess.SoftNMS(input(), 0.5, false, func(string) float64 { return 0.5 })
+	require.Len(t, kept, 2)
+	assert.Equal(t, 0.9, kept[0].Confidence)
+	assert.InDelta(t, decayed, kept[1].Confidence, 1e-9)
+
+	kept = postprocess.SoftNMS(input(), 0.5, false, func(string) float64 { return 0.7 })
+	assert.Len(t, kept, 1, "decayed below the threshold")
+}
+
+func TestFilterApply(t *testing.T) {
+	cfg := config.Default().Detector.Postprocess
+	cfg.MinConfidence = 0.3
+	cfg.ClassThresholds = map[string]float64{"person": 0.6}
+	cfg.MaxDetections = 2
+	f := postprocess.New(cfg)
+	reader := sdkmetric.NewManualReader()
+	require.NoError(t, f.RegisterMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")))
+
+	input := []frames.Detection{
+		det("person", 0.5, 0, 0, 10, 10),   // below the person threshold
+		det("car", 0.4, 100, 0, 10, 10),    // kept by the default threshold
+		det("car", 0.2, 200, 0, 10, 10),    // below the default threshold
+		det("person", 0.9, 0, 100, 10, 10), // kept
+		det("person", 0.8, 1, 101, 10, 10), // suppressed by the 0.9 person
+		det("bike", 0.35, 300, 0, 10, 10),  // over the cap
+	}
+	kept := f.Apply(context.Background(), "lane-1", input)
+	assert.Equal(t, []string{"person", "car"}, classes(kept))
+	assert.Equal(t, "person", input[0].Class, "input is left alone")
+
+	assert.Equal(t, map[string]int64{"confidence": 2, "nms": 1, "max_detections": 1}, sumByAttr(t, reader, "detections.dropped", "stage"))
+	assert.Equal(t, map[string]int64{"lane-1": 4}, sumByAttr(t, reader, "detections.dropped", "camera.id"))
+}
+
+func TestFilterBoundsCameraLabels(t *testing.T) {
+	cfg := config.Default().Detector.Postprocess
+	cfg.MinConfidence = 0.5
+	f := postprocess.New(cfg)
+	reader := sdkmetric.NewManualReader()
+	require.NoError(t, f.RegisterMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")))
+
+	for i := 0; i < 1100; i++ {
+		f.Apply(context.Background(), fmt.Sprintf("cam-%d", i), []frames.Detection{det("car", 0.1, 0, 0, 10, 10)})
+	}
+	f.Apply(context.Background(), "cam-0", []frames.Detection{det("car", 0.1, 0, 0, 10, 10)})
+
+	byCamera := sumByAttr(t, reader, "detections.dropped", "camera.id")
+	assert.Len(t, byCamera, 1025, "1024 cameras and the rest")
+	assert.Equal(t, int64(76), byCamera[postprocess.OtherCameras])
+	assert.Equal(t, int64(2), byCamera["cam-0"], "cameras already labelled keep their label")
+}
+
+func TestFilterCameraThresholds(t *testing.T) {
+	cfg := config.Default().Detector.Postprocess
+	cfg.MinConfidence = 0.3
+	cfg.ClassThresholds = map[string]float64{"person": 0.6}
+	dim := 0.1
+	cfg.Cameras = map[string]config.CameraThresholds{
+		"dock-1": {MinConfidence: &dim, ClassThresholds: map[string]float64{"person": 0.4}},
+		"lane-2": {ClassThresholds: map[string]float64{"car": 0.8}},
+	}
+	f := postprocess.New(cfg)
+
+	assert.Equal(t, 0.4, f.Threshold("dock-1", "person"), "camera class threshold")
+	assert.Equal(t, 0.1, f.Threshold("dock-1", "car"), "camera minimum")
+	assert.Equal(t, 0.6, f.Threshold("lane-2", "person"), "global class threshold")
+	assert.Equal(t, 0.8, f.Threshold("lane-2", "car"))
+	assert.Equal(t, 0.3, f.Threshold("lane-2", "bike"), "global minimum")
+	assert.Equal(t, 0.6, f.Threshold("lane-1", "person"), "cameras without overrides")
+
+	input := []frames.Detection{
+		det("person", 0.5, 0, 0, 10, 10),
+		det("car", 0.2, 100, 0, 10, 10),
+	}
+	assert.Equal(t, []string{"person", "car"}, classes(f.Apply(context.Background(), "dock-1", input)))
+	assert.Empty(t, f.Apply(context.Background(), "lane-2", input))
+}
+
+func TestFilterWithoutNMS(t *testing.T) {
+	cfg := config.Default().Detector.Postprocess
+	cfg.NMS = postprocess.NMSNone
+	f := postprocess.New(cfg)
+
+	kept := f.Apply(context.Background(), "lane-1", []frames.Detection{
+		det("person", 0.5, 0, 0, 10, 10),
+		det("person", 0.9, 0, 0, 10, 10),
+	})
+	assert.Equal(t, []float64{0.9, 0.5}, []float64{kept[0].Confidence, kept[1].Confidence})
+	assert.NotNil(t, f.Apply(context.Background(), "lane-1", nil))
+}
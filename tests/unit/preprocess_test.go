@@ -0,0 +1,154 @@
+package unit
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/frames"
+	"github.com/adron/golang-services-build-base/internal/preprocess"
+)
+
+func uniform(w, h int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func TestResizeBilinear(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.Black)
+	src.Set(1, 0, color.White)
+
+	dst, transform := preprocess.Resize(src, 4, 1)
+	var reds []uint8
+	for x := 0; x < 4; x++ {
+		reds = append(reds, dst.RGBAAt(x, 0).R)
+	}
+	assert.Equal(t, []uint8{0, 64, 191, 255}, reds)
+	assert.Equal(t, 0.5, transform.ScaleX)
+}
+
+func TestLetterbox(t *testing.T) {
+	src := uniform(200, 100, color.Gray{Y: 200})
+	dst, transform := preprocess.Letterbox(src, 100, 100, color.Gray{Y: 114})
+
+	assert.Equal(t, image.Rect(0, 0, 100, 100), dst.Bounds())
+	assert.Equal(t, uint8(114), dst.RGBAAt(50, 10).R, "top border")
+	assert.Equal(t, uint8(200), dst.RGBAAt(50, 50).R)
+	assert.Equal(t, uint8(114), dst.RGBAAt(50, 80).R, "bottom border")
+
+	// Boxes map back to the frame, losing any part over the borders
+	assert.Equal(t, frames.Box{X: 20, Y: 0, Width: 100, Height: 100}, transform.Box(frames.Box{X: 10, Y: 25, Width: 50, Height: 50}))
+	assert.Equal(t, frames.Box{X: 0, Y: 0, Width: 200, Height: 100}, transform.Box(frames.Box{X: 0, Y: 0, Width: 100, Height: 100}))
+
+	// A box only over the padding comes back empty
+	assert.Zero(t, transform.Box(frames.Box{X: 10, Y: 0, Width: 50, Height: 20}).Area())
+
+	// Points are relative to the top-left of a cropped frame, as the
+	// background detector's boxes are
+	crop := src.SubImage(image.Rect(50, 0, 150, 100))
+	_, transform = preprocess.Letterbox(crop, 50, 50, color.Black)
+	x, y := transform.Point(10, 20)
+	assert.Equal(t, []float64{20, 40}, []float64{x, y})
+}
+
+func TestPreprocessorTensor(t *testing.T) {
+	// Red, green / blue, white
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	src.Set(0, 0, color.RGBA{R: 255, A: 255})
+	src.Set(1, 0, color.RGBA{G: 255, A: 255})
+	src.Set(0, 1, color.RGBA{B: 255, A: 255})
+	src.Set(1, 1, color.White)
+
+	tests := []struct {
+		name  string
+		cfg   config.PreprocessConfig
+		shape []int
+		want  []float32
+	}{
+		{
+			name:  "nchw",
+			cfg:   config.PreprocessConfig{ChannelOrder: "rgb", Layout: "nchw"},
+			shape: []int{1, 3, 2, 2},
+			want:  []float32{1, 0, 0, 1, 0, 1, 0, 1, 0, 0, 1, 1},
+		},
+		{
+			name:  "nhwc",
+			cfg:   config.PreprocessConfig{ChannelOrder: "rgb", Layout: "nhwc"},
+			shape: []int{1, 2, 2, 3},
+			want:  []float32{1, 0, 0, 0, 1, 0, 0, 0, 1, 1, 1, 1},
+		},
+		{
+			name:  "bgr",
+			cfg:   config.PreprocessConfig{ChannelOrder: "bgr", Layout: "nchw"},
+			shape: []int{1, 3, 2, 2},
+			want:  []float32{0, 0, 1, 1, 0, 1, 0, 1, 1, 0, 0, 1},
+		},
+		{
+			name:  "normalized",
+			cfg:   config.PreprocessConfig{ChannelOrder: "rgb", Layout: "nchw", Mean: []float64{0.5, 0.5, 0.5}, Std: []float64{0.5, 0.5, 0.5}},
+			shape: []int{1, 3, 2, 2},
+			want:  []float32{1, -1, -1, 1, -1, 1, -1, 1, -1, -1, 1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := preprocess.New(2, 2, tt.cfg)
+			require.Equal(t, 12, p.Size())
+			assert.Equal(t, tt.shape, p.Shape(1))
+			dst := make([]float32, p.Size())
+			p.Tensor(src, dst)
+			assert.InDeltaSlice(t, tt.want, dst, 1e-6)
+		})
+	}
+}
+
+func TestPreprocessorLetterbox(t *testing.T) {
+	p := preprocess.New(4, 4, config.PreprocessConfig{Letterbox: true, PadValue: 0, ChannelOrder: "rgb", Layout: "nchw"})
+	dst := make([]float32, p.Size())
+	transform := p.Tensor(uniform(4, 2, color.White), dst)
+
+	// Rows 0 and 3 of each channel are padding
+	plane := []float32{0, 0, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0}
+	assert.Equal(t, append(append(append([]float32{}, plane...), plane...), plane...), dst)
+	assert.Equal(t, 1.0, transform.PadY)
+	assert.Equal(t, frames.Box{X: 1, Y: 0, Width: 2, Height: 1}, transform.Box(frames.Box{X: 1, Y: 1, Width: 2, Height: 1}))
+}
+
+func TestFromNV12(t *testing.T) {
+	// Two 2x2 blocks: white over black with neutral chroma, then red
+	data := []byte{
+		235, 235, 81, 81,
+		16, 16, 81, 81,
+		128, 128, 90, 240,
+	}
+	img, err := preprocess.FromNV12(data, 4, 2)
+	require.NoError(t, err)
+	assert.Equal(t, color.RGBA{255, 255, 255, 255}, img.RGBAAt(1, 0))
+	assert.Equal(t, color.RGBA{0, 0, 0, 255}, img.RGBAAt(0, 1))
+	assert.Equal(t, color.RGBA{255, 0, 0, 255}, img.RGBAAt(2, 0))
+	assert.Equal(t, color.RGBA{255, 0, 0, 255}, img.RGBAAt(3, 1))
+
+	_, err = preprocess.FromNV12(data[:10], 4, 2)
+	assert.ErrorContains(t, err, "needs 12 bytes")
+	_, err = preprocess.FromNV12(make([]byte, 9), 3, 2)
+	assert.ErrorContains(t, err, "even dimensions")
+}
+
+func TestFromPacked(t *testing.T) {
+	img, err := preprocess.FromPacked([]byte{0, 0, 255, 255, 0, 0}, 2, 1, preprocess.BGR)
+	require.NoError(t, err)
+	assert.Equal(t, color.RGBA{255, 0, 0, 255}, img.RGBAAt(0, 0))
+	assert.Equal(t, color.RGBA{0, 0, 255, 255}, img.RGBAAt(1, 0))
+
+	_, err = preprocess.FromPacked([]byte{1, 2, 3}, 2, 1, preprocess.RGB)
+	assert.Error(t, err)
+	_, err = preprocess.FromPacked([]byte{1, 2, 3}, 1, 1, "yuv")
+	assert.ErrorContains(t, err, "unknown channel order")
+}
@@ -0,0 +1,152 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/reload"
+)
+
+func newTestReloader(load reload.LoadFunc) *reload.Reloader {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return reload.New(config.Default(), load, logger)
+}
+
+func TestReloadAppliesLiveFields(t *testing.T) {
+	next := config.Default()
+	next.LogLevel = "debug"
+	next.Port = 9090
+
+	r := newTestReloader(func() (*config.Config, error) { return next, nil })
+
+	var applied string
+	r.Handle("log_level", func(old, new *config.Config) error {
+		applied = new.LogLevel
+		return nil
+	})
+
+	result := r.Reload("test")
+	assert.True(t, result.Success)
+	assert.Equal(t, "debug", applied)
+	assert.Equal(t, []string{"log_level"}, result.Applied)
+	assert.Equal(t, []reload.Rejection{{Field: "port", Reason: "requires restart"}}, result.Rejected)
+
+	current := r.Current()
+	assert.Equal(t, "debug", current.LogLevel)
+	assert.Equal(t, 8080, current.Port, "rejected fields keep their old value")
+}
+
+func TestReloadRejectsFailedApply(t *testing.T) {
+	next := config.Default()
+	next.LogLevel = "debug"
+
+	r := newTestReloader(func() (*config.Config, error) { return next, nil })
+	r.Handle("log_level", func(old, new *config.Config) error {
+		return errors.New("logger unavailable")
+	})
+
+	result := r.Reload("test")
+	assert.True(t, result.Success)
+	assert.Empty(t, result.Applied)
+	assert.Equal(t, []reload.Rejection{{Field: "log_level", Reason: "logger unavailable"}}, result.Rejected)
+	assert.Equal(t, "info", r.Current().LogLevel)
+}
+
+func TestReloadUpdate(t *testing.T) {
+	r := newTestReloader(func() (*config.Config, error) { return config.Default(), nil })
+	var level string
+	r.Handle("log_level", func(old, new *config.Config) error {
+		level = new.LogLevel
+		return nil
+	})
+
+	result := r.Update("api", func(c *config.Config) { c.LogLevel = "debug" })
+	assert.True(t, result.Success, result.Error)
+	assert.Equal(t, []string{"log_level"}, result.Applied)
+	assert.Equal(t, "debug", r.Current().LogLevel)
+	_, ok := r.Last()
+	assert.False(t, ok, "updates are not recorded as reloads")
+
+	result = r.Update("api", func(c *config.Config) { c.LogLevel = "loud" })
+	assert.False(t, result.Success)
+	assert.Equal(t, "debug", r.Current().LogLevel)
+
+	// The next reload compares against the updated level
+	result = r.Reload("signal")
+	assert.Equal(t, []string{"log_level"}, result.Applied)
+	assert.Equal(t, "info", level)
+}
+
+func TestReloadLoadError(t *testing.T) {
+	r := newTestReloader(func() (*config.Config, error) {
+		return nil, errors.New("invalid configuration")
+	})
+
+	result := r.Reload("test")
+	assert.False(t, result.Success)
+	assert.Equal(t, "invalid configuration", result.Error)
+	assert.Equal(t, config.Default(), r.Current())
+}
+
+func TestReloadHandler(t *testing.T) {
+	r := newTestReloader(func() (*config.Config, error) { return config.Default(), nil })
+
+	// No reload attempted yet
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/reload", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Trigger a reload
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Last result is reported
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/reload", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var result reload.Result
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, "api", result.Trigger)
+	assert.True(t, result.Success)
+}
+
+func TestReloadWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "site.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("log_level: info\n"), 0o600))
+
+	r := newTestReloader(func() (*config.Config, error) {
+		return config.LoadConfig(config.WithFile(path))
+	})
+	r.Handle("log_level", func(old, new *config.Config) error { return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.WatchFile(ctx, path, 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, os.WriteFile(path, []byte("log_level: debug\n"), 0o600))
+
+	assert.Eventually(t, func() bool {
+		return r.Current().LogLevel == "debug"
+	}, 2*time.Second, 10*time.Millisecond)
+
+	result, ok := r.Last()
+	require.True(t, ok)
+	assert.Equal(t, "file", result.Trigger)
+}
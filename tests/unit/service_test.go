@@ -0,0 +1,184 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/adron/golang-services-build-base/internal/service"
+)
+
+func newTestService(opts ...service.Option) *service.Service {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := func() http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+	return service.New("127.0.0.1:0", handler, logger, opts...)
+}
+
+func TestServiceLifecycle(t *testing.T) {
+	svc := newTestService()
+
+	var mu sync.Mutex
+	var seen []string
+	svc.OnTransition(func(from, to service.State) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, from.String()+"->"+to.String())
+	})
+
+	assert.Equal(t, service.Stopped, svc.State())
+	require.NoError(t, svc.Start(context.Background()))
+	assert.Equal(t, service.Running, svc.State())
+
+	resp, err := http.Get("http://" + svc.Status().Addr)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Error(t, svc.Start(context.Background()), "starting twice should fail")
+
+	require.NoError(t, svc.Stop(context.Background()))
+	assert.Equal(t, service.Stopped, svc.State())
+	assert.ErrorIs(t, svc.Stop(context.Background()), service.ErrNotRunning)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"stopped->starting",
+		"starting->running",
+		"running->draining",
+		"draining->stopped",
+	}, seen)
+}
+
+func TestServiceListenErrorReturned(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	svc := service.New(busy.Addr().String(), func() http.Handler { return http.NotFoundHandler() }, logger)
+
+	err = svc.Start(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, service.Failed, svc.State())
+	assert.Contains(t, svc.Status().LastError, "failed to listen")
+}
+
+func TestServiceRestart(t *testing.T) {
+	svc := newTestService()
+
+	// Restarting a stopped service starts it
+	require.NoError(t, svc.Restart(context.Background()))
+	assert.Equal(t, service.Running, svc.State())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, svc.Restart(context.Background()))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, service.Running, svc.State())
+	resp, err := http.Get("http://" + svc.Status().Addr)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.NoError(t, svc.Stop(context.Background()))
+}
+
+func TestServiceServeErrorReported(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	svc := newTestService(service.WithListener(func(addr string) (net.Listener, error) {
+		return listener, nil
+	}))
+	require.NoError(t, svc.Start(context.Background()))
+
+	// Closing the listener underneath the server makes Serve fail
+	listener.Close()
+
+	select {
+	case err := <-svc.Errors():
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("serve error not reported")
+	}
+	assert.Equal(t, service.Failed, svc.State())
+
+	// A failed service can be started again
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, svc.Start(context.Background()))
+	assert.NoError(t, svc.Stop(context.Background()))
+}
+
+func TestServiceMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer mp.Shutdown(context.Background())
+
+	svc := newTestService()
+	require.NoError(t, svc.RegisterMetrics(mp.Meter("test")))
+	require.NoError(t, svc.Start(context.Background()))
+	defer svc.Stop(context.Background())
+
+	gauge := findMetric(t, reader, "service.state").Data.(metricdata.Gauge[int64])
+	states := map[string]int64{}
+	for _, dp := range gauge.DataPoints {
+		state, _ := dp.Attributes.Value("state")
+		states[state.AsString()] = dp.Value
+	}
+	assert.Equal(t, int64(1), states["running"])
+	assert.Equal(t, int64(0), states["stopped"])
+	assert.Len(t, states, 5)
+
+	transitions := findMetric(t, reader, "service.state.transitions").Data.(metricdata.Sum[int64])
+	assert.Len(t, transitions.DataPoints, 2)
+}
+
+func TestServiceAdminHandlers(t *testing.T) {
+	svc := newTestService()
+	require.NoError(t, svc.Start(context.Background()))
+	defer svc.Stop(context.Background())
+
+	w := httptest.NewRecorder()
+	svc.StatusHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/service", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var status map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, "running", status["state"])
+	assert.NotEmpty(t, status["addr"])
+
+	started := svc.Status().Since
+	w = httptest.NewRecorder()
+	svc.RestartHandler(time.Second).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/service/restart", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	assert.Eventually(t, func() bool {
+		status := svc.Status()
+		return status.State == service.Running && status.Since.After(started)
+	}, 2*time.Second, 10*time.Millisecond)
+}
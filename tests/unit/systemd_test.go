@@ -0,0 +1,197 @@
+//go:build linux
+
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/adron/golang-services-build-base/internal/systemd"
+)
+
+// fakeNotifySocket listens where the service manager would and returns
+// the messages it receives
+func fakeNotifySocket(t *testing.T) <-chan string {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	messages := make(chan string, 16)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+	return messages
+}
+
+func receive(t *testing.T, messages <-chan string) string {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no notification received")
+		return ""
+	}
+}
+
+func TestNotifierSendsStates(t *testing.T) {
+	messages := fakeNotifySocket(t)
+
+	n := systemd.NewNotifier()
+	require.NotNil(t, n)
+
+	require.NoError(t, n.Notify(systemd.Ready, systemd.Status("Serving on :8080")))
+	assert.Equal(t, "READY=1\nSTATUS=Serving on :8080", receive(t, messages))
+
+	require.NoError(t, n.Notify(systemd.Stopping))
+	assert.Equal(t, "STOPPING=1", receive(t, messages))
+}
+
+func TestNotifierDisabledOutsideSystemd(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	n := systemd.NewNotifier()
+	assert.Nil(t, n)
+	assert.NoError(t, n.Notify(systemd.Ready))
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	assert.Zero(t, systemd.WatchdogInterval())
+
+	t.Setenv("WATCHDOG_USEC", "3000000")
+	t.Setenv("WATCHDOG_PID", fmt.Sprint(os.Getpid()))
+	assert.Equal(t, 3*time.Second, systemd.WatchdogInterval())
+
+	t.Setenv("WATCHDOG_PID", "1")
+	assert.Zero(t, systemd.WatchdogInterval(), "watchdog meant for another process")
+}
+
+func TestWatchdogPausesWhileUnhealthy(t *testing.T) {
+	messages := fakeNotifySocket(t)
+	n := systemd.NewNotifier()
+
+	var healthy atomic.Bool
+	healthy.Store(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.RunWatchdog(ctx, 40*time.Millisecond, func(ctx context.Context) error {
+		if !healthy.Load() {
+			return errors.New("model not loaded")
+		}
+		return nil
+	})
+
+	assert.Equal(t, "WATCHDOG=1", receive(t, messages))
+
+	healthy.Store(false)
+	msg := receive(t, messages)
+	for msg == "WATCHDOG=1" {
+		msg = receive(t, messages)
+	}
+	assert.Equal(t, "STATUS=Watchdog paused: model not loaded", msg)
+
+	// No pings while unhealthy
+	select {
+	case msg := <-messages:
+		if strings.Contains(msg, "WATCHDOG=1") {
+			t.Fatalf("watchdog pinged while unhealthy: %q", msg)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	healthy.Store(true)
+	assert.Equal(t, "WATCHDOG=1\nSTATUS=Healthy", receive(t, messages))
+}
+
+// TestListenFilesHelper runs in a child process started with a socket on
+// fd 3, the way systemd socket activation starts the service.
+func TestListenFilesHelper(t *testing.T) {
+	if os.Getenv("SYSTEMD_LISTEN_HELPER") != "1" {
+		t.Skip("helper process for TestListenFiles")
+	}
+
+	files := systemd.ListenFiles()
+	if len(files) != 1 {
+		fmt.Fprintf(os.Stderr, "expected 1 socket, got %d\n", len(files))
+		os.Exit(2)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		fmt.Fprintln(os.Stderr, "LISTEN_FDS was not cleared")
+		os.Exit(2)
+	}
+
+	// Each listener duplicates the socket, so closing one keeps it open
+	for _, reply := range []string{files[0].Name(), "again"} {
+		l, err := net.FileListener(files[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		conn, err := l.Accept()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		conn.Write([]byte(reply))
+		conn.Close()
+		l.Close()
+	}
+	os.Exit(0)
+}
+
+func TestListenFiles(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer f.Close()
+
+	// LISTEN_PID must match the child, so set it from a shell that execs the test binary
+	cmd := exec.Command("/bin/sh", "-c", `LISTEN_PID=$$ exec "$0" -test.run=^TestListenFilesHelper$`, os.Args[0])
+	cmd.Env = append(os.Environ(), "SYSTEMD_LISTEN_HELPER=1", "LISTEN_FDS=1", "LISTEN_FDNAMES=http")
+	cmd.ExtraFiles = []*os.File{f}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	require.NoError(t, cmd.Start())
+
+	for _, want := range []string{"http", "again"} {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		got, err := io.ReadAll(conn)
+		conn.Close()
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	}
+
+	require.NoError(t, cmd.Wait(), stderr.String())
+}
+
+func TestListenFilesWithoutActivation(t *testing.T) {
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+	assert.Nil(t, systemd.ListenFiles())
+}
@@ -0,0 +1,252 @@
+package unit
+
+import (
+	"context"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/telemetry"
+)
+
+func exportTestSpan(t *testing.T, exporter sdktrace.SpanExporter) {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	_, span := tp.Tracer("test").Start(context.Background(), "test-span")
+	span.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, tp.Shutdown(ctx))
+}
+
+func TestTraceExporterHTTPWithTLS(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		path     string
+		header   http.Header
+		requests int
+	)
+	collector := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		path = r.URL.Path
+		header = r.Header.Clone()
+		requests++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	// Trust the test collector's certificate through a CA bundle file
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: collector.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, caPEM, 0o600))
+
+	cfg := config.Default()
+	cfg.OtelEndpoint = collector.URL + "/otlp"
+	cfg.OtelHeaders = map[string]string{"Api-Key": "secret"}
+	cfg.OtelCACert = caFile
+	cfg.OtelCompression = "gzip"
+
+	exporter, err := telemetry.NewTraceExporter(context.Background(), cfg)
+	require.NoError(t, err)
+	exportTestSpan(t, exporter)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, "/otlp/v1/traces", path)
+	assert.Equal(t, "secret", header.Get("Api-Key"))
+	assert.Equal(t, "gzip", header.Get("Content-Encoding"))
+}
+
+type fakeTraceService struct {
+	coltracepb.UnimplementedTraceServiceServer
+	mu       sync.Mutex
+	metadata metadata.MD
+}
+
+func (s *fakeTraceService) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metadata, _ = metadata.FromIncomingContext(ctx)
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func TestTraceExporterGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	service := &fakeTraceService{}
+	server := grpc.NewServer()
+	coltracepb.RegisterTraceServiceServer(server, service)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	cfg := config.Default()
+	cfg.OtelEndpoint = "http://" + listener.Addr().String()
+	cfg.OtelProtocol = "grpc"
+	cfg.OtelHeaders = map[string]string{"api-key": "secret"}
+
+	exporter, err := telemetry.NewTraceExporter(context.Background(), cfg)
+	require.NoError(t, err)
+	exportTestSpan(t, exporter)
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	assert.Equal(t, []string{"secret"}, service.metadata.Get("api-key"))
+}
+
+func TestExporterInvalidSettings(t *testing.T) {
+	cfg := config.Default()
+	cfg.OtelEndpoint = "localhost:4318"
+	_, err := telemetry.NewTraceExporter(context.Background(), cfg)
+	assert.Error(t, err)
+
+	cfg = config.Default()
+	cfg.OtelEndpoint = "https://collector.example.com:4318"
+	cfg.OtelCACert = filepath.Join(t.TempDir(), "missing.pem")
+	_, err = telemetry.NewMetricExporter(context.Background(), cfg)
+	assert.Error(t, err)
+}
+
+func TestProviderLifecycle(t *testing.T) {
+	globalTracerProvider := otel.GetTracerProvider()
+
+	spans := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	provider := telemetry.New(config.Default(),
+		telemetry.WithSpanExporter(spans),
+		telemetry.WithMetricReader(reader),
+	)
+
+	// Before Start the provider hands out no-op instruments
+	_, span := provider.Tracer().Start(context.Background(), "before-start")
+	span.End()
+	assert.False(t, span.SpanContext().IsValid())
+
+	ctx := context.Background()
+	require.NoError(t, provider.Start(ctx))
+	assert.Error(t, provider.Start(ctx), "starting twice should fail")
+
+	_, span = provider.Tracer().Start(ctx, "test-span")
+	span.End()
+	counter, err := provider.Meter().Int64Counter("test_count")
+	require.NoError(t, err)
+	counter.Add(ctx, 3)
+
+	// Spans are batched until flushed
+	require.NoError(t, provider.ForceFlush(ctx))
+	require.Len(t, spans.GetSpans(), 1)
+	assert.Equal(t, "test-span", spans.GetSpans()[0].Name)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(ctx, &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	assert.Equal(t, "test_count", rm.ScopeMetrics[0].Metrics[0].Name)
+
+	require.NoError(t, provider.Shutdown(ctx))
+	require.NoError(t, provider.Shutdown(ctx), "shutdown should be idempotent")
+
+	// The provider was not registered globally
+	assert.Equal(t, globalTracerProvider, otel.GetTracerProvider())
+}
+
+func TestProviderSampleRatio(t *testing.T) {
+	cfg := config.Default()
+	cfg.Traces.SampleRatio = 0
+	spans := tracetest.NewInMemoryExporter()
+	provider := telemetry.New(cfg,
+		telemetry.WithSpanExporter(spans),
+		telemetry.WithMetricReader(sdkmetric.NewManualReader()),
+	)
+	ctx := context.Background()
+	require.NoError(t, provider.Start(ctx))
+	defer provider.Shutdown(ctx)
+
+	_, span := provider.Tracer().Start(ctx, "unsampled")
+	span.End()
+	assert.False(t, span.SpanContext().IsSampled())
+
+	// The ratio changes without restarting the provider
+	provider.SetSampleRatio(1)
+	_, span = provider.Tracer().Start(ctx, "sampled")
+	span.End()
+	assert.True(t, span.SpanContext().IsSampled())
+
+	require.NoError(t, provider.ForceFlush(ctx))
+	require.Len(t, spans.GetSpans(), 1)
+	assert.Equal(t, "sampled", spans.GetSpans()[0].Name)
+}
+
+func TestProviderPrometheusEndpoint(t *testing.T) {
+	cfg := config.Default()
+	cfg.Metrics.OTLPEnabled = false
+	cfg.Metrics.PrometheusEnabled = true
+	cfg.Metrics.PrometheusPrefix = "site_42"
+
+	provider := telemetry.New(cfg, telemetry.WithSpanExporter(tracetest.NewInMemoryExporter()))
+	assert.Nil(t, provider.MetricsHandler(), "no handler before Start")
+
+	ctx := context.Background()
+	require.NoError(t, provider.Start(ctx))
+	defer provider.Shutdown(ctx)
+
+	counter, err := provider.Meter().Int64Counter("frames_processed")
+	require.NoError(t, err)
+	counter.Add(ctx, 5)
+
+	handler := provider.MetricsHandler()
+	require.NotNil(t, handler)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `site_42_frames_processed_total{otel_scope_name="vision-service",otel_scope_version=""} 5`)
+}
+
+func TestProviderPrometheusDisabled(t *testing.T) {
+	cfg := config.Default()
+	cfg.Metrics.OTLPEnabled = false
+
+	provider := telemetry.New(cfg, telemetry.WithSpanExporter(tracetest.NewInMemoryExporter()))
+	require.NoError(t, provider.Start(context.Background()))
+	defer provider.Shutdown(context.Background())
+
+	assert.Nil(t, provider.MetricsHandler())
+}
+
+func TestProviderLogExport(t *testing.T) {
+	cfg := config.Default()
+	cfg.Metrics.OTLPEnabled = false
+
+	disabled := telemetry.New(cfg, telemetry.WithSpanExporter(tracetest.NewInMemoryExporter()))
+	require.NoError(t, disabled.Start(context.Background()))
+	defer disabled.Shutdown(context.Background())
+	assert.Nil(t, disabled.LoggerProvider(), "log export is off by default")
+
+	enabled := telemetry.New(cfg,
+		telemetry.WithSpanExporter(tracetest.NewInMemoryExporter()),
+		telemetry.WithLogExporter(&recordingLogExporter{}),
+	)
+	require.NoError(t, enabled.Start(context.Background()))
+	defer enabled.Shutdown(context.Background())
+	assert.NotNil(t, enabled.LoggerProvider())
+}
@@ -0,0 +1,239 @@
+package unit
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/service"
+	"github.com/adron/golang-services-build-base/internal/tlsconfig"
+)
+
+// testCA issues certificates for TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns PEM encoded certificate and key for name, usable as a
+// server certificate for 127.0.0.1 and as a client certificate
+func (ca *testCA) issue(t *testing.T, name string, serial int64) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) pem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+// writeServerFiles writes a server certificate, key and client CA bundle
+// and returns a TLS config pointing at them
+func writeServerFiles(t *testing.T, ca *testCA, dir string, serial int64) config.TLSConfig {
+	certPEM, keyPEM := ca.issue(t, "server", serial)
+	cfg := config.Default().TLS
+	cfg.Enabled = true
+	cfg.CertFile = filepath.Join(dir, "server.pem")
+	cfg.KeyFile = filepath.Join(dir, "server-key.pem")
+	cfg.ClientCAFile = filepath.Join(dir, "ca.pem")
+	cfg.ClientAuth = "require"
+	require.NoError(t, os.WriteFile(cfg.CertFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(cfg.KeyFile, keyPEM, 0o600))
+	require.NoError(t, os.WriteFile(cfg.ClientCAFile, ca.pem(), 0o600))
+	return cfg
+}
+
+func startTLSService(t *testing.T, cfg config.TLSConfig) (*service.Service, *tlsconfig.Reloader) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	certs, err := tlsconfig.New(cfg, logger)
+	require.NoError(t, err)
+
+	svc := service.New("127.0.0.1:0", func() http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+		})
+	}, logger, service.WithTLS(certs.Config()))
+	require.NoError(t, svc.Start(context.Background()))
+	t.Cleanup(func() { svc.Stop(context.Background()) })
+	return svc, certs
+}
+
+func tlsClient(t *testing.T, ca *testCA, name string) *http.Client {
+	certPEM, keyPEM := ca.issue(t, name, 100)
+	clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      ca.pool,
+		Certificates: []tls.Certificate{clientCert},
+	}}}
+}
+
+func TestTLSMutualAuthentication(t *testing.T) {
+	ca := newTestCA(t)
+	svc, _ := startTLSService(t, writeServerFiles(t, ca, t.TempDir(), 2))
+	url := "https://" + svc.Status().Addr
+
+	resp, err := tlsClient(t, ca, "edge-box-1").Get(url)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "edge-box-1", string(body))
+
+	// Without a client certificate the handshake is rejected
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool}}}
+	_, err = anonymous.Get(url)
+	assert.Error(t, err)
+
+	// A certificate from another CA is rejected
+	other := newTestCA(t)
+	client := tlsClient(t, other, "intruder")
+	client.Transport.(*http.Transport).TLSClientConfig.RootCAs = ca.pool
+	_, err = client.Get(url)
+	assert.Error(t, err)
+}
+
+func TestTLSCertificateReload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	cfg := writeServerFiles(t, ca, dir, 2)
+	svc, certs := startTLSService(t, cfg)
+	addr := svc.Status().Addr
+
+	serverSerial := func(conn *tls.Conn) int64 {
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	certPEM, keyPEM := ca.issue(t, "client", 100)
+	clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	clientConfig := &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{clientCert}}
+
+	before, err := tls.Dial("tcp", addr, clientConfig)
+	require.NoError(t, err)
+	defer before.Close()
+	assert.Equal(t, int64(2), serverSerial(before))
+
+	reloaded, err := certs.Reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded, "nothing changed on disk")
+
+	// Rotate the certificate and key
+	newCert, newKey := ca.issue(t, "server", 3)
+	require.NoError(t, os.WriteFile(cfg.CertFile, newCert, 0o600))
+	require.NoError(t, os.WriteFile(cfg.KeyFile, newKey, 0o600))
+	future := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(cfg.CertFile, future, future))
+
+	reloaded, err = certs.Reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, int64(3), certs.Certificate().SerialNumber.Int64())
+
+	after, err := tls.Dial("tcp", addr, clientConfig)
+	require.NoError(t, err)
+	defer after.Close()
+	assert.Equal(t, int64(3), serverSerial(after))
+
+	// The connection made before the rotation still works
+	_, err = io.WriteString(before, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	require.NoError(t, err)
+	buf := make([]byte, 12)
+	_, err = io.ReadFull(before, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200", string(buf))
+}
+
+func TestTLSReloadKeepsCertificateOnBadFiles(t *testing.T) {
+	ca := newTestCA(t)
+	cfg := writeServerFiles(t, ca, t.TempDir(), 2)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	certs, err := tlsconfig.New(cfg, logger)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(cfg.CertFile, []byte("half written"), 0o600))
+	reloaded, err := certs.Reload()
+	assert.Error(t, err)
+	assert.False(t, reloaded)
+	assert.Equal(t, int64(2), certs.Certificate().SerialNumber.Int64())
+}
+
+func TestTLSPolicy(t *testing.T) {
+	ca := newTestCA(t)
+	cfg := writeServerFiles(t, ca, t.TempDir(), 2)
+	cfg.ClientAuth = "none"
+	cfg.MinVersion = "1.3"
+	svc, _ := startTLSService(t, cfg)
+
+	_, err := tls.Dial("tcp", svc.Status().Addr, &tls.Config{RootCAs: ca.pool, MaxVersion: tls.VersionTLS12})
+	assert.Error(t, err, "TLS 1.2 client should be rejected")
+
+	conn, err := tls.Dial("tcp", svc.Status().Addr, &tls.Config{RootCAs: ca.pool})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), conn.ConnectionState().Version)
+	conn.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg.CipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+	_, err = tlsconfig.New(cfg, logger)
+	assert.Error(t, err)
+}
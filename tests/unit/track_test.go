@@ -0,0 +1,177 @@
+package unit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/frames"
+	"github.com/adron/golang-services-build-base/internal/track"
+)
+
+var trackStart = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// trackFrame is frame seq from camera, captured every 100ms
+func trackFrame(camera string, seq int) *frames.Frame {
+	return &frames.Frame{Camera: camera, Sequence: uint64(seq), CapturedAt: trackStart.Add(time.Duration(seq) * 100 * time.Millisecond)}
+}
+
+// walker is a 50x100 person moving right 10 pixels a frame
+func walker(seq int) frames.Detection {
+	return det("person", 0.9, float64(100+10*seq), 100, 50, 100)
+}
+
+type eventLog struct {
+	mu     sync.Mutex
+	events []track.Event
+}
+
+func (l *eventLog) record(ctx context.Context, e track.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+}
+
+func (l *eventLog) types() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var types []string
+	for _, e := range l.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func newTestTracker(t *testing.T) (*track.Tracker, *eventLog, *sdkmetric.ManualReader) {
+	tr := track.New(config.Default().Tracking)
+	log := &eventLog{}
+	tr.Subscribe(log.record)
+	reader := sdkmetric.NewManualReader()
+	require.NoError(t, tr.RegisterMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")))
+	return tr, log, reader
+}
+
+func TestTrackerConfirmsAndFollows(t *testing.T) {
+	tr, log, reader := newTestTracker(t)
+	ctx := context.Background()
+
+	var ids []uint64
+	for seq := 1; seq <= 6; seq++ {
+		out := tr.Update(ctx, trackFrame("lane-1", seq), []frames.Detection{walker(seq)})
+		require.Len(t, out, 1)
+		ids = append(ids, out[0].TrackID)
+	}
+
+	// IDs are given once the track has been matched in 3 frames
+	assert.Equal(t, []uint64{0, 0, 1, 1, 1, 1}, ids)
+	assert.Equal(t, []string{"created", "updated", "updated", "updated"}, log.types())
+
+	tracks := tr.Tracks("lane-1")
+	require.Len(t, tracks, 1)
+	assert.Equal(t, "person", tracks[0].Class)
+	assert.Equal(t, 6, tracks[0].Hits)
+	assert.Equal(t, trackStart.Add(100*time.Millisecond), tracks[0].FirstSeen)
+	assert.InDelta(t, 100, tracks[0].Velocity.X, 25, "pixels per second")
+	assert.InDelta(t, 0, tracks[0].Velocity.Y, 5)
+
+	assert.Equal(t, map[string]int64{"created": 1, "updated": 3}, sumByAttr(t, reader, "tracks.events", "type"))
+	gauge := findMetric(t, reader, "tracks.active").Data.(metricdata.Gauge[int64])
+	require.Len(t, gauge.DataPoints, 1)
+	assert.Equal(t, int64(1), gauge.DataPoints[0].Value)
+}
+
+func TestTrackerCoastsThroughOcclusion(t *testing.T) {
+	tr, log, _ := newTestTracker(t)
+	ctx := context.Background()
+	for seq := 1; seq <= 6; seq++ {
+		tr.Update(ctx, trackFrame("lane-1", seq), []frames.Detection{walker(seq)})
+	}
+
+	// Hidden for 4 frames, it has moved most of its width when it
+	// reappears, and only the predicted motion links it to its track
+	for seq := 7; seq <= 10; seq++ {
+		out := tr.Update(ctx, trackFrame("lane-1", seq), nil)
+		assert.Empty(t, out)
+	}
+	out := tr.Update(ctx, trackFrame("lane-1", 11), []frames.Detection{walker(11)})
+	assert.Equal(t, uint64(1), out[0].TrackID)
+	assert.NotContains(t, log.types(), "lost")
+}
+
+func TestTrackerLosesTracks(t *testing.T) {
+	tr, log, _ := newTestTracker(t)
+	ctx := context.Background()
+	for seq := 1; seq <= 3; seq++ {
+		tr.Update(ctx, trackFrame("lane-1", seq), []frames.Detection{walker(seq)})
+	}
+
+	// Unmatched for more than the 1s MaxAge
+	for seq := 4; seq <= 14; seq++ {
+		tr.Update(ctx, trackFrame("lane-1", seq), nil)
+	}
+	assert.Equal(t, []string{"created", "lost"}, log.types())
+	assert.Equal(t, uint64(1), log.events[1].Track.ID)
+	assert.Empty(t, tr.Tracks("lane-1"))
+
+	// A new object gets a new ID
+	var out []frames.Detection
+	for seq := 15; seq <= 17; seq++ {
+		out = tr.Update(ctx, trackFrame("lane-1", seq), []frames.Detection{walker(seq)})
+	}
+	assert.Equal(t, uint64(2), out[0].TrackID)
+}
+
+func TestTrackerExpiresIdleCameras(t *testing.T) {
+	tr, log, _ := newTestTracker(t)
+	ctx := context.Background()
+	for seq := 1; seq <= 3; seq++ {
+		tr.Update(ctx, trackFrame("lane-1", seq), []frames.Detection{walker(seq)})
+	}
+
+	tr.Expire(ctx, time.Now())
+	assert.Equal(t, []string{"created"}, log.types(), "camera still active")
+	tr.Expire(ctx, time.Now().Add(2*time.Second))
+	assert.Equal(t, []string{"created", "lost"}, log.types())
+	assert.Empty(t, tr.Active())
+}
+
+func TestTrackerIgnoresFlickerAndOtherClasses(t *testing.T) {
+	tr, log, _ := newTestTracker(t)
+	ctx := context.Background()
+
+	// A detection seen once, then a car where the person was
+	tr.Update(ctx, trackFrame("lane-1", 1), []frames.Detection{walker(1)})
+	tr.Update(ctx, trackFrame("lane-1", 2), nil)
+	for seq := 3; seq <= 4; seq++ {
+		car := walker(seq)
+		car.Class = "car"
+		tr.Update(ctx, trackFrame("lane-1", seq), []frames.Detection{car, det("person", 0.9, 500, 500, 50, 100)})
+	}
+	assert.Empty(t, log.types())
+}
+
+func TestTrackerPerCamera(t *testing.T) {
+	tr, _, _ := newTestTracker(t)
+	ctx := context.Background()
+
+	var lane1, lane2 []frames.Detection
+	for seq := 1; seq <= 3; seq++ {
+		lane1 = tr.Update(ctx, trackFrame("lane-1", seq), []frames.Detection{walker(seq)})
+		lane2 = tr.Update(ctx, trackFrame("lane-2", seq), []frames.Detection{walker(seq)})
+	}
+	assert.NotZero(t, lane1[0].TrackID)
+	assert.NotZero(t, lane2[0].TrackID)
+	assert.NotEqual(t, lane1[0].TrackID, lane2[0].TrackID)
+	assert.Equal(t, map[string]int{"lane-1": 1, "lane-2": 1}, tr.Active())
+
+	// A frame arriving after a later one from the same camera is skipped
+	stale := tr.Update(ctx, trackFrame("lane-1", 2), []frames.Detection{walker(2)})
+	assert.Zero(t, stale[0].TrackID)
+}
@@ -0,0 +1,227 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/adron/golang-services-build-base/config"
+	"github.com/adron/golang-services-build-base/internal/frames"
+	"github.com/adron/golang-services-build-base/internal/track"
+	"github.com/adron/golang-services-build-base/internal/zones"
+)
+
+// footAt is an event for a 20x40 track on lane-1 whose foot-point is (x, y)
+func footAt(typ string, id uint64, x, y float64) track.Event {
+	return track.Event{Type: typ, Track: track.Track{
+		ID:     id,
+		Camera: "lane-1",
+		Class:  "person",
+		Box:    frames.Box{X: x - 10, Y: y - 40, Width: 20, Height: 40},
+	}}
+}
+
+func newTestZones(t *testing.T, cfg ...config.ZoneConfig) (*zones.Registry, *sdkmetric.ManualReader) {
+	r, err := zones.New(cfg)
+	require.NoError(t, err)
+	reader := sdkmetric.NewManualReader()
+	require.NoError(t, r.RegisterMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")))
+	return r, reader
+}
+
+// notch is a U shape open at the bottom, with its notch between x=100 and
+// x=200 below y=100
+var notch = config.ZoneConfig{
+	Camera: "lane-1",
+	ID:     "notch",
+	Kind:   "polygon",
+	Points: [][2]float64{{0, 0}, {300, 0}, {300, 300}, {200, 300}, {200, 100}, {100, 100}, {100, 300}, {0, 300}},
+}
+
+func TestZonesPolygonMembership(t *testing.T) {
+	r, reader := newTestZones(t, notch)
+	ctx := context.Background()
+
+	r.HandleEvent(ctx, footAt(track.EventCreated, 1, 50, 200))  // left arm
+	r.HandleEvent(ctx, footAt(track.EventCreated, 2, 150, 200)) // in the notch
+	r.HandleEvent(ctx, footAt(track.EventCreated, 3, 150, 50))  // across the top
+	r.HandleEvent(ctx, footAt(track.EventCreated, 4, 400, 50))  // outside
+
+	status, ok := r.Get("lane-1", "notch")
+	require.True(t, ok)
+	assert.Equal(t, 2, status.Occupancy)
+	assert.Equal(t, []uint64{1, 3}, status.Tracks)
+
+	// Track 2 walks up into the zone, track 1 walks out and track 3 is lost
+	r.HandleEvent(ctx, footAt(track.EventUpdated, 2, 150, 90))
+	r.HandleEvent(ctx, footAt(track.EventUpdated, 1, -10, 200))
+	r.HandleEvent(ctx, footAt(track.EventLost, 3, 150, 50))
+	status, _ = r.Get("lane-1", "notch")
+	assert.Equal(t, []uint64{2}, status.Tracks)
+	assert.Equal(t, map[string]int64{"entered": 3, "exited": 2}, sumByAttr(t, reader, "zones.transitions", "transition"))
+
+	gauge := findMetric(t, reader, "zones.occupancy").Data.(metricdata.Gauge[int64])
+	require.Len(t, gauge.DataPoints, 1)
+	assert.Equal(t, int64(1), gauge.DataPoints[0].Value)
+	_, labelled := gauge.DataPoints[0].Attributes.Value("zone.version")
+	assert.False(t, labelled, "the version is only on zones.info")
+
+	// Zones on other cameras are not affected
+	other := footAt(track.EventCreated, 5, 50, 200)
+	other.Track.Camera = "lane-2"
+	r.HandleEvent(ctx, other)
+	status, _ = r.Get("lane-1", "notch")
+	assert.Equal(t, 1, status.Occupancy)
+}
+
+func TestZonesLineCrossings(t *testing.T) {
+	// Looking along the line, from the top of the image down, its left is
+	// the right of the image
+	r, reader := newTestZones(t, config.ZoneConfig{Camera: "lane-1", ID: "door", Kind: "line", Points: [][2]float64{{100, 0}, {100, 200}}})
+	ctx := context.Background()
+
+	r.HandleEvent(ctx, footAt(track.EventCreated, 1, 50, 100))
+	r.HandleEvent(ctx, footAt(track.EventUpdated, 1, 150, 100))
+	r.HandleEvent(ctx, footAt(track.EventUpdated, 1, 160, 100))
+	r.HandleEvent(ctx, footAt(track.EventUpdated, 1, 60, 100))
+
+	// Passing beyond the end of the line is not a crossing
+	r.HandleEvent(ctx, footAt(track.EventCreated, 2, 50, 300))
+	r.HandleEvent(ctx, footAt(track.EventUpdated, 2, 150, 300))
+
+	status, _ := r.Get("lane-1", "door")
+	assert.Equal(t, map[string]int64{zones.LeftToRight: 1, zones.RightToLeft: 1}, status.Crossings)
+	assert.Zero(t, status.Occupancy)
+	assert.Equal(t, map[string]int64{zones.LeftToRight: 1, zones.RightToLeft: 1}, sumByAttr(t, reader, "zones.crossings", "direction"))
+}
+
+func TestZonesVersions(t *testing.T) {
+	r, reader := newTestZones(t, notch)
+	ctx := context.Background()
+	r.HandleEvent(ctx, footAt(track.EventCreated, 1, 250, 250))
+
+	// Replacing a zone gives it a new version and works out its occupancy
+	// from where the tracks last were
+	smaller := zones.Zone{Camera: "lane-1", ID: "notch", Kind: "polygon", Points: []zones.Point{{0, 0}, {100, 0}, {100, 100}}}
+	z, created, err := r.Put(ctx, smaller)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, uint64(2), z.Version)
+	status, _ := r.Get("lane-1", "notch")
+	assert.Zero(t, status.Occupancy)
+
+	assert.True(t, r.Delete(ctx, "lane-1", "notch"))
+	assert.False(t, r.Delete(ctx, "lane-1", "notch"))
+	z, created, err = r.Put(ctx, smaller)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, uint64(4), z.Version, "versions are not reused after a delete")
+
+	// The configured zone was loaded before metrics were registered
+	assert.Equal(t, map[string]int64{"created": 1, "updated": 1, "deleted": 1}, sumByAttr(t, reader, "zones.changes", "operation"))
+	changes := findMetric(t, reader, "zones.changes").Data.(metricdata.Sum[int64])
+	assert.Len(t, changes.DataPoints, 3, "one series per operation, whatever the version")
+
+	// Only the current version is reported
+	info := findMetric(t, reader, "zones.info").Data.(metricdata.Gauge[int64])
+	require.Len(t, info.DataPoints, 1)
+	version, _ := info.DataPoints[0].Attributes.Value("zone.version")
+	assert.Equal(t, int64(4), version.AsInt64())
+
+	_, _, err = r.Put(ctx, zones.Zone{Camera: "lane-1", ID: "bad", Kind: "line", Points: []zones.Point{{0, 0}}})
+	assert.ErrorIs(t, err, zones.ErrInvalid)
+	_, err = zones.New([]config.ZoneConfig{{Camera: "lane 1", ID: "a", Kind: "line", Points: [][2]float64{{0, 0}, {1, 1}}}})
+	assert.ErrorIs(t, err, zones.ErrInvalid)
+}
+
+func TestZonesOnlyFollowCamerasWithZones(t *testing.T) {
+	r, _ := newTestZones(t)
+	ctx := context.Background()
+	var points []zones.Point
+	for _, p := range notch.Points {
+		points = append(points, zones.Point{p[0], p[1]})
+	}
+
+	// Tracks on a camera without zones are not remembered, so a zone
+	// drawn around one counts it from its next update
+	r.HandleEvent(ctx, footAt(track.EventCreated, 1, 50, 200))
+	_, _, err := r.Put(ctx, zones.Zone{Camera: "lane-1", ID: "notch", Kind: "polygon", Points: points})
+	require.NoError(t, err)
+	status, _ := r.Get("lane-1", "notch")
+	assert.Zero(t, status.Occupancy)
+	r.HandleEvent(ctx, footAt(track.EventUpdated, 1, 50, 200))
+	status, _ = r.Get("lane-1", "notch")
+	assert.Equal(t, 1, status.Occupancy)
+
+	// Deleting the last zone forgets the camera's tracks
+	require.True(t, r.Delete(ctx, "lane-1", "notch"))
+	_, _, err = r.Put(ctx, zones.Zone{Camera: "lane-1", ID: "notch", Kind: "polygon", Points: points})
+	require.NoError(t, err)
+	status, _ = r.Get("lane-1", "notch")
+	assert.Zero(t, status.Occupancy)
+}
+
+func TestZoneHandlers(t *testing.T) {
+	r, _ := newTestZones(t, notch)
+	router := mux.NewRouter()
+	router.Handle("/cameras/{camera}/zones", r.ListHandler()).Methods(http.MethodGet)
+	router.Handle("/cameras/{camera}/zones/{zone}", r.GetHandler()).Methods(http.MethodGet)
+	router.Handle("/cameras/{camera}/zones/{zone}", r.PutHandler()).Methods(http.MethodPut)
+	router.Handle("/cameras/{camera}/zones/{zone}", r.DeleteHandler()).Methods(http.MethodDelete)
+	do := func(method, path, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPut, "/cameras/lane-1/zones/door", `{"name":"Door","kind":"line","points":[[0,0],[0,100]]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+
+	rec = do(http.MethodGet, "/cameras/lane-1/zones", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var list []zones.Status
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&list))
+	require.Len(t, list, 2)
+	assert.Equal(t, "door", list[0].ID)
+	assert.Equal(t, "Door", list[0].Name)
+	assert.Equal(t, "notch", list[1].ID)
+
+	rec = do(http.MethodGet, "/cameras/lane-2/zones", "")
+	assert.JSONEq(t, `[]`, rec.Body.String())
+
+	// Changes can be made conditional on the version last read
+	rec = do(http.MethodPut, "/cameras/lane-1/zones/door", `{"kind":"line","points":[[0,0],[0,50]]}`, "If-Match", `"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	rec = do(http.MethodPut, "/cameras/lane-1/zones/door", `{"kind":"line","points":[[0,0],[0,50]]}`, "If-Match", `"2"`)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, `"3"`, rec.Header().Get("ETag"))
+
+	rec = do(http.MethodGet, "/cameras/lane-1/zones/door", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"3"`, rec.Header().Get("ETag"))
+	assert.Contains(t, rec.Body.String(), `"crossings":{"left_to_right":0,"right_to_left":0}`)
+
+	for _, body := range []string{`{"kind":"circle","points":[[0,0],[1,1]]}`, `{"kind":"polygon","points":[[0,0],[1,1]]}`, `{"kind":"line","points":[[0,0],[1,1]],"color":"red"}`, `not json`} {
+		rec = do(http.MethodPut, "/cameras/lane-1/zones/bad", body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+
+	assert.Equal(t, http.StatusPreconditionFailed, do(http.MethodDelete, "/cameras/lane-1/zones/door", "", "If-Match", `"2"`).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/cameras/lane-1/zones/door", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/cameras/lane-1/zones/door", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/cameras/lane-1/zones/door", "").Code)
+}